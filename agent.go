@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// Default receiver used with --local-agent when no --url is given. Alloy's
+// prometheus.receive_http component serves this path; a Prometheus agent
+// uses /api/v1/write instead.
+const defaultLocalAgentURL = "http://127.0.0.1:9999/api/v1/metrics/write"
+
+// A local Prometheus Agent or Grafana Alloy owns egress and credentials, so
+// only the URL is required and it is expected to point at this machine.
+func validateLocalAgentConfiguration(remoteWriteURL string) error {
+	parsedURL, err := url.Parse(remoteWriteURL)
+	if err != nil {
+		return fmt.Errorf("invalid remote write URL format: %v", err)
+	}
+	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
+		return fmt.Errorf("remote write URL must use http or https scheme")
+	}
+	if parsedURL.Host == "" {
+		return fmt.Errorf("remote write URL must include a host")
+	}
+
+	host := parsedURL.Hostname()
+	ip := net.ParseIP(host)
+	if host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		log.Printf("WARNING: Local agent URL %s does not point at localhost", remoteWriteURL)
+	}
+
+	log.Printf("Configuration validated - local agent URL: %s", remoteWriteURL)
+	return nil
+}
+
+// Generates a config snippet for the local agent that receives our pushes on
+// listenURL and forwards them upstream.
+func agentConfigSnippet(kind, listenURL string) (string, error) {
+	parsedURL, err := url.Parse(listenURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid listen URL: %v", err)
+	}
+	host, port, err := net.SplitHostPort(parsedURL.Host)
+	if err != nil {
+		return "", fmt.Errorf("listen URL must include a port: %v", err)
+	}
+
+	switch kind {
+	case "alloy":
+		return strings.TrimLeft(fmt.Sprintf(`
+prometheus.receive_http "librespeed" {
+  http {
+    listen_address = %q
+    listen_port    = %s
+  }
+  forward_to = [prometheus.remote_write.grafana_cloud.receiver]
+}
+
+prometheus.remote_write "grafana_cloud" {
+  endpoint {
+    url = "https://prometheus-<region>.grafana.net/api/prom/push"
+
+    basic_auth {
+      username = "<instance ID>"
+      password = sys.env("GRAFANA_CLOUD_API_KEY")
+    }
+  }
+}
+`, host, port), "\n"), nil
+	case "prometheus":
+		return strings.TrimLeft(fmt.Sprintf(`
+# Run the agent with:
+#   prometheus --agent --web.enable-remote-write-receiver --web.listen-address=%s
+# and point the exporter at http://%s/api/v1/write
+remote_write:
+  - url: https://prometheus-<region>.grafana.net/api/prom/push
+    basic_auth:
+      username: <instance ID>
+      password_file: /etc/prometheus/grafana_cloud_api_key
+`, parsedURL.Host, parsedURL.Host), "\n"), nil
+	default:
+		return "", fmt.Errorf("unknown agent type: %s (supported: alloy, prometheus)", kind)
+	}
+}
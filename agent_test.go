@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func TestValidateLocalAgentConfiguration(t *testing.T) {
+	testCases := []struct {
+		name, url   string
+		shouldError bool
+	}{
+		{"Default URL", defaultLocalAgentURL, false},
+		{"Localhost", "http://localhost:9090/api/v1/write", false},
+		{"Remote host still allowed", "http://agent.internal:9090/api/v1/write", false},
+		{"Bad scheme", "ftp://localhost:9090", true},
+		{"No host", "http://", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateLocalAgentConfiguration(tc.url)
+			if (err != nil) != tc.shouldError {
+				t.Errorf("Expected error=%v, got %v", tc.shouldError, err)
+			}
+		})
+	}
+}
+
+func TestAgentConfigSnippet(t *testing.T) {
+	alloy, err := agentConfigSnippet("alloy", "http://127.0.0.1:9999/api/v1/metrics/write")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !strings.Contains(alloy, `listen_address = "127.0.0.1"`) || !strings.Contains(alloy, "listen_port    = 9999") {
+		t.Errorf("Alloy snippet missing listen settings:\n%s", alloy)
+	}
+
+	prom, err := agentConfigSnippet("prometheus", "http://localhost:9090/api/v1/write")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !strings.Contains(prom, "--web.enable-remote-write-receiver") || !strings.Contains(prom, "remote_write:") {
+		t.Errorf("Prometheus snippet missing receiver settings:\n%s", prom)
+	}
+
+	if _, err := agentConfigSnippet("telegraf", defaultLocalAgentURL); err == nil {
+		t.Error("Expected error for unknown agent type, got nil")
+	}
+	if _, err := agentConfigSnippet("alloy", "http://localhost/api"); err == nil {
+		t.Error("Expected error for listen URL without port, got nil")
+	}
+}
+
+func TestSendToRemoteWrite_NoAuthWithoutUsername(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" {
+			t.Errorf("Expected no Authorization header, got %s", r.Header.Get("Authorization"))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer mockServer.Close()
+
+	ts := createTimeSeries("test_metric", 1.0, time.Now().UnixMilli(), "server", "instance")
+	if err := sendToRemoteWrite(context.Background(), mockServer.URL, "", "", []*prompb.TimeSeries{ts}); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
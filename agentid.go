@@ -0,0 +1,52 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func newUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40 // version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// Returns the agent ID stored in stateDir, generating it on first use. The ID
+// outlives hostname changes; re-imaging keeps it only if the state dir is
+// preserved or restored.
+func loadOrCreateAgentID(stateDir string) (string, error) {
+	path := filepath.Join(stateDir, "agent_id")
+	data, err := os.ReadFile(path)
+	if err == nil {
+		id := strings.TrimSpace(string(data))
+		if uuidPattern.MatchString(id) {
+			return id, nil
+		}
+		return "", fmt.Errorf("invalid agent ID in %s", path)
+	}
+	if !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to read agent ID: %v", err)
+	}
+
+	id, err := newUUID()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate agent ID: %v", err)
+	}
+	if err := os.MkdirAll(stateDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create state directory: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(id+"\n"), 0644); err != nil {
+		return "", fmt.Errorf("failed to save agent ID: %v", err)
+	}
+	return id, nil
+}
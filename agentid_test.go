@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewUUID(t *testing.T) {
+	id, err := newUUID()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !uuidPattern.MatchString(id) {
+		t.Errorf("Expected a version 4 UUID, got %s", id)
+	}
+}
+
+func TestLoadOrCreateAgentID_Persists(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "state")
+	first, err := loadOrCreateAgentID(dir)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	second, err := loadOrCreateAgentID(dir)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if first != second {
+		t.Errorf("Expected the same ID across runs, got %s and %s", first, second)
+	}
+}
+
+func TestLoadOrCreateAgentID_Corrupt(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "agent_id"), []byte("not-a-uuid"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	// A corrupt file must not be silently replaced, or the fleet would see a
+	// new agent
+	if _, err := loadOrCreateAgentID(dir); err == nil {
+		t.Error("Expected error for corrupt agent ID, got nil")
+	}
+}
+
+func TestLoadOrCreateAgentID_DefaultStateDirIgnoresWorkingDirectory(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", home)
+	t.Setenv("LocalAppData", home)
+	t.Setenv("HOME", home)
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+
+	var ids []string
+	for _, dir := range []string{t.TempDir(), t.TempDir()} {
+		os.Chdir(dir)
+		cfg := &Config{}
+		if err := applyStateDirDefault(cfg); err != nil {
+			t.Fatal(err)
+		}
+		id, err := loadOrCreateAgentID(cfg.StateDir)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		ids = append(ids, id)
+	}
+	if ids[0] != ids[1] {
+		t.Errorf("Expected one agent ID whatever the working directory, got %s and %s", ids[0], ids[1])
+	}
+}
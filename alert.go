@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Zero disables the corresponding check.
+type AlertThresholds struct {
+	MinDownload float64
+	MinUpload   float64
+	MaxPing     float64
+	MaxJitter   float64
+}
+
+type thresholdCheck struct {
+	AlertName string
+	Metric    string
+	Value     float64
+	Threshold float64
+	Breached  bool
+}
+
+// Alertmanager v2 API alert (POST /api/v2/alerts).
+type amAlert struct {
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL,omitempty"`
+}
+
+func evaluateThresholds(thresholds AlertThresholds, result *LibrespeedResult) []thresholdCheck {
+	var checks []thresholdCheck
+	if thresholds.MinDownload > 0 {
+		checks = append(checks, thresholdCheck{"LibrespeedDownloadLow", "librespeed_download_mbps", result.Download, thresholds.MinDownload, result.Download < thresholds.MinDownload})
+	}
+	if thresholds.MinUpload > 0 {
+		checks = append(checks, thresholdCheck{"LibrespeedUploadLow", "librespeed_upload_mbps", result.Upload, thresholds.MinUpload, result.Upload < thresholds.MinUpload})
+	}
+	if thresholds.MaxPing > 0 {
+		checks = append(checks, thresholdCheck{"LibrespeedPingHigh", "librespeed_ping_ms", result.Ping, thresholds.MaxPing, result.Ping > thresholds.MaxPing})
+	}
+	if thresholds.MaxJitter > 0 {
+		checks = append(checks, thresholdCheck{"LibrespeedJitterHigh", "librespeed_jitter_ms", result.Jitter, thresholds.MaxJitter, result.Jitter > thresholds.MaxJitter})
+	}
+	return checks
+}
+
+// Every check produces an alert so a recovered check resolves a previously
+// firing one: breached alerts end resolveAfter from now (long enough to
+// survive until the next run), recovered alerts end immediately. Alerts share
+// alertname/instance/server_url so Alertmanager groups them predictably.
+func buildAlerts(checks []thresholdCheck, instance, serverURL string, now time.Time, resolveAfter time.Duration) []amAlert {
+	var alerts []amAlert
+	for _, check := range checks {
+		alert := amAlert{
+			Labels: map[string]string{
+				"alertname":  check.AlertName,
+				"job":        "librespeed_exporter",
+				"instance":   instance,
+				"server_url": serverURL,
+				"severity":   "warning",
+			},
+			Annotations: map[string]string{
+				"summary":     fmt.Sprintf("%s on %s", check.AlertName, instance),
+				"description": fmt.Sprintf("%s is %.2f (threshold %.2f)", check.Metric, check.Value, check.Threshold),
+			},
+			StartsAt: now,
+		}
+		if check.Breached {
+			alert.EndsAt = now.Add(resolveAfter)
+		} else {
+			alert.EndsAt = now
+		}
+		alerts = append(alerts, alert)
+	}
+	return alerts
+}
+
+func postAlerts(alertmanagerURL string, alerts []amAlert) error {
+	if len(alerts) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(alerts)
+	if err != nil {
+		return fmt.Errorf("failed to marshal alerts: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	endpoint := strings.TrimRight(alertmanagerURL, "/") + "/api/v2/alerts"
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create HTTP request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := remoteWriteClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send HTTP request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("alertmanager rejected alerts: %s - %s", resp.Status, string(respBody))
+	}
+
+	firing := 0
+	for _, alert := range alerts {
+		if alert.EndsAt.After(alert.StartsAt) {
+			firing++
+		}
+	}
+	log.Printf("Sent %d alerts to Alertmanager (%d firing, %d resolved)", len(alerts), firing, len(alerts)-firing)
+	return nil
+}
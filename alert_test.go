@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEvaluateThresholds(t *testing.T) {
+	result := &LibrespeedResult{Download: 80, Upload: 45, Ping: 30, Jitter: 2}
+	thresholds := AlertThresholds{MinDownload: 100, MinUpload: 40, MaxPing: 20}
+
+	checks := evaluateThresholds(thresholds, result)
+	if len(checks) != 3 {
+		t.Fatalf("Expected 3 checks (jitter disabled), got %d", len(checks))
+	}
+
+	expected := map[string]bool{
+		"LibrespeedDownloadLow": true,
+		"LibrespeedUploadLow":   false,
+		"LibrespeedPingHigh":    true,
+	}
+	for _, check := range checks {
+		breached, ok := expected[check.AlertName]
+		if !ok {
+			t.Errorf("Unexpected check: %s", check.AlertName)
+			continue
+		}
+		if check.Breached != breached {
+			t.Errorf("%s: expected breached=%v, got %v", check.AlertName, breached, check.Breached)
+		}
+	}
+}
+
+func TestEvaluateThresholds_AllDisabled(t *testing.T) {
+	checks := evaluateThresholds(AlertThresholds{}, &LibrespeedResult{Download: 1})
+	if len(checks) != 0 {
+		t.Errorf("Expected no checks, got %d", len(checks))
+	}
+}
+
+func TestBuildAlerts_FiringAndResolved(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	checks := []thresholdCheck{
+		{"LibrespeedDownloadLow", "librespeed_download_mbps", 80, 100, true},
+		{"LibrespeedPingHigh", "librespeed_ping_ms", 10, 20, false},
+	}
+	alerts := buildAlerts(checks, "host1", "http://server", now, time.Hour)
+	if len(alerts) != 2 {
+		t.Fatalf("Expected 2 alerts, got %d", len(alerts))
+	}
+	if !alerts[0].EndsAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("Expected firing alert to end in 1h, got %v", alerts[0].EndsAt)
+	}
+	if !alerts[1].EndsAt.Equal(now) {
+		t.Errorf("Expected recovered alert to end now, got %v", alerts[1].EndsAt)
+	}
+	if alerts[0].Labels["instance"] != "host1" || alerts[0].Labels["server_url"] != "http://server" {
+		t.Errorf("Unexpected grouping labels: %v", alerts[0].Labels)
+	}
+}
+
+func TestPostAlerts(t *testing.T) {
+	var received []amAlert
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/alerts" {
+			t.Errorf("Expected /api/v2/alerts, got %s", r.URL.Path)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("Failed to decode alerts: %v", err)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer mockServer.Close()
+
+	now := time.Now()
+	alerts := buildAlerts([]thresholdCheck{{"LibrespeedDownloadLow", "librespeed_download_mbps", 80, 100, true}}, "host1", "http://server", now, time.Hour)
+	if err := postAlerts(mockServer.URL+"/", alerts); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(received) != 1 || received[0].Labels["alertname"] != "LibrespeedDownloadLow" {
+		t.Errorf("Unexpected alerts received: %+v", received)
+	}
+}
+
+func TestPostAlerts_Empty(t *testing.T) {
+	if err := postAlerts("http://127.0.0.1:1", nil); err != nil {
+		t.Errorf("Expected no request and no error for empty alerts, got %v", err)
+	}
+}
+
+func TestPostAlerts_ErrorResponse(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad alerts", http.StatusBadRequest)
+	}))
+	defer mockServer.Close()
+
+	alerts := buildAlerts([]thresholdCheck{{"LibrespeedPingHigh", "librespeed_ping_ms", 50, 20, true}}, "host1", "", time.Now(), time.Hour)
+	if err := postAlerts(mockServer.URL, alerts); err == nil {
+		t.Error("Expected error for 400 response, got nil")
+	}
+}
@@ -0,0 +1,90 @@
+//go:build !minimal
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+type AMQPConfig struct {
+	URL        string
+	Exchange   string
+	RoutingKey string
+	CAFile     string
+	Confirm    bool
+}
+
+func publishAMQP(cfg AMQPConfig, hostname string, record RunRecord, ts time.Time) error {
+	body, err := json.Marshal(resultMessage{Instance: hostname, Timestamp: ts, RunRecord: record})
+	if err != nil {
+		return fmt.Errorf("failed to marshal AMQP message: %v", err)
+	}
+
+	var conn *amqp.Connection
+	if strings.HasPrefix(cfg.URL, "amqps://") {
+		tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12}
+		if cfg.CAFile != "" {
+			pool, err := loadCAPool(cfg.CAFile)
+			if err != nil {
+				return err
+			}
+			tlsCfg.RootCAs = pool
+		}
+		conn, err = amqp.DialTLS(cfg.URL, tlsCfg)
+	} else {
+		conn, err = amqp.Dial(cfg.URL)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to AMQP broker: %v", err)
+	}
+	defer conn.Close()
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return fmt.Errorf("failed to open AMQP channel: %v", err)
+	}
+	defer ch.Close()
+
+	if cfg.Confirm {
+		if err := ch.Confirm(false); err != nil {
+			return fmt.Errorf("failed to enable publisher confirms: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	routingKey := strings.ReplaceAll(cfg.RoutingKey, "{host}", hostname)
+	confirmation, err := ch.PublishWithDeferredConfirmWithContext(ctx, cfg.Exchange, routingKey, false, false, amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		Timestamp:    ts,
+		AppId:        "librespeed_exporter",
+		Body:         body,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to publish to AMQP: %v", err)
+	}
+
+	// Without confirm mode the broker gives no feedback and confirmation is nil
+	if confirmation != nil {
+		acked, err := confirmation.WaitContext(ctx)
+		if err != nil {
+			return fmt.Errorf("failed waiting for publisher confirm: %v", err)
+		}
+		if !acked {
+			return fmt.Errorf("broker nacked the message")
+		}
+	}
+
+	log.Printf("Run record published to AMQP exchange %q (routing key %s)", cfg.Exchange, routingKey)
+	return nil
+}
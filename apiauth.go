@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// What an API token may do. Each scope includes the ones below it.
+type apiScope int
+
+const (
+	scopeNone apiScope = iota
+	// Metrics, run status and results, for dashboards
+	scopeRead
+	// Also trigger tests (/api/v1/run, /probe, RunTest) and switch
+	// maintenance mode
+	scopeOperator
+	// Also reload the configuration
+	scopeAdmin
+)
+
+var apiScopes = map[string]apiScope{"read": scopeRead, "operator": scopeOperator, "admin": scopeAdmin}
+
+func (s apiScope) String() string {
+	for name, scope := range apiScopes {
+		if scope == s {
+			return name
+		}
+	}
+	return "none"
+}
+
+type apiToken struct {
+	Name  string
+	Scope apiScope
+	value string
+}
+
+// Parses --api-token values of the form name=scope:token.
+func parseAPITokens(specs []string) ([]apiToken, error) {
+	var tokens []apiToken
+	names := map[string]bool{}
+	for _, spec := range specs {
+		name, rest, ok := strings.Cut(spec, "=")
+		scopeName, value, ok2 := strings.Cut(rest, ":")
+		if !ok || !ok2 || name == "" || value == "" {
+			return nil, fmt.Errorf("invalid --api-token for %q, expected name=scope:token", name)
+		}
+		scope, ok := apiScopes[scopeName]
+		if !ok {
+			return nil, fmt.Errorf("--api-token %s has unknown scope %q, expected read, operator or admin", name, scopeName)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("--api-token %s is defined twice", name)
+		}
+		names[name] = true
+		tokens = append(tokens, apiToken{Name: name, Scope: scope, value: value})
+	}
+	return tokens, nil
+}
+
+// Bearer token checks for the HTTP and gRPC APIs. Without tokens every
+// request is allowed, as before tokens existed. Tokens are replaced on
+// reload.
+type apiAuth struct {
+	mu     sync.RWMutex
+	tokens []apiToken
+}
+
+func (a *apiAuth) set(tokens []apiToken) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.tokens = tokens
+}
+
+// The token matching the presented value. ok is false when tokens are
+// configured and none matches; token is nil when no tokens are configured.
+func (a *apiAuth) lookup(presented string) (token *apiToken, ok bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if len(a.tokens) == 0 {
+		return nil, true
+	}
+	for i := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(a.tokens[i].value), []byte(presented)) == 1 {
+			t := a.tokens[i]
+			return &t, true
+		}
+	}
+	return nil, false
+}
+
+type apiTokenKey struct{}
+
+// Name of the token that authorized the request, if any.
+func tokenName(ctx context.Context) string {
+	name, _ := ctx.Value(apiTokenKey{}).(string)
+	return name
+}
+
+// Scope an HTTP request needs. Health checks stay open for load balancers
+// and orchestrators.
+func httpScope(r *http.Request) apiScope {
+	switch {
+	case r.URL.Path == "/healthz" || r.URL.Path == "/readyz":
+		return scopeNone
+	case r.URL.Path == "/api/v1/webhook":
+		// Signed with --webhook-secret instead
+		return scopeNone
+	case r.URL.Path == "/-/reload":
+		return scopeAdmin
+	case r.URL.Path == "/probe", r.URL.Path == "/api/v1/run" && r.Method == http.MethodPost,
+		changesMaintenance(r):
+		return scopeOperator
+	}
+	return scopeRead
+}
+
+func changesMaintenance(r *http.Request) bool {
+	return r.URL.Path == "/api/v1/maintenance" && r.Method != http.MethodGet && r.Method != http.MethodHead
+}
+
+// /probe starts tests without an opt-in flag of its own, like
+// --enable-run-api, and maintenance mode silences alerting, so both are
+// only served to a token holder.
+func tokenRequired(r *http.Request) bool {
+	return r.URL.Path == "/probe" || changesMaintenance(r)
+}
+
+// Answers 401 without a valid token and 403 when its scope is too narrow,
+// or when the path needs a token and none are configured.
+func (a *apiAuth) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		need := httpScope(r)
+		if need == scopeNone {
+			next.ServeHTTP(w, r)
+			return
+		}
+		presented, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		token, ok := a.lookup(presented)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="librespeed_exporter"`)
+			http.Error(w, "missing or invalid API token", http.StatusUnauthorized)
+			return
+		}
+		if token == nil && tokenRequired(r) {
+			http.Error(w, fmt.Sprintf("%s requires an --api-token with scope %s", r.URL.Path, need), http.StatusForbidden)
+			return
+		}
+		if token != nil {
+			if token.Scope < need {
+				http.Error(w, fmt.Sprintf("token %s has scope %s, %s required", token.Name, token.Scope, need), http.StatusForbidden)
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), apiTokenKey{}, token.Name))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestAPIAuth(t *testing.T, specs ...string) *apiAuth {
+	t.Helper()
+	tokens, err := parseAPITokens(specs)
+	if err != nil {
+		t.Fatalf("Failed to parse tokens: %v", err)
+	}
+	auth := &apiAuth{}
+	auth.set(tokens)
+	return auth
+}
+
+func TestParseAPITokens_Errors(t *testing.T) {
+	for _, spec := range []string{
+		"grafana",
+		"grafana=read",
+		"grafana=read:",
+		"=read:abc",
+		"grafana=owner:abc",
+	} {
+		if _, err := parseAPITokens([]string{spec}); err == nil {
+			t.Errorf("Expected error for %q, got nil", spec)
+		}
+	}
+	if _, err := parseAPITokens([]string{"a=read:x", "a=admin:y"}); err == nil {
+		t.Error("Expected error for a duplicate token name, got nil")
+	}
+}
+
+func TestAPIAuth_HTTPScopes(t *testing.T) {
+	auth := newTestAPIAuth(t, "grafana=read:r1", "ci=operator:o1", "ops=admin:a1")
+	var actor string
+	handler := auth.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor = requestActor(r)
+	}))
+
+	tests := []struct {
+		method, path, token string
+		want                int
+	}{
+		{"GET", "/healthz", "", http.StatusOK},
+		{"GET", "/metrics", "", http.StatusUnauthorized},
+		{"GET", "/metrics", "wrong", http.StatusUnauthorized},
+		{"GET", "/metrics", "r1", http.StatusOK},
+		{"GET", "/api/v1/run/42", "r1", http.StatusOK},
+		{"POST", "/api/v1/run", "r1", http.StatusForbidden},
+		{"POST", "/api/v1/run", "o1", http.StatusOK},
+		{"GET", "/probe", "r1", http.StatusForbidden},
+		{"GET", "/probe", "o1", http.StatusOK},
+		{"GET", "/api/v1/maintenance", "r1", http.StatusOK},
+		{"PUT", "/api/v1/maintenance", "r1", http.StatusForbidden},
+		{"DELETE", "/api/v1/maintenance", "o1", http.StatusOK},
+		{"POST", "/-/reload", "o1", http.StatusForbidden},
+		{"POST", "/-/reload", "a1", http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s %s with %q: expected %d, got %d", tt.method, tt.path, tt.token, tt.want, w.Code)
+		}
+	}
+	if actor != "ops from 192.0.2.1:1234" {
+		t.Errorf("Expected the token name in the actor, got %q", actor)
+	}
+}
+
+func TestAPIAuth_NoTokensAllowsAll(t *testing.T) {
+	handler := (&apiAuth{}).middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/-/reload", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected 200 without tokens configured, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/probe?server_id=1", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected /probe to need a token even without tokens configured, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("PUT", "/api/v1/maintenance", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected maintenance changes to need a token even without tokens configured, got %d", w.Code)
+	}
+}
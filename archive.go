@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Where daily history segments are uploaded. s3:// and gs:// (through the
+// GCS XML API with HMAC keys) are signed with AWS Signature V4; Azure Blob
+// containers are addressed by a SAS URL.
+type ArchiveConfig struct {
+	URL      string
+	Endpoint string
+	Region   string
+	SSE      string
+	KMSKeyID string
+}
+
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// Same variables the AWS CLI and SDKs read.
+func awsCredentialsFromEnv() (awsCredentials, error) {
+	creds := awsCredentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return creds, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set for s3:// and gs:// archives")
+	}
+	return creds, nil
+}
+
+func validateArchiveConfig(cfg ArchiveConfig) error {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return fmt.Errorf("invalid --archive-url: %v", err)
+	}
+	switch u.Scheme {
+	case "s3", "gs":
+		if u.Host == "" {
+			return fmt.Errorf("--archive-url %s has no bucket", cfg.URL)
+		}
+	case "https":
+		if u.Query().Get("sig") == "" {
+			return fmt.Errorf("https --archive-url must be an Azure Blob container SAS URL")
+		}
+	default:
+		return fmt.Errorf("--archive-url must start with s3://, gs:// or https:// (Azure SAS URL)")
+	}
+	switch cfg.SSE {
+	case "", "AES256", "aws:kms":
+	default:
+		return fmt.Errorf("--archive-sse must be AES256 or aws:kms")
+	}
+	if cfg.KMSKeyID != "" && cfg.SSE != "aws:kms" {
+		return fmt.Errorf("--archive-kms-key-id requires --archive-sse aws:kms")
+	}
+	return nil
+}
+
+// History entries of one day as JSON lines, one object per run.
+func historySegment(history []HistoryEntry, dayStart time.Time) ([]byte, int) {
+	dayEnd := dayStart.AddDate(0, 0, 1)
+	var buf bytes.Buffer
+	count := 0
+	for _, entry := range history {
+		if entry.Timestamp.Before(dayStart) || !entry.Timestamp.Before(dayEnd) {
+			continue
+		}
+		line, _ := json.Marshal(entry)
+		buf.Write(line)
+		buf.WriteByte('\n')
+		count++
+	}
+	return buf.Bytes(), count
+}
+
+// S3 URI-encodes every byte but the unreserved ones, keeping '/' in paths.
+func s3Escape(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// Signs req with AWS Signature Version 4, covering host and every header
+// already set on it. payloadHash is the hex SHA-256 of the body.
+func signV4(req *http.Request, payloadHash string, creds awsCredentials, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3Escape(req.URL.Path),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// Builds the PUT request for object name under the archive URL's prefix.
+func newArchiveRequest(cfg ArchiveConfig, name string, body []byte, now time.Time) (*http.Request, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive URL: %v", err)
+	}
+	key := path.Join(strings.TrimPrefix(u.Path, "/"), name)
+
+	if u.Scheme == "https" {
+		// Azure Blob: the SAS token in the query authorises the upload
+		blobURL := *u
+		blobURL.Path = path.Join(u.Path, name)
+		req, err := http.NewRequest("PUT", blobURL.String(), bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create HTTP request: %v", err)
+		}
+		req.Header.Set("x-ms-blob-type", "BlockBlob")
+		req.Header.Set("x-ms-version", "2021-08-06")
+		req.Header.Set("Content-Type", "application/x-ndjson")
+		return req, nil
+	}
+
+	creds, err := awsCredentialsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	region := cfg.Region
+	endpoint := cfg.Endpoint
+	var objectURL string
+	switch {
+	case endpoint != "":
+		objectURL = strings.TrimSuffix(endpoint, "/") + "/" + u.Host + "/" + key
+	case u.Scheme == "gs":
+		objectURL = "https://storage.googleapis.com/" + u.Host + "/" + key
+		region = "auto"
+	default:
+		objectURL = fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", u.Host, region, key)
+	}
+
+	req, err := http.NewRequest("PUT", objectURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if cfg.SSE != "" {
+		req.Header.Set("X-Amz-Server-Side-Encryption", cfg.SSE)
+	}
+	if cfg.KMSKeyID != "" {
+		req.Header.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", cfg.KMSKeyID)
+	}
+	sum := sha256.Sum256(body)
+	signV4(req, hex.EncodeToString(sum[:]), creds, region, "s3", now)
+	return req, nil
+}
+
+func uploadArchiveObject(cfg ArchiveConfig, name string, body []byte, now time.Time) error {
+	req, err := newArchiveRequest(cfg, name, body, now)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %v", name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("upload of %s failed: %s - %s", name, resp.Status, string(respBody))
+	}
+	return nil
+}
+
+func archiveConfig(cfg *Config) ArchiveConfig {
+	return ArchiveConfig{
+		URL:      cfg.ArchiveURL,
+		Endpoint: cfg.ArchiveEndpoint,
+		Region:   cfg.ArchiveRegion,
+		SSE:      cfg.ArchiveSSE,
+		KMSKeyID: cfg.ArchiveKMSKeyID,
+	}
+}
+
+// Uploads yesterday's history entries as <prefix>/<host>/<date>.jsonl on the
+// first run of a new day.
+func (rc *runContext) archiveHistory(now time.Time) error {
+	cfg := rc.cfg
+	archive := archiveConfig(cfg)
+	return runDailyJob(cfg.StateDir, cfg.HistoryFile, "last_archive", now, func(day time.Time, history []HistoryEntry) error {
+		segment, count := historySegment(history, day)
+		if count == 0 {
+			return nil
+		}
+		name := path.Join(rc.hostname, day.Format(rollupDayFormat)+".jsonl")
+		if err := uploadArchiveObject(archive, name, segment, now); err != nil {
+			return err
+		}
+		log.Printf("Archived %d results to %s", count, name)
+		return nil
+	})
+}
@@ -0,0 +1,76 @@
+//go:build !minimal
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCompileAssertions(t *testing.T) {
+	if _, err := compileAssertions([]string{"download >= 500 && ping < 20", "jitter < 5"}); err != nil {
+		t.Errorf("Expected valid assertions, got %v", err)
+	}
+	if _, err := compileAssertions([]string{"download * 2"}); err == nil {
+		t.Error("Expected error for a non-boolean expression")
+	}
+	if _, err := compileAssertions([]string{"latency < 20"}); err == nil {
+		t.Error("Expected error for an unknown variable")
+	}
+}
+
+func TestAssertWithRunner(t *testing.T) {
+	saved := verboseMetricLogging
+	defer func() { verboseMetricLogging = saved }()
+	runner := &MockRunner{Output: []byte(`[{"download":620.0,"upload":300.0,"ping":12.0,"jitter":1.0,"server":{"url":"http://example.com"}}]`)}
+
+	var out bytes.Buffer
+	code := assertWithRunner([]string{"--name", "site-42", "download >= 500 && ping < 20", "upload >= 500"}, runner, &out)
+	if code != 1 {
+		t.Errorf("Expected exit code 1 for a failed assertion, got %d", code)
+	}
+	var report junitTestSuites
+	if err := xml.Unmarshal(out.Bytes(), &report); err != nil {
+		t.Fatalf("Expected valid JUnit XML, got %v:\n%s", err, out.String())
+	}
+	suite := report.Suites[0]
+	if suite.Name != "site-42" || suite.Tests != 2 || suite.Failures != 1 || suite.Errors != 0 {
+		t.Errorf("Unexpected suite totals: %+v", suite)
+	}
+	if suite.Cases[0].Failure != nil {
+		t.Errorf("Expected the first assertion to pass, got %+v", suite.Cases[0].Failure)
+	}
+	if suite.Cases[1].Failure == nil || !strings.Contains(suite.Cases[1].Failure.Text, "upload=300.00") {
+		t.Errorf("Expected the second assertion to fail with the measured values, got %+v", suite.Cases[1].Failure)
+	}
+
+	out.Reset()
+	if code := assertWithRunner([]string{"ping < 20"}, runner, &out); code != 0 {
+		t.Errorf("Expected exit code 0 when every assertion holds, got %d", code)
+	}
+}
+
+func TestAssertWithRunner_TestFails(t *testing.T) {
+	saved := verboseMetricLogging
+	defer func() { verboseMetricLogging = saved }()
+	var out bytes.Buffer
+	code := assertWithRunner([]string{"download >= 500"}, &MockRunner{Err: fmt.Errorf("server unreachable")}, &out)
+	if code != 1 {
+		t.Errorf("Expected exit code 1, got %d", code)
+	}
+	if !strings.Contains(out.String(), `<error message="speed test failed">`) {
+		t.Errorf("Expected the assertion reported as an error, got:\n%s", out.String())
+	}
+}
+
+func TestAssertWithRunner_Usage(t *testing.T) {
+	if code := assertWithRunner(nil, &MockRunner{}, &bytes.Buffer{}); code != 2 {
+		t.Errorf("Expected exit code 2 without assertions, got %d", code)
+	}
+	if code := assertWithRunner([]string{"download >>= 5"}, &MockRunner{}, &bytes.Buffer{}); code != 2 {
+		t.Errorf("Expected exit code 2 for an invalid assertion, got %d", code)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// One line of the audit log. Actor is the caller's address for API
+// requests, or what caused the action (SIGHUP, the maintenance file).
+type auditEvent struct {
+	Time   time.Time `json:"time"`
+	Host   string    `json:"host"`
+	Action string    `json:"action"`
+	Actor  string    `json:"actor"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+// Append-only JSON lines file of configuration and trigger actions for
+// compliance reviews. Lines are only ever added; rotating or shipping the
+// file is left to the platform's tooling. A nil *auditLog records nothing.
+type auditLog struct {
+	mu       sync.Mutex
+	path     string
+	hostname string
+}
+
+func newAuditLog(path, hostname string) *auditLog {
+	if path == "" {
+		return nil
+	}
+	return &auditLog{path: path, hostname: hostname}
+}
+
+func (a *auditLog) record(action, actor, detail string) {
+	if a == nil {
+		return
+	}
+	line, _ := json.Marshal(auditEvent{Time: clock.Now().UTC(), Host: a.hostname, Action: action, Actor: actor, Detail: detail})
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	f, err := os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		log.Printf("WARNING: Failed to write audit log: %v", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		log.Printf("WARNING: Failed to write audit log: %v", err)
+	}
+}
+
+// Identity of an HTTP caller: its address, the proxy chain when the request
+// was forwarded, and the API token it used.
+func requestActor(r *http.Request) string {
+	actor := r.RemoteAddr
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		actor = fmt.Sprintf("%s (forwarded for %s)", r.RemoteAddr, forwarded)
+	}
+	if name := tokenName(r.Context()); name != "" {
+		actor = name + " from " + actor
+	}
+	return actor
+}
+
+// Names of the credential settings that differ between old and cfg. Values
+// are never logged.
+func changedCredentials(old, cfg *Config) string {
+	var changed []string
+	for _, c := range []struct {
+		name       string
+		old, value string
+	}{
+		{"url", old.URL, cfg.URL},
+		{"username", old.Username, cfg.Username},
+		{"password", old.Password, cfg.Password},
+		{"remote-write-config", old.RemoteWriteConfig, cfg.RemoteWriteConfig},
+		{"loki-password", old.LokiPassword, cfg.LokiPassword},
+		{"redis-password", old.RedisPassword, cfg.RedisPassword},
+		{"delivery-password", old.DeliveryPassword, cfg.DeliveryPassword},
+		{"snmp-community", old.SNMPCommunity, cfg.SNMPCommunity},
+		{"snmp-auth-pass", old.SNMPAuthPass, cfg.SNMPAuthPass},
+		{"snmp-priv-pass", old.SNMPPrivPass, cfg.SNMPPrivPass},
+		{"api-token", old.APITokens.String(), cfg.APITokens.String()},
+		{"webhook-secret", old.WebhookSecret, cfg.WebhookSecret},
+	} {
+		if c.old != c.value {
+			changed = append(changed, c.name)
+		}
+	}
+	return strings.Join(changed, ", ")
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func readAuditLog(t *testing.T, path string) []auditEvent {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %v", err)
+	}
+	defer f.Close()
+	var events []auditEvent
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var event auditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("Invalid audit line %q: %v", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestAuditLog(t *testing.T) {
+	useFakeClock(t, time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	os.WriteFile(path, []byte(`{"action":"earlier"}`+"\n"), 0600)
+
+	audit := newAuditLog(path, "host1")
+	audit.record("config_reload", "SIGHUP", "config abc")
+	audit.record("run_triggered", "10.0.0.5:51234", "run 42")
+
+	events := readAuditLog(t, path)
+	if len(events) != 3 || events[0].Action != "earlier" {
+		t.Fatalf("Expected two lines appended to the existing one, got %+v", events)
+	}
+	want := auditEvent{Time: clock.Now().UTC(), Host: "host1", Action: "run_triggered", Actor: "10.0.0.5:51234", Detail: "run 42"}
+	if events[2] != want {
+		t.Errorf("Expected %+v, got %+v", want, events[2])
+	}
+
+	var disabled *auditLog = newAuditLog("", "host1")
+	disabled.record("config_reload", "SIGHUP", "")
+}
+
+func TestRequestActor(t *testing.T) {
+	r := httptest.NewRequest("POST", "/-/reload", nil)
+	r.RemoteAddr = "10.0.0.1:4000"
+	if got := requestActor(r); got != "10.0.0.1:4000" {
+		t.Errorf("Expected the remote address, got %q", got)
+	}
+	r.Header.Set("X-Forwarded-For", "192.0.2.7")
+	if got := requestActor(r); got != "10.0.0.1:4000 (forwarded for 192.0.2.7)" {
+		t.Errorf("Expected the forwarded address too, got %q", got)
+	}
+}
+
+func TestChangedCredentials(t *testing.T) {
+	old := &Config{URL: "https://a/push", Username: "1", Password: "p"}
+	cfg := &Config{URL: "https://a/push", Username: "1", Password: "q", SNMPCommunity: "private"}
+	if got := changedCredentials(old, cfg); got != "password, snmp-community" {
+		t.Errorf("Expected password and snmp-community, got %q", got)
+	}
+	if got := changedCredentials(old, old); got != "" {
+		t.Errorf("Expected no changes, got %q", got)
+	}
+}
+
+func TestRunOnce_AuditsMaintenanceChanges(t *testing.T) {
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "maintenance")
+	auditPath := filepath.Join(dir, "audit.jsonl")
+	runner := &MockRunner{Output: []byte(`[{"download":100.0,"upload":50.0,"ping":10.0,"jitter":1.0,"server":{"url":"http://example.com"}}]`)}
+	rc := newTestRunContext(t, "", runner)
+	rc.cfg.MaintenanceFile = marker
+	rc.audit = newAuditLog(auditPath, "host1")
+
+	rc.runOnce(context.Background())
+	os.WriteFile(marker, []byte("ISP works on the fiber"), 0600)
+	rc.runOnce(context.Background())
+	rc.runOnce(context.Background())
+	os.Remove(marker)
+	rc.runOnce(context.Background())
+
+	events := readAuditLog(t, auditPath)
+	if len(events) != 2 {
+		t.Fatalf("Expected one event per change, got %+v", events)
+	}
+	if events[0].Action != "maintenance_on" || events[0].Actor != marker || events[0].Detail != "ISP works on the fiber" {
+		t.Errorf("Unexpected event: %+v", events[0])
+	}
+	if events[1].Action != "maintenance_off" {
+		t.Errorf("Unexpected event: %+v", events[1])
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// Minimum relative download improvement required to keep doubling streams.
+const autoTuneMinGain = 0.1
+
+// Doubles the number of concurrent streams until download throughput stops
+// improving by at least minGain, so slow DSL lines settle on a handful of
+// streams and fast fiber gets as many as it needs. Returns the best result
+// and the stream count that produced it.
+func autoTuneConcurrency(runner CommandRunner, cliPath, localJSONPath string, serverID *int, opts TestOptions, maxStreams int, minGain float64) (*LibrespeedResult, int, error) {
+	if maxStreams < 1 {
+		return nil, 0, fmt.Errorf("max concurrency must be at least 1")
+	}
+
+	var best *LibrespeedResult
+	bestStreams := 0
+	for streams := 1; streams <= maxStreams; streams *= 2 {
+		opts.Concurrent = streams
+		log.Printf("Auto-concurrency: testing with %d streams", streams)
+		result, err := runLibrespeedWithOptions(runner, cliPath, localJSONPath, serverID, opts)
+		if err != nil {
+			if best != nil {
+				log.Printf("Auto-concurrency: run with %d streams failed, keeping %d streams: %v", streams, bestStreams, err)
+				break
+			}
+			return nil, 0, err
+		}
+
+		if best != nil && result.Download < best.Download*(1+minGain) {
+			log.Printf("Auto-concurrency: %d streams gave %.2f Mbps (best %.2f Mbps), plateau reached",
+				streams, result.Download, best.Download)
+			if result.Download > best.Download {
+				best, bestStreams = result, streams
+			}
+			break
+		}
+		best, bestStreams = result, streams
+	}
+
+	log.Printf("Auto-concurrency: selected %d streams (%.2f Mbps download)", bestStreams, best.Download)
+	return best, bestStreams, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Returns a scripted download figure per --concurrent value.
+type ConcurrencyRunner struct {
+	Downloads map[string]float64
+	Calls     []string
+	FailAt    string
+}
+
+func (r *ConcurrencyRunner) Run(name string, args ...string) ([]byte, error) {
+	streams := ""
+	for i, arg := range args {
+		if arg == "--concurrent" && i+1 < len(args) {
+			streams = args[i+1]
+		}
+	}
+	r.Calls = append(r.Calls, streams)
+	if streams == r.FailAt {
+		return nil, fmt.Errorf("command failed")
+	}
+	return []byte(fmt.Sprintf(`[{"download":%f,"upload":10,"ping":5,"jitter":1,"server":{"url":"http://example.com"}}]`, r.Downloads[streams])), nil
+}
+
+func TestAutoTuneConcurrency_StopsAtPlateau(t *testing.T) {
+	runner := &ConcurrencyRunner{Downloads: map[string]float64{"1": 100, "2": 190, "4": 350, "8": 360, "16": 365}}
+	result, streams, err := autoTuneConcurrency(runner, "librespeed-cli.exe", "", nil, TestOptions{}, 16, 0.1)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if streams != 8 {
+		t.Errorf("Expected 8 streams (best within plateau), got %d", streams)
+	}
+	if result.Download != 360 {
+		t.Errorf("Expected download 360, got %f", result.Download)
+	}
+	if strings.Join(runner.Calls, ",") != "1,2,4,8" {
+		t.Errorf("Expected ramp 1,2,4,8, got %s", strings.Join(runner.Calls, ","))
+	}
+}
+
+func TestAutoTuneConcurrency_RespectsMax(t *testing.T) {
+	runner := &ConcurrencyRunner{Downloads: map[string]float64{"1": 100, "2": 200, "4": 400}}
+	_, streams, err := autoTuneConcurrency(runner, "librespeed-cli.exe", "", nil, TestOptions{}, 4, 0.1)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if streams != 4 {
+		t.Errorf("Expected 4 streams, got %d", streams)
+	}
+}
+
+func TestAutoTuneConcurrency_FailureAfterProgress(t *testing.T) {
+	runner := &ConcurrencyRunner{Downloads: map[string]float64{"1": 100, "2": 200}, FailAt: "4"}
+	result, streams, err := autoTuneConcurrency(runner, "librespeed-cli.exe", "", nil, TestOptions{}, 16, 0.1)
+	if err != nil {
+		t.Fatalf("Expected no error when earlier runs succeeded, got %v", err)
+	}
+	if streams != 2 || result.Download != 200 {
+		t.Errorf("Expected to keep 2 streams at 200 Mbps, got %d streams at %f", streams, result.Download)
+	}
+}
+
+func TestAutoTuneConcurrency_FirstRunFails(t *testing.T) {
+	runner := &ConcurrencyRunner{FailAt: "1"}
+	_, _, err := autoTuneConcurrency(runner, "librespeed-cli.exe", "", nil, TestOptions{}, 16, 0.1)
+	if err == nil {
+		t.Error("Expected error when the first run fails, got nil")
+	}
+}
+
+func TestAutoTuneConcurrency_InvalidMax(t *testing.T) {
+	runner := &ConcurrencyRunner{}
+	_, _, err := autoTuneConcurrency(runner, "librespeed-cli.exe", "", nil, TestOptions{}, 0, 0.1)
+	if err == nil {
+		t.Error("Expected error for max concurrency 0, got nil")
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import "time"
+
+// Firing to wait for after failures consecutive failed tests. The first
+// failure keeps the schedule; after that 1, 3, 7, ... firings are skipped,
+// as long as the wait stays within max (0 disables the backoff).
+func backoffNext(schedule Schedule, next time.Time, failures int, max time.Duration, now time.Time) time.Time {
+	if failures < 2 || max <= 0 {
+		return next
+	}
+	skips := 1<<min(failures-1, 30) - 1
+	for i := 0; i < skips; i++ {
+		later := schedule.Next(next)
+		if later.Sub(now) > max {
+			break
+		}
+		next = later
+	}
+	return next
+}
+
+// Consecutive failed tests and the configured backoff cap, for the daemon
+// loop.
+func (rc *runContext) failureBackoff() (int, time.Duration) {
+	rc.runMu.Lock()
+	defer rc.runMu.Unlock()
+	return rc.testFailures, rc.cfg.FailureBackoffMax
+}
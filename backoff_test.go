@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestBackoffNext(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	schedule := intervalSchedule{start: start, interval: 5 * time.Minute}
+	now := start.Add(time.Minute)
+	next := schedule.Next(now)
+
+	tests := []struct {
+		name     string
+		failures int
+		max      time.Duration
+		want     time.Time
+	}{
+		{"no failures", 0, time.Hour, next},
+		{"one failure keeps the schedule", 1, time.Hour, next},
+		{"two failures skip one firing", 2, time.Hour, next.Add(5 * time.Minute)},
+		{"three failures skip three", 3, time.Hour, next.Add(15 * time.Minute)},
+		{"capped at max", 10, 30 * time.Minute, start.Add(30 * time.Minute)},
+		{"disabled", 10, 0, next},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := backoffNext(schedule, next, tt.failures, tt.max, now); !got.Equal(tt.want) {
+				t.Errorf("Expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRunOnce_CountsConsecutiveFailures(t *testing.T) {
+	runner := &MockRunner{Err: fmt.Errorf("server unreachable")}
+	rc := newTestRunContext(t, "", runner)
+	rc.cache = &resultCache{}
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		rc.runOnce(ctx)
+	}
+	if failures, _ := rc.failureBackoff(); failures != 2 {
+		t.Errorf("Expected 2 consecutive failures, got %d", failures)
+	}
+	if rc.cache.failures != 2 {
+		t.Errorf("Expected the cache to report 2 failures, got %d", rc.cache.failures)
+	}
+
+	runner.Err = nil
+	runner.Output = []byte(`[{"download":100.0,"upload":50.0,"ping":10.0,"jitter":1.0,"server":{"url":"http://example.com"}}]`)
+	rc.cfg.DryRun = true
+	dryRunOutput = io.Discard
+	defer func() { dryRunOutput = os.Stdout }()
+	if err := rc.runOnce(ctx); err != nil {
+		t.Fatalf("Expected the run to succeed, got %v", err)
+	}
+	if failures, _ := rc.failureBackoff(); failures != 0 {
+		t.Errorf("Expected failures reset after a successful test, got %d", failures)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Public holidays per year and country, from Nager.Date; replaced by tests.
+var holidayAPIURL = "https://date.nager.at/api/v3/PublicHolidays/%d/%s"
+
+// A failed holiday lookup is retried after this long; until then the year
+// only has the --holiday-file dates.
+const holidayRetry = time.Hour
+
+// Tells business days from weekends and holidays, for --off-day-interval and
+// the business_day label. Days are taken in the site's --timezone, or the
+// host's.
+type businessCalendar struct {
+	weekend  map[time.Weekday]bool
+	holidays map[string]string
+	// --holiday-country, e.g. DE, and the subdivision of DE-BY
+	country, region string
+	location        *time.Location
+
+	mu     sync.Mutex
+	loaded map[int]bool
+	failed map[int]time.Time
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// Comma-separated day names such as sat,sun or fri,sat; empty for none.
+func parseWeekendDays(spec string) (map[time.Weekday]bool, error) {
+	days := map[time.Weekday]bool{}
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		day, ok := weekdayNames[name[:min(3, len(name))]]
+		if !ok {
+			return nil, fmt.Errorf("invalid --weekend-days entry %q, expected day names such as sat,sun", name)
+		}
+		days[day] = true
+	}
+	return days, nil
+}
+
+// One date per line, YYYY-MM-DD optionally followed by a name; blank lines
+// and lines starting with # are skipped.
+func loadHolidayFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open --holiday-file: %v", err)
+	}
+	defer f.Close()
+	holidays := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		date, name, _ := strings.Cut(text, " ")
+		if _, err := time.Parse(time.DateOnly, date); err != nil {
+			return nil, fmt.Errorf("invalid date %q on line %d of --holiday-file, expected YYYY-MM-DD", date, line)
+		}
+		holidays[date] = strings.TrimSpace(name)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read --holiday-file: %v", err)
+	}
+	return holidays, nil
+}
+
+// nil unless the configuration uses business days at all.
+func newBusinessCalendar(cfg *Config, location *time.Location) (*businessCalendar, error) {
+	if cfg.OffDayInterval == 0 && cfg.OffDaySchedule == "" && cfg.HolidayFile == "" && cfg.HolidayCountry == "" {
+		return nil, nil
+	}
+	weekend, err := parseWeekendDays(cfg.WeekendDays)
+	if err != nil {
+		return nil, err
+	}
+	holidays := map[string]string{}
+	if cfg.HolidayFile != "" {
+		holidays, err = loadHolidayFile(cfg.HolidayFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	country, region := "", ""
+	if cfg.HolidayCountry != "" {
+		code := strings.ToUpper(cfg.HolidayCountry)
+		country, _, _ = strings.Cut(code, "-")
+		if len(country) != 2 {
+			return nil, fmt.Errorf("invalid --holiday-country %q, expected an ISO 3166 code such as DE or DE-BY", cfg.HolidayCountry)
+		}
+		if code != country {
+			region = code
+		}
+	}
+	if location == nil {
+		location = time.Local
+	}
+	return &businessCalendar{
+		weekend:  weekend,
+		holidays: holidays,
+		country:  country,
+		region:   region,
+		location: location,
+		loaded:   map[int]bool{},
+		failed:   map[int]time.Time{},
+	}, nil
+}
+
+// Adds the public holidays of year to the calendar, unless they were
+// looked up recently.
+func (c *businessCalendar) fetchHolidays(year int) {
+	if c.country == "" || c.loaded[year] {
+		return
+	}
+	if last, ok := c.failed[year]; ok && clock.Now().Sub(last) < holidayRetry {
+		return
+	}
+	url := fmt.Sprintf(holidayAPIURL, year, c.country)
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		log.Printf("WARNING: Failed to look up %d public holidays: %v", year, err)
+		c.failed[year] = clock.Now()
+		return
+	}
+	defer resp.Body.Close()
+	var holidays []struct {
+		Date      string   `json:"date"`
+		LocalName string   `json:"localName"`
+		Global    bool     `json:"global"`
+		Counties  []string `json:"counties"`
+	}
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("status %s", resp.Status)
+	} else {
+		err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&holidays)
+	}
+	if err != nil {
+		log.Printf("WARNING: Failed to look up %d public holidays: %v", year, err)
+		c.failed[year] = clock.Now()
+		return
+	}
+	added := 0
+	for _, h := range holidays {
+		if !h.Global && !slices.Contains(h.Counties, c.region) {
+			continue
+		}
+		if _, ok := c.holidays[h.Date]; !ok {
+			c.holidays[h.Date] = h.LocalName
+			added++
+		}
+	}
+	where := c.country
+	if c.region != "" {
+		where = c.region
+	}
+	log.Printf("Loaded %d public holidays for %d in %s", added, year, where)
+	c.loaded[year] = true
+}
+
+func (c *businessCalendar) businessDay(t time.Time) bool {
+	local := t.In(c.location)
+	if c.weekend[local.Weekday()] {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fetchHolidays(local.Year())
+	_, holiday := c.holidays[local.Format(time.DateOnly)]
+	return !holiday
+}
+
+// business_day="true" or "false" for results at t.
+func (c *businessCalendar) labels(t time.Time) map[string]string {
+	if c == nil {
+		return nil
+	}
+	return map[string]string{"business_day": fmt.Sprint(c.businessDay(t))}
+}
+
+// Bounds the search for the next firing of a schedule on the right kind of
+// day, e.g. a 15 minute schedule across a long holiday.
+const maxCalendarSteps = 100000
+
+// Fires business on business days and offDay on weekends and holidays.
+type calendarSchedule struct {
+	business, offDay Schedule
+	calendar         *businessCalendar
+}
+
+func (s calendarSchedule) Next(after time.Time) time.Time {
+	next := func(schedule Schedule, businessDay bool) time.Time {
+		t := schedule.Next(after)
+		for i := 0; i < maxCalendarSteps && s.calendar.businessDay(t) != businessDay; i++ {
+			t = schedule.Next(t)
+		}
+		return t
+	}
+	business, offDay := next(s.business, true), next(s.offDay, false)
+	if offDay.Before(business) {
+		return offDay
+	}
+	return business
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseWeekendDays(t *testing.T) {
+	days, err := parseWeekendDays("Fri, saturday")
+	if err != nil || len(days) != 2 || !days[time.Friday] || !days[time.Saturday] {
+		t.Errorf("Expected Friday and Saturday, got %v, %v", days, err)
+	}
+	if days, err := parseWeekendDays(""); err != nil || len(days) != 0 {
+		t.Errorf("Expected no weekend, got %v, %v", days, err)
+	}
+	if _, err := parseWeekendDays("sat,holiday"); err == nil {
+		t.Error("Expected an unknown day to be rejected")
+	}
+}
+
+func TestLoadHolidayFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "holidays.txt")
+	os.WriteFile(path, []byte("# Company holidays\n2024-12-24 Christmas Eve\n\n2024-12-31\n"), 0644)
+	holidays, err := loadHolidayFile(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(holidays) != 2 || holidays["2024-12-24"] != "Christmas Eve" {
+		t.Errorf("Expected two holidays, got %v", holidays)
+	}
+
+	os.WriteFile(path, []byte("2024-12-24\n24.12.2024\n"), 0644)
+	if _, err := loadHolidayFile(path); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Expected the bad line to be reported, got %v", err)
+	}
+}
+
+func testCalendar(t *testing.T, cfg *Config) *businessCalendar {
+	t.Helper()
+	if cfg.WeekendDays == "" {
+		cfg.WeekendDays = "sat,sun"
+	}
+	calendar, err := newBusinessCalendar(cfg, time.UTC)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return calendar
+}
+
+func TestCalendarSchedule(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "holidays.txt")
+	os.WriteFile(path, []byte("2024-03-04 Company day\n"), 0644)
+	calendar := testCalendar(t, &Config{HolidayFile: path})
+
+	// Friday 2024-03-01; hourly on business days, every 6 hours otherwise
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	schedule := calendarSchedule{
+		business: intervalSchedule{start: start, interval: time.Hour},
+		offDay:   intervalSchedule{start: start, interval: 6 * time.Hour},
+		calendar: calendar,
+	}
+	for _, tc := range []struct{ after, want time.Time }{
+		{start.Add(9*time.Hour + 30*time.Minute), start.Add(10 * time.Hour)},
+		{start.Add(23*time.Hour + 30*time.Minute), start.Add(24 * time.Hour)},
+		{start.Add(24*time.Hour + time.Minute), start.Add(30 * time.Hour)},
+		// Sunday evening to the Monday holiday, then Tuesday business hours
+		{start.Add(2*24*time.Hour + 19*time.Hour), start.Add(3 * 24 * time.Hour)},
+		{start.Add(3*24*time.Hour + 19*time.Hour), start.Add(4 * 24 * time.Hour)},
+		{start.Add(4*24*time.Hour + 30*time.Minute), start.Add(4*24*time.Hour + time.Hour)},
+	} {
+		if got := schedule.Next(tc.after); !got.Equal(tc.want) {
+			t.Errorf("Next(%s): expected %s, got %s", tc.after.Format(time.RFC3339), tc.want.Format(time.RFC3339), got.Format(time.RFC3339))
+		}
+	}
+}
+
+func TestBusinessCalendar_HolidayAPI(t *testing.T) {
+	useFakeClock(t, time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	requests := 0
+	status := http.StatusInternalServerError
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/2024/DE" {
+			t.Errorf("Unexpected request %s", r.URL.Path)
+		}
+		w.WriteHeader(status)
+		fmt.Fprint(w, `[{"date":"2024-05-01","localName":"Tag der Arbeit","global":true,"counties":null},
+			{"date":"2024-05-30","localName":"Fronleichnam","global":false,"counties":["DE-BW","DE-BY"]},
+			{"date":"2024-10-31","localName":"Reformationstag","global":false,"counties":["DE-BB"]}]`)
+	}))
+	defer server.Close()
+	saved := holidayAPIURL
+	holidayAPIURL = server.URL + "/%d/%s"
+	defer func() { holidayAPIURL = saved }()
+
+	calendar := testCalendar(t, &Config{HolidayCountry: "de-by"})
+	mayDay := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if !calendar.businessDay(mayDay) || !calendar.businessDay(mayDay) || requests != 1 {
+		t.Errorf("Expected a failed lookup to count as no holidays and not be retried at once, got %d requests", requests)
+	}
+
+	status = http.StatusOK
+	clock.(*FakeClock).Advance(holidayRetry)
+	for date, want := range map[string]bool{"2024-05-01": false, "2024-05-30": false, "2024-10-31": true, "2024-05-02": true} {
+		day, _ := time.Parse(time.DateOnly, date)
+		if got := calendar.businessDay(day); got != want {
+			t.Errorf("%s: expected business day %v, got %v", date, want, got)
+		}
+	}
+	if requests != 2 {
+		t.Errorf("Expected the year to be fetched once more, got %d requests", requests)
+	}
+	if calendar.labels(mayDay)["business_day"] != "false" {
+		t.Errorf("Expected business_day=false, got %v", calendar.labels(mayDay))
+	}
+}
+
+func TestConfigure_OffDaySchedule(t *testing.T) {
+	configure := func(args ...string) (*runContext, error) {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		cfg := &Config{}
+		cfg.RegisterFlags(fs)
+		if err := fs.Parse(append([]string{"--url", "http://localhost:9090/api/v1/write", "--username", "u", "--password", "p", "--state-dir", t.TempDir()}, args...)); err != nil {
+			t.Fatal(err)
+		}
+		rc := &runContext{flags: fs}
+		return rc, rc.configure(cfg, explicitFlags(fs))
+	}
+	if _, err := configure("--off-day-interval", "4h"); err == nil || !strings.Contains(err.Error(), "require --interval") {
+		t.Errorf("Expected --off-day-interval to need a schedule, got %v", err)
+	}
+	if _, err := configure("--interval", "1h", "--off-day-interval", "4h", "--off-day-schedule", "@daily"); err == nil {
+		t.Error("Expected both off-day flags to be rejected")
+	}
+	rc, err := configure("--schedule", "*/15 8-18 * * *", "--off-day-schedule", "0 */4 * * *", "--weekend-days", "fri,sat")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if s, ok := rc.schedule.(calendarSchedule); !ok || s.calendar == nil || !s.calendar.weekend[time.Friday] {
+		t.Errorf("Expected a calendar schedule with a Friday weekend, got %#v", rc.schedule)
+	}
+}
+
+func TestRun_BusinessDayLabel(t *testing.T) {
+	useFakeClock(t, time.Date(2024, 3, 2, 12, 0, 0, 0, time.UTC))
+	runner := &MockRunner{Output: []byte(`[{"download":100.0,"upload":50.0,"ping":10.0,"jitter":1.0,"server":{"url":"http://example.com"}}]`)}
+	rc := newTestRunContext(t, "", runner)
+	rc.cfg.URL = ""
+	rc.cache = &resultCache{}
+	rc.calendar = testCalendar(t, &Config{OffDayInterval: 4 * time.Hour})
+
+	if err := rc.runOnce(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, ts := range rc.cache.series {
+		if getLabelValue(ts.Labels, "business_day") != "false" {
+			t.Errorf("Expected a Saturday result to carry business_day=false, got %v", ts.Labels)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// A campaign is a named, time-bounded burst of testing (e.g. during an ISP
+// dispute). Runs inside the window are labelled with the campaign name; once
+// it ends, runs go back to being unlabelled without any config change.
+type Campaign struct {
+	Name  string
+	Until time.Time
+}
+
+func parseCampaign(name, until string) (*Campaign, error) {
+	if name == "" {
+		if until != "" {
+			return nil, fmt.Errorf("--campaign-until requires --campaign")
+		}
+		return nil, nil
+	}
+	if until == "" {
+		return nil, fmt.Errorf("--campaign requires --campaign-until")
+	}
+	end, err := time.Parse(time.RFC3339, until)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --campaign-until (expected RFC 3339, e.g. 2024-05-01T18:00:00Z): %v", err)
+	}
+	return &Campaign{Name: name, Until: end}, nil
+}
+
+func (c *Campaign) activeAt(t time.Time) bool {
+	return c != nil && t.Before(c.Until)
+}
+
+func (c *Campaign) labels(t time.Time) map[string]string {
+	if !c.activeAt(t) {
+		if c != nil {
+			log.Printf("Campaign %s ended at %s, not labelling this run", c.Name, c.Until.Format(time.RFC3339))
+		}
+		return nil
+	}
+	return map[string]string{"campaign": c.Name}
+}
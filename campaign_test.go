@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func TestAddLabels_SortsLabels(t *testing.T) {
+	series := []*prompb.TimeSeries{createTimeSeries("test_metric", 1, 1, "http://server", "host1")}
+	addLabels(series, map[string]string{"campaign": "dispute"})
+
+	labels := series[0].Labels
+	if len(labels) != 4 {
+		t.Fatalf("Expected 4 labels, got %d", len(labels))
+	}
+	for i := 1; i < len(labels); i++ {
+		if labels[i-1].Name > labels[i].Name {
+			t.Errorf("Labels not sorted: %v", labels)
+		}
+	}
+	if getLabelValue(labels, "campaign") != "dispute" {
+		t.Errorf("Expected campaign label, got %v", labels)
+	}
+}
+
+func TestAddLabels_Empty(t *testing.T) {
+	series := []*prompb.TimeSeries{createTimeSeries("test_metric", 1, 1, "http://server", "host1")}
+	addLabels(series, nil)
+	if len(series[0].Labels) != 3 {
+		t.Errorf("Expected labels unchanged, got %v", series[0].Labels)
+	}
+}
+
+func TestParseCampaign(t *testing.T) {
+	testCases := []struct {
+		name, campaign, until string
+		shouldError           bool
+		expectNil             bool
+	}{
+		{"No campaign", "", "", false, true},
+		{"Valid", "dispute", "2024-05-01T18:00:00Z", false, false},
+		{"Missing end", "dispute", "", true, false},
+		{"End without name", "", "2024-05-01T18:00:00Z", true, false},
+		{"Bad end", "dispute", "tomorrow", true, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			campaign, err := parseCampaign(tc.campaign, tc.until)
+			if (err != nil) != tc.shouldError {
+				t.Fatalf("Expected error=%v, got %v", tc.shouldError, err)
+			}
+			if !tc.shouldError && (campaign == nil) != tc.expectNil {
+				t.Errorf("Expected nil=%v, got %+v", tc.expectNil, campaign)
+			}
+		})
+	}
+}
+
+func TestCampaignLabels(t *testing.T) {
+	end := time.Date(2024, 5, 1, 18, 0, 0, 0, time.UTC)
+	campaign := &Campaign{Name: "dispute", Until: end}
+
+	labels := campaign.labels(end.Add(-time.Minute))
+	if labels["campaign"] != "dispute" {
+		t.Errorf("Expected campaign label during window, got %v", labels)
+	}
+	if labels := campaign.labels(end); labels != nil {
+		t.Errorf("Expected no labels after the campaign ended, got %v", labels)
+	}
+
+	var none *Campaign
+	if labels := none.labels(end); labels != nil {
+		t.Errorf("Expected no labels without a campaign, got %v", labels)
+	}
+}
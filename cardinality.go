@@ -0,0 +1,128 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// Counts the distinct label sets pushed per metric name over the last
+// cardinalityWindow and drops series that would take a metric over the
+// limit, so a flag that adds per-server or per-target labels can't multiply
+// the series a backend bills for.
+type cardinalityGuard struct {
+	limit int
+
+	mu sync.Mutex
+	// Label sets per metric and when each was last pushed
+	seen   map[string]map[string]time.Time
+	warned map[string]bool
+}
+
+// Backends bill for series active within about a day; a label set not pushed
+// for longer no longer counts against the limit.
+const cardinalityWindow = 24 * time.Hour
+
+// nil without a limit. What previous has seen is kept, so a reload doesn't
+// reset the budget.
+func newCardinalityGuard(limit int, previous *cardinalityGuard) *cardinalityGuard {
+	if limit <= 0 {
+		return nil
+	}
+	g := &cardinalityGuard{limit: limit, seen: map[string]map[string]time.Time{}, warned: map[string]bool{}}
+	if previous != nil {
+		previous.mu.Lock()
+		g.seen, g.warned = previous.seen, previous.warned
+		previous.mu.Unlock()
+	}
+	return g
+}
+
+// Labels other than __name__, which are kept sorted.
+func labelSetKey(labels []prompb.Label) string {
+	var b strings.Builder
+	for _, l := range labels {
+		if l.Name == "__name__" {
+			continue
+		}
+		b.WriteString(l.Name)
+		b.WriteByte(0xff)
+		b.WriteString(l.Value)
+		b.WriteByte(0xff)
+	}
+	return b.String()
+}
+
+// Returns the series within the limit and how many were dropped per metric.
+// Label sets seen within the window always pass.
+func (g *cardinalityGuard) filter(series []*prompb.TimeSeries, now time.Time) ([]*prompb.TimeSeries, map[string]int) {
+	if g == nil {
+		return series, nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.expire(now)
+	kept := series[:0:0]
+	dropped := map[string]int{}
+	for _, ts := range series {
+		name := getLabelValue(ts.Labels, "__name__")
+		key := labelSetKey(ts.Labels)
+		sets := g.seen[name]
+		if sets == nil {
+			sets = map[string]time.Time{}
+			g.seen[name] = sets
+		}
+		if _, ok := sets[key]; !ok && len(sets) >= g.limit {
+			if !g.warned[name] {
+				log.Printf("WARNING: %s reached --cardinality-limit of %d label sets, dropping its new series", name, g.limit)
+				g.warned[name] = true
+			}
+			dropped[name]++
+			continue
+		}
+		sets[key] = now
+		kept = append(kept, ts)
+	}
+	return kept, dropped
+}
+
+// Forgets label sets not pushed within the window, warning again the next
+// time a metric reaches the limit.
+func (g *cardinalityGuard) expire(now time.Time) {
+	cutoff := now.Add(-cardinalityWindow)
+	for name, sets := range g.seen {
+		for key, last := range sets {
+			if last.Before(cutoff) {
+				delete(sets, key)
+			}
+		}
+		if len(sets) < g.limit {
+			delete(g.warned, name)
+		}
+		if len(sets) == 0 {
+			delete(g.seen, name)
+		}
+	}
+}
+
+// Applies --cardinality-limit to a labelled payload, adding
+// librespeed_cardinality_dropped_series for each metric that lost series.
+func (rc *runContext) limitCardinality(series []*prompb.TimeSeries, now int64) []*prompb.TimeSeries {
+	kept, dropped := rc.cardinality.filter(series, time.UnixMilli(now))
+	metrics := make([]string, 0, len(dropped))
+	for metric := range dropped {
+		metrics = append(metrics, metric)
+	}
+	sort.Strings(metrics)
+	for _, metric := range metrics {
+		ts := createTimeSeries("librespeed_cardinality_dropped_series", float64(dropped[metric]), now, "", rc.hostname)
+		addLabels([]*prompb.TimeSeries{ts}, map[string]string{"metric": metric})
+		addLabels([]*prompb.TimeSeries{ts}, rc.extraLabels)
+		kept = append(kept, ts)
+	}
+	return kept
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func serverSeriesFor(metric string, ids ...int) []*prompb.TimeSeries {
+	var series []*prompb.TimeSeries
+	for _, id := range ids {
+		ts := createTimeSeries(metric, 1, 0, "http://example.com", "host1")
+		addLabels([]*prompb.TimeSeries{ts}, map[string]string{"server_id": strconv.Itoa(id)})
+		series = append(series, ts)
+	}
+	return series
+}
+
+func TestCardinalityGuard(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	guard := newCardinalityGuard(2, nil)
+	kept, dropped := guard.filter(serverSeriesFor("librespeed_download_mbps", 1, 2, 3), now)
+	if len(kept) != 2 || dropped["librespeed_download_mbps"] != 1 {
+		t.Errorf("Expected 2 kept and 1 dropped, got %d and %v", len(kept), dropped)
+	}
+
+	// Known label sets still pass, other metrics have their own budget
+	series := append(serverSeriesFor("librespeed_download_mbps", 2, 4), serverSeriesFor("librespeed_upload_mbps", 4)...)
+	kept, dropped = guard.filter(series, now)
+	if len(kept) != 2 || getLabelValue(kept[0].Labels, "server_id") != "2" || dropped["librespeed_download_mbps"] != 1 {
+		t.Errorf("Expected server 2 and the upload series to pass, got %d kept and %v", len(kept), dropped)
+	}
+
+	reloaded := newCardinalityGuard(2, guard)
+	if _, dropped := reloaded.filter(serverSeriesFor("librespeed_download_mbps", 5), now); dropped["librespeed_download_mbps"] != 1 {
+		t.Error("Expected the budget to survive a reload")
+	}
+
+	if newCardinalityGuard(0, guard) != nil {
+		t.Error("Expected no guard without a limit")
+	}
+	var none *cardinalityGuard
+	if kept, dropped := none.filter(series, now); len(kept) != len(series) || dropped != nil {
+		t.Error("Expected a nil guard to pass everything")
+	}
+}
+
+func TestCardinalityGuard_SlidingWindow(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	guard := newCardinalityGuard(2, nil)
+	guard.filter(serverSeriesFor("librespeed_download_mbps", 1), now)
+	guard.filter(serverSeriesFor("librespeed_download_mbps", 2), now.Add(12*time.Hour))
+	if _, dropped := guard.filter(serverSeriesFor("librespeed_download_mbps", 3), now.Add(20*time.Hour)); dropped["librespeed_download_mbps"] != 1 {
+		t.Error("Expected server 3 dropped while servers 1 and 2 are within the window")
+	}
+
+	// Server 1 was last pushed over a day ago and no longer counts
+	kept, dropped := guard.filter(serverSeriesFor("librespeed_download_mbps", 3), now.Add(25*time.Hour))
+	if len(kept) != 1 || len(dropped) != 0 {
+		t.Errorf("Expected server 3 to take server 1's place, got %d kept and %v", len(kept), dropped)
+	}
+	if _, dropped := guard.filter(serverSeriesFor("librespeed_download_mbps", 1), now.Add(25*time.Hour)); dropped["librespeed_download_mbps"] != 1 {
+		t.Error("Expected server 1 dropped as a new label set once the budget is used")
+	}
+}
+
+func TestLimitCardinality(t *testing.T) {
+	rc := newTestRunContext(t, "", &MockRunner{})
+	rc.extraLabels = map[string]string{"agent_id": "abc"}
+	rc.cardinality = newCardinalityGuard(1, nil)
+
+	series := rc.limitCardinality(serverSeriesFor("librespeed_download_mbps", 1, 2, 3), 1000)
+	if len(series) != 2 {
+		t.Fatalf("Expected one result and one drop marker, got %d series", len(series))
+	}
+	marker := series[1]
+	if marker.Labels[0].Value != "librespeed_cardinality_dropped_series" || marker.Samples[0].Value != 2 {
+		t.Errorf("Expected 2 dropped series reported, got %v", marker)
+	}
+	if getLabelValue(marker.Labels, "metric") != "librespeed_download_mbps" || getLabelValue(marker.Labels, "agent_id") != "abc" {
+		t.Errorf("Expected metric and agent_id labels, got %v", marker.Labels)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// A file on a CDN edge downloaded every cycle next to the librespeed test,
+// e.g. cloudflare=https://speed.cloudflare.com/__down?bytes=25000000. Slow
+// results for one CDN but not the others point at that network's peering.
+type cdnTarget struct {
+	Name string
+	URL  string
+}
+
+type cdnResult struct {
+	Download float64 // Mbps, measured from the first byte to the last
+	TTFB     float64 // ms
+	Bytes    int64
+	TCP      *tcpStats
+}
+
+func parseCDNTargets(definitions []string) ([]cdnTarget, error) {
+	var targets []cdnTarget
+	seen := map[string]bool{}
+	for _, def := range definitions {
+		name, rawURL, ok := strings.Cut(def, "=")
+		if !ok || !metricNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("CDN target must be name=url, got %q", def)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate CDN target %s", name)
+		}
+		seen[name] = true
+		u, err := url.Parse(rawURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("CDN target %s needs an http(s) URL, got %q", name, rawURL)
+		}
+		targets = append(targets, cdnTarget{Name: name, URL: rawURL})
+	}
+	return targets, nil
+}
+
+// Downloads the target once over a fresh connection. Hitting timeout after
+// the first byte still gives a result over the part that was transferred.
+// With tcpInfo the socket's TCP_INFO is sampled as data arrives; the last
+// sample is taken before the transport closes the connection at EOF.
+func measureCDNTarget(ctx context.Context, client *http.Client, target cdnTarget, timeout time.Duration, tcpInfo bool) (cdnResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	// The HTTP/3 transport keeps connections open; close them so the next
+	// target starts from a new handshake too
+	defer client.CloseIdleConnections()
+	var raw syscall.RawConn
+	if tcpInfo {
+		ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) {
+				raw, _ = rawTCPConn(info.Conn)
+			},
+		})
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", target.URL, nil)
+	if err != nil {
+		return cdnResult{}, fmt.Errorf("failed to create HTTP request: %v", err)
+	}
+	req.Header.Set("Cache-Control", "no-cache")
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return cdnResult{}, fmt.Errorf("failed to download %s: %v", target.Name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return cdnResult{}, fmt.Errorf("download of %s failed: %s", target.Name, resp.Status)
+	}
+
+	buf := make([]byte, 64*1024)
+	var firstByte time.Time
+	var bytes int64
+	var stats *tcpStats
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 && bytes == 0 {
+			firstByte = time.Now()
+		}
+		bytes += int64(n)
+		if raw != nil && err == nil {
+			if sample, err := readTCPStats(raw); err == nil {
+				stats = sample
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if bytes > 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				break
+			}
+			return cdnResult{}, fmt.Errorf("failed to download %s: %v", target.Name, err)
+		}
+	}
+	if bytes == 0 {
+		return cdnResult{}, fmt.Errorf("download of %s returned no data", target.Name)
+	}
+
+	result := cdnResult{TTFB: float64(firstByte.Sub(start).Microseconds()) / 1000, Bytes: bytes, TCP: stats}
+	if elapsed := time.Since(firstByte).Seconds(); elapsed > 0 {
+		result.Download = float64(bytes) * 8 / elapsed / 1e6
+	}
+	return result, nil
+}
+
+// Client for target downloads. dscp marks the connections unless it is
+// negative.
+func newCDNClient(systemProxy bool, dscp int) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// Every target gets its own connection, and compressed bytes would
+	// overstate the line rate
+	transport.DisableKeepAlives = true
+	transport.DisableCompression = true
+	if dscp >= 0 {
+		transport.DialContext = dscpDialer(dscp).DialContext
+	}
+	client := &http.Client{Transport: transport}
+	if systemProxy {
+		useSystemProxy(client)
+	}
+	return client
+}
+
+// Measures every target in turn and returns librespeed_cdn_* series
+// labelled with target. A failed target reports librespeed_cdn_up 0 only.
+func cdnSeries(ctx context.Context, client *http.Client, targets []cdnTarget, timeout time.Duration, tcpInfo bool, now int64, instance string) []*prompb.TimeSeries {
+	var series []*prompb.TimeSeries
+	for _, target := range targets {
+		if ctx.Err() != nil {
+			break
+		}
+		result, err := measureCDNTarget(ctx, client, target, timeout, tcpInfo)
+		var targetSeries []*prompb.TimeSeries
+		if err != nil {
+			log.Printf("WARNING: %v", err)
+			targetSeries = append(targetSeries, createTimeSeries("librespeed_cdn_up", 0, now, target.URL, instance))
+		} else {
+			log.Printf("CDN target %s: %.2f Mbps, first byte after %.0f ms", target.Name, result.Download, result.TTFB)
+			targetSeries = append(targetSeries,
+				createTimeSeries("librespeed_cdn_up", 1, now, target.URL, instance),
+				createTimeSeries("librespeed_cdn_download_mbps", result.Download, now, target.URL, instance),
+				createTimeSeries("librespeed_cdn_ttfb_ms", result.TTFB, now, target.URL, instance),
+			)
+			if result.TCP != nil {
+				targetSeries = append(targetSeries,
+					createTimeSeries("librespeed_cdn_tcp_rtt_ms", result.TCP.RTT, now, target.URL, instance),
+					createTimeSeries("librespeed_cdn_tcp_rcv_rtt_ms", result.TCP.RcvRTT, now, target.URL, instance),
+					createTimeSeries("librespeed_cdn_tcp_out_of_order_packets", float64(result.TCP.OutOfOrder), now, target.URL, instance),
+				)
+			}
+		}
+		addLabels(targetSeries, map[string]string{"target": target.Name})
+		series = append(series, targetSeries...)
+	}
+	return series
+}
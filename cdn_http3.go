@@ -0,0 +1,15 @@
+//go:build !minimal
+
+package main
+
+import (
+	"net/http"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// Experimental client that downloads targets over HTTP/3 (QUIC). Proxies
+// and DSCP marking do not apply to it.
+func newCDNHTTP3Client() *http.Client {
+	return &http.Client{Transport: &http3.Transport{DisableCompression: true}}
+}
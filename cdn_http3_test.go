@@ -0,0 +1,48 @@
+//go:build !minimal
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+func TestMeasureCDNTarget_HTTP3(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 3 {
+			t.Errorf("Expected an HTTP/3 request, got %s", r.Proto)
+		}
+		w.Write([]byte(strings.Repeat("x", 1<<20)))
+	})
+	// Borrow httptest's certificate and the pool that trusts it
+	tlsServer := httptest.NewTLSServer(handler)
+	defer tlsServer.Close()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("UDP not available: %v", err)
+	}
+	server := &http3.Server{Handler: handler, TLSConfig: http3.ConfigureTLSConfig(tlsServer.TLS.Clone())}
+	go server.Serve(conn)
+	defer server.Close()
+
+	client := newCDNHTTP3Client()
+	client.Transport.(*http3.Transport).TLSClientConfig = &tls.Config{
+		RootCAs: tlsServer.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs,
+	}
+	target := cdnTarget{Name: "edge", URL: "https://" + conn.LocalAddr().String() + "/"}
+	result, err := measureCDNTarget(context.Background(), client, target, 5*time.Second, false)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if result.Bytes != 1<<20 || result.Download <= 0 {
+		t.Errorf("Expected the whole file with a throughput, got %+v", result)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCDNTargets(t *testing.T) {
+	targets, err := parseCDNTargets([]string{
+		"cloudflare=https://speed.cloudflare.com/__down?bytes=25000000",
+		"fastly=http://example.global.ssl.fastly.net/10MB.bin",
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(targets) != 2 || targets[0].Name != "cloudflare" || targets[0].URL != "https://speed.cloudflare.com/__down?bytes=25000000" {
+		t.Errorf("Unexpected targets: %+v", targets)
+	}
+
+	for _, defs := range [][]string{
+		{"https://speed.cloudflare.com/"},
+		{"bad name=https://example.com/"},
+		{"akamai=ftp://example.com/file"},
+		{"akamai=https://a.example/", "akamai=https://b.example/"},
+	} {
+		if _, err := parseCDNTargets(defs); err == nil {
+			t.Errorf("Expected error for %q", defs)
+		}
+	}
+}
+
+func TestMeasureCDNTarget(t *testing.T) {
+	payload := strings.Repeat("x", 1<<20)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(payload))
+	}))
+	defer server.Close()
+
+	result, err := measureCDNTarget(context.Background(), server.Client(), cdnTarget{Name: "edge", URL: server.URL}, 5*time.Second, false)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if result.Bytes != int64(len(payload)) {
+		t.Errorf("Expected %d bytes, got %d", len(payload), result.Bytes)
+	}
+	if result.Download <= 0 || result.TTFB < 0 {
+		t.Errorf("Expected positive throughput, got %+v", result)
+	}
+}
+
+func TestMeasureCDNTarget_TimeoutKeepsPartialResult(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", 4096)))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	result, err := measureCDNTarget(context.Background(), server.Client(), cdnTarget{Name: "edge", URL: server.URL}, 200*time.Millisecond, false)
+	if err != nil {
+		t.Fatalf("Expected partial result, got %v", err)
+	}
+	if result.Bytes != 4096 {
+		t.Errorf("Expected 4096 bytes, got %d", result.Bytes)
+	}
+}
+
+func TestCDNSeries(t *testing.T) {
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Cache-Control") != "no-cache" {
+			t.Errorf("Expected Cache-Control: no-cache, got %q", r.Header.Get("Cache-Control"))
+		}
+		w.Write([]byte(strings.Repeat("x", 65536)))
+	}))
+	defer ok.Close()
+	missing := httptest.NewServer(http.NotFoundHandler())
+	defer missing.Close()
+
+	targets := []cdnTarget{{Name: "cloudflare", URL: ok.URL}, {Name: "akamai", URL: missing.URL}}
+	series := cdnSeries(context.Background(), newCDNClient(false, -1), targets, 5*time.Second, false, 1000, "host1")
+
+	got := map[string]float64{}
+	for _, ts := range series {
+		got[getLabelValue(ts.Labels, "target")+" "+getLabelValue(ts.Labels, "__name__")] = ts.Samples[0].Value
+	}
+	if got["cloudflare librespeed_cdn_up"] != 1 || got["cloudflare librespeed_cdn_download_mbps"] <= 0 {
+		t.Errorf("Expected a result for cloudflare, got %v", got)
+	}
+	if _, has := got["cloudflare librespeed_cdn_ttfb_ms"]; !has {
+		t.Errorf("Expected librespeed_cdn_ttfb_ms for cloudflare, got %v", got)
+	}
+	if value, has := got["akamai librespeed_cdn_up"]; !has || value != 0 {
+		t.Errorf("Expected librespeed_cdn_up 0 for akamai, got %v", got)
+	}
+	if _, has := got["akamai librespeed_cdn_download_mbps"]; has {
+		t.Errorf("Expected no throughput for a failed target, got %v", got)
+	}
+}
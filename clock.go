@@ -0,0 +1,36 @@
+package main
+
+import "time"
+
+// Clock is the source of wall-clock time for time-based behaviour (retry
+// backoff, timestamps, run duration, waits between runs) so it can be driven
+// deterministically in tests. Latency measurements of individual requests
+// still use the time package directly.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	// After and NewTimer are for waits that must also end when a context is
+	// cancelled.
+	After(d time.Duration) <-chan time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is the part of *time.Timer that Clock users need.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) NewTimer(d time.Duration) Timer         { return realTimer{time.NewTimer(d)} }
+
+type realTimer struct{ t *time.Timer }
+
+func (t realTimer) C() <-chan time.Time { return t.t.C }
+func (t realTimer) Stop() bool          { return t.t.Stop() }
+
+var clock Clock = realClock{}
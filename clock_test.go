@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// FakeClock only moves when Sleep, a wait or Advance is called, and records
+// every sleep and wait so tests can assert on backoff schedules. Waits fire
+// at once, advancing the clock like Sleep.
+type FakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	Sleeps []time.Duration
+}
+
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) Sleep(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Sleeps = append(c.Sleeps, d)
+	c.now = c.now.Add(d)
+}
+
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.Sleep(d)
+	fired := make(chan time.Time, 1)
+	fired <- c.Now()
+	return fired
+}
+
+func (c *FakeClock) NewTimer(d time.Duration) Timer { return fakeTimer{c.After(d)} }
+
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+type fakeTimer struct{ c <-chan time.Time }
+
+func (t fakeTimer) C() <-chan time.Time { return t.c }
+func (t fakeTimer) Stop() bool          { return false }
+
+func useFakeClock(t *testing.T, start time.Time) *FakeClock {
+	t.Helper()
+	fake := NewFakeClock(start)
+	original := clock
+	clock = fake
+	t.Cleanup(func() { clock = original })
+	return fake
+}
+
+func TestSendWithRetry_BackoffSchedule(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	fake := useFakeClock(t, start)
+
+	calls := 0
+	err := sendWithRetry(context.Background(), func() error {
+		calls++
+		return fmt.Errorf("503 Service Unavailable")
+	}, 3)
+	if err == nil {
+		t.Fatal("Expected error after exhausting retries, got nil")
+	}
+	if calls != 4 {
+		t.Errorf("Expected 4 attempts, got %d", calls)
+	}
+	if len(fake.Sleeps) != 3 {
+		t.Fatalf("Expected 3 sleeps, got %v", fake.Sleeps)
+	}
+	// Exponential backoff with jitter: attempt n waits [2^(n-1), 2^n) seconds
+	for i, d := range fake.Sleeps {
+		min := time.Duration(1<<i) * time.Second
+		if d < min || d >= 2*min {
+			t.Errorf("Sleep %d: expected [%v, %v), got %v", i+1, min, 2*min, d)
+		}
+	}
+	if elapsed := fake.Now().Sub(start); elapsed < 7*time.Second {
+		t.Errorf("Expected the fake clock to advance by the sleeps, got %v", elapsed)
+	}
+}
+
+func TestSendWithRetry_NoSleepOnSuccess(t *testing.T) {
+	fake := useFakeClock(t, time.Now())
+	if err := sendWithRetry(context.Background(), func() error { return nil }, 3); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(fake.Sleeps) != 0 {
+		t.Errorf("Expected no sleeps, got %v", fake.Sleeps)
+	}
+}
+
+func TestSendWithRetry_StopsAtDeadline(t *testing.T) {
+	fake := useFakeClock(t, time.Now())
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	calls := 0
+	err := sendWithRetry(ctx, func() error {
+		calls++
+		return fmt.Errorf("503 Service Unavailable")
+	}, 3)
+	if err == nil || !strings.Contains(err.Error(), "failed after 1 attempts") {
+		t.Errorf("Expected to give up after one attempt, got %v", err)
+	}
+	if calls != 1 || len(fake.Sleeps) != 0 {
+		t.Errorf("Expected no backoff past the deadline, got %d calls and sleeps %v", calls, fake.Sleeps)
+	}
+
+	cancel()
+	if err := sendWithRetry(ctx, func() error { calls++; return nil }, 3); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if calls != 1 {
+		t.Error("Expected no attempt with a cancelled context")
+	}
+}
+
+// Cancels after a number of runs so a daemon loop on the fake clock ends.
+type countdownRunner struct {
+	MockRunner
+	after  int
+	cancel context.CancelFunc
+}
+
+func (r *countdownRunner) Run(name string, args ...string) ([]byte, error) {
+	out, err := r.MockRunner.Run(name, args...)
+	if r.Calls == r.after {
+		r.cancel()
+	}
+	return out, err
+}
+
+func TestDaemon_WaitsOnClock(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	fake := useFakeClock(t, start)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	runner := &countdownRunner{after: 3, cancel: cancel}
+	runner.Output = []byte(`[{"download":100.0,"upload":50.0,"ping":10.0,"jitter":1.0,"server":{"url":"http://example.com"}}]`)
+	rc := newTestRunContext(t, "", runner)
+	rc.cfg.URL = ""
+	rc.schedule, _ = newSchedule(15*time.Minute, "", start)
+
+	rc.daemon(ctx, true)
+	if runner.Calls != 3 {
+		t.Errorf("Expected 3 runs, got %d", runner.Calls)
+	}
+	// Fake waits fire at once, so the loop may go round again before it
+	// notices the shutdown; every wait is still one interval on the clock
+	if len(fake.Sleeps) < 2 {
+		t.Errorf("Expected a wait between the runs, got sleeps %v", fake.Sleeps)
+	}
+	for _, d := range fake.Sleeps {
+		if d != 15*time.Minute {
+			t.Errorf("Expected 15m waits, got sleeps %v", fake.Sleeps)
+			break
+		}
+	}
+	if elapsed := fake.Now().Sub(start); elapsed < 30*time.Minute {
+		t.Errorf("Expected the fake clock to advance by the waits, got %v", elapsed)
+	}
+}
+
+func TestWaitJitter_WaitsOnClock(t *testing.T) {
+	fake := useFakeClock(t, time.Now())
+	original := jitterFunc
+	defer func() { jitterFunc = original }()
+	jitterFunc = func(max time.Duration) time.Duration { return 3 * time.Minute }
+
+	if !waitJitter(context.Background(), 5*time.Minute) {
+		t.Error("Expected the wait to complete")
+	}
+	if len(fake.Sleeps) != 1 || fake.Sleeps[0] != 3*time.Minute {
+		t.Errorf("Expected a 3m wait on the clock, got %v", fake.Sleeps)
+	}
+}
+
+func TestSendWithRetry_CancelledDuringBackoff(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	original := retryDelayFunc
+	defer func() { retryDelayFunc = original }()
+	retryDelayFunc = func(attempt int) time.Duration { return time.Hour }
+
+	calls := 0
+	done := make(chan error, 1)
+	go func() {
+		done <- sendWithRetry(ctx, func() error {
+			calls++
+			return fmt.Errorf("503 Service Unavailable")
+		}, 3)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "503") {
+			t.Errorf("Expected the last error once cancelled, got %v", err)
+		}
+		if calls != 1 {
+			t.Errorf("Expected no attempt after the cancel, got %d calls", calls)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the backoff to end when the context is cancelled")
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// Default write paths, used when a target URL is given without one.
+var receiverPaths = map[string]string{
+	"prometheus":      "/api/v1/write",
+	"mimir":           "/api/v1/push",
+	"victoriametrics": "/api/v1/write",
+	"thanos":          "/api/v1/receive",
+}
+
+type conformanceTarget struct {
+	Kind string
+	URL  string
+}
+
+func parseConformanceTarget(value string) (conformanceTarget, error) {
+	kind, rawURL, ok := strings.Cut(value, "=")
+	if !ok {
+		return conformanceTarget{}, fmt.Errorf("target must be kind=url, got %q", value)
+	}
+	path, known := receiverPaths[kind]
+	if !known {
+		return conformanceTarget{}, fmt.Errorf("unknown receiver kind %q (supported: prometheus, mimir, victoriametrics, thanos)", kind)
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return conformanceTarget{}, fmt.Errorf("invalid URL for %s: %q", kind, rawURL)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = path
+	}
+	return conformanceTarget{Kind: kind, URL: u.String()}, nil
+}
+
+type conformanceCase struct {
+	Name   string
+	Series func(ts int64) []*prompb.TimeSeries
+}
+
+// Payload shapes the exporter actually produces. Metric names are prefixed so
+// runs against a shared receiver don't pollute real dashboards.
+var conformanceCases = []conformanceCase{
+	{"basic", func(ts int64) []*prompb.TimeSeries {
+		var series []*prompb.TimeSeries
+		for _, name := range []string{"download_mbps", "upload_mbps", "ping_ms", "jitter_ms"} {
+			series = append(series, createTimeSeries("librespeed_conformance_"+name, 42.5, ts, "http://speedtest.example.com", "conformance"))
+		}
+		return series
+	}},
+	{"extra-labels", func(ts int64) []*prompb.TimeSeries {
+		series := []*prompb.TimeSeries{createTimeSeries("librespeed_conformance_labels", 1, ts, "http://speedtest.example.com", "conformance")}
+		addLabels(series, map[string]string{
+			"agent_id": "00000000-0000-4000-8000-000000000000",
+			"campaign": "isp-dispute",
+			"reason":   "disk/memory ünïcode",
+		})
+		return series
+	}},
+	{"batch", func(ts int64) []*prompb.TimeSeries {
+		var series []*prompb.TimeSeries
+		for i := 0; i < 200; i++ {
+			series = append(series, createTimeSeries("librespeed_conformance_batch", float64(i), ts, fmt.Sprintf("http://server%d.example.com", i), "conformance"))
+		}
+		return series
+	}},
+}
+
+// Sends every case to the target and returns the failures by case name.
+func checkConformance(target conformanceTarget, format, username, password string, now time.Time) map[string]error {
+	failures := map[string]error{}
+	encoder, err := newEncoder(format)
+	if err != nil {
+		failures["encoder"] = err
+		return failures
+	}
+	for i, c := range conformanceCases {
+		// Distinct timestamps so receivers don't reject repeats as duplicates
+		ts := now.UnixMilli() + int64(i)
+		if err := sendWithEncoder(context.Background(), encoder, target.URL, username, password, c.Series(ts)); err != nil {
+			failures[c.Name] = err
+		}
+	}
+	return failures
+}
+
+// conformance pushes representative payloads to one or more receivers and
+// reports which ones accept them.
+func runConformance(args []string) int {
+	fs := flag.NewFlagSet("conformance", flag.ContinueOnError)
+	var targets []conformanceTarget
+	fs.Func("target", "Receiver to test as kind=url (prometheus, mimir, victoriametrics, thanos); repeatable", func(value string) error {
+		target, err := parseConformanceTarget(value)
+		if err != nil {
+			return err
+		}
+		targets = append(targets, target)
+		return nil
+	})
+	format := fs.String("format", "remote-write", "Wire format to test: remote-write or influx")
+	username := fs.String("username", "", "Basic auth username (optional)")
+	password := fs.String("password", "", "Basic auth password (optional)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if len(targets) == 0 {
+		fmt.Fprintln(os.Stderr, "ERROR: at least one --target is required")
+		return 2
+	}
+
+	verboseMetricLogging = false
+	failed := 0
+	for _, target := range targets {
+		failures := checkConformance(target, *format, *username, *password, clock.Now())
+		for _, c := range conformanceCases {
+			if err, ok := failures[c.Name]; ok {
+				fmt.Printf("FAIL %-16s %-13s %v\n", target.Kind, c.Name, err)
+				failed++
+			} else {
+				fmt.Printf("PASS %-16s %s\n", target.Kind, c.Name)
+			}
+		}
+		if err, ok := failures["encoder"]; ok {
+			fmt.Printf("FAIL %-16s %v\n", target.Kind, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
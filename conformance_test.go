@@ -0,0 +1,108 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// Mimics the validation real receivers apply: protocol headers, snappy
+// protobuf body, a metric name and sorted, unique label names per series.
+func mockReceiver(t *testing.T, requireVersionHeader bool) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "snappy" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+			http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+			return
+		}
+		if requireVersionHeader && r.Header.Get("X-Prometheus-Remote-Write-Version") == "" {
+			http.Error(w, "missing remote write version", http.StatusBadRequest)
+			return
+		}
+		compressed, _ := io.ReadAll(r.Body)
+		data, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			http.Error(w, "bad snappy", http.StatusBadRequest)
+			return
+		}
+		var req prompb.WriteRequest
+		if err := req.Unmarshal(data); err != nil {
+			http.Error(w, "bad protobuf", http.StatusBadRequest)
+			return
+		}
+		for _, ts := range req.Timeseries {
+			names := make([]string, 0, len(ts.Labels))
+			for _, l := range ts.Labels {
+				names = append(names, l.Name)
+			}
+			if !sort.StringsAreSorted(names) || getLabelValue(ts.Labels, "__name__") == "" {
+				http.Error(w, "out of order labels", http.StatusBadRequest)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestParseConformanceTarget(t *testing.T) {
+	target, err := parseConformanceTarget("mimir=http://mimir:9009")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if target.URL != "http://mimir:9009/api/v1/push" {
+		t.Errorf("Expected default Mimir path, got %s", target.URL)
+	}
+
+	target, err = parseConformanceTarget("thanos=http://thanos:19291/custom")
+	if err != nil || target.URL != "http://thanos:19291/custom" {
+		t.Errorf("Expected explicit path to be kept, got %+v, %v", target, err)
+	}
+
+	for _, bad := range []string{"http://no-kind", "influxdb=http://x", "prometheus=not a url"} {
+		if _, err := parseConformanceTarget(bad); err == nil {
+			t.Errorf("Expected error for %q, got nil", bad)
+		}
+	}
+}
+
+func TestCheckConformance_MockReceivers(t *testing.T) {
+	verboseMetricLogging = false
+	defer func() { verboseMetricLogging = true }()
+
+	for _, kind := range []string{"prometheus", "mimir", "victoriametrics", "thanos"} {
+		t.Run(kind, func(t *testing.T) {
+			server := mockReceiver(t, kind != "victoriametrics")
+			target, err := parseConformanceTarget(kind + "=" + server.URL)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if failures := checkConformance(target, "remote-write", "", "", time.Now()); len(failures) != 0 {
+				t.Errorf("Expected all cases to pass, got %v", failures)
+			}
+		})
+	}
+}
+
+func TestCheckConformance_ReportsRejection(t *testing.T) {
+	verboseMetricLogging = false
+	defer func() { verboseMetricLogging = true }()
+
+	server := mockReceiver(t, true)
+	target, _ := parseConformanceTarget("prometheus=" + server.URL)
+	failures := checkConformance(target, "influx", "", "", time.Now())
+	if len(failures) != len(conformanceCases) {
+		t.Fatalf("Expected every case to fail with the wrong format, got %v", failures)
+	}
+	if !strings.Contains(failures["basic"].Error(), "415") {
+		t.Errorf("Expected 415 from the receiver, got %v", failures["basic"])
+	}
+}
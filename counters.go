@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+type InterfaceCounters struct {
+	RxBytes uint64
+	TxBytes uint64
+}
+
+// Reads the OS-wide byte counters for all non-loopback interfaces. Windows
+// has no procfs, so we fall back to parsing `netstat -e`; the BSDs and macOS
+// report per-interface bytes with `netstat -ibn`.
+func readInterfaceCounters(runner CommandRunner) (*InterfaceCounters, error) {
+	switch runtime.GOOS {
+	case "linux":
+		f, err := os.Open("/proc/net/dev")
+		if err != nil {
+			return nil, fmt.Errorf("failed to open /proc/net/dev: %v", err)
+		}
+		defer f.Close()
+		return parseProcNetDev(f)
+	case "windows":
+		output, err := runner.Run("netstat", "-e")
+		if err != nil {
+			return nil, fmt.Errorf("failed to run netstat: %v", err)
+		}
+		return parseNetstatE(output)
+	case "freebsd", "openbsd", "netbsd", "dragonfly", "darwin":
+		output, err := runner.Run("netstat", "-ibn")
+		if err != nil {
+			return nil, fmt.Errorf("failed to run netstat: %v", err)
+		}
+		return parseNetstatIbn(output)
+	default:
+		return nil, fmt.Errorf("interface counters are not supported on %s", runtime.GOOS)
+	}
+}
+
+func parseProcNetDev(r io.Reader) (*InterfaceCounters, error) {
+	counters := &InterfaceCounters{}
+	found := false
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		name, stats, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		name = strings.TrimSpace(name)
+		if name == "lo" {
+			continue
+		}
+		fields := strings.Fields(stats)
+		if len(fields) < 9 {
+			return nil, fmt.Errorf("unexpected /proc/net/dev line for %s", name)
+		}
+		rx, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid receive bytes for %s: %v", name, err)
+		}
+		tx, err := strconv.ParseUint(fields[8], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid transmit bytes for %s: %v", name, err)
+		}
+		counters.RxBytes += rx
+		counters.TxBytes += tx
+		found = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read interface counters: %v", err)
+	}
+	if !found {
+		return nil, fmt.Errorf("no network interfaces found")
+	}
+	return counters, nil
+}
+
+func parseNetstatE(output []byte) (*InterfaceCounters, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 || fields[0] != "Bytes" {
+			continue
+		}
+		rx, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid received bytes: %v", err)
+		}
+		tx, err := strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sent bytes: %v", err)
+		}
+		return &InterfaceCounters{RxBytes: rx, TxBytes: tx}, nil
+	}
+	return nil, fmt.Errorf("no Bytes line in netstat output")
+}
+
+// Sums Ibytes/Obytes over the link-level rows of BSD `netstat -ibn`. Each
+// interface also has a row per address with the same counters, so only
+// <Link...> rows are counted. Column positions come from the header since
+// FreeBSD and OpenBSD differ; a row with an empty Address is one field short.
+func parseNetstatIbn(output []byte) (*InterfaceCounters, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	if !scanner.Scan() {
+		return nil, fmt.Errorf("empty netstat output")
+	}
+	header := strings.Fields(scanner.Text())
+	column := map[string]int{}
+	for i, name := range header {
+		column[name] = i
+	}
+	rxCol, rxOK := column["Ibytes"]
+	txCol, txOK := column["Obytes"]
+	netCol, netOK := column["Network"]
+	addrCol, addrOK := column["Address"]
+	if !rxOK || !txOK || !netOK || !addrOK {
+		return nil, fmt.Errorf("unexpected netstat header %q", scanner.Text())
+	}
+
+	counters := &InterfaceCounters{}
+	found := false
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == len(header)-1 {
+			fields = append(fields[:addrCol], append([]string{""}, fields[addrCol:]...)...)
+		}
+		if len(fields) < len(header) || !strings.HasPrefix(fields[netCol], "<Link") {
+			continue
+		}
+		name := strings.TrimSuffix(fields[0], "*")
+		if strings.HasPrefix(name, "lo") {
+			continue
+		}
+		rx, err := strconv.ParseUint(fields[rxCol], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid receive bytes for %s: %v", name, err)
+		}
+		tx, err := strconv.ParseUint(fields[txCol], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid transmit bytes for %s: %v", name, err)
+		}
+		counters.RxBytes += rx
+		counters.TxBytes += tx
+		found = true
+	}
+	if !found {
+		return nil, fmt.Errorf("no network interfaces found")
+	}
+	return counters, nil
+}
+
+// Relative difference between what the interfaces saw and what the CLI claims
+// to have transferred. Other traffic on the host makes small positive values
+// normal; large values in either direction point at a proxy or a CLI bug.
+func counterDiscrepancy(interfaceBytes, cliBytes uint64) float64 {
+	if cliBytes == 0 {
+		return 0
+	}
+	return math.Abs(float64(interfaceBytes)-float64(cliBytes)) / float64(cliBytes)
+}
+
+func checkInterfaceCounters(before, after *InterfaceCounters, result *LibrespeedResult, threshold float64) (rxDiscrepancy, txDiscrepancy float64) {
+	// Counters can wrap or reset (e.g. adapter reconnect) mid-test
+	if after.RxBytes < before.RxBytes || after.TxBytes < before.TxBytes {
+		log.Println("WARNING: Interface counters went backwards during the test, skipping cross-check")
+		return 0, 0
+	}
+	rxDelta := after.RxBytes - before.RxBytes
+	txDelta := after.TxBytes - before.TxBytes
+
+	rxDiscrepancy = counterDiscrepancy(rxDelta, result.BytesReceived)
+	txDiscrepancy = counterDiscrepancy(txDelta, result.BytesSent)
+	log.Printf("Interface counters - Received: %d bytes (CLI: %d), Sent: %d bytes (CLI: %d)",
+		rxDelta, result.BytesReceived, txDelta, result.BytesSent)
+
+	if rxDiscrepancy > threshold {
+		log.Printf("WARNING: Download bytes diverge from interface counters by %.0f%%", rxDiscrepancy*100)
+	}
+	if txDiscrepancy > threshold {
+		log.Printf("WARNING: Upload bytes diverge from interface counters by %.0f%%", txDiscrepancy*100)
+	}
+	return rxDiscrepancy, txDiscrepancy
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+)
+
+func TestParseProcNetDev(t *testing.T) {
+	content := `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo: 1490747     423    0    0    0     0          0         0  1490747     423    0    0    0     0       0          0
+  eth0: 1000     10    0    0    0     0          0         0  500     5    0    0    0     0       0          0
+ wlan0: 2000     20    0    0    0     0          0         0  700     7    0    0    0     0       0          0
+`
+	counters, err := parseProcNetDev(strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if counters.RxBytes != 3000 {
+		t.Errorf("Expected 3000 received bytes (loopback excluded), got %d", counters.RxBytes)
+	}
+	if counters.TxBytes != 1200 {
+		t.Errorf("Expected 1200 sent bytes (loopback excluded), got %d", counters.TxBytes)
+	}
+}
+
+func TestParseProcNetDev_NoInterfaces(t *testing.T) {
+	content := `Inter-|   Receive
+    lo: 1490747     423    0    0    0     0          0         0  1490747     423    0    0    0     0       0          0
+`
+	_, err := parseProcNetDev(strings.NewReader(content))
+	if err == nil {
+		t.Error("Expected error when only loopback is present, got nil")
+	}
+}
+
+func TestParseNetstatE(t *testing.T) {
+	output := `Interface Statistics
+
+                           Received            Sent
+
+Bytes                    3996571848      1025016183
+Unicast packets             2987398         1734017
+Non-unicast packets           12345            6789
+`
+	counters, err := parseNetstatE([]byte(output))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if counters.RxBytes != 3996571848 {
+		t.Errorf("Expected 3996571848 received bytes, got %d", counters.RxBytes)
+	}
+	if counters.TxBytes != 1025016183 {
+		t.Errorf("Expected 1025016183 sent bytes, got %d", counters.TxBytes)
+	}
+}
+
+func TestParseNetstatE_Malformed(t *testing.T) {
+	_, err := parseNetstatE([]byte("garbage output"))
+	if err == nil {
+		t.Error("Expected error for output without a Bytes line, got nil")
+	}
+}
+
+func TestParseNetstatIbn(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		rx, tx uint64
+	}{
+		{
+			name: "FreeBSD",
+			output: `Name    Mtu Network       Address              Ipkts Ierrs Idrop     Ibytes    Opkts Oerrs     Obytes  Coll
+vtnet0 1500 <Link#1>      52:54:00:12:34:56   123456     0     0  987654321    65432     0   12345678     0
+vtnet0    - 10.0.0.0/24   10.0.0.5            120000     -     -  980000000    65000     -   12000000     -
+igb1*  1500 <Link#2>      00:1b:21:aa:bb:cc     1000     0     0    1000000     2000     0    2000000     0
+lo0   16384 <Link#3>      lo0                    100     0     0       5000      100     0       5000     0
+pflog0 33160 <Link#4>                              0     0     0         10        0     0         20     0
+`,
+			rx: 987654321 + 1000000 + 10,
+			tx: 12345678 + 2000000 + 20,
+		},
+		{
+			name: "OpenBSD",
+			output: `Name    Mtu   Network     Address              Ibytes      Obytes
+em0     1500  <Link>      00:0c:29:aa:bb:cc  1000000      200000
+em0     1500  192.168.1/24 192.168.1.2       1000000      200000
+lo0     32768 <Link>                             500         500
+`,
+			rx: 1000000,
+			tx: 200000,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counters, err := parseNetstatIbn([]byte(tt.output))
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if counters.RxBytes != tt.rx || counters.TxBytes != tt.tx {
+				t.Errorf("Expected %d/%d bytes, got %d/%d", tt.rx, tt.tx, counters.RxBytes, counters.TxBytes)
+			}
+		})
+	}
+
+	if _, err := parseNetstatIbn([]byte("Name Mtu Network Address Ipkts\n")); err == nil {
+		t.Error("Expected error for netstat output without byte columns, got nil")
+	}
+}
+
+func TestCheckInterfaceCounters(t *testing.T) {
+	testCases := []struct {
+		name          string
+		before, after InterfaceCounters
+		result        LibrespeedResult
+		expectedRx    float64
+		expectedTx    float64
+	}{
+		{"Matching", InterfaceCounters{0, 0}, InterfaceCounters{1000, 500}, LibrespeedResult{BytesReceived: 1000, BytesSent: 500}, 0, 0},
+		{"Proxy compression", InterfaceCounters{100, 100}, InterfaceCounters{350, 200}, LibrespeedResult{BytesReceived: 1000, BytesSent: 100}, 0.75, 0},
+		{"Counters reset", InterfaceCounters{1000, 1000}, InterfaceCounters{10, 10}, LibrespeedResult{BytesReceived: 1000, BytesSent: 1000}, 0, 0},
+		{"No CLI bytes", InterfaceCounters{0, 0}, InterfaceCounters{1000, 1000}, LibrespeedResult{}, 0, 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rx, tx := checkInterfaceCounters(&tc.before, &tc.after, &tc.result, 0.5)
+			if rx != tc.expectedRx {
+				t.Errorf("Expected rx discrepancy %f, got %f", tc.expectedRx, rx)
+			}
+			if tx != tc.expectedTx {
+				t.Errorf("Expected tx discrepancy %f, got %f", tc.expectedTx, tx)
+			}
+		})
+	}
+}
+
+func TestRunLibrespeed_ParsesTransferredBytes(t *testing.T) {
+	mockOutput := `[{"download":100.0,"upload":50.0,"ping":10.0,"jitter":1.0,"bytes_sent":12345,"bytes_received":67890,"server":{"url":"http://example.com"}}]`
+	runner := &MockRunner{Output: []byte(mockOutput)}
+	result, err := runLibrespeed(runner, "librespeed-cli.exe", "", nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if result.BytesSent != 12345 || result.BytesReceived != 67890 {
+		t.Errorf("Expected bytes 12345/67890, got %d/%d", result.BytesSent, result.BytesReceived)
+	}
+}
+
+func TestConfigure_CheckCountersSingleTest(t *testing.T) {
+	configure := func(args ...string) error {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		cfg := &Config{}
+		cfg.RegisterFlags(fs)
+		if err := fs.Parse(append([]string{"--url", "http://localhost:9090/api/v1/write", "--username", "u", "--password", "p", "--state-dir", t.TempDir()}, args...)); err != nil {
+			t.Fatal(err)
+		}
+		rc := &runContext{flags: fs}
+		return rc.configure(cfg, explicitFlags(fs))
+	}
+	if err := configure("--check-interface-counters"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	// Rejected on a single server too, as with --samples
+	if err := configure("--check-interface-counters", "--auto-concurrency"); err == nil || !strings.Contains(err.Error(), "--auto-concurrency") {
+		t.Errorf("Expected --auto-concurrency to be rejected with --check-interface-counters, got %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"path"
+	"time"
+)
+
+// File drop for partners that only take uploads: sftp://user@host[:port]/dir
+// with key authentication, or ftps://user@host[:port]/dir with explicit TLS.
+type DeliveryConfig struct {
+	URL        string
+	Mode       string
+	KeyFile    string
+	KnownHosts string
+	Password   string
+}
+
+func validateDeliveryConfig(cfg DeliveryConfig) error {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return fmt.Errorf("invalid --delivery-url: %v", err)
+	}
+	if u.Host == "" || u.User == nil || u.User.Username() == "" {
+		return fmt.Errorf("--delivery-url must include a user and host, e.g. sftp://user@host/dir")
+	}
+	switch u.Scheme {
+	case "sftp":
+		if cfg.KeyFile == "" {
+			return fmt.Errorf("sftp:// delivery requires --delivery-key")
+		}
+	case "ftps":
+	default:
+		return fmt.Errorf("--delivery-url must start with sftp:// or ftps://")
+	}
+	if cfg.Mode != "run" && cfg.Mode != "daily" {
+		return fmt.Errorf("--delivery-mode must be run or daily")
+	}
+	return nil
+}
+
+func deliveryPassword(cfg DeliveryConfig, u *url.URL) string {
+	if password, ok := u.User.Password(); ok {
+		return password
+	}
+	return cfg.Password
+}
+
+// Uploads data as name in the URL's directory. It is written as name.part
+// and renamed once complete, so a partner polling the directory never picks
+// up a half-written file.
+func deliverFile(cfg DeliveryConfig, name string, data []byte) error {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return fmt.Errorf("invalid delivery URL: %v", err)
+	}
+	target := path.Join(u.Path, name)
+	if u.Path == "" {
+		target = name
+	}
+	if u.Scheme == "ftps" {
+		return deliverFTPS(cfg, u, target, data)
+	}
+	return deliverSFTP(cfg, u, target, data)
+}
+
+func deliveryConfig(cfg *Config) DeliveryConfig {
+	return DeliveryConfig{
+		URL:        cfg.DeliveryURL,
+		Mode:       cfg.DeliveryMode,
+		KeyFile:    cfg.DeliveryKey,
+		KnownHosts: cfg.DeliveryKnownHosts,
+		Password:   cfg.DeliveryPassword,
+	}
+}
+
+// Delivers the run record as <host>_<UTC time>.json (mode run), or on the
+// first run of a day, the previous day's results as <host>_<date>.jsonl
+// (mode daily).
+func (rc *runContext) deliverResults(record RunRecord, now time.Time) error {
+	cfg := rc.cfg
+	delivery := deliveryConfig(cfg)
+
+	if delivery.Mode == "run" {
+		data, err := json.Marshal(struct {
+			Host      string    `json:"host"`
+			Timestamp time.Time `json:"timestamp"`
+			RunRecord
+		}{rc.hostname, now.UTC(), record})
+		if err != nil {
+			return fmt.Errorf("failed to marshal run record: %v", err)
+		}
+		name := fmt.Sprintf("%s_%s.json", rc.hostname, now.UTC().Format("20060102T150405Z"))
+		if err := deliverFile(delivery, name, data); err != nil {
+			return err
+		}
+		log.Printf("Delivered %s", name)
+		return nil
+	}
+
+	return runDailyJob(cfg.StateDir, cfg.HistoryFile, "last_delivery", now, func(day time.Time, history []HistoryEntry) error {
+		segment, count := historySegment(history, day)
+		if count == 0 {
+			return nil
+		}
+		name := fmt.Sprintf("%s_%s.jsonl", rc.hostname, day.Format(rollupDayFormat))
+		if err := deliverFile(delivery, name, segment); err != nil {
+			return err
+		}
+		log.Printf("Delivered %s", name)
+		return nil
+	})
+}
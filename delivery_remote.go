@@ -0,0 +1,107 @@
+//go:build !minimal
+
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"time"
+
+	"github.com/jlaffaye/ftp"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func deliverSFTP(cfg DeliveryConfig, u *url.URL, target string, data []byte) error {
+	keyData, err := os.ReadFile(cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to read SFTP key: %v", err)
+	}
+	signer, err := ssh.ParsePrivateKey(keyData)
+	if err != nil {
+		return fmt.Errorf("failed to parse SFTP key: %v", err)
+	}
+	knownHostsFile := cfg.KnownHosts
+	if knownHostsFile == "" {
+		home, _ := os.UserHomeDir()
+		knownHostsFile = path.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeyCallback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return fmt.Errorf("failed to load known hosts: %v", err)
+	}
+
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "22")
+	}
+	client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            u.User.Username(),
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         30 * time.Second,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to connect to SFTP server: %v", err)
+	}
+	defer client.Close()
+
+	sftpClient, err := sftp.NewClient(client)
+	if err != nil {
+		return fmt.Errorf("failed to start SFTP session: %v", err)
+	}
+	defer sftpClient.Close()
+
+	partial := target + ".part"
+	f, err := sftpClient.Create(partial)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", partial, err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write %s: %v", partial, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %v", partial, err)
+	}
+	// posix-rename replaces an existing file; plain SFTP rename refuses to
+	if err := sftpClient.PosixRename(partial, target); err != nil {
+		if err := sftpClient.Rename(partial, target); err != nil {
+			return fmt.Errorf("failed to rename %s: %v", partial, err)
+		}
+	}
+	return nil
+}
+
+func deliverFTPS(cfg DeliveryConfig, u *url.URL, target string, data []byte) error {
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "21")
+	}
+	conn, err := ftp.Dial(addr,
+		ftp.DialWithTimeout(30*time.Second),
+		ftp.DialWithExplicitTLS(&tls.Config{ServerName: u.Hostname()}),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to connect to FTPS server: %v", err)
+	}
+	defer conn.Quit()
+
+	if err := conn.Login(u.User.Username(), deliveryPassword(cfg, u)); err != nil {
+		return fmt.Errorf("FTPS login failed: %v", err)
+	}
+	partial := target + ".part"
+	if err := conn.Stor(partial, bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("failed to upload %s: %v", partial, err)
+	}
+	if err := conn.Rename(partial, target); err != nil {
+		return fmt.Errorf("failed to rename %s: %v", partial, err)
+	}
+	return nil
+}
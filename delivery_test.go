@@ -0,0 +1,172 @@
+//go:build !minimal
+
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Starts an SSH server on localhost that accepts clientKey and serves the
+// sftp subsystem on the real filesystem. Returns its address and a
+// known_hosts file for it.
+func startTestSFTPServer(t *testing.T, clientKey ssh.PublicKey) (string, string) {
+	t.Helper()
+	_, hostPriv, _ := ed25519.GenerateKey(rand.Reader)
+	hostSigner, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatalf("Failed to create host key: %v", err)
+	}
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) == string(clientKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, os.ErrPermission
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestSFTPConn(conn, config)
+		}
+	}()
+
+	knownHostsFile := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{ln.Addr().String()}, hostSigner.PublicKey())
+	if err := os.WriteFile(knownHostsFile, []byte(line+"\n"), 0600); err != nil {
+		t.Fatalf("Failed to write known_hosts: %v", err)
+	}
+	return ln.Addr().String(), knownHostsFile
+}
+
+func serveTestSFTPConn(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			for req := range requests {
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if ok {
+					server, err := sftp.NewServer(channel)
+					if err == nil {
+						server.Serve()
+					}
+					channel.Close()
+				}
+			}
+		}()
+	}
+}
+
+func writeTestClientKey(t *testing.T) (string, ssh.PublicKey) {
+	t.Helper()
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	signer, _ := ssh.NewSignerFromKey(priv)
+	return path, signer.PublicKey()
+}
+
+func TestDeliverResults_SFTPPerRun(t *testing.T) {
+	keyFile, publicKey := writeTestClientKey(t)
+	addr, knownHosts := startTestSFTPServer(t, publicKey)
+	outDir := t.TempDir()
+
+	rc := newTestRunContext(t, "http://127.0.0.1:1/write", &MockRunner{})
+	rc.cfg.DeliveryURL = "sftp://partner@" + addr + outDir
+	rc.cfg.DeliveryMode = "run"
+	rc.cfg.DeliveryKey = keyFile
+	rc.cfg.DeliveryKnownHosts = knownHosts
+
+	now := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	record := newRunRecord("", &LibrespeedResult{Download: 88, Server: ServerInfo{URL: "http://server"}}, nil, time.Second)
+	if err := rc.deliverResults(record, now); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(outDir, "host1_20240501T123000Z.json"))
+	if err != nil {
+		t.Fatalf("Expected delivered file, got %v", err)
+	}
+	var delivered struct {
+		Host     string  `json:"host"`
+		Download float64 `json:"download_mbps"`
+	}
+	if err := json.Unmarshal(data, &delivered); err != nil || delivered.Host != "host1" || delivered.Download != 88 {
+		t.Errorf("Unexpected file contents: %s", data)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(outDir, "*.part")); len(matches) != 0 {
+		t.Errorf("Expected no partial files left, got %v", matches)
+	}
+}
+
+func TestDeliverFile_UnknownHostKeyRejected(t *testing.T) {
+	keyFile, publicKey := writeTestClientKey(t)
+	addr, _ := startTestSFTPServer(t, publicKey)
+	emptyKnownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	os.WriteFile(emptyKnownHosts, nil, 0600)
+
+	cfg := DeliveryConfig{URL: "sftp://partner@" + addr + t.TempDir(), Mode: "run", KeyFile: keyFile, KnownHosts: emptyKnownHosts}
+	if err := deliverFile(cfg, "x.json", []byte("{}")); err == nil {
+		t.Error("Expected an unknown host key to be rejected, got nil")
+	}
+}
+
+func TestValidateDeliveryConfig(t *testing.T) {
+	valid := []DeliveryConfig{
+		{URL: "sftp://user@host/in", Mode: "run", KeyFile: "id_ed25519"},
+		{URL: "ftps://user:pw@host:990/in", Mode: "daily"},
+	}
+	for _, cfg := range valid {
+		if err := validateDeliveryConfig(cfg); err != nil {
+			t.Errorf("Expected %+v to be valid, got %v", cfg, err)
+		}
+	}
+	invalid := []DeliveryConfig{
+		{URL: "sftp://user@host/in", Mode: "run"},
+		{URL: "sftp://host/in", Mode: "run", KeyFile: "k"},
+		{URL: "ftp://user@host/in", Mode: "run"},
+		{URL: "ftps://user@host/in", Mode: "hourly"},
+	}
+	for _, cfg := range invalid {
+		if err := validateDeliveryConfig(cfg); err == nil {
+			t.Errorf("Expected %+v to be rejected, got nil", cfg)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+)
+
+// Repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ", ") }
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// Values available to derived metric expressions.
+type derivedEnv struct {
+	Download         float64 `expr:"download"`
+	Upload           float64 `expr:"upload"`
+	Ping             float64 `expr:"ping"`
+	Jitter           float64 `expr:"jitter"`
+	BytesSent        float64 `expr:"bytes_sent"`
+	BytesReceived    float64 `expr:"bytes_received"`
+	ExpectedDownload float64 `expr:"expected_download"`
+	ExpectedUpload   float64 `expr:"expected_upload"`
+}
+
+type derivedMetric struct {
+	Name    string
+	program *exprProgram
+}
+
+var metricNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Compiles name=expression definitions up front so a typo fails at startup
+// rather than on every run.
+func compileDerivedMetrics(definitions []string) ([]derivedMetric, error) {
+	var metrics []derivedMetric
+	for _, def := range definitions {
+		name, source, ok := strings.Cut(def, "=")
+		name = strings.TrimSpace(name)
+		if !ok || !metricNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("derived metric must be name=expression, got %q", def)
+		}
+		program, err := compileExpr(source, false)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expression for %s: %v", name, err)
+		}
+		metrics = append(metrics, derivedMetric{Name: "librespeed_" + name, program: program})
+	}
+	return metrics, nil
+}
+
+func newDerivedEnv(result *LibrespeedResult, sla *SLAProfile) derivedEnv {
+	env := derivedEnv{
+		Download:      result.Download,
+		Upload:        result.Upload,
+		Ping:          result.Ping,
+		Jitter:        result.Jitter,
+		BytesSent:     float64(result.BytesSent),
+		BytesReceived: float64(result.BytesReceived),
+	}
+	if sla != nil {
+		env.ExpectedDownload = sla.Download
+		env.ExpectedUpload = sla.Upload
+	}
+	return env
+}
+
+// Evaluates every derived metric. Results that aren't finite (e.g. a ratio
+// over a zero download) are skipped rather than sent as NaN.
+func evaluateDerivedMetrics(metrics []derivedMetric, env derivedEnv) (map[string]float64, error) {
+	values := map[string]float64{}
+	for _, m := range metrics {
+		out, err := runExpr(m.program, env)
+		if err != nil {
+			return values, fmt.Errorf("failed to evaluate %s: %v", m.Name, err)
+		}
+		value := out.(float64)
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			continue
+		}
+		values[m.Name] = value
+	}
+	return values, nil
+}
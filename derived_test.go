@@ -0,0 +1,63 @@
+//go:build !minimal
+
+package main
+
+import (
+	"testing"
+)
+
+func TestCompileDerivedMetrics_Invalid(t *testing.T) {
+	testCases := [][]string{
+		{"no_equals_sign"},
+		{"bad name=download"},
+		{"ratio=download /"},
+		{"unknown=latency * 2"},
+		{"text=\"fast\""},
+	}
+	for _, defs := range testCases {
+		if _, err := compileDerivedMetrics(defs); err == nil {
+			t.Errorf("Expected error for %q, got nil", defs)
+		}
+	}
+}
+
+func TestEvaluateDerivedMetrics(t *testing.T) {
+	metrics, err := compileDerivedMetrics([]string{
+		"bandwidth_ratio=upload / download",
+		"effective_score = min(download / expected_download, 1) * 100 - jitter",
+		"ping_ok=ping < 20 ? 1 : 0",
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	result := &LibrespeedResult{Download: 200, Upload: 50, Ping: 12, Jitter: 3}
+	values, err := evaluateDerivedMetrics(metrics, newDerivedEnv(result, &SLAProfile{Download: 100, Upload: 40}))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := map[string]float64{
+		"librespeed_bandwidth_ratio": 0.25,
+		"librespeed_effective_score": 97,
+		"librespeed_ping_ok":         1,
+	}
+	for name, want := range expected {
+		if values[name] != want {
+			t.Errorf("%s: expected %v, got %v", name, want, values[name])
+		}
+	}
+}
+
+func TestEvaluateDerivedMetrics_SkipsNonFinite(t *testing.T) {
+	metrics, err := compileDerivedMetrics([]string{"bandwidth_ratio=upload / download"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	values, err := evaluateDerivedMetrics(metrics, newDerivedEnv(&LibrespeedResult{}, nil))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, ok := values["librespeed_bandwidth_ratio"]; ok {
+		t.Errorf("Expected NaN result to be skipped, got %v", values)
+	}
+}
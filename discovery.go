@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Looks up SRV records; replaced by tests.
+var lookupSRV = net.DefaultResolver.LookupSRV
+
+// Finds the remote write endpoint at the start of every run, so the central
+// receiver can move or scale out without reconfiguring each agent. The spec
+// is either a DNS SRV name, dns+srv://_remote-write._tcp.example.com/api/v1/push
+// (dns+srv+http:// for plain HTTP), or the URL of a JSON document such as
+// {"url": "https://mimir.example.com/api/v1/push"}.
+type remoteWriteDiscovery struct {
+	spec string
+	// For SRV: the record name, and the scheme and path of the endpoint
+	srvName, scheme, path string
+	// Host suffixes from --url-discovery-allowed-hosts
+	allowedHosts []string
+	// Whether pushes carry --username/--password or --remote-write-config
+	// headers, which must only go to https endpoints
+	credentials bool
+
+	mu      sync.Mutex
+	current string
+}
+
+// nil without --url-discovery. The endpoint previous found last is kept
+// when the spec is unchanged, as a fallback should the next lookup fail.
+//
+// Neither SRV answers nor a document fetched over plain HTTP are
+// authenticated, so with credentials the discovery itself must be protected
+// by TLS and the endpoints it finds are limited to the allowed hosts.
+func newRemoteWriteDiscovery(cfg *Config, previous *remoteWriteDiscovery) (*remoteWriteDiscovery, error) {
+	spec := cfg.URLDiscovery
+	if spec == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(spec)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid --url-discovery %q, expected dns+srv://<name>/<path> or an http(s) URL", spec)
+	}
+	d := &remoteWriteDiscovery{
+		spec:         spec,
+		allowedHosts: parseAllowedHosts(cfg.URLDiscoveryAllowedHosts),
+		credentials:  cfg.Username != "" || cfg.Password != "" || cfg.RemoteWriteConfig != "",
+	}
+	switch u.Scheme {
+	case "dns+srv", "dns+srv+http":
+		d.srvName, d.scheme, d.path = u.Host, "https", u.EscapedPath()
+		if u.Scheme == "dns+srv+http" {
+			d.scheme = "http"
+		}
+	case "http", "https":
+	default:
+		return nil, fmt.Errorf("invalid --url-discovery %q, expected dns+srv://<name>/<path> or an http(s) URL", spec)
+	}
+	if d.credentials {
+		if u.Scheme == "dns+srv+http" || u.Scheme == "http" {
+			return nil, fmt.Errorf("--url-discovery %s would send the remote write credentials over plain HTTP; use dns+srv:// or an https URL", u.Scheme)
+		}
+		if len(d.allowedHosts) == 0 {
+			return nil, fmt.Errorf("--url-discovery with remote write credentials requires --url-discovery-allowed-hosts")
+		}
+	}
+	if previous != nil && previous.spec == spec {
+		if current := previous.url(""); current != "" && d.check(current) == nil {
+			d.current = current
+		}
+	}
+	return d, nil
+}
+
+// Comma-separated host names; each also allows its subdomains.
+func parseAllowedHosts(spec string) []string {
+	var hosts []string
+	for _, host := range strings.Split(spec, ",") {
+		host = strings.Trim(strings.ToLower(strings.TrimSpace(host)), ".")
+		if host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// Rejects a discovered endpoint the pushes must not go to.
+func (d *remoteWriteDiscovery) check(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid endpoint %q: %v", endpoint, err)
+	}
+	if d.credentials && u.Scheme != "https" {
+		return fmt.Errorf("refusing to send credentials to %s, which is not https", endpoint)
+	}
+	if len(d.allowedHosts) == 0 {
+		return nil
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	for _, allowed := range d.allowedHosts {
+		if host == allowed || strings.HasSuffix(host, "."+allowed) {
+			return nil
+		}
+	}
+	return fmt.Errorf("discovered host %s is not in --url-discovery-allowed-hosts", host)
+}
+
+func (d *remoteWriteDiscovery) lookup(ctx context.Context, client *http.Client) (string, error) {
+	found, err := d.find(ctx, client)
+	if err != nil {
+		return "", err
+	}
+	if err := d.check(found); err != nil {
+		return "", err
+	}
+	return found, nil
+}
+
+func (d *remoteWriteDiscovery) find(ctx context.Context, client *http.Client) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if d.srvName != "" {
+		// Sorted by priority and shuffled by weight
+		_, records, err := lookupSRV(ctx, "", "", d.srvName)
+		if err != nil {
+			return "", fmt.Errorf("SRV lookup of %s failed: %v", d.srvName, err)
+		}
+		target := ""
+		if len(records) > 0 {
+			target = strings.TrimSuffix(records[0].Target, ".")
+		}
+		if target == "" {
+			return "", fmt.Errorf("no SRV records for %s", d.srvName)
+		}
+		return fmt.Sprintf("%s://%s%s", d.scheme, net.JoinHostPort(target, strconv.Itoa(int(records[0].Port))), d.path), nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", d.spec, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create HTTP request: %v", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch discovery document: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("discovery document request failed with status: %s", resp.Status)
+	}
+	var doc struct {
+		URL string `json:"url"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&doc); err != nil {
+		return "", fmt.Errorf("failed to parse discovery document: %v", err)
+	}
+	if err := validateRemoteWriteURL(doc.URL); err != nil {
+		return "", fmt.Errorf("discovery document has no usable url: %v", err)
+	}
+	return doc.URL, nil
+}
+
+// Looks the endpoint up again. When that fails, the endpoint found last is
+// used, then fallback (--url); it is an error only if there is neither.
+func (d *remoteWriteDiscovery) resolve(ctx context.Context, client *http.Client, fallback string) (string, error) {
+	found, err := d.lookup(ctx, client)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch {
+	case err == nil:
+		if found != d.current {
+			log.Printf("Remote write endpoint from %s: %s", d.spec, found)
+			d.current = found
+		}
+		return found, nil
+	case d.current != "":
+		log.Printf("WARNING: Remote write discovery failed, keeping %s: %v", d.current, err)
+		return d.current, nil
+	case fallback != "":
+		log.Printf("WARNING: Remote write discovery failed, using --url: %v", err)
+		return fallback, nil
+	}
+	return "", fmt.Errorf("remote write discovery failed and there is no --url to fall back to: %v", err)
+}
+
+// The endpoint found last, or fallback before the first lookup succeeds.
+func (d *remoteWriteDiscovery) url(fallback string) string {
+	if d == nil {
+		return fallback
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.current == "" {
+		return fallback
+	}
+	return d.current
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func useSRVRecords(t *testing.T, records []*net.SRV, err error) {
+	t.Helper()
+	saved := lookupSRV
+	lookupSRV = func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		if name != "_remote-write._tcp.example.com" {
+			t.Errorf("Unexpected SRV lookup of %s", name)
+		}
+		return "", records, err
+	}
+	t.Cleanup(func() { lookupSRV = saved })
+}
+
+func TestNewRemoteWriteDiscovery(t *testing.T) {
+	for _, spec := range []string{"srv://_remote-write._tcp.example.com", "dns+srv:///api/v1/push", "_remote-write._tcp.example.com"} {
+		if _, err := newRemoteWriteDiscovery(&Config{URLDiscovery: spec}, nil); err == nil {
+			t.Errorf("Expected %q to be rejected", spec)
+		}
+	}
+	d, err := newRemoteWriteDiscovery(&Config{URLDiscovery: "dns+srv+http://_remote-write._tcp.example.com/api/v1/push"}, nil)
+	if err != nil || d.srvName != "_remote-write._tcp.example.com" || d.scheme != "http" || d.path != "/api/v1/push" {
+		t.Errorf("Unexpected discovery %+v, %v", d, err)
+	}
+}
+
+func TestRemoteWriteDiscovery_SRV(t *testing.T) {
+	d, _ := newRemoteWriteDiscovery(&Config{URLDiscovery: "dns+srv://_remote-write._tcp.example.com/api/v1/push"}, nil)
+
+	useSRVRecords(t, nil, fmt.Errorf("no such host"))
+	if _, err := d.resolve(context.Background(), http.DefaultClient, ""); err == nil {
+		t.Error("Expected an error without records or --url")
+	}
+	if got, err := d.resolve(context.Background(), http.DefaultClient, "https://fallback.example.com/push"); err != nil || got != "https://fallback.example.com/push" {
+		t.Errorf("Expected --url as the fallback, got %q, %v", got, err)
+	}
+
+	useSRVRecords(t, []*net.SRV{{Target: "rw2.example.com.", Port: 9009}, {Target: "rw1.example.com.", Port: 9009}}, nil)
+	want := "https://rw2.example.com:9009/api/v1/push"
+	if got, err := d.resolve(context.Background(), http.DefaultClient, "https://fallback.example.com/push"); err != nil || got != want {
+		t.Errorf("Expected %s, got %q, %v", want, got, err)
+	}
+
+	// The endpoint found last survives a failed lookup and a reload
+	useSRVRecords(t, nil, fmt.Errorf("timeout"))
+	reloaded, _ := newRemoteWriteDiscovery(&Config{URLDiscovery: d.spec}, d)
+	if got, err := reloaded.resolve(context.Background(), http.DefaultClient, "https://fallback.example.com/push"); err != nil || got != want {
+		t.Errorf("Expected the last endpoint %s, got %q, %v", want, got, err)
+	}
+	if got := reloaded.url("https://fallback.example.com/push"); got != want {
+		t.Errorf("Expected url() to return %s, got %s", want, got)
+	}
+}
+
+func TestRemoteWriteDiscovery_Document(t *testing.T) {
+	body := `{"url": "https://mimir.example.com/api/v1/push"}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, body)
+	}))
+	defer server.Close()
+	d, err := newRemoteWriteDiscovery(&Config{URLDiscovery: server.URL + "/remote-write.json"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := d.resolve(context.Background(), http.DefaultClient, ""); err != nil || got != "https://mimir.example.com/api/v1/push" {
+		t.Errorf("Expected the document's URL, got %q, %v", got, err)
+	}
+
+	body = `{"url": "mimir.example.com"}`
+	if _, err := d.lookup(context.Background(), http.DefaultClient); err == nil || !strings.Contains(err.Error(), "no usable url") {
+		t.Errorf("Expected an invalid URL to be rejected, got %v", err)
+	}
+}
+
+func TestRemoteWriteDiscovery_Restricted(t *testing.T) {
+	cfg := &Config{
+		URLDiscovery:             "dns+srv://_remote-write._tcp.example.com/api/v1/push",
+		URLDiscoveryAllowedHosts: "example.com, .example.net",
+		Username:                 "u",
+		Password:                 "p",
+	}
+	d, err := newRemoteWriteDiscovery(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for endpoint, allowed := range map[string]bool{
+		"https://rw.example.com/api/v1/push":      true,
+		"https://example.net:9009/api/v1/push":    true,
+		"https://RW.Example.NET./api/v1/push":     true,
+		"https://example.com.evil.io/api/v1/push": false,
+		"https://badexample.com/api/v1/push":      false,
+		"http://rw.example.com/api/v1/push":       false,
+	} {
+		if err := d.check(endpoint); (err == nil) != allowed {
+			t.Errorf("check(%s): expected allowed=%v, got %v", endpoint, allowed, err)
+		}
+	}
+
+	// A spoofed SRV answer falls back to --url instead of getting the credentials
+	useSRVRecords(t, []*net.SRV{{Target: "attacker.io.", Port: 443}}, nil)
+	got, err := d.resolve(context.Background(), http.DefaultClient, "https://fallback.example.com/push")
+	if err != nil || got != "https://fallback.example.com/push" {
+		t.Errorf("Expected the spoofed host to be refused, got %q, %v", got, err)
+	}
+
+	// An endpoint found before a reload must still be allowed afterwards
+	useSRVRecords(t, []*net.SRV{{Target: "rw.example.com.", Port: 443}}, nil)
+	d.resolve(context.Background(), http.DefaultClient, "")
+	narrowed := *cfg
+	narrowed.URLDiscoveryAllowedHosts = "example.net"
+	reloaded, _ := newRemoteWriteDiscovery(&narrowed, d)
+	if got := reloaded.url(""); got != "" {
+		t.Errorf("Expected the previous endpoint to be dropped, got %s", got)
+	}
+}
+
+func TestRun_URLDiscovery(t *testing.T) {
+	pushes := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pushes++
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+	discovery := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"url": %q}`, receiver.URL+"/api/v1/push")
+	}))
+	defer discovery.Close()
+
+	runner := &MockRunner{Output: []byte(`[{"download":100.0,"upload":50.0,"ping":10.0,"jitter":1.0,"server":{"url":"http://example.com"}}]`)}
+	rc := newTestRunContext(t, "", runner)
+	// Plain HTTP endpoints only get pushes without credentials
+	rc.cfg.URL, rc.cfg.Username, rc.cfg.Password = "", "", ""
+	rc.cfg.URLDiscovery = discovery.URL
+	rc.discovery, _ = newRemoteWriteDiscovery(rc.cfg, nil)
+	if err := rc.runOnce(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if pushes != 1 {
+		t.Errorf("Expected the result to be pushed to the discovered endpoint, got %d pushes", pushes)
+	}
+
+	discovery.Close()
+	rc.discovery, _ = newRemoteWriteDiscovery(rc.cfg, nil)
+	if err := rc.runOnce(context.Background()); err == nil || !strings.Contains(err.Error(), "remote write discovery failed") {
+		t.Errorf("Expected the run to fail without an endpoint, got %v", err)
+	}
+}
+
+func TestConfigure_URLDiscovery(t *testing.T) {
+	configure := func(args ...string) error {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		cfg := &Config{}
+		cfg.RegisterFlags(fs)
+		if err := fs.Parse(append([]string{"--state-dir", t.TempDir()}, args...)); err != nil {
+			t.Fatal(err)
+		}
+		rc := &runContext{flags: fs}
+		return rc.configure(cfg, explicitFlags(fs))
+	}
+	spec := "dns+srv://_remote-write._tcp.example.com/api/v1/push"
+	if err := configure("--url-discovery", spec, "--url-discovery-allowed-hosts", "example.com", "--username", "u", "--password", "p"); err != nil {
+		t.Errorf("Expected --url to be optional with --url-discovery, got %v", err)
+	}
+	if err := configure("--url-discovery", spec, "--username", "u", "--password", "p"); err == nil || !strings.Contains(err.Error(), "--url-discovery-allowed-hosts") {
+		t.Errorf("Expected credentials to require an allow-list, got %v", err)
+	}
+	for _, plain := range []string{"dns+srv+http://_remote-write._tcp.example.com/api/v1/push", "http://config.example.com/remote-write.json"} {
+		if err := configure("--url-discovery", plain, "--url-discovery-allowed-hosts", "example.com", "--username", "u", "--password", "p"); err == nil || !strings.Contains(err.Error(), "plain HTTP") {
+			t.Errorf("Expected credentials over %s to be rejected, got %v", plain, err)
+		}
+	}
+	if err := configure("--url-discovery", spec, "--password", "p"); err == nil || !strings.Contains(err.Error(), "username") {
+		t.Errorf("Expected credentials to be required, got %v", err)
+	}
+	if err := configure("--url-discovery", "ftp://discovery.example.com", "--username", "u", "--password", "p"); err == nil {
+		t.Error("Expected an ftp URL to be rejected")
+	}
+}
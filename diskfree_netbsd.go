@@ -0,0 +1,12 @@
+package main
+
+import "golang.org/x/sys/unix"
+
+// NetBSD replaced statfs with statvfs.
+func diskFreeBytes(dir string) (uint64, error) {
+	var stat unix.Statvfs_t
+	if err := unix.Statvfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return stat.Bavail * stat.Frsize, nil
+}
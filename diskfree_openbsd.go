@@ -0,0 +1,15 @@
+package main
+
+import "syscall"
+
+func diskFreeBytes(dir string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	// Negative when root's reserve is in use
+	if stat.F_bavail < 0 {
+		return 0, nil
+	}
+	return uint64(stat.F_bavail) * uint64(stat.F_bsize), nil
+}
@@ -0,0 +1,13 @@
+//go:build !windows && !openbsd && !netbsd
+
+package main
+
+import "syscall"
+
+func diskFreeBytes(dir string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
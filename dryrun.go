@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// Where --dry-run prints the series it would have pushed.
+var dryRunOutput io.Writer = os.Stdout
+
+// Prints series one per line as name{labels} value timestamp, the labels
+// sorted, followed by the size of the payload the encoder would send.
+func printDryRun(w io.Writer, encoder Encoder, series []*prompb.TimeSeries) error {
+	for _, ts := range series {
+		var labels []string
+		for _, l := range ts.Labels {
+			if l.Name != "__name__" {
+				labels = append(labels, l.Name+"="+strconv.Quote(l.Value))
+			}
+		}
+		sort.Strings(labels)
+		for _, sample := range ts.Samples {
+			fmt.Fprintf(w, "%s{%s} %s %d\n", getLabelValue(ts.Labels, "__name__"), strings.Join(labels, ","),
+				strconv.FormatFloat(sample.Value, 'g', -1, 64), sample.Timestamp)
+		}
+	}
+	payload, err := encoder.Encode(series)
+	if err != nil {
+		return err
+	}
+	defer putBuffer(payload)
+	fmt.Fprintf(w, "# Dry run: %d series, %d byte payload not sent\n", len(series), len(*payload))
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func TestPrintDryRun(t *testing.T) {
+	ts := createTimeSeries("librespeed_download_mbps", 93.5, 1700000000000, "http://example.com", "host1")
+	addLabels([]*prompb.TimeSeries{ts}, map[string]string{"campaign": "isp-migration"})
+
+	var out bytes.Buffer
+	if err := printDryRun(&out, remoteWriteEncoder{}, []*prompb.TimeSeries{ts}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	want := `librespeed_download_mbps{campaign="isp-migration",instance="host1",server_url="http://example.com"} 93.5 1700000000000`
+	if len(lines) != 2 || lines[0] != want {
+		t.Fatalf("Expected %q, got %q", want, lines)
+	}
+	if !strings.HasPrefix(lines[1], "# Dry run: 1 series, ") || !strings.HasSuffix(lines[1], "byte payload not sent") {
+		t.Errorf("Expected the payload size, got %q", lines[1])
+	}
+}
+
+func TestRunOnce_DryRun(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	var out bytes.Buffer
+	dryRunOutput = &out
+	defer func() { dryRunOutput = os.Stdout }()
+
+	runner := &MockRunner{Output: []byte(`[{"download":100.0,"upload":50.0,"ping":10.0,"jitter":1.0,"server":{"url":"http://example.com"}}]`)}
+	rc := newTestRunContext(t, server.URL, runner)
+	rc.cfg.DryRun = true
+	rc.cfg.AlertmanagerURL = server.URL
+	redis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer redis.Close()
+	redisConns := make(chan struct{}, 1)
+	go func() {
+		if conn, err := redis.Accept(); err == nil {
+			conn.Close()
+			redisConns <- struct{}{}
+		}
+	}()
+	rc.cfg.RedisAddress = redis.Addr().String()
+	if err := rc.runOnce(context.Background()); err != nil {
+		t.Fatalf("Expected the run to succeed, got %v", err)
+	}
+	if requests != 0 {
+		t.Errorf("Expected nothing pushed in a dry run, got %d requests", requests)
+	}
+	select {
+	case <-redisConns:
+		t.Error("Expected no Redis write in a dry run")
+	default:
+	}
+	if !strings.Contains(out.String(), "librespeed_download_mbps{") || !strings.Contains(out.String(), "# Dry run: ") {
+		t.Errorf("Expected the series to be printed, got %q", out.String())
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// RFC 4594 class names accepted by --dscp next to plain numbers.
+var dscpClasses = map[string]int{
+	"BE": 0, "CS0": 0, "CS1": 8, "CS2": 16, "CS3": 24, "CS4": 32, "CS5": 40, "CS6": 48, "CS7": 56,
+	"AF11": 10, "AF12": 12, "AF13": 14, "AF21": 18, "AF22": 20, "AF23": 22,
+	"AF31": 26, "AF32": 28, "AF33": 30, "AF41": 34, "AF42": 36, "AF43": 38,
+	"EF": 46, "VA": 44,
+}
+
+// Returns the DSCP code point for a class name (EF, AF41, CS1, ...) or a
+// number from 0 to 63, and the name used for the dscp label.
+func parseDSCP(s string) (int, string, error) {
+	name := strings.ToUpper(strings.TrimSpace(s))
+	if value, ok := dscpClasses[name]; ok {
+		return value, name, nil
+	}
+	value, err := strconv.Atoi(name)
+	if err != nil || value < 0 || value > 63 {
+		return 0, "", fmt.Errorf("--dscp must be a class name such as EF or AF41, or a number from 0 to 63, got %q", s)
+	}
+	return value, name, nil
+}
+
+// Dialer that marks every connection it opens with the DSCP code point.
+func dscpDialer(dscp int) *net.Dialer {
+	return &net.Dialer{
+		// Same as http.DefaultTransport's dialer
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, c syscall.RawConn) error {
+			var sockErr error
+			err := c.Control(func(fd uintptr) {
+				sockErr = setDSCP(network, fd, dscp)
+			})
+			if err != nil {
+				return err
+			}
+			if sockErr != nil {
+				return fmt.Errorf("failed to set DSCP %d: %v", dscp, sockErr)
+			}
+			return nil
+		},
+	}
+}
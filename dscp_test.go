@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"net"
+	"runtime"
+	"testing"
+)
+
+func TestParseDSCP(t *testing.T) {
+	cases := []struct {
+		in    string
+		value int
+		name  string
+	}{
+		{"EF", 46, "EF"},
+		{"af41", 34, "AF41"},
+		{"cs1", 8, "CS1"},
+		{"0", 0, "0"},
+		{"63", 63, "63"},
+	}
+	for _, c := range cases {
+		value, name, err := parseDSCP(c.in)
+		if err != nil || value != c.value || name != c.name {
+			t.Errorf("parseDSCP(%q) = %d, %q, %v; want %d, %q", c.in, value, name, err, c.value, c.name)
+		}
+	}
+	for _, in := range []string{"64", "-1", "AF44", "voice"} {
+		if _, _, err := parseDSCP(in); err == nil {
+			t.Errorf("Expected error for %q", in)
+		}
+	}
+}
+
+func TestDSCPDialer(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("DSCP marking is not supported on Windows")
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+
+	conn, err := dscpDialer(46).DialContext(context.Background(), "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Expected marked connection, got %v", err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+
+package main
+
+import (
+	"strings"
+	"syscall"
+)
+
+// DSCP is the upper six bits of the IPv4 TOS / IPv6 traffic class byte.
+func setDSCP(network string, fd uintptr, dscp int) error {
+	if strings.HasSuffix(network, "6") {
+		return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, dscp<<2)
+	}
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, dscp<<2)
+}
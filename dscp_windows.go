@@ -0,0 +1,11 @@
+//go:build windows
+
+package main
+
+import "fmt"
+
+// Windows ignores IP_TOS from applications; marking is done with a
+// Group Policy QoS policy for librespeed_exporter.exe instead.
+func setDSCP(network string, fd uintptr, dscp int) error {
+	return fmt.Errorf("not supported on Windows")
+}
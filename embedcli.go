@@ -0,0 +1,30 @@
+//go:build embedcli
+
+package main
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"runtime"
+	"strings"
+)
+
+// librespeed-cli for the target platform, copied into embedded/ before
+// building with -tags embedcli (see embedded/README.md).
+//
+//go:embed embedded
+var embeddedFiles embed.FS
+
+func embeddedCLI() (*embeddedBinary, error) {
+	binary := cliBinaryName(runtime.GOOS)
+	data, err := fs.ReadFile(embeddedFiles, "embedded/"+binary)
+	if err != nil {
+		return nil, fmt.Errorf("this build was made with -tags embedcli but has no embedded/%s", binary)
+	}
+	version := cliVersion
+	if recorded, err := fs.ReadFile(embeddedFiles, "embedded/librespeed-cli.version"); err == nil {
+		version = strings.TrimSpace(string(recorded))
+	}
+	return &embeddedBinary{data: data, version: version}, nil
+}
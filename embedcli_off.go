@@ -0,0 +1,5 @@
+//go:build !embedcli
+
+package main
+
+func embeddedCLI() (*embeddedBinary, error) { return nil, nil }
@@ -0,0 +1,39 @@
+//go:build embedcli
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestEnsureLibrespeedCLI_Embedded(t *testing.T) {
+	saved := systemInstallDir
+	systemInstallDir = t.TempDir()
+	defer func() { systemInstallDir = saved }()
+	// Never downloads, even from an unreachable release server
+	savedBase := cliReleaseBase
+	cliReleaseBase = "http://127.0.0.1:1"
+	defer func() { cliReleaseBase = savedBase }()
+
+	exe, err := ensureLibrespeedCLI(context.Background(), cliInstallOptions{SystemInstall: true})
+	if _, missing := embeddedFiles.Open("embedded/" + cliBinaryName(runtime.GOOS)); missing != nil {
+		if err == nil || !strings.Contains(err.Error(), "no embedded/") {
+			t.Errorf("Expected a build without the binary to say so, got %v", err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if filepath.Dir(exe) != filepath.Join(systemInstallDir, "embedded") {
+		t.Errorf("Expected the binary under %s, got %s", systemInstallDir, exe)
+	}
+	if info, err := os.Stat(exe); err != nil || info.Size() == 0 {
+		t.Errorf("Expected the embedded binary at %s, got %v", exe, err)
+	}
+}
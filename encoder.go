@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// Encoder turns time series into a request body for a specific wire format.
+// The returned buffer comes from bufferPool and must be released with
+// putBuffer once the request has been sent.
+type Encoder interface {
+	Encode(series []*prompb.TimeSeries) (*[]byte, error)
+	Headers() map[string]string
+}
+
+func newEncoder(format string) (Encoder, error) {
+	switch format {
+	case "", "remote-write":
+		return remoteWriteEncoder{}, nil
+	case "influx":
+		return influxEncoder{}, nil
+	default:
+		return nil, fmt.Errorf("unknown output format: %s (supported: remote-write, influx)", format)
+	}
+}
+
+// Prometheus remote write 1.0: protobuf WriteRequest, snappy block compressed.
+type remoteWriteEncoder struct{}
+
+func (remoteWriteEncoder) Encode(series []*prompb.TimeSeries) (*[]byte, error) {
+	tsList := make([]prompb.TimeSeries, 0, len(series))
+	for _, ts := range series {
+		tsList = append(tsList, *ts)
+	}
+	req := &prompb.WriteRequest{
+		Timeseries: tsList,
+	}
+
+	marshalBuf := getBuffer(req.Size())
+	defer putBuffer(marshalBuf)
+	n, err := req.MarshalToSizedBuffer(*marshalBuf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal protobuf: %v", err)
+	}
+	data := (*marshalBuf)[len(*marshalBuf)-n:]
+
+	compressBuf := getBuffer(snappy.MaxEncodedLen(len(data)))
+	*compressBuf = snappy.Encode(*compressBuf, data)
+	return compressBuf, nil
+}
+
+func (remoteWriteEncoder) Headers() map[string]string {
+	return map[string]string{
+		"Content-Encoding":                  "snappy",
+		"Content-Type":                      "application/x-protobuf",
+		"X-Prometheus-Remote-Write-Version": "0.1.0",
+	}
+}
+
+// InfluxDB line protocol with nanosecond timestamps, so it works against the
+// write endpoint's default precision.
+type influxEncoder struct{}
+
+var influxTagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+var influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+
+func (influxEncoder) Encode(series []*prompb.TimeSeries) (*[]byte, error) {
+	buf := getBuffer(0)
+	for _, ts := range series {
+		name := getLabelValue(ts.Labels, "__name__")
+		if name == "" {
+			putBuffer(buf)
+			return nil, fmt.Errorf("time series without a metric name")
+		}
+		for _, sample := range ts.Samples {
+			if math.IsNaN(sample.Value) || math.IsInf(sample.Value, 0) {
+				continue
+			}
+			line := *buf
+			line = append(line, influxMeasurementEscaper.Replace(name)...)
+			for _, label := range ts.Labels {
+				if label.Name == "__name__" || label.Value == "" {
+					continue
+				}
+				line = append(line, ',')
+				line = append(line, influxTagEscaper.Replace(label.Name)...)
+				line = append(line, '=')
+				line = append(line, influxTagEscaper.Replace(label.Value)...)
+			}
+			line = append(line, " value="...)
+			line = strconv.AppendFloat(line, sample.Value, 'g', -1, 64)
+			line = append(line, ' ')
+			line = strconv.AppendInt(line, sample.Timestamp*1e6, 10)
+			line = append(line, '\n')
+			*buf = line
+		}
+	}
+	return buf, nil
+}
+
+func (influxEncoder) Headers() map[string]string {
+	return map[string]string{
+		"Content-Type": "text/plain; charset=utf-8",
+	}
+}
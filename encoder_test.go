@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func TestNewEncoder(t *testing.T) {
+	testCases := []struct {
+		format      string
+		shouldError bool
+	}{
+		{"", false},
+		{"remote-write", false},
+		{"influx", false},
+		{"otlp", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.format, func(t *testing.T) {
+			_, err := newEncoder(tc.format)
+			if (err != nil) != tc.shouldError {
+				t.Errorf("Expected error=%v, got %v", tc.shouldError, err)
+			}
+		})
+	}
+}
+
+func TestRemoteWriteEncoder_RoundTrip(t *testing.T) {
+	ts := createTimeSeries("test_metric", 42.5, 1690000000000, "http://server", "host1")
+	payload, err := remoteWriteEncoder{}.Encode([]*prompb.TimeSeries{ts})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer putBuffer(payload)
+
+	data, err := snappy.Decode(nil, *payload)
+	if err != nil {
+		t.Fatalf("Failed to decompress payload: %v", err)
+	}
+	var req prompb.WriteRequest
+	if err := req.Unmarshal(data); err != nil {
+		t.Fatalf("Failed to unmarshal payload: %v", err)
+	}
+	if len(req.Timeseries) != 1 || req.Timeseries[0].Samples[0].Value != 42.5 {
+		t.Errorf("Unexpected decoded payload: %+v", req.Timeseries)
+	}
+}
+
+func TestInfluxEncoder_LineProtocol(t *testing.T) {
+	series := []*prompb.TimeSeries{
+		createTimeSeries("librespeed_download_mbps", 100.5, 1690000000000, "http://server.com/backend", "host 1"),
+		createTimeSeries("librespeed_ping_ms", 12, 1690000000000, "", "host1"),
+	}
+	payload, err := influxEncoder{}.Encode(series)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer putBuffer(payload)
+
+	expected := "librespeed_download_mbps,instance=host\\ 1,server_url=http://server.com/backend value=100.5 1690000000000000000\n" +
+		"librespeed_ping_ms,instance=host1 value=12 1690000000000000000\n"
+	if string(*payload) != expected {
+		t.Errorf("Unexpected line protocol.\nExpected:\n%s\nGot:\n%s", expected, string(*payload))
+	}
+}
+
+func TestInfluxEncoder_MissingName(t *testing.T) {
+	ts := &prompb.TimeSeries{Samples: []prompb.Sample{{Value: 1, Timestamp: 1}}}
+	_, err := influxEncoder{}.Encode([]*prompb.TimeSeries{ts})
+	if err == nil {
+		t.Error("Expected error for series without a name, got nil")
+	}
+}
+
+func TestSendWithEncoder_UsesEncoderHeaders(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "text/plain; charset=utf-8" {
+			t.Errorf("Expected influx content type, got %s", r.Header.Get("Content-Type"))
+		}
+		if r.Header.Get("Content-Encoding") != "" {
+			t.Errorf("Expected no content encoding, got %s", r.Header.Get("Content-Encoding"))
+		}
+		body, _ := io.ReadAll(r.Body)
+		if !strings.HasPrefix(string(body), "test_metric,") {
+			t.Errorf("Expected line protocol body, got %s", string(body))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer mockServer.Close()
+
+	ts := createTimeSeries("test_metric", 1.0, 1690000000000, "server", "instance")
+	err := sendWithEncoder(context.Background(), influxEncoder{}, mockServer.URL, "user", "pass", []*prompb.TimeSeries{ts})
+	if err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var dailySummaryHeader = []string{
+	"date", "host", "runs",
+	"download_min_mbps", "download_median_mbps", "download_max_mbps",
+	"upload_min_mbps", "upload_median_mbps", "upload_max_mbps",
+	"ping_min_ms", "ping_median_ms", "ping_max_ms",
+	"jitter_min_ms", "jitter_median_ms", "jitter_max_ms",
+}
+
+// Cells in dailySummaryHeader order. Numbers stay numbers so spreadsheets
+// can chart them without reformatting.
+func (s *dailySummary) row(host string) []any {
+	row := []any{s.Day.Format(rollupDayFormat), host, s.Runs}
+	for _, stats := range []dailyStats{s.Download, s.Upload, s.Ping, s.Jitter} {
+		row = append(row, round2(stats.Min), round2(stats.Median), round2(stats.Max))
+	}
+	return row
+}
+
+func round2(v float64) float64 {
+	rounded, _ := strconv.ParseFloat(strconv.FormatFloat(v, 'f', 2, 64), 64)
+	return rounded
+}
+
+// Appends one row to a CSV file, writing the header first when the file is
+// new, so it can be opened directly in Excel or dropped on a share.
+func appendCSV(path string, row []any) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open CSV export: %v", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat CSV export: %v", err)
+	}
+	w := csv.NewWriter(f)
+	if info.Size() == 0 {
+		w.Write(dailySummaryHeader)
+	}
+	record := make([]string, len(row))
+	for i, cell := range row {
+		record[i] = fmt.Sprint(cell)
+	}
+	w.Write(record)
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("failed to write CSV export: %v", err)
+	}
+	return nil
+}
+
+type SheetsConfig struct {
+	SpreadsheetID   string
+	CredentialsFile string
+	Range           string
+}
+
+// The fields of a Google service account key file that the JWT grant needs.
+type serviceAccountKey struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// Overridden in tests.
+var sheetsAPIBase = "https://sheets.googleapis.com"
+
+const sheetsScope = "https://www.googleapis.com/auth/spreadsheets"
+
+func loadServiceAccountKey(path string) (*serviceAccountKey, *rsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read service account key: %v", err)
+	}
+	var key serviceAccountKey
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse service account key: %v", err)
+	}
+	if key.ClientEmail == "" || key.PrivateKey == "" {
+		return nil, nil, fmt.Errorf("service account key %s lacks client_email or private_key", path)
+	}
+	if key.TokenURI == "" {
+		key.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+
+	block, _ := pem.Decode([]byte(key.PrivateKey))
+	if block == nil {
+		return nil, nil, fmt.Errorf("service account private_key is not PEM encoded")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse service account private key: %v", err)
+	}
+	rsaKey, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, nil, fmt.Errorf("service account private key is not RSA")
+	}
+	return &key, rsaKey, nil
+}
+
+// Exchanges a self-signed JWT for an access token (OAuth 2.0 JWT bearer
+// grant), which is all a service account needs without the Google SDK.
+func fetchServiceAccountToken(key *serviceAccountKey, privateKey *rsa.PrivateKey, scope string, now time.Time) (string, error) {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]any{
+		"iss":   key.ClientEmail,
+		"scope": scope,
+		"aud":   key.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(nil, privateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token request: %v", err)
+	}
+	assertion := unsigned + "." + base64.RawURLEncoding.EncodeToString(signature)
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", key.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to create token request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to request access token: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request failed: %s - %s", resp.Status, string(body))
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil || token.AccessToken == "" {
+		return "", fmt.Errorf("token response has no access_token")
+	}
+	return token.AccessToken, nil
+}
+
+// Appends a row below the table found in cfg.Range. The service account
+// needs editor access to the spreadsheet (share it with its client_email).
+func appendToSheet(cfg SheetsConfig, row []any, now time.Time) error {
+	key, privateKey, err := loadServiceAccountKey(cfg.CredentialsFile)
+	if err != nil {
+		return err
+	}
+	token, err := fetchServiceAccountToken(key, privateKey, sheetsScope, now)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(map[string]any{"values": [][]any{row}})
+	if err != nil {
+		return fmt.Errorf("failed to marshal sheet row: %v", err)
+	}
+	appendURL := fmt.Sprintf("%s/v4/spreadsheets/%s/values/%s:append?valueInputOption=USER_ENTERED&insertDataOption=INSERT_ROWS",
+		sheetsAPIBase, url.PathEscape(cfg.SpreadsheetID), url.PathEscape(cfg.Range))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", appendURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create HTTP request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to append to sheet: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("sheet append failed: %s - %s", resp.Status, string(respBody))
+	}
+	return nil
+}
+
+// Appends yesterday's summary to the CSV file and/or Google Sheet on the
+// first run of a new day, then uploads the CSV file with --archive-url and
+// --delivery-url. Each target tracks its own last day so one failing
+// doesn't repeat the other.
+func (rc *runContext) exportDailySummary(now time.Time) {
+	cfg := rc.cfg
+	if cfg.CSVExport != "" {
+		err := runDailyJob(cfg.StateDir, cfg.HistoryFile, "last_csv_export", now, func(day time.Time, history []HistoryEntry) error {
+			summary := summarizeDay(history, day)
+			if summary == nil {
+				return nil
+			}
+			return appendCSV(cfg.CSVExport, summary.row(rc.hostname))
+		})
+		if err != nil {
+			log.Printf("WARNING: %v", err)
+		} else {
+			rc.uploadCSVExport(now)
+		}
+	}
+	if cfg.SheetsID != "" {
+		sheets := SheetsConfig{SpreadsheetID: cfg.SheetsID, CredentialsFile: cfg.SheetsCredentials, Range: cfg.SheetsRange}
+		err := runDailyJob(cfg.StateDir, cfg.HistoryFile, "last_sheets_export", now, func(day time.Time, history []HistoryEntry) error {
+			summary := summarizeDay(history, day)
+			if summary == nil {
+				return nil
+			}
+			return appendToSheet(sheets, summary.row(rc.hostname), now)
+		})
+		if err != nil {
+			log.Printf("WARNING: %v", err)
+		}
+	}
+}
+
+// Uploads the whole CSV file, replacing the previous day's copy, as
+// <prefix>/<host>/<file> with --archive-url and as <host>_<file> with
+// --delivery-url.
+func (rc *runContext) uploadCSVExport(now time.Time) {
+	cfg := rc.cfg
+	base := filepath.Base(cfg.CSVExport)
+	upload := func(job string, do func(data []byte) error) {
+		err := runDailyJob(cfg.StateDir, cfg.HistoryFile, job, now, func(day time.Time, history []HistoryEntry) error {
+			data, err := os.ReadFile(cfg.CSVExport)
+			if os.IsNotExist(err) {
+				// No summary written yet
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read CSV export: %v", err)
+			}
+			return do(data)
+		})
+		if err != nil {
+			log.Printf("WARNING: %v", err)
+		}
+	}
+	if cfg.ArchiveURL != "" {
+		name := path.Join(rc.hostname, base)
+		upload("last_csv_archive", func(data []byte) error {
+			if err := uploadArchiveObject(archiveConfig(cfg), name, data, now); err != nil {
+				return err
+			}
+			log.Printf("Archived %s", name)
+			return nil
+		})
+	}
+	if cfg.DeliveryURL != "" {
+		name := rc.hostname + "_" + base
+		upload("last_csv_delivery", func(data []byte) error {
+			if err := deliverFile(deliveryConfig(cfg), name, data); err != nil {
+				return err
+			}
+			log.Printf("Delivered %s", name)
+			return nil
+		})
+	}
+}
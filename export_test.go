@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testSummary() *dailySummary {
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	return summarizeDay([]HistoryEntry{
+		{Timestamp: day.Add(time.Hour), Download: 80.123, Upload: 20, Ping: 15, Jitter: 2},
+		{Timestamp: day.Add(2 * time.Hour), Download: 100, Upload: 40, Ping: 10, Jitter: 1},
+	}, day)
+}
+
+func TestAppendCSV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "summary.csv")
+	summary := testSummary()
+
+	for i := 0; i < 2; i++ {
+		if err := appendCSV(path, summary.row("host1")); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read CSV: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected header and two rows, got %d lines:\n%s", len(lines), data)
+	}
+	if !strings.HasPrefix(lines[0], "date,host,runs,download_min_mbps") {
+		t.Errorf("Unexpected header: %s", lines[0])
+	}
+	if want := "2024-05-01,host1,2,80.12,90.06,100,20,30,40,10,12.5,15,1,1.5,2"; lines[1] != want {
+		t.Errorf("Expected row %q, got %q", want, lines[1])
+	}
+}
+
+func TestExportDailySummary_UploadsCSV(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	uploads := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		uploads[r.URL.Path] = string(body)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	rc := newTestRunContext(t, "http://127.0.0.1:1/write", &MockRunner{})
+	rc.cfg.StateDir = dir
+	rc.cfg.HistoryFile = filepath.Join(dir, "history.json")
+	rc.cfg.CSVExport = filepath.Join(dir, "speedtest.csv")
+	rc.cfg.ArchiveURL = "s3://results/branch-7"
+	rc.cfg.ArchiveEndpoint = server.URL
+
+	now := time.Date(2024, 5, 2, 0, 10, 0, 0, time.Local)
+	history := []HistoryEntry{
+		{Timestamp: now.Add(-5 * time.Hour), Download: 50},
+		{Timestamp: now, Download: 70},
+	}
+	if err := saveHistory(rc.cfg.HistoryFile, history, 0, 0); err != nil {
+		t.Fatalf("Failed to save history: %v", err)
+	}
+
+	rc.exportDailySummary(now)
+	body, ok := uploads["/results/branch-7/host1/speedtest.csv"]
+	if !ok {
+		t.Fatalf("Expected the CSV uploaded next to the archive, got %v", uploads)
+	}
+	if lines := strings.Split(strings.TrimSpace(body), "\n"); len(lines) != 2 || !strings.HasPrefix(lines[1], "2024-05-01,host1,1,") {
+		t.Errorf("Expected the header and yesterday's row, got:\n%s", body)
+	}
+
+	// Once a day, like the row itself
+	delete(uploads, "/results/branch-7/host1/speedtest.csv")
+	rc.exportDailySummary(now.Add(time.Hour))
+	if len(uploads) != 0 {
+		t.Errorf("Expected no second upload the same day, got %v", uploads)
+	}
+}
+
+func writeTestServiceAccount(t *testing.T, tokenURI string) string {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+	data, _ := json.Marshal(map[string]string{
+		"client_email": "exporter@project.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":    tokenURI,
+	})
+	path := filepath.Join(t.TempDir(), "sa.json")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	return path
+}
+
+func TestAppendToSheet(t *testing.T) {
+	var gotPath, gotAuth string
+	var gotBody struct {
+		Values [][]any `json:"values"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			r.ParseForm()
+			if r.Form.Get("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" || strings.Count(r.Form.Get("assertion"), ".") != 2 {
+				http.Error(w, "bad grant", http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"access_token":"ya29.test","expires_in":3600}`))
+			return
+		}
+		gotPath = r.URL.EscapedPath()
+		gotAuth = r.Header.Get("Authorization")
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &gotBody)
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	originalBase := sheetsAPIBase
+	sheetsAPIBase = server.URL
+	defer func() { sheetsAPIBase = originalBase }()
+
+	cfg := SheetsConfig{
+		SpreadsheetID:   "sheet123",
+		CredentialsFile: writeTestServiceAccount(t, server.URL+"/token"),
+		Range:           "Sheet1!A1",
+	}
+	if err := appendToSheet(cfg, testSummary().row("host1"), time.Now()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if gotPath != "/v4/spreadsheets/sheet123/values/Sheet1%21A1:append" {
+		t.Errorf("Unexpected append path: %s", gotPath)
+	}
+	if gotAuth != "Bearer ya29.test" {
+		t.Errorf("Expected bearer token, got %q", gotAuth)
+	}
+	if len(gotBody.Values) != 1 || gotBody.Values[0][0] != "2024-05-01" || gotBody.Values[0][3] != 80.12 {
+		t.Errorf("Unexpected row: %v", gotBody.Values)
+	}
+}
+
+func TestAppendToSheet_InvalidKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sa.json")
+	os.WriteFile(path, []byte(`{"client_email":"x@y","private_key":"not pem"}`), 0600)
+	err := appendToSheet(SheetsConfig{SpreadsheetID: "s", CredentialsFile: path, Range: "A1"}, nil, time.Now())
+	if err == nil || !strings.Contains(err.Error(), "PEM") {
+		t.Errorf("Expected PEM error, got %v", err)
+	}
+}
@@ -0,0 +1,25 @@
+//go:build !minimal
+
+package main
+
+import (
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// Expressions over derivedEnv for --derived-metric and the assert
+// subcommand, left out of -tags minimal builds with expr.
+type exprProgram = vm.Program
+
+// Compiles source to yield a bool, or a float64 when asBool is false.
+func compileExpr(source string, asBool bool) (*exprProgram, error) {
+	result := expr.AsFloat64()
+	if asBool {
+		result = expr.AsBool()
+	}
+	return expr.Compile(source, expr.Env(derivedEnv{}), result)
+}
+
+func runExpr(program *exprProgram, env derivedEnv) (any, error) {
+	return expr.Run(program, env)
+}
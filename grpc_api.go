@@ -0,0 +1,238 @@
+//go:build !minimal
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Implements librespeed.v1.Librespeed from api/librespeed.proto. The service
+// only uses well-known message types, so it is registered by hand instead of
+// through generated code.
+type grpcServer struct {
+	run      func(ctx context.Context) (*RunRecord, error)
+	progress *progressHub
+	audit    *auditLog
+	auth     *apiAuth
+	limits   *triggerLimiter
+}
+
+type librespeedService interface {
+	RunTest(ctx context.Context, in *emptypb.Empty) (*structpb.Struct, error)
+	GetLastResult(ctx context.Context, in *emptypb.Empty) (*structpb.Struct, error)
+	WatchProgress(in *emptypb.Empty, stream grpc.ServerStream) error
+}
+
+func recordStruct(record *RunRecord) (*structpb.Struct, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return structpb.NewStruct(fields)
+}
+
+func (s *grpcServer) RunTest(ctx context.Context, in *emptypb.Empty) (*structpb.Struct, error) {
+	addr := "grpc"
+	if p, ok := peer.FromContext(ctx); ok {
+		addr = p.Addr.String()
+	}
+	actor := addr
+	if name := tokenName(ctx); name != "" {
+		actor = name + " from " + addr
+	}
+	release, err := s.limits.acquire(triggerCaller(tokenName(ctx), addr))
+	if err != nil {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+	defer release()
+
+	s.audit.record("run_triggered", actor, "gRPC RunTest")
+	record, err := s.run(ctx)
+	if record == nil {
+		if err != nil {
+			return nil, status.Errorf(codes.Unavailable, "run did not complete: %v", err)
+		}
+		return nil, status.Error(codes.FailedPrecondition, "test skipped (maintenance or quiet hours)")
+	}
+	// A failed test still produced a record; its status field says so
+	return recordStruct(record)
+}
+
+func (s *grpcServer) GetLastResult(ctx context.Context, in *emptypb.Empty) (*structpb.Struct, error) {
+	record := s.progress.lastResult()
+	if record == nil {
+		return nil, status.Error(codes.NotFound, "no run has completed yet")
+	}
+	return recordStruct(record)
+}
+
+func (s *grpcServer) WatchProgress(in *emptypb.Empty, stream grpc.ServerStream) error {
+	events, stop := s.progress.subscribe()
+	defer stop()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case event := <-events:
+			fields := map[string]any{
+				"stage": event.Stage,
+				"time":  event.Time.Format(time.RFC3339Nano),
+			}
+			msg, err := structpb.NewStruct(fields)
+			if err != nil {
+				return err
+			}
+			if event.Record != nil {
+				result, err := recordStruct(event.Record)
+				if err != nil {
+					return err
+				}
+				msg.Fields["result"] = structpb.NewStructValue(result)
+			}
+			if err := stream.SendMsg(msg); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func unaryHandler(method string, call func(srv librespeedService, ctx context.Context, in *emptypb.Empty) (*structpb.Struct, error)) grpc.MethodHandler {
+	return func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+		in := new(emptypb.Empty)
+		if err := dec(in); err != nil {
+			return nil, err
+		}
+		if interceptor == nil {
+			return call(srv.(librespeedService), ctx, in)
+		}
+		info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/librespeed.v1.Librespeed/" + method}
+		return interceptor(ctx, in, info, func(ctx context.Context, req any) (any, error) {
+			return call(srv.(librespeedService), ctx, req.(*emptypb.Empty))
+		})
+	}
+}
+
+var librespeedServiceDesc = grpc.ServiceDesc{
+	ServiceName: "librespeed.v1.Librespeed",
+	HandlerType: (*librespeedService)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "RunTest",
+			Handler:    unaryHandler("RunTest", librespeedService.RunTest),
+		},
+		{
+			MethodName: "GetLastResult",
+			Handler:    unaryHandler("GetLastResult", librespeedService.GetLastResult),
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName: "WatchProgress",
+			Handler: func(srv any, stream grpc.ServerStream) error {
+				in := new(emptypb.Empty)
+				if err := stream.RecvMsg(in); err != nil {
+					return err
+				}
+				return srv.(librespeedService).WatchProgress(in, stream)
+			},
+			ServerStreams: true,
+		},
+	},
+	Metadata: "api/librespeed.proto",
+}
+
+func newGRPCServer(impl *grpcServer) *grpc.Server {
+	var opts []grpc.ServerOption
+	if impl.auth != nil {
+		opts = append(opts, grpc.UnaryInterceptor(impl.auth.unaryInterceptor), grpc.StreamInterceptor(impl.auth.streamInterceptor))
+	}
+	server := grpc.NewServer(opts...)
+	server.RegisterService(&librespeedServiceDesc, impl)
+	return server
+}
+
+// Serves the gRPC API on addr until ctx is cancelled.
+func serveGRPC(ctx context.Context, addr string, impl *grpcServer) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for gRPC: %v", err)
+	}
+	server := newGRPCServer(impl)
+
+	go func() {
+		<-ctx.Done()
+		// Not GracefulStop: open WatchProgress streams would hold it forever
+		server.Stop()
+	}()
+
+	log.Printf("Serving gRPC API on %s", addr)
+	return server.Serve(ln)
+}
+
+// Scope a gRPC method needs.
+func grpcScope(fullMethod string) apiScope {
+	if fullMethod == "/librespeed.v1.Librespeed/RunTest" {
+		return scopeOperator
+	}
+	return scopeRead
+}
+
+// Checks the "authorization: Bearer" metadata of a call and returns the
+// context to continue with. Like /probe, RunTest has no opt-in flag of its
+// own, so it is only served with tokens configured.
+func (a *apiAuth) authorizeGRPC(ctx context.Context, fullMethod string) (context.Context, error) {
+	var presented string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			presented, _ = strings.CutPrefix(values[0], "Bearer ")
+		}
+	}
+	token, ok := a.lookup(presented)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "missing or invalid API token")
+	}
+	need := grpcScope(fullMethod)
+	if token == nil {
+		if need == scopeOperator {
+			return nil, status.Errorf(codes.PermissionDenied, "%s requires an --api-token with scope %s", fullMethod, need)
+		}
+		return ctx, nil
+	}
+	if token.Scope < need {
+		return nil, status.Errorf(codes.PermissionDenied, "token %s has scope %s, %s required", token.Name, token.Scope, need)
+	}
+	return context.WithValue(ctx, apiTokenKey{}, token.Name), nil
+}
+
+func (a *apiAuth) unaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := a.authorizeGRPC(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (a *apiAuth) streamInterceptor(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if _, err := a.authorizeGRPC(stream.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, stream)
+}
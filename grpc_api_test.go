@@ -0,0 +1,233 @@
+//go:build !minimal
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func newTestGRPCClient(t *testing.T, impl *grpcServer) *grpc.ClientConn {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	server := newGRPCServer(impl)
+	go server.Serve(ln)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient(ln.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestGRPC_RunTestAndGetLastResult(t *testing.T) {
+	hub := newProgressHub()
+	impl := &grpcServer{
+		progress: hub,
+		run: func(ctx context.Context) (*RunRecord, error) {
+			record := newRunRecord("", &LibrespeedResult{Download: 95, Upload: 40}, nil, time.Second)
+			hub.publish(stageSucceeded, &record)
+			return &record, nil
+		},
+	}
+	conn := newTestGRPCClient(t, impl)
+	ctx := context.Background()
+
+	out := &structpb.Struct{}
+	err := conn.Invoke(ctx, "/librespeed.v1.Librespeed/GetLastResult", &emptypb.Empty{}, out)
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Expected NotFound before any run, got %v", err)
+	}
+
+	if err := conn.Invoke(ctx, "/librespeed.v1.Librespeed/RunTest", &emptypb.Empty{}, out); err != nil {
+		t.Fatalf("RunTest failed: %v", err)
+	}
+	if got := out.Fields["download_mbps"].GetNumberValue(); got != 95 {
+		t.Errorf("Expected download_mbps 95, got %v (%v)", got, out)
+	}
+
+	last := &structpb.Struct{}
+	if err := conn.Invoke(ctx, "/librespeed.v1.Librespeed/GetLastResult", &emptypb.Empty{}, last); err != nil {
+		t.Fatalf("GetLastResult failed: %v", err)
+	}
+	if got := last.Fields["upload_mbps"].GetNumberValue(); got != 40 {
+		t.Errorf("Expected upload_mbps 40, got %v", got)
+	}
+}
+
+func TestGRPC_RunTestLimited(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	limits := &triggerLimiter{}
+	limits.set(0, 1)
+	impl := &grpcServer{
+		progress: newProgressHub(),
+		limits:   limits,
+		run: func(ctx context.Context) (*RunRecord, error) {
+			close(started)
+			<-release
+			record := newRunRecord("", &LibrespeedResult{Download: 95}, nil, time.Second)
+			return &record, nil
+		},
+	}
+	conn := newTestGRPCClient(t, impl)
+	ctx := context.Background()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- conn.Invoke(ctx, "/librespeed.v1.Librespeed/RunTest", &emptypb.Empty{}, &structpb.Struct{})
+	}()
+	<-started
+	err := conn.Invoke(ctx, "/librespeed.v1.Librespeed/RunTest", &emptypb.Empty{}, &structpb.Struct{})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Expected ResourceExhausted while a test is running, got %v", err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Errorf("Expected the first RunTest to succeed, got %v", err)
+	}
+}
+
+func TestGRPC_RunTestSkippedAndAborted(t *testing.T) {
+	var runErr error
+	impl := &grpcServer{
+		progress: newProgressHub(),
+		run: func(ctx context.Context) (*RunRecord, error) {
+			return nil, runErr
+		},
+	}
+	conn := newTestGRPCClient(t, impl)
+	out := &structpb.Struct{}
+
+	err := conn.Invoke(context.Background(), "/librespeed.v1.Librespeed/RunTest", &emptypb.Empty{}, out)
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition for a skipped run, got %v", err)
+	}
+	runErr = errors.New("context canceled")
+	err = conn.Invoke(context.Background(), "/librespeed.v1.Librespeed/RunTest", &emptypb.Empty{}, out)
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("Expected Unavailable for an aborted run, got %v", err)
+	}
+}
+
+func TestGRPC_WatchProgress(t *testing.T) {
+	hub := newProgressHub()
+	conn := newTestGRPCClient(t, &grpcServer{progress: hub})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := conn.NewStream(ctx, &librespeedServiceDesc.Streams[0], "/librespeed.v1.Librespeed/WatchProgress")
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	if err := stream.SendMsg(&emptypb.Empty{}); err != nil {
+		t.Fatalf("SendMsg failed: %v", err)
+	}
+	stream.CloseSend()
+
+	// The subscription starts when the server handles the call; keep
+	// publishing until the first event comes through.
+	received := make(chan *structpb.Struct, 1)
+	go func() {
+		msg := &structpb.Struct{}
+		if err := stream.RecvMsg(msg); err == nil {
+			received <- msg
+		}
+	}()
+	var first *structpb.Struct
+	deadline := time.After(5 * time.Second)
+	for first == nil {
+		hub.publish(stageTesting, nil)
+		select {
+		case first = <-received:
+		case <-deadline:
+			t.Fatal("No progress event received")
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+	if got := first.Fields["stage"].GetStringValue(); got != stageTesting {
+		t.Errorf("Expected stage %q, got %q", stageTesting, got)
+	}
+
+	record := newRunRecord("", &LibrespeedResult{Download: 95}, nil, time.Second)
+	hub.publish(stageSucceeded, &record)
+	for {
+		msg := &structpb.Struct{}
+		if err := stream.RecvMsg(msg); err != nil {
+			t.Fatalf("RecvMsg failed: %v", err)
+		}
+		if msg.Fields["stage"].GetStringValue() != stageSucceeded {
+			continue
+		}
+		result := msg.Fields["result"].GetStructValue()
+		if got := result.Fields["download_mbps"].GetNumberValue(); got != 95 {
+			t.Errorf("Expected result download_mbps 95, got %v", got)
+		}
+		break
+	}
+}
+
+func TestAPIAuth_GRPC(t *testing.T) {
+	impl := &grpcServer{
+		progress: newProgressHub(),
+		auth:     newTestAPIAuth(t, "grafana=read:r1", "ci=operator:o1"),
+		run: func(ctx context.Context) (*RunRecord, error) {
+			record := newRunRecord("", &LibrespeedResult{Download: 95}, nil, time.Second)
+			return &record, nil
+		},
+	}
+	conn := newTestGRPCClient(t, impl)
+	withToken := func(token string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+	}
+
+	out := &structpb.Struct{}
+	err := conn.Invoke(context.Background(), "/librespeed.v1.Librespeed/RunTest", &emptypb.Empty{}, out)
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected Unauthenticated without a token, got %v", err)
+	}
+	err = conn.Invoke(withToken("r1"), "/librespeed.v1.Librespeed/RunTest", &emptypb.Empty{}, out)
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected PermissionDenied for a read token, got %v", err)
+	}
+	if err := conn.Invoke(withToken("o1"), "/librespeed.v1.Librespeed/RunTest", &emptypb.Empty{}, out); err != nil {
+		t.Errorf("Expected RunTest to succeed with an operator token, got %v", err)
+	}
+}
+
+func TestAPIAuth_GRPCRunTestNeedsTokens(t *testing.T) {
+	impl := &grpcServer{
+		progress: newProgressHub(),
+		auth:     &apiAuth{},
+		run: func(ctx context.Context) (*RunRecord, error) {
+			t.Error("Expected RunTest not to start a test without tokens configured")
+			return nil, nil
+		},
+	}
+	conn := newTestGRPCClient(t, impl)
+
+	err := conn.Invoke(context.Background(), "/librespeed.v1.Librespeed/RunTest", &emptypb.Empty{}, &structpb.Struct{})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected PermissionDenied without tokens configured, got %v", err)
+	}
+	err = conn.Invoke(context.Background(), "/librespeed.v1.Librespeed/GetLastResult", &emptypb.Empty{}, &structpb.Struct{})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Expected GetLastResult to stay open without tokens, got %v", err)
+	}
+}
@@ -0,0 +1,158 @@
+//go:build testharness
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Fault-injection fakes for integration testing, only compiled with
+// -tags testharness so they can never be enabled in a release build.
+
+var (
+	harnessRemoteWrite string
+	harnessResults     string
+)
+
+func registerHarnessFlags(fs *flag.FlagSet) {
+	fs.StringVar(&harnessRemoteWrite, "harness-remote-write", "", "Start a local remote write receiver answering with this status sequence, e.g. 503,503,200")
+	fs.StringVar(&harnessResults, "harness-results", "", "JSON file of scripted speed test results/errors used instead of librespeed-cli")
+}
+
+// Starts the requested fakes and points cfg at them. Returns a runner to use
+// instead of librespeed-cli, or nil to run the real CLI.
+func applyTestHarness(cfg *Config) (CommandRunner, error) {
+	if harnessRemoteWrite != "" {
+		receiver, err := newFlakyReceiver(harnessRemoteWrite)
+		if err != nil {
+			return nil, err
+		}
+		url, err := receiver.Start()
+		if err != nil {
+			return nil, err
+		}
+		cfg.URL = url
+		if cfg.Username == "" {
+			cfg.Username = "harness"
+		}
+		if cfg.Password == "" {
+			cfg.Password = "harness"
+		}
+		log.Printf("TEST HARNESS: remote write receiver at %s with statuses %s", url, harnessRemoteWrite)
+	}
+	if harnessResults != "" {
+		runner, err := loadScriptedRunner(harnessResults)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("TEST HARNESS: using %d scripted speed test results from %s", len(runner.steps), harnessResults)
+		return runner, nil
+	}
+	return nil, nil
+}
+
+// FlakyReceiver answers remote write requests with a scripted sequence of
+// status codes; once the sequence is exhausted it keeps returning the last.
+type FlakyReceiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests int
+}
+
+func newFlakyReceiver(sequence string) (*FlakyReceiver, error) {
+	var statuses []int
+	for _, part := range strings.Split(sequence, ",") {
+		code, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || code < 100 || code > 599 {
+			return nil, fmt.Errorf("invalid status %q in harness sequence", part)
+		}
+		statuses = append(statuses, code)
+	}
+	return &FlakyReceiver{statuses: statuses}, nil
+}
+
+func (f *FlakyReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	io.Copy(io.Discard, r.Body)
+	f.mu.Lock()
+	i := f.requests
+	if i >= len(f.statuses) {
+		i = len(f.statuses) - 1
+	}
+	f.requests++
+	status := f.statuses[i]
+	f.mu.Unlock()
+
+	log.Printf("TEST HARNESS: remote write request %d -> %d", f.Requests(), status)
+	w.WriteHeader(status)
+	if status >= 300 {
+		fmt.Fprintf(w, "injected failure %d", status)
+	}
+}
+
+func (f *FlakyReceiver) Requests() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests
+}
+
+func (f *FlakyReceiver) Start() (string, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", fmt.Errorf("failed to start harness receiver: %v", err)
+	}
+	go http.Serve(ln, f)
+	return "http://" + ln.Addr().String() + "/api/v1/write", nil
+}
+
+type scriptedStep struct {
+	Result *LibrespeedResult `json:"result,omitempty"`
+	Error  string            `json:"error,omitempty"`
+}
+
+// ScriptedRunner stands in for librespeed-cli, replaying steps in order and
+// repeating the last one. Other commands go to the real runner.
+type ScriptedRunner struct {
+	mu    sync.Mutex
+	steps []scriptedStep
+	next  int
+}
+
+func loadScriptedRunner(path string) (*ScriptedRunner, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read harness results: %v", err)
+	}
+	var steps []scriptedStep
+	if err := json.Unmarshal(data, &steps); err != nil {
+		return nil, fmt.Errorf("failed to parse harness results: %v", err)
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("no steps in %s", path)
+	}
+	return &ScriptedRunner{steps: steps}, nil
+}
+
+func (s *ScriptedRunner) Run(name string, args ...string) ([]byte, error) {
+	if !strings.Contains(strings.Join(args, " "), "--json") {
+		return (&DefaultRunner{}).Run(name, args...)
+	}
+	s.mu.Lock()
+	step := s.steps[min(s.next, len(s.steps)-1)]
+	s.next++
+	s.mu.Unlock()
+
+	if step.Error != "" {
+		return nil, fmt.Errorf("%s", step.Error)
+	}
+	return json.Marshal([]LibrespeedResult{*step.Result})
+}
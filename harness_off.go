@@ -0,0 +1,9 @@
+//go:build !testharness
+
+package main
+
+import "flag"
+
+func registerHarnessFlags(fs *flag.FlagSet) {}
+
+func applyTestHarness(cfg *Config) (CommandRunner, error) { return nil, nil }
@@ -0,0 +1,67 @@
+//go:build testharness
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func TestFlakyReceiver_RetryRecovers(t *testing.T) {
+	originalDelayFunc := retryDelayFunc
+	retryDelayFunc = func(attempt int) time.Duration { return 0 }
+	defer func() { retryDelayFunc = originalDelayFunc }()
+
+	receiver, err := newFlakyReceiver("503,500,200")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	url, err := receiver.Start()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	series := []*prompb.TimeSeries{createTimeSeries("test_metric", 1, time.Now().UnixMilli(), "http://server", "host")}
+	if err := sendToRemoteWriteWithRetry(context.Background(), url, "user", "pass", series, 3); err != nil {
+		t.Fatalf("Expected success after injected failures, got %v", err)
+	}
+	if receiver.Requests() != 3 {
+		t.Errorf("Expected 3 requests, got %d", receiver.Requests())
+	}
+}
+
+func TestFlakyReceiver_InvalidSequence(t *testing.T) {
+	if _, err := newFlakyReceiver("503,abc"); err == nil {
+		t.Error("Expected error for invalid sequence, got nil")
+	}
+}
+
+func TestScriptedRunner(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.json")
+	script := `[{"error":"server unreachable"},{"result":{"download":90,"upload":20,"ping":12,"jitter":1,"server":{"url":"http://fake"}}}]`
+	if err := os.WriteFile(path, []byte(script), 0644); err != nil {
+		t.Fatalf("Failed to write script: %v", err)
+	}
+	runner, err := loadScriptedRunner(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if _, err := runLibrespeed(runner, "librespeed-cli", "", nil); err == nil {
+		t.Error("Expected scripted error on the first run, got nil")
+	}
+	for i := 0; i < 2; i++ {
+		result, err := runLibrespeed(runner, "librespeed-cli", "", nil)
+		if err != nil {
+			t.Fatalf("Expected scripted result, got %v", err)
+		}
+		if result.Download != 90 || result.Server.URL != "http://fake" {
+			t.Errorf("Unexpected result %+v", result)
+		}
+	}
+}
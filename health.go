@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// What /healthz and /readyz report, taken from the outcome of each run.
+type healthStatus struct {
+	Ready        bool       `json:"ready"`
+	Reason       string     `json:"reason,omitempty"`
+	CLIAvailable *bool      `json:"cli_available,omitempty"`
+	LastRun      *time.Time `json:"last_run,omitempty"`
+	LastSuccess  *time.Time `json:"last_success,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+	LastPushOK   *bool      `json:"last_push_ok,omitempty"`
+}
+
+// Tracks run outcomes for the health endpoints. A nil tracker ignores
+// them, like progressHub.
+type healthTracker struct {
+	pushConfigured bool
+
+	mu     sync.Mutex
+	status healthStatus
+}
+
+func newHealthTracker(pushConfigured bool) *healthTracker {
+	return &healthTracker{
+		pushConfigured: pushConfigured,
+		status:         healthStatus{Reason: "no test has completed yet"},
+	}
+}
+
+// Records a finished run. stage is the failed stage as in RunRecord: install
+// means the CLI is missing, remote_write that the test passed but the push
+// did not.
+func (h *healthTracker) recordRun(stage string, runErr error, ts time.Time) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := &h.status
+	ts = ts.UTC()
+	s.LastRun = &ts
+	cliAvailable := stage != "install"
+	s.CLIAvailable = &cliAvailable
+	s.LastError = ""
+	if runErr != nil {
+		s.LastError = runErr.Error()
+	}
+	s.LastPushOK = nil
+	if h.pushConfigured && (stage == "" || stage == "remote_write") {
+		pushOK := stage == ""
+		s.LastPushOK = &pushOK
+	}
+
+	switch stage {
+	case "":
+		s.LastSuccess = &ts
+		s.Ready, s.Reason = true, ""
+	case "install":
+		s.Ready, s.Reason = false, "librespeed-cli is not available"
+	case "remote_write":
+		s.Ready, s.Reason = false, "last remote_write push failed"
+	default:
+		s.Ready, s.Reason = false, fmt.Sprintf("last test failed at stage %s", stage)
+	}
+}
+
+func (h *healthTracker) snapshot() healthStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.status
+}
+
+// /healthz answers 200 while the process serves requests; restarting would
+// not fix a failing test. /readyz answers 503 until a run has succeeded end
+// to end and again whenever the last one did not.
+func (h *healthTracker) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, h.snapshot())
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		status := h.snapshot()
+		code := http.StatusOK
+		if !status.Ready {
+			code = http.StatusServiceUnavailable
+		}
+		writeJSON(w, code, status)
+	})
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func getHealth(t *testing.T, url string) (int, healthStatus) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s failed: %v", url, err)
+	}
+	defer resp.Body.Close()
+	var status healthStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		t.Fatalf("Failed to decode %s: %v", url, err)
+	}
+	return resp.StatusCode, status
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	health := newHealthTracker(true)
+	mux := http.NewServeMux()
+	health.register(mux)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	code, status := getHealth(t, server.URL+"/readyz")
+	if code != http.StatusServiceUnavailable || status.Reason != "no test has completed yet" {
+		t.Errorf("Expected 503 before the first run, got %d %+v", code, status)
+	}
+	if code, _ := getHealth(t, server.URL+"/healthz"); code != http.StatusOK {
+		t.Errorf("Expected /healthz 200 before the first run, got %d", code)
+	}
+
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	health.recordRun("", nil, now)
+	code, status = getHealth(t, server.URL+"/readyz")
+	if code != http.StatusOK || !status.Ready || status.LastSuccess == nil || !status.LastSuccess.Equal(now) {
+		t.Errorf("Expected ready after a successful run, got %d %+v", code, status)
+	}
+	if status.CLIAvailable == nil || !*status.CLIAvailable || status.LastPushOK == nil || !*status.LastPushOK {
+		t.Errorf("Expected CLI available and push OK, got %+v", status)
+	}
+
+	health.recordRun("remote_write", errors.New("503 Service Unavailable"), now.Add(time.Hour))
+	code, status = getHealth(t, server.URL+"/readyz")
+	if code != http.StatusServiceUnavailable || *status.LastPushOK || status.LastError != "503 Service Unavailable" {
+		t.Errorf("Expected 503 after a failed push, got %d %+v", code, status)
+	}
+	if !status.LastSuccess.Equal(now) {
+		t.Errorf("Expected last success to stay at %v, got %v", now, status.LastSuccess)
+	}
+	if code, _ := getHealth(t, server.URL+"/healthz"); code != http.StatusOK {
+		t.Errorf("Expected /healthz to stay 200 after a failure, got %d", code)
+	}
+}
+
+func TestHealthTracker_RecordRun(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	health := newHealthTracker(false)
+	health.recordRun("install", errors.New("download failed"), now)
+	status := health.snapshot()
+	if status.Ready || *status.CLIAvailable || status.Reason != "librespeed-cli is not available" {
+		t.Errorf("Expected CLI unavailable, got %+v", status)
+	}
+
+	health.recordRun("speedtest", errors.New("exit status 1"), now)
+	status = health.snapshot()
+	if status.Ready || !*status.CLIAvailable || status.Reason != "last test failed at stage speedtest" {
+		t.Errorf("Expected failed test, got %+v", status)
+	}
+
+	health.recordRun("", nil, now)
+	if status := health.snapshot(); !status.Ready || status.LastPushOK != nil {
+		t.Errorf("Expected ready without push status in pull mode, got %+v", status)
+	}
+
+	var nilTracker *healthTracker
+	nilTracker.recordRun("", nil, now)
+}
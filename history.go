@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Keeps the last results on disk so trends can be evaluated across runs of
+// an otherwise stateless, one-shot process.
+type HistoryEntry struct {
+	Timestamp time.Time `json:"timestamp"`
+	Server    string    `json:"server"`
+	Download  float64   `json:"download"`
+	Upload    float64   `json:"upload"`
+	Ping      float64   `json:"ping"`
+	Jitter    float64   `json:"jitter"`
+}
+
+func newHistoryEntry(result *LibrespeedResult, ts time.Time) HistoryEntry {
+	return HistoryEntry{
+		Timestamp: ts,
+		Server:    result.Server.URL,
+		Download:  result.Download,
+		Upload:    result.Upload,
+		Ping:      result.Ping,
+		Jitter:    result.Jitter,
+	}
+}
+
+func loadHistory(path string) ([]HistoryEntry, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history file: %v", err)
+	}
+	var entries []HistoryEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse history file: %v", err)
+	}
+	return entries, nil
+}
+
+// Writes the history atomically (temp file + rename) keeping only the newest
+// maxEntries, so a crash mid-write never leaves a truncated file behind.
+// Entries within keep of the newest one stay even beyond maxEntries.
+func saveHistory(path string, entries []HistoryEntry, maxEntries int, keep time.Duration) error {
+	if maxEntries > 0 && len(entries) > maxEntries {
+		start := len(entries) - maxEntries
+		if keep > 0 {
+			cutoff := entries[len(entries)-1].Timestamp.Add(-keep)
+			for start > 0 && entries[start-1].Timestamp.After(cutoff) {
+				start--
+			}
+		}
+		entries = entries[start:]
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal history: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to create history temp file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write history: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write history: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace history file: %v", err)
+	}
+	return nil
+}
+
+// How far back the history file has to reach whatever --history-size says.
+// The daily jobs read all of yesterday on the first run of today, up to 48h
+// after yesterday began, and the daemon's rolling percentiles read their
+// longest window.
+func historyRetention(cfg *Config) time.Duration {
+	if cfg.DailyRollup || cfg.CSVExport != "" || cfg.SheetsID != "" || cfg.ArchiveURL != "" ||
+		cfg.DeliveryURL != "" && cfg.DeliveryMode == "daily" {
+		return 48 * time.Hour
+	}
+	var keep time.Duration
+	if cfg.Interval > 0 || cfg.Schedule != "" {
+		for _, window := range rollingWindows {
+			keep = max(keep, window.Duration)
+		}
+	}
+	return keep
+}
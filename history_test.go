@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSaveAndLoadHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.json")
+
+	entries, err := loadHistory(path)
+	if err != nil {
+		t.Fatalf("Expected no error for missing history, got %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Expected empty history, got %d entries", len(entries))
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	for i := 0; i < 5; i++ {
+		entries = append(entries, newHistoryEntry(&LibrespeedResult{Download: float64(i)}, now.Add(time.Duration(i)*time.Minute)))
+	}
+	if err := saveHistory(path, entries, 3, 0); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	loaded, err := loadHistory(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(loaded) != 3 {
+		t.Fatalf("Expected 3 entries after trimming, got %d", len(loaded))
+	}
+	if loaded[0].Download != 2 || loaded[2].Download != 4 {
+		t.Errorf("Expected newest entries to be kept, got %+v", loaded)
+	}
+	if !loaded[2].Timestamp.Equal(now.Add(4 * time.Minute)) {
+		t.Errorf("Timestamp not preserved: %v", loaded[2].Timestamp)
+	}
+}
+
+func TestLoadHistory_Corrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.json")
+	if err := os.WriteFile(path, []byte("{not json"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if _, err := loadHistory(path); err == nil {
+		t.Error("Expected error for corrupt history, got nil")
+	}
+}
+
+func TestSaveHistory_KeepsRetention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.json")
+	now := time.Now().UTC().Truncate(time.Second)
+	var entries []HistoryEntry
+	// Every 15 minutes for three days: far more than 100 entries
+	for ts := now.Add(-72 * time.Hour); !ts.After(now); ts = ts.Add(15 * time.Minute) {
+		entries = append(entries, newHistoryEntry(&LibrespeedResult{}, ts))
+	}
+	if err := saveHistory(path, entries, 100, 48*time.Hour); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	loaded, err := loadHistory(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(loaded) != 48*4 {
+		t.Errorf("Expected the last 48h (192 entries) to be kept, got %d", len(loaded))
+	}
+
+	cfg := &Config{DeliveryURL: "sftp://host/reports", DeliveryMode: "daily"}
+	if got := historyRetention(cfg); got != 48*time.Hour {
+		t.Errorf("Expected 48h retention for daily delivery, got %v", got)
+	}
+	if got := historyRetention(&Config{Interval: 15 * time.Minute}); got != 24*time.Hour {
+		t.Errorf("Expected the 24h rolling window kept in daemon mode, got %v", got)
+	}
+	if got := historyRetention(&Config{}); got != 0 {
+		t.Errorf("Expected no retention without daily jobs, got %v", got)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestConfigHash_IgnoresSecrets(t *testing.T) {
+	hashFor := func(args ...string) string {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		cfg := &Config{}
+		cfg.RegisterFlags(fs)
+		if err := fs.Parse(args); err != nil {
+			t.Fatalf("Failed to parse flags: %v", err)
+		}
+		return configHash(fs)
+	}
+
+	base := hashFor("--url", "https://example.com/push", "--password", "one")
+	if hashFor("--url", "https://example.com/push", "--password", "two") != base {
+		t.Error("Expected the hash to ignore credential values")
+	}
+	if hashFor("--url", "https://other.example.com/push", "--password", "one") == base {
+		t.Error("Expected the hash to change with the configuration")
+	}
+}
+
+func TestPostInventory(t *testing.T) {
+	var received InventoryRecord
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("Failed to decode body: %v", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	record := newRunRecord("", &LibrespeedResult{Download: 100}, nil, time.Second)
+	inv := newInventoryRecord("agent-1", "host1", "abc", record, time.Now())
+	if err := postInventory(server.URL, inv); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if received.AgentID != "agent-1" || received.LastResult.Download != 100 || received.Version != version {
+		t.Errorf("Unexpected inventory record %+v", received)
+	}
+}
+
+func TestPostInventory_ServerError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	inv := newInventoryRecord("", "host1", "abc", RunRecord{}, time.Now())
+	if err := postInventory(server.URL, inv); err == nil {
+		t.Error("Expected error for server failure, got nil")
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Either Statuspage (statuspage.io) v2 endpoint, which most ISPs and CDNs
+// publish: /api/v2/status.json or /api/v2/incidents/unresolved.json.
+type ispStatusResponse struct {
+	Status *struct {
+		Indicator   string `json:"indicator"`
+		Description string `json:"description"`
+	} `json:"status"`
+	Incidents *[]struct {
+		Name string `json:"name"`
+	} `json:"incidents"`
+}
+
+// Reports whether the ISP's status page shows an ongoing incident, with a
+// short description for the log.
+func fetchISPStatus(statusURL string) (bool, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", statusURL, nil)
+	if err != nil {
+		return false, "", fmt.Errorf("failed to create HTTP request: %v", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, "", fmt.Errorf("failed to query ISP status: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode != http.StatusOK {
+		return false, "", fmt.Errorf("ISP status query failed: %s", resp.Status)
+	}
+
+	var status ispStatusResponse
+	if err := json.Unmarshal(body, &status); err != nil {
+		return false, "", fmt.Errorf("failed to parse ISP status: %v", err)
+	}
+	switch {
+	case status.Incidents != nil:
+		var names []string
+		for _, incident := range *status.Incidents {
+			names = append(names, incident.Name)
+		}
+		return len(names) > 0, strings.Join(names, "; "), nil
+	case status.Status != nil:
+		return status.Status.Indicator != "none", status.Status.Description, nil
+	}
+	return false, "", fmt.Errorf("ISP status response is not a Statuspage status or incidents document")
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFetchISPStatus(t *testing.T) {
+	cases := []struct {
+		name         string
+		body         string
+		wantIncident bool
+		wantDesc     string
+	}{
+		{"status none", `{"status":{"indicator":"none","description":"All Systems Operational"}}`, false, "All Systems Operational"},
+		{"status major", `{"status":{"indicator":"major","description":"Partial System Outage"}}`, true, "Partial System Outage"},
+		{"no incidents", `{"incidents":[]}`, false, ""},
+		{"incidents", `{"incidents":[{"name":"Fibre cut in Leeds"},{"name":"DNS degradation"}]}`, true, "Fibre cut in Leeds; DNS degradation"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(c.body))
+			}))
+			defer server.Close()
+
+			incident, desc, err := fetchISPStatus(server.URL)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if incident != c.wantIncident || desc != c.wantDesc {
+				t.Errorf("Expected %v %q, got %v %q", c.wantIncident, c.wantDesc, incident, desc)
+			}
+		})
+	}
+}
+
+func TestFetchISPStatus_Errors(t *testing.T) {
+	for _, body := range []string{`not json`, `{"page":{}}`} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(body))
+		}))
+		if _, _, err := fetchISPStatus(server.URL); err == nil {
+			t.Errorf("Expected error for %q, got nil", body)
+		}
+		server.Close()
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	if _, _, err := fetchISPStatus(server.URL); err == nil {
+		t.Error("Expected error for 503, got nil")
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const defaultLaunchdLabel = "io.librespeed.exporter"
+
+// macOS locations for --launchd: per-user for a LaunchAgent, system-wide
+// when running as root from a LaunchDaemon.
+func launchdDirs(root bool, home string) (stateDir, logFile string) {
+	base := home
+	if root {
+		base = "/"
+	}
+	return filepath.Join(base, "Library", "Application Support", "librespeed_exporter"),
+		filepath.Join(base, "Library", "Logs", "librespeed_exporter.log")
+}
+
+// Under launchd the working directory is / and the Windows defaults for
+// --state-dir and --logfile make no sense, so flags left unset get the
+// macOS locations instead.
+func applyLaunchdDefaults(cfg *Config, explicit map[string]bool) {
+	home, _ := os.UserHomeDir()
+	stateDir, logFile := launchdDirs(os.Getuid() == 0, home)
+	if !explicit["state-dir"] {
+		cfg.StateDir = stateDir
+	}
+	if !explicit["logfile"] {
+		cfg.LogFile = logFile
+	}
+}
+
+// Install prefixes of Homebrew on Apple silicon and Intel, the latter also
+// being where BSD packages go. launchd and rc.d start jobs with a PATH that
+// has neither.
+var cliSearchDirs = []string{"/opt/homebrew/bin", "/usr/local/bin"}
+
+func cliInstallHint() string {
+	if runtime.GOOS == "darwin" {
+		return "install it with brew install librespeed-cli"
+	}
+	if runtime.GOOS == "windows" {
+		return "download it from https://github.com/librespeed/speedtest-cli/releases and put it on PATH"
+	}
+	return "install it from https://github.com/librespeed/speedtest-cli/releases into /usr/local/bin"
+}
+
+func findLibrespeedCLI() (string, error) {
+	if path, err := exec.LookPath("librespeed-cli"); err == nil {
+		return path, nil
+	}
+	for _, dir := range cliSearchDirs {
+		path := filepath.Join(dir, "librespeed-cli")
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("failed to find librespeed-cli in PATH or %s; %s", strings.Join(cliSearchDirs, ", "), cliInstallHint())
+}
+
+func plistString(b *bytes.Buffer, s string) {
+	b.WriteString("<string>")
+	xml.EscapeText(b, []byte(s))
+	b.WriteString("</string>")
+}
+
+// Property list for a job that starts at load and is restarted if it exits
+// with an error, or with startInterval, is started by launchd that often for
+// a single run. Only stderr is captured; the exporter writes its own log.
+func launchdPlist(label string, program []string, stderrPath string, startInterval time.Duration) []byte {
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	`)
+	plistString(&b, label)
+	b.WriteString("\n\t<key>ProgramArguments</key>\n\t<array>\n")
+	for _, arg := range program {
+		b.WriteString("\t\t")
+		plistString(&b, arg)
+		b.WriteString("\n")
+	}
+	b.WriteString("\t</array>\n\t<key>StandardErrorPath</key>\n\t")
+	plistString(&b, stderrPath)
+	b.WriteString("\n\t<key>RunAtLoad</key>\n\t<true/>\n")
+	if startInterval > 0 {
+		// launchd coalesces intervals missed during sleep into one run
+		fmt.Fprintf(&b, "\t<key>StartInterval</key>\n\t<integer>%d</integer>\n", int(startInterval.Seconds()))
+	} else {
+		b.WriteString(`	<key>KeepAlive</key>
+	<dict>
+		<key>SuccessfulExit</key>
+		<false/>
+	</dict>
+`)
+	}
+	b.WriteString(`	<key>ProcessType</key>
+	<string>Background</string>
+</dict>
+</plist>
+`)
+	return b.Bytes()
+}
+
+// Whether args set any of the named flags, in any of the forms flag accepts.
+func hasFlagArg(args []string, names ...string) bool {
+	for _, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name, _, _ := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		for _, n := range names {
+			if strings.HasPrefix(arg, "-") && name == n {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// install-launchd writes a LaunchAgent (or with --daemon, a LaunchDaemon)
+// plist that runs this binary with --launchd and the exporter flags given
+// after --.
+func runInstallLaunchd(args []string) int {
+	fs := flag.NewFlagSet("install-launchd", flag.ContinueOnError)
+	label := fs.String("label", defaultLaunchdLabel, "launchd job label, also the plist file name")
+	daemon := fs.Bool("daemon", false, "Install a system-wide LaunchDaemon in /Library/LaunchDaemons (needs root) instead of a LaunchAgent for the current user")
+	output := fs.String("output", "", "Write the plist here instead of the LaunchAgents/LaunchDaemons directory")
+	startInterval := fs.Duration("start-interval", 0, "Let launchd start a single run this often instead of keeping the exporter running with --interval")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	exporterArgs := fs.Args()
+	if len(exporterArgs) == 0 {
+		fmt.Fprintln(os.Stderr, "ERROR: pass the exporter flags after --, e.g. install-launchd -- --url https://... --interval 1h")
+		return 2
+	}
+	if *startInterval > 0 && hasFlagArg(exporterArgs, "interval", "schedule") {
+		fmt.Fprintln(os.Stderr, "ERROR: --start-interval runs single tests; drop --interval and --schedule from the exporter flags")
+		return 2
+	}
+	if *startInterval < 0 || (*startInterval > 0 && *startInterval < time.Second) {
+		fmt.Fprintln(os.Stderr, "ERROR: --start-interval must be at least 1s")
+		return 2
+	}
+
+	exe, err := os.Executable()
+	if err == nil {
+		exe, err = filepath.EvalSymlinks(exe)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: failed to find own executable: %v\n", err)
+		return 1
+	}
+	home, err := os.UserHomeDir()
+	if err != nil && !*daemon {
+		fmt.Fprintf(os.Stderr, "ERROR: failed to find home directory: %v\n", err)
+		return 1
+	}
+
+	path := *output
+	domain := fmt.Sprintf("gui/%d", os.Getuid())
+	if path == "" {
+		path = filepath.Join(home, "Library", "LaunchAgents", *label+".plist")
+		if *daemon {
+			path = filepath.Join("/Library", "LaunchDaemons", *label+".plist")
+		}
+	}
+	if *daemon {
+		domain = "system"
+	}
+	_, logFile := launchdDirs(*daemon, home)
+	stderrPath := strings.TrimSuffix(logFile, ".log") + ".stderr.log"
+
+	program := append([]string{exe, "--launchd"}, exporterArgs...)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: failed to create %s: %v\n", filepath.Dir(path), err)
+		return 1
+	}
+	// The plist may contain a password from the exporter flags
+	if err := os.WriteFile(path, launchdPlist(*label, program, stderrPath, *startInterval), 0600); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: failed to write %s: %v\n", path, err)
+		return 1
+	}
+	fmt.Printf("Wrote %s\n", path)
+	fmt.Printf("Start it with: launchctl bootstrap %s %s\n", domain, path)
+	return 0
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLaunchdPlist(t *testing.T) {
+	data := launchdPlist("io.librespeed.exporter", []string{"/usr/local/bin/librespeed_exporter", "--launchd", "--password", "a<b&c"}, "/Users/tech/Library/Logs/librespeed_exporter.stderr.log", 0)
+
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = false
+	var strs []string
+	inString := false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Plist is not well-formed XML: %v\n%s", err, data)
+		}
+		switch tok := token.(type) {
+		case xml.StartElement:
+			inString = tok.Name.Local == "string"
+		case xml.CharData:
+			if inString {
+				strs = append(strs, string(tok))
+			}
+		case xml.EndElement:
+			inString = false
+		}
+	}
+	want := []string{"io.librespeed.exporter", "/usr/local/bin/librespeed_exporter", "--launchd", "--password", "a<b&c", "/Users/tech/Library/Logs/librespeed_exporter.stderr.log", "Background"}
+	if strings.Join(strs, "|") != strings.Join(want, "|") {
+		t.Errorf("Expected strings %q, got %q", want, strs)
+	}
+	for _, key := range []string{"<key>RunAtLoad</key>", "<key>SuccessfulExit</key>"} {
+		if !bytes.Contains(data, []byte(key)) {
+			t.Errorf("Expected %s in plist", key)
+		}
+	}
+}
+
+func TestApplyLaunchdDefaults(t *testing.T) {
+	t.Setenv("HOME", "/Users/tech")
+	cfg := &Config{StateDir: `C:\librespeed-cli`, LogFile: "custom.log"}
+	applyLaunchdDefaults(cfg, map[string]bool{"logfile": true})
+	if os.Getuid() != 0 && cfg.StateDir != "/Users/tech/Library/Application Support/librespeed_exporter" {
+		t.Errorf("Unexpected state dir %q", cfg.StateDir)
+	}
+	if cfg.LogFile != "custom.log" {
+		t.Errorf("Expected explicit --logfile to be kept, got %q", cfg.LogFile)
+	}
+
+	stateDir, logFile := launchdDirs(true, "/var/root")
+	if stateDir != "/Library/Application Support/librespeed_exporter" || logFile != "/Library/Logs/librespeed_exporter.log" {
+		t.Errorf("Unexpected daemon locations %q, %q", stateDir, logFile)
+	}
+}
+
+func TestRunInstallLaunchd(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.plist")
+	if code := runInstallLaunchd([]string{"--output", path, "--", "--url", "https://example.com/push", "--interval", "1h"}); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Plist not written: %v", err)
+	}
+	for _, want := range []string{"<string>--launchd</string>", "<string>--interval</string>", "<string>1h</string>", "<string>io.librespeed.exporter</string>"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("Expected %s in plist:\n%s", want, data)
+		}
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("Expected mode 0600, got %v", info.Mode().Perm())
+	}
+
+	if code := runInstallLaunchd([]string{"--output", path}); code != 2 {
+		t.Errorf("Expected exit code 2 without exporter flags, got %d", code)
+	}
+}
+
+func TestRunInstallLaunchd_StartInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.plist")
+	if code := runInstallLaunchd([]string{"--output", path, "--start-interval", "30m", "--", "--url", "https://example.com/push"}); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), "<key>StartInterval</key>\n\t<integer>1800</integer>") {
+		t.Errorf("Expected StartInterval 1800 in plist:\n%s", data)
+	}
+	if strings.Contains(string(data), "KeepAlive") {
+		t.Errorf("Expected no KeepAlive for interval runs:\n%s", data)
+	}
+
+	for _, args := range [][]string{
+		{"--output", path, "--start-interval", "30m", "--", "--interval=1h"},
+		{"--output", path, "--start-interval", "30m", "--", "-schedule", "0 * * * *"},
+	} {
+		if code := runInstallLaunchd(args); code != 2 {
+			t.Errorf("Expected exit code 2 for %q, got %d", args, code)
+		}
+	}
+}
+
+func TestFindLibrespeedCLI(t *testing.T) {
+	dir := t.TempDir()
+	cli := filepath.Join(dir, "librespeed-cli")
+	if err := os.WriteFile(cli, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", "")
+	saved := cliSearchDirs
+	defer func() { cliSearchDirs = saved }()
+
+	cliSearchDirs = []string{filepath.Join(dir, "missing"), dir}
+	if got, err := findLibrespeedCLI(); err != nil || got != cli {
+		t.Errorf("Expected %s, got %q, %v", cli, got, err)
+	}
+
+	cliSearchDirs = []string{filepath.Join(dir, "missing")}
+	if _, err := findLibrespeedCLI(); err == nil || !strings.Contains(err.Error(), cliInstallHint()) {
+		t.Errorf("Expected install hint, got %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Number of runs before the recent window used to establish the baseline.
+const levelShiftBaselineRuns = 10
+
+type LevelShift struct {
+	Metric   string  `json:"metric"`
+	Baseline float64 `json:"baseline"`
+	Current  float64 `json:"current"`
+	Change   float64 `json:"change"`
+}
+
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+// Reports a sustained drop: the last runs values are all at least threshold
+// below the median of the runs that preceded them.
+func detectDrop(values []float64, runs int, threshold float64) (baseline, current float64, shifted bool) {
+	if runs < 1 || len(values) < 2*runs {
+		return 0, 0, false
+	}
+	recent := values[len(values)-runs:]
+	before := values[:len(values)-runs]
+	if len(before) > levelShiftBaselineRuns {
+		before = before[len(before)-levelShiftBaselineRuns:]
+	}
+
+	baseline = median(before)
+	if baseline <= 0 {
+		return 0, 0, false
+	}
+	limit := baseline * (1 - threshold)
+	for _, v := range recent {
+		if v > limit {
+			return 0, 0, false
+		}
+	}
+	return baseline, median(recent), true
+}
+
+// Returns the download/upload shifts that became sustained with the newest
+// entry. Shifts that were already detected on the previous run are not
+// reported again, so each downgrade fires once.
+func detectLevelShifts(history []HistoryEntry, runs int, threshold float64) []LevelShift {
+	if len(history) == 0 {
+		return nil
+	}
+	var shifts []LevelShift
+	metrics := []struct {
+		name  string
+		value func(HistoryEntry) float64
+	}{
+		{"download", func(e HistoryEntry) float64 { return e.Download }},
+		{"upload", func(e HistoryEntry) float64 { return e.Upload }},
+	}
+	for _, m := range metrics {
+		values := make([]float64, len(history))
+		for i, e := range history {
+			values[i] = m.value(e)
+		}
+		baseline, current, shifted := detectDrop(values, runs, threshold)
+		if !shifted {
+			continue
+		}
+		if _, _, already := detectDrop(values[:len(values)-1], runs, threshold); already {
+			continue
+		}
+		shifts = append(shifts, LevelShift{
+			Metric:   m.name,
+			Baseline: baseline,
+			Current:  current,
+			Change:   (current - baseline) / baseline,
+		})
+	}
+	return shifts
+}
+
+type levelShiftEvent struct {
+	Instance  string       `json:"instance"`
+	Server    string       `json:"server"`
+	Timestamp time.Time    `json:"timestamp"`
+	Shifts    []LevelShift `json:"shifts"`
+}
+
+func postLevelShiftWebhook(webhookURL, instance, server string, shifts []LevelShift, ts time.Time) error {
+	body, err := json.Marshal(levelShiftEvent{Instance: instance, Server: server, Timestamp: ts, Shifts: shifts})
+	if err != nil {
+		return fmt.Errorf("failed to marshal level shift event: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create HTTP request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := remoteWriteClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send HTTP request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("webhook failed: %s - %s", resp.Status, string(respBody))
+	}
+
+	log.Printf("Level shift webhook delivered (%d shifts)", len(shifts))
+	return nil
+}
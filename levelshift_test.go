@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func historyFromDownloads(downloads ...float64) []HistoryEntry {
+	var history []HistoryEntry
+	for _, d := range downloads {
+		history = append(history, HistoryEntry{Download: d, Upload: 40})
+	}
+	return history
+}
+
+func TestMedian(t *testing.T) {
+	if m := median([]float64{3, 1, 2}); m != 2 {
+		t.Errorf("Expected 2, got %f", m)
+	}
+	if m := median([]float64{4, 1, 3, 2}); m != 2.5 {
+		t.Errorf("Expected 2.5, got %f", m)
+	}
+	if m := median(nil); m != 0 {
+		t.Errorf("Expected 0 for empty input, got %f", m)
+	}
+}
+
+func TestDetectLevelShifts(t *testing.T) {
+	testCases := []struct {
+		name      string
+		downloads []float64
+		expected  int
+	}{
+		{"Not enough history", []float64{100, 100, 60}, 0},
+		{"Stable", []float64{100, 98, 102, 99, 101, 100}, 0},
+		{"Single dip", []float64{100, 100, 100, 100, 60, 100}, 0},
+		{"Sustained drop", []float64{100, 100, 100, 65, 60, 62}, 1},
+		{"Drop already reported", []float64{100, 100, 100, 65, 60, 62, 61}, 0},
+		{"Drop too small", []float64{100, 100, 100, 80, 75, 78}, 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			shifts := detectLevelShifts(historyFromDownloads(tc.downloads...), 3, 0.3)
+			if len(shifts) != tc.expected {
+				t.Fatalf("Expected %d shifts, got %d: %+v", tc.expected, len(shifts), shifts)
+			}
+			if tc.expected == 1 {
+				if shifts[0].Metric != "download" || shifts[0].Baseline != 100 || shifts[0].Current != 62 {
+					t.Errorf("Unexpected shift: %+v", shifts[0])
+				}
+			}
+		})
+	}
+}
+
+func TestPostLevelShiftWebhook(t *testing.T) {
+	var event levelShiftEvent
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("Failed to decode event: %v", err)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer mockServer.Close()
+
+	shifts := []LevelShift{{Metric: "download", Baseline: 100, Current: 60, Change: -0.4}}
+	if err := postLevelShiftWebhook(mockServer.URL, "host1", "http://server", shifts, time.Now()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if event.Instance != "host1" || len(event.Shifts) != 1 || event.Shifts[0].Current != 60 {
+		t.Errorf("Unexpected event: %+v", event)
+	}
+}
+
+func TestPostLevelShiftWebhook_ErrorResponse(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer mockServer.Close()
+
+	if err := postLevelShiftWebhook(mockServer.URL, "host1", "", nil, time.Now()); err == nil {
+		t.Error("Expected error for 500 response, got nil")
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type LokiConfig struct {
+	URL      string
+	Username string
+	Password string
+}
+
+// One structured log line per run, shipped to Loki so metric anomalies can be
+// matched with what happened on the machine.
+type RunRecord struct {
+	Status          string  `json:"status"`
+	Stage           string  `json:"stage,omitempty"`
+	Error           string  `json:"error,omitempty"`
+	Server          string  `json:"server,omitempty"`
+	Download        float64 `json:"download_mbps,omitempty"`
+	Upload          float64 `json:"upload_mbps,omitempty"`
+	Ping            float64 `json:"ping_ms,omitempty"`
+	Jitter          float64 `json:"jitter_ms,omitempty"`
+	DurationSeconds float64 `json:"duration_seconds"`
+
+	ConsecutiveFailures int `json:"consecutive_failures,omitempty"`
+}
+
+func newRunRecord(stage string, result *LibrespeedResult, runErr error, duration time.Duration) RunRecord {
+	record := RunRecord{
+		Status:          "success",
+		DurationSeconds: duration.Seconds(),
+	}
+	if runErr != nil {
+		record.Status = "failure"
+		record.Stage = stage
+		record.Error = runErr.Error()
+	}
+	if result != nil {
+		record.Server = result.Server.URL
+		record.Download = result.Download
+		record.Upload = result.Upload
+		record.Ping = result.Ping
+		record.Jitter = result.Jitter
+	}
+	return record
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+type lokiPushRequest struct {
+	Streams []lokiStream `json:"streams"`
+}
+
+func pushRunRecord(cfg LokiConfig, host string, record RunRecord, ts time.Time) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal run record: %v", err)
+	}
+
+	labels := map[string]string{
+		"job":    "librespeed_exporter",
+		"host":   host,
+		"status": record.Status,
+	}
+	if record.Server != "" {
+		labels["server"] = record.Server
+	}
+
+	body, err := json.Marshal(lokiPushRequest{
+		Streams: []lokiStream{{
+			Stream: labels,
+			Values: [][2]string{{strconv.FormatInt(ts.UnixNano(), 10), string(line)}},
+		}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal Loki push request: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create HTTP request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if cfg.Username != "" {
+		req.SetBasicAuth(cfg.Username, cfg.Password)
+	}
+
+	resp, err := remoteWriteClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send HTTP request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("loki push failed: %s - %s", resp.Status, string(respBody))
+	}
+
+	log.Printf("Run record shipped to Loki (status: %s)", record.Status)
+	return nil
+}
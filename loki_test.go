@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewRunRecord(t *testing.T) {
+	result := &LibrespeedResult{Download: 100, Upload: 50, Ping: 10, Jitter: 1, Server: ServerInfo{URL: "http://server"}}
+	record := newRunRecord("", result, nil, 2*time.Second)
+	if record.Status != "success" || record.Stage != "" || record.Error != "" {
+		t.Errorf("Unexpected success record: %+v", record)
+	}
+	if record.Server != "http://server" || record.Download != 100 || record.DurationSeconds != 2 {
+		t.Errorf("Result fields not copied: %+v", record)
+	}
+
+	record = newRunRecord("speedtest", nil, fmt.Errorf("command failed"), time.Second)
+	if record.Status != "failure" || record.Stage != "speedtest" || record.Error != "command failed" {
+		t.Errorf("Unexpected failure record: %+v", record)
+	}
+}
+
+func TestPushRunRecord(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok || username != "loki-user" || password != "key" {
+			t.Errorf("Expected basic auth loki-user:key, got %s:%s (ok=%v)", username, password, ok)
+		}
+		var req lokiPushRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("Failed to decode push request: %v", err)
+		}
+		if len(req.Streams) != 1 || len(req.Streams[0].Values) != 1 {
+			t.Fatalf("Expected one stream with one value, got %+v", req)
+		}
+		stream := req.Streams[0]
+		if stream.Stream["host"] != "host1" || stream.Stream["status"] != "failure" || stream.Stream["server"] != "" {
+			t.Errorf("Unexpected stream labels: %v", stream.Stream)
+		}
+		if stream.Values[0][0] != "1690000000000000000" {
+			t.Errorf("Expected nanosecond timestamp, got %s", stream.Values[0][0])
+		}
+		if !strings.Contains(stream.Values[0][1], `"stage":"speedtest"`) {
+			t.Errorf("Expected stage in log line, got %s", stream.Values[0][1])
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer mockServer.Close()
+
+	cfg := LokiConfig{URL: mockServer.URL, Username: "loki-user", Password: "key"}
+	record := newRunRecord("speedtest", nil, fmt.Errorf("command failed"), time.Second)
+	if err := pushRunRecord(cfg, "host1", record, time.UnixMilli(1690000000000)); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
+
+func TestPushRunRecord_ErrorResponse(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "entry too far behind", http.StatusBadRequest)
+	}))
+	defer mockServer.Close()
+
+	record := newRunRecord("", &LibrespeedResult{}, nil, time.Second)
+	err := pushRunRecord(LokiConfig{URL: mockServer.URL}, "host1", record, time.Now())
+	if err == nil || !strings.Contains(err.Error(), "400") {
+		t.Errorf("Expected 400 error, got %v", err)
+	}
+}
@@ -1,10 +1,12 @@
 package main
 
 import (
-	"archive/zip"
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -16,11 +18,14 @@ import (
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sort"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
-	"github.com/golang/snappy"
 	"github.com/prometheus/prometheus/prompb"
 )
 
@@ -28,10 +33,18 @@ type CommandRunner interface {
 	Run(name string, args ...string) ([]byte, error)
 }
 
-type DefaultRunner struct{}
+// When ctx is set the process is killed as soon as it is cancelled, so a
+// shutdown doesn't wait for a test that may take minutes.
+type DefaultRunner struct {
+	ctx context.Context
+}
 
 func (r *DefaultRunner) Run(name string, args ...string) ([]byte, error) {
-	cmd := exec.Command(name, args...)
+	ctx := r.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	cmd := exec.CommandContext(ctx, name, args...)
 	var out bytes.Buffer
 	var stderr bytes.Buffer
 	cmd.Stdout = &out
@@ -51,54 +64,191 @@ type ServerInfo struct {
 }
 
 type LibrespeedResult struct {
-	Download float64    `json:"download"`
-	Upload   float64    `json:"upload"`
-	Ping     float64    `json:"ping"`
-	Jitter   float64    `json:"jitter"`
-	Server   ServerInfo `json:"server"`
+	Download      float64    `json:"download"`
+	Upload        float64    `json:"upload"`
+	Ping          float64    `json:"ping"`
+	Jitter        float64    `json:"jitter"`
+	BytesSent     uint64     `json:"bytes_sent"`
+	BytesReceived uint64     `json:"bytes_received"`
+	Server        ServerInfo `json:"server"`
+}
+
+// Machine-wide install location, used with --system-install and still
+// searched so existing installs keep working.
+var systemInstallDir = func() string {
+	if runtime.GOOS == "windows" {
+		return `C:\librespeed-cli`
+	}
+	return "/usr/local/lib/librespeed_exporter"
+}()
+
+// Where a missing librespeed-cli is downloaded to: the user's cache
+// directory (%LocalAppData% on Windows, ~/Library/Caches on macOS,
+// $XDG_CACHE_HOME or ~/.cache elsewhere), which needs no admin rights,
+// unless systemInstall is set.
+func cliInstallDir(systemInstall bool) (string, error) {
+	if systemInstall {
+		return systemInstallDir, nil
+	}
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find a per-user install directory (use --system-install): %v", err)
+	}
+	return filepath.Join(cacheDir, "librespeed_exporter"), nil
+}
+
+// Machine-wide state location, the --state-dir default with
+// --system-install. On Windows it is where state was always kept.
+var systemStateDir = func() string {
+	if runtime.GOOS == "windows" {
+		return `C:\librespeed-cli`
+	}
+	return "/var/lib/librespeed_exporter"
+}()
+
+// Where --state-dir defaults to without --system-install: %LocalAppData% on
+// Windows, which unlike %AppData% doesn't roam and carry the agent ID to
+// other machines, ~/Library/Application Support on macOS and
+// $XDG_CONFIG_HOME or ~/.config elsewhere. An install that already has an
+// agent ID in the Windows machine-wide location keeps using it.
+func defaultStateDir(systemInstall bool) (string, error) {
+	if systemInstall {
+		return systemStateDir, nil
+	}
+	if runtime.GOOS == "windows" {
+		if _, err := os.Stat(filepath.Join(systemStateDir, "agent_id")); err == nil {
+			return systemStateDir, nil
+		}
+	}
+	var base string
+	var err error
+	if runtime.GOOS == "windows" {
+		base, err = os.UserCacheDir()
+	} else {
+		base, err = os.UserConfigDir()
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to find a per-user state directory (set --state-dir or use --system-install): %v", err)
+	}
+	return filepath.Join(base, "librespeed_exporter"), nil
+}
+
+// Fills in --state-dir when neither it nor --launchd chose one.
+func applyStateDirDefault(cfg *Config) error {
+	if cfg.StateDir != "" {
+		return nil
+	}
+	dir, err := defaultStateDir(cfg.SystemInstall)
+	if err != nil {
+		return err
+	}
+	cfg.StateDir = dir
+	return nil
 }
 
-func ensureLibrespeedCLI() (string, error) {
+// Returns the path of librespeed-cli, downloading the release for this
+// platform if it is not on PATH or its install directory copy is not the
+// wanted version. The absolute path is used to run it, so PATH is left
+// alone.
+func ensureLibrespeedCLI(ctx context.Context, opts cliInstallOptions) (string, error) {
 	log.Println("Checking for librespeed-cli...")
-	
-	exePath, err := exec.LookPath("librespeed-cli.exe")
-	if err == nil {
+	if opts.Path != "" {
+		// Checked again in case it went away since startup
+		if err := checkCLIPath(opts.Path); err != nil {
+			return "", err
+		}
+		log.Printf("Using librespeed-cli at: %s", opts.Path)
+		return opts.Path, nil
+	}
+	bin, err := embeddedCLI()
+	if err != nil {
+		return "", err
+	}
+	if bin != nil {
+		// A -tags embedcli build never downloads
+		installDir, err := cliInstallDir(opts.SystemInstall)
+		if err != nil {
+			return "", err
+		}
+		return installEmbeddedCLI(bin, filepath.Join(installDir, "embedded"))
+	}
+	binary := cliBinaryName(runtime.GOOS)
+	if runtime.GOOS != "windows" {
+		// Homebrew, BSD packages or opkg on OpenWrt
+		if exePath, err := findLibrespeedCLI(); err == nil {
+			log.Printf("Found librespeed-cli at: %s", exePath)
+			return exePath, nil
+		}
+	} else if exePath, err := exec.LookPath(binary); err == nil {
 		log.Printf("Found librespeed-cli at: %s", exePath)
 		return exePath, nil
 	}
 
-	installDir := `C:\librespeed-cli`
-	exePath = filepath.Join(installDir, "librespeed-cli.exe")
+	installDir, err := cliInstallDir(opts.SystemInstall)
+	if err != nil {
+		return "", err
+	}
 
-	if _, err := os.Stat(exePath); err == nil {
-		log.Printf("Found librespeed-cli in install directory: %s", installDir)
-		os.Setenv("PATH", installDir+";"+os.Getenv("PATH"))
-		return exePath, nil
+	// Create HTTP client with timeout
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	client, err := opts.downloadClient()
+	if err != nil {
+		return "", err
+	}
+
+	version := opts.version()
+	if version == cliLatest {
+		version, err = resolveLatestCLIVersion(ctx, client)
+		if err != nil {
+			// An installed copy of any version beats no test at all
+			log.Printf("WARNING: %v", err)
+		}
+	}
+	for _, dir := range []string{installDir, systemInstallDir} {
+		path := filepath.Join(dir, binary)
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		installed := recordedCLIVersion(path)
+		if installed == "" {
+			// The only version installed before versions were recorded
+			installed = cliVersion
+		}
+		if version == "" || installed == version {
+			log.Printf("Found librespeed-cli %s in install directory: %s", installed, dir)
+			return path, nil
+		}
+		log.Printf("librespeed-cli in %s is version %s, want %s", dir, installed, version)
+	}
+	if version == "" {
+		return "", fmt.Errorf("librespeed-cli is not installed and the latest version is unknown")
+	}
+	exePath := filepath.Join(installDir, binary)
+
+	asset, err := currentCLIAsset(version)
+	if err != nil {
+		return "", fmt.Errorf("%v; %s", err, cliInstallHint())
 	}
 
-	log.Println("librespeed-cli not found. Downloading...")
+	log.Printf("Downloading librespeed-cli %s...", version)
 
 	err = os.MkdirAll(installDir, 0755)
 	if err != nil {
 		return "", fmt.Errorf("failed to create install directory: %v", err)
 	}
 
-	zipURL := "https://github.com/librespeed/speedtest-cli/releases/download/v1.0.12/librespeed-cli_1.0.12_windows_amd64.zip"
-	
-	// Create HTTP client with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-	
-	req, err := http.NewRequestWithContext(ctx, "GET", zipURL, nil)
+	downloadURL := opts.assetURL(version, asset)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", downloadURL, nil)
 	if err != nil {
 		return "", fmt.Errorf("failed to create HTTP request: %v", err)
 	}
-	
-	log.Printf("Downloading from: %s", zipURL)
-	client := &http.Client{Timeout: 30 * time.Second}
+
+	log.Printf("Downloading from: %s", downloadURL)
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to download ZIP: %v", err)
+		return "", fmt.Errorf("failed to download %s: %v", asset, err)
 	}
 	defer resp.Body.Close()
 
@@ -108,61 +258,63 @@ func ensureLibrespeedCLI() (string, error) {
 
 	log.Printf("Download successful, status: %s", resp.Status)
 
-	zipPath := filepath.Join(installDir, "librespeed-cli.zip")
-	out, err := os.Create(zipPath)
+	archivePath := filepath.Join(installDir, asset)
+	out, err := os.Create(archivePath)
 	if err != nil {
-		return "", fmt.Errorf("failed to create ZIP file: %v", err)
+		return "", fmt.Errorf("failed to create archive file: %v", err)
+	}
+	sum := sha256.New()
+	_, err = io.Copy(io.MultiWriter(out, sum), resp.Body)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
 	}
-	defer out.Close()
-
-	_, err = io.Copy(out, resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("failed to save ZIP file: %v", err)
+		return "", fmt.Errorf("failed to save archive file: %v", err)
+	}
+	if err := opts.verifyArchive(ctx, client, version, asset, hex.EncodeToString(sum.Sum(nil))); err != nil {
+		os.Remove(archivePath)
+		return "", err
 	}
 
 	log.Println("Extracting librespeed-cli...")
-
-	// Extract the ZIP
-	r, err := zip.OpenReader(zipPath)
-	if err != nil {
-		return "", fmt.Errorf("failed to open ZIP: %v", err)
+	if err := extractCLI(archivePath, binary, exePath); err != nil {
+		return "", err
+	}
+	if err := recordCLIVersion(exePath, version); err != nil {
+		log.Printf("WARNING: %v", err)
 	}
-	defer r.Close()
 
-	found := false
-	for _, f := range r.File {
-		if strings.EqualFold(f.Name, "librespeed-cli.exe") {
-			rc, err := f.Open()
-			if err != nil {
-				return "", fmt.Errorf("failed to open file in ZIP: %v", err)
-			}
-			defer rc.Close()
+	log.Printf("Successfully installed librespeed-cli to: %s", exePath)
+	return exePath, nil
+}
 
-			outExe, err := os.Create(exePath)
-			if err != nil {
-				return "", fmt.Errorf("failed to create EXE file: %v", err)
-			}
-			defer outExe.Close()
+// Tuning knobs passed through to librespeed-cli. Zero values leave the CLI
+// defaults in place.
+type TestOptions struct {
+	Chunks        int
+	UploadSizeKiB int
+	Concurrent    int
+}
 
-			_, err = io.Copy(outExe, rc)
-			if err != nil {
-				return "", fmt.Errorf("failed to extract EXE: %v", err)
-			}
-			found = true
-			break
-		}
+func (o TestOptions) args() []string {
+	var args []string
+	if o.Chunks > 0 {
+		args = append(args, "--chunks", fmt.Sprintf("%d", o.Chunks))
 	}
-
-	if !found {
-		return "", fmt.Errorf("librespeed-cli.exe not found in downloaded ZIP file")
+	if o.UploadSizeKiB > 0 {
+		args = append(args, "--upload-size", fmt.Sprintf("%d", o.UploadSizeKiB))
 	}
-
-	log.Printf("Successfully installed librespeed-cli to: %s", exePath)
-	os.Setenv("PATH", installDir+";"+os.Getenv("PATH"))
-	return exePath, nil
+	if o.Concurrent > 0 {
+		args = append(args, "--concurrent", fmt.Sprintf("%d", o.Concurrent))
+	}
+	return args
 }
 
 func runLibrespeed(runner CommandRunner, cliPath, localJSONPath string, serverID *int) (*LibrespeedResult, error) {
+	return runLibrespeedWithOptions(runner, cliPath, localJSONPath, serverID, TestOptions{})
+}
+
+func runLibrespeedWithOptions(runner CommandRunner, cliPath, localJSONPath string, serverID *int, opts TestOptions) (*LibrespeedResult, error) {
 	log.Println("Running librespeed-cli...")
 	start := time.Now()
 
@@ -173,6 +325,7 @@ func runLibrespeed(runner CommandRunner, cliPath, localJSONPath string, serverID
 	} else if localJSONPath != "" {
 		args = append(args, "--local-json", localJSONPath)
 	}
+	args = append(args, opts.args()...)
 	
 	log.Printf("Running command: %s %s", cliPath, strings.Join(args, " "))
 	output, err := runner.Run(cliPath, args...)
@@ -204,11 +357,12 @@ func runLibrespeed(runner CommandRunner, cliPath, localJSONPath string, serverID
 }
 
 func createTimeSeries(metric string, value float64, ts int64, serverURL, instance string) *prompb.TimeSeries {
+	// Labels must be sorted by name; Mimir and Thanos reject unsorted series
 	return &prompb.TimeSeries{
 		Labels: []prompb.Label{
 			{Name: "__name__", Value: metric},
-			{Name: "server_url", Value: serverURL},
 			{Name: "instance", Value: instance},
+			{Name: "server_url", Value: serverURL},
 		},
 		Samples: []prompb.Sample{
 			{Value: value, Timestamp: ts},
@@ -225,15 +379,60 @@ func getLabelValue(labels []prompb.Label, name string) string {
 	return ""
 }
 
-func sendToRemoteWrite(url, username, password string, series []*prompb.TimeSeries) error {
+// Adds extra labels to every series. Labels are kept sorted by name as the
+// remote write spec requires.
+func addLabels(series []*prompb.TimeSeries, labels map[string]string) {
+	if len(labels) == 0 {
+		return
+	}
+	for _, ts := range series {
+		for name, value := range labels {
+			ts.Labels = append(ts.Labels, prompb.Label{Name: name, Value: value})
+		}
+		sort.Slice(ts.Labels, func(i, j int) bool {
+			return ts.Labels[i].Name < ts.Labels[j].Name
+		})
+	}
+}
+
+// Shared across sends so retries and repeated runs reuse connections.
+var remoteWriteClient = &http.Client{Timeout: 30 * time.Second}
+
+// Marshal and compression buffers are pooled so repeated sends don't
+// allocate a fresh payload-sized slice every time.
+var bufferPool = sync.Pool{
+	New: func() any { return new([]byte) },
+}
+
+// Returns a pooled buffer resized to exactly size bytes.
+func getBuffer(size int) *[]byte {
+	buf := bufferPool.Get().(*[]byte)
+	if cap(*buf) < size {
+		*buf = make([]byte, size)
+	}
+	*buf = (*buf)[:size]
+	return buf
+}
+
+func putBuffer(buf *[]byte) {
+	bufferPool.Put(buf)
+}
+
+func sendToRemoteWrite(ctx context.Context, url, username, password string, series []*prompb.TimeSeries) error {
+	return sendWithEncoder(ctx, remoteWriteEncoder{}, url, username, password, series)
+}
+
+func sendWithEncoder(ctx context.Context, encoder Encoder, url, username, password string, series []*prompb.TimeSeries) error {
 	if len(series) == 0 {
 		return fmt.Errorf("no time series data to send")
 	}
 	
 	log.Printf("Preparing to send %d metrics to remote write endpoint", len(series))
 	
-	var tsList []prompb.TimeSeries
 	for _, ts := range series {
+		if !verboseMetricLogging {
+			break
+		}
 		log.Printf("Sending metric: %s | Server: %s | Instance: %s | Value: %.2f | Timestamp: %d",
 			getLabelValue(ts.Labels, "__name__"),
 			getLabelValue(ts.Labels, "server_url"),
@@ -241,23 +440,17 @@ func sendToRemoteWrite(url, username, password string, series []*prompb.TimeSeri
 			ts.Samples[0].Value,
 			ts.Samples[0].Timestamp,
 		)
-		tsList = append(tsList, *ts)
 	}
 
-	req := &prompb.WriteRequest{
-		Timeseries: tsList,
-	}
-
-	data, err := req.Marshal()
+	payload, err := encoder.Encode(series)
 	if err != nil {
-		return fmt.Errorf("failed to marshal protobuf: %v", err)
+		return err
 	}
+	defer putBuffer(payload)
+	log.Printf("Payload size: %d bytes", len(*payload))
 
-	compressed := snappy.Encode(nil, data)
-	log.Printf("Payload size: %d bytes (compressed: %d bytes)", len(data), len(compressed))
-
-	reqBody := bytes.NewReader(compressed)
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	reqBody := bytes.NewReader(*payload)
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 	
 	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, reqBody)
@@ -265,14 +458,18 @@ func sendToRemoteWrite(url, username, password string, series []*prompb.TimeSeri
 		return fmt.Errorf("failed to create HTTP request: %v", err)
 	}
 
-	httpReq.Header.Set("Content-Encoding", "snappy")
-	httpReq.Header.Set("Content-Type", "application/x-protobuf")
-	httpReq.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
-	httpReq.SetBasicAuth(username, password)
+	for name, value := range remoteWriteHeaders {
+		httpReq.Header.Set(name, value)
+	}
+	for name, value := range encoder.Headers() {
+		httpReq.Header.Set(name, value)
+	}
+	if username != "" {
+		httpReq.SetBasicAuth(username, password)
+	}
 
-	client := &http.Client{Timeout: 30 * time.Second}
 	start := time.Now()
-	resp, err := client.Do(httpReq)
+	resp, err := remoteWriteClient.Do(httpReq)
 	duration := time.Since(start)
 	
 	if err != nil {
@@ -302,17 +499,40 @@ var retryDelayFunc = func(attempt int) time.Duration {
 	return time.Duration(backoffSeconds) * time.Second
 }
 
-func sendToRemoteWriteWithRetry(url, username, password string, series []*prompb.TimeSeries, maxRetries int) error {
+func sendToRemoteWriteWithRetry(ctx context.Context, url, username, password string, series []*prompb.TimeSeries, maxRetries int) error {
+	return sendWithRetry(ctx, func() error {
+		return sendToRemoteWrite(ctx, url, username, password, series)
+	}, maxRetries)
+}
+
+// Retries stop early when ctx is done or its deadline would pass during
+// the backoff.
+func sendWithRetry(ctx context.Context, send func() error, maxRetries int) error {
 	var lastErr error
+	attempts := 0
 	
 	for attempt := 0; attempt <= maxRetries; attempt++ {
 		if attempt > 0 {
 			delay := retryDelayFunc(attempt)
+			if deadline, ok := ctx.Deadline(); ok && deadline.Sub(clock.Now()) < delay {
+				log.Printf("Not retrying, the deadline is in %v", deadline.Sub(clock.Now()).Round(time.Second))
+				break
+			}
 			log.Printf("Retrying in %v (attempt %d/%d)", delay, attempt+1, maxRetries+1)
-			time.Sleep(delay)
+			select {
+			case <-ctx.Done():
+			case <-clock.After(delay):
+			}
+		}
+		if ctx.Err() != nil {
+			if lastErr == nil {
+				return ctx.Err()
+			}
+			break
 		}
 		
-		err := sendToRemoteWrite(url, username, password, series)
+		attempts++
+		err := send()
 		if err == nil {
 			if attempt > 0 {
 				log.Printf("Successfully sent metrics after %d retries", attempt)
@@ -331,7 +551,7 @@ func sendToRemoteWriteWithRetry(url, username, password string, series []*prompb
 		}
 	}
 	
-	return fmt.Errorf("failed after %d attempts, last error: %v", maxRetries+1, lastErr)
+	return fmt.Errorf("failed after %d attempts, last error: %v", attempts, lastErr)
 }
 
 func validateLogFilePath(path string) error {
@@ -356,7 +576,19 @@ func validateConfiguration(remoteWriteURL, username, password string) error {
 	if password == "" {
 		return fmt.Errorf("password is required")
 	}
+	if err := validateRemoteWriteURL(remoteWriteURL); err != nil {
+		return err
+	}
 	
+	log.Printf("Configuration validated - URL: %s, Username: %s", remoteWriteURL, username)
+	return nil
+}
+
+func validateRemoteWriteURL(remoteWriteURL string) error {
+	if remoteWriteURL == "" {
+		return fmt.Errorf("remote write URL is required")
+	}
+
 	// Validate URL format
 	parsedURL, err := url.Parse(remoteWriteURL)
 	if err != nil {
@@ -370,12 +602,35 @@ func validateConfiguration(remoteWriteURL, username, password string) error {
 	if parsedURL.Host == "" {
 		return fmt.Errorf("remote write URL must include a host")
 	}
-	
-	log.Printf("Configuration validated - URL: %s, Username: %s", remoteWriteURL, username)
 	return nil
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "diag" {
+		os.Exit(runDiag(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "conformance" {
+		os.Exit(runConformance(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "assert" {
+		os.Exit(runAssert(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "install-launchd" {
+		os.Exit(runInstallLaunchd(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "install-rcd" {
+		os.Exit(runInstallRCD(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "install-procd" {
+		os.Exit(runInstallProcd(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "setup-grafana-cloud" {
+		os.Exit(runSetupGrafanaCloud(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "self-update" {
+		os.Exit(runSelfUpdate(os.Args[2:]))
+	}
+
 	// Set up graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -386,28 +641,62 @@ func main() {
 	go func() {
 		sig := <-sigChan
 		log.Printf("Received signal %v, initiating graceful shutdown...", sig)
+		notifySystemd("STOPPING=1")
 		cancel()
+		sig = <-sigChan
+		log.Printf("Received signal %v again, exiting immediately", sig)
+		os.Exit(1)
 	}()
 
-	logFilePath := flag.String("logfile", "librespeed_exporter.log", "Path to the log file")
-	url := flag.String("url", "", "Grafana Cloud remote_write URL")
-	username := flag.String("username", "", "Grafana Cloud instance ID")
-	password := flag.String("password", "", "Grafana Cloud API key")
-	localJSONPath := flag.String("local-json", "", "Path to JSON file with server list")
-	serverID := flag.Int("server-id", 1, "ID of the server to use from the JSON list")
-	flag.Parse()
+	cfg := &Config{}
+	cfg.RegisterFlags(flag.CommandLine)
+	registerHarnessFlags(flag.CommandLine)
+	args, err := withConfigFile(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
+	flag.CommandLine.Parse(args)
+	explicit := explicitFlags(flag.CommandLine)
+
+	if cfg.Launchd {
+		applyLaunchdDefaults(cfg, explicit)
+	}
+	if err := applyStateDirDefault(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
+
+	if cfg.LocalAgent && cfg.URL == "" {
+		cfg.URL = defaultLocalAgentURL
+	}
+
+	if cfg.PrintAgentConfig != "" {
+		listenURL := cfg.URL
+		if listenURL == "" {
+			listenURL = defaultLocalAgentURL
+		}
+		snippet, err := agentConfigSnippet(cfg.PrintAgentConfig, listenURL)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Print(snippet)
+		return
+	}
 
 	log.Println("Starting librespeed exporter...")
-	log.Printf("Version: librespeed-go (production-ready)")
-	log.Printf("Log file: %s", *logFilePath)
+	log.Printf("Version: librespeed-go %s", version)
+	removeReplacedExecutable()
+	log.Printf("Log file: %s", cfg.LogFile)
 
-	if err := validateLogFilePath(*logFilePath); err != nil {
+	if err := validateLogFilePath(cfg.LogFile); err != nil {
 		log.Printf("Invalid log file path: %v", err)
 		fmt.Fprintf(os.Stderr, "Invalid log file path: %v\n", err)
 		os.Exit(1)
 	}
 
-	logFile, err := os.OpenFile(*logFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	logFile, err := os.OpenFile(cfg.LogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
 	if err != nil {
 		log.Printf("Failed to open log file: %v", err)
 		fmt.Fprintf(os.Stderr, "Failed to open log file: %v\n", err)
@@ -419,43 +708,30 @@ func main() {
 		}
 	}()
 
-	log.SetOutput(io.MultiWriter(os.Stdout, logFile))
+	logOutput := io.MultiWriter(os.Stdout, logFile)
+	if cfg.OSLog {
+		osLog, err := newOSLogWriter()
+		if err != nil {
+			log.Printf("ERROR: Configuration validation failed: %v", err)
+			fmt.Fprintf(os.Stderr, "ERROR: Configuration validation failed: %v\n", err)
+			os.Exit(1)
+		}
+		logOutput = io.MultiWriter(os.Stdout, logFile, osLog)
+	}
+	log.SetOutput(logOutput)
 	log.SetFlags(log.LstdFlags | log.Lmicroseconds)
 
-	// Validate required parameters and configuration
-	if err := validateConfiguration(*url, *username, *password); err != nil {
+	client, err := newRemoteWriteClient(cfg)
+	if err != nil {
 		log.Printf("ERROR: Configuration validation failed: %v", err)
 		fmt.Fprintf(os.Stderr, "ERROR: Configuration validation failed: %v\n", err)
 		os.Exit(1)
 	}
+	remoteWriteClient = client
 
-	start := time.Now()
-	
-	// Check for cancellation before expensive operations
-	select {
-	case <-ctx.Done():
-		log.Println("Shutdown requested before librespeed-cli download")
-		return
-	default:
-	}
-	
-	cliPath, err := ensureLibrespeedCLI()
-	if err != nil {
-		log.Printf("ERROR: Failed to ensure librespeed-cli: %v", err)
-		os.Exit(1)
-	}
-
-	// Check for cancellation before speed test
-	select {
-	case <-ctx.Done():
-		log.Println("Shutdown requested before running speed test")
-		return
-	default:
-	}
-
-	result, err := runLibrespeed(&DefaultRunner{}, cliPath, *localJSONPath, serverID)
+	harnessRunner, err := applyTestHarness(cfg)
 	if err != nil {
-		log.Printf("ERROR: Failed to run librespeed test: %v", err)
+		log.Printf("ERROR: Failed to start test harness: %v", err)
 		os.Exit(1)
 	}
 
@@ -467,27 +743,1410 @@ func main() {
 	
 	log.Printf("Instance hostname: %s", hostname)
 
-	now := time.Now().UnixMilli()
-	series := []*prompb.TimeSeries{
-		createTimeSeries("librespeed_download_mbps", result.Download, now, result.Server.URL, hostname),
-		createTimeSeries("librespeed_upload_mbps", result.Upload, now, result.Server.URL, hostname),
-		createTimeSeries("librespeed_ping_ms", result.Ping, now, result.Server.URL, hostname),
-		createTimeSeries("librespeed_jitter_ms", result.Jitter, now, result.Server.URL, hostname),
+	extraLabels := map[string]string{}
+	if !filepath.IsAbs(cfg.StateDir) {
+		// cron and a service started elsewhere would each get an agent ID
+		log.Printf("WARNING: --state-dir %s is relative to the working directory, so the agent ID depends on where the exporter is started; use an absolute path", cfg.StateDir)
+	}
+	agentID, err := loadOrCreateAgentID(cfg.StateDir)
+	if err != nil {
+		log.Printf("WARNING: Sending metrics without agent_id label: %v", err)
+	} else {
+		log.Printf("Agent ID: %s", agentID)
+		extraLabels["agent_id"] = agentID
+	}
+
+	rc := &runContext{
+		hostname:      hostname,
+		agentID:       agentID,
+		extraLabels:   extraLabels,
+		harnessRunner: harnessRunner,
+		logOutput:     logOutput,
+		flags:         flag.CommandLine,
+		reloaded:      make(chan struct{}, 1),
+	}
+	if err := rc.configure(cfg, explicit); err != nil {
+		log.Printf("ERROR: Configuration validation failed: %v", err)
+		fmt.Fprintf(os.Stderr, "ERROR: Configuration validation failed: %v\n", err)
+		os.Exit(1)
+	}
+	rc.runLock = newRunLock()
+	if cfg.ResultRing != "" {
+		rc.ring, err = openResultRing(cfg.ResultRing, cfg.ResultRingSize)
+		if err != nil {
+			log.Printf("WARNING: Keeping no result ring: %v", err)
+		}
+		defer rc.ring.close()
+	}
+	rc.audit = newAuditLog(cfg.AuditLog, hostname)
+	rc.audit.record("started", "process", "config "+configHash(flag.CommandLine))
+
+	if cfg.ListenAddress != "" {
+		rc.cache = &resultCache{}
+		mux := newMetricsMux(rc.cache, rc.probe, cfg.LocalJSONPath)
+		rc.health = newHealthTracker(cfg.URL != "" || cfg.URLDiscovery != "")
+		rc.health.register(mux)
+		rc.ring.register(mux)
+		if cfg.RunAPI {
+			rc.maintenance = &maintenanceSwitch{}
+			api := newRunAPI(ctx, rc.runWithRecord)
+			api.audit = rc.audit
+			api.maintenance = rc.maintenance
+			api.webhook = rc.webhook
+			api.limits = rc.triggers
+			api.register(mux)
+		}
+		if cfg.Lifecycle {
+			rc.registerReload(mux)
+		}
+		served := make(chan struct{})
+		go func() {
+			defer close(served)
+			if err := serveHTTP(ctx, cfg.ListenAddress, rc.auth.middleware(mux)); err != nil {
+				log.Printf("ERROR: Metrics server failed: %v", err)
+				cancel()
+			}
+		}()
+		// Runs before the deferred ring close, so no request still reads it
+		defer func() {
+			cancel()
+			<-served
+		}()
+	}
+
+	if cfg.GRPCAddress != "" {
+		rc.progress = newProgressHub()
+		impl := &grpcServer{run: rc.runWithRecord, progress: rc.progress, audit: rc.audit, auth: rc.auth, limits: rc.triggers}
+		go func() {
+			if err := serveGRPC(ctx, cfg.GRPCAddress, impl); err != nil {
+				log.Printf("ERROR: gRPC server failed: %v", err)
+				cancel()
+			}
+		}()
 	}
 
-	// Check for cancellation before sending metrics
-	select {
-	case <-ctx.Done():
-		log.Println("Shutdown requested before sending metrics")
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			log.Println("Received SIGHUP, reloading configuration")
+			if err := rc.reload("SIGHUP"); err != nil {
+				log.Printf("ERROR: %v", err)
+			}
+		}
+	}()
+
+	notifySystemd("READY=1")
+	startWatchdog(ctx)
+
+	if schedule, _ := rc.currentSchedule(); schedule == nil {
+		if !waitJitter(ctx, cfg.ScheduleJitter) {
+			return
+		}
+		err := rc.runOnce(ctx)
+		if rc.cache != nil || rc.progress != nil {
+			// Keep serving the result until we're stopped
+			<-ctx.Done()
+			return
+		}
+		if errors.Is(err, errAlreadyRunning) {
+			os.Exit(exitAlreadyRunning)
+		}
+		if err != nil && ctx.Err() == nil {
+			os.Exit(1)
+		}
 		return
-	default:
 	}
 
-	if err := sendToRemoteWriteWithRetry(*url, *username, *password, series, 3); err != nil {
-		log.Printf("ERROR: Failed to send metrics after retries: %v", err)
-		os.Exit(1)
+	// Daemon mode: --interval runs straight away, --schedule waits for its
+	// first firing.
+	rc.daemon(ctx, cfg.Schedule == "")
+}
+
+// Runs a test on every firing of the schedule until ctx is cancelled. A
+// failed run is logged and the next firing tries again. A reload re-reads
+// the schedule and recomputes the next firing.
+func (rc *runContext) daemon(ctx context.Context, runNow bool) {
+	for {
+		schedule, jitter := rc.currentSchedule()
+		if runNow && waitJitter(ctx, jitter) {
+			if err := rc.runOnce(ctx); err != nil && ctx.Err() == nil && !errors.Is(err, errAlreadyRunning) {
+				log.Printf("ERROR: Run failed: %v", err)
+			}
+			schedule, _ = rc.currentSchedule()
+		}
+		runNow = true
+
+		next := schedule.Next(clock.Now())
+		if next.IsZero() {
+			// Firing straight away would run tests back to back
+			log.Println("ERROR: The schedule never fires again, waiting for a reload")
+			select {
+			case <-ctx.Done():
+				log.Println("Shutdown requested, stopping daemon")
+				return
+			case <-rc.reloaded:
+				runNow = false
+				continue
+			}
+		}
+		if failures, max := rc.failureBackoff(); failures > 1 {
+			backoff := backoffNext(schedule, next, failures, max, clock.Now())
+			if backoff != next {
+				log.Printf("WARNING: %d consecutive tests failed, backing off", failures)
+				next = backoff
+			}
+		}
+		log.Printf("Next run at %s", next.Format(time.RFC3339))
+		notifySystemd("STATUS=Next run at " + next.Format(time.RFC3339))
+		timer := clock.NewTimer(next.Sub(clock.Now()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			log.Println("Shutdown requested, stopping daemon")
+			return
+		case <-rc.reloaded:
+			timer.Stop()
+			runNow = false
+		case <-timer.C():
+		}
 	}
+}
 
-	totalDuration := time.Since(start)
-	log.Printf("SUCCESS: Librespeed exporter completed successfully in %v", totalDuration)
+// Per-process state shared by every run; runOnce does one test and push.
+type runContext struct {
+	cfg           *Config
+	encoder       Encoder
+	campaign      *Campaign
+	timezone      *siteTimezone
+	calendar      *businessCalendar
+	metadata      *metadataFile
+	quietHours    QuietHours
+	slaProfile    *SLAProfile
+	derived       []derivedMetric
+	cdnTargets    []cdnTarget
+	cdnClient     *http.Client
+	cdnHTTP3      *http.Client
+	serverIDs     []int
+	mdns          *mdnsDiscovery
+	profiles      []testProfile
+	discovery     *remoteWriteDiscovery
+	shadow        *shadowWriter
+	cardinality   *cardinalityGuard
+	dscpClass     string
+	thresholds    AlertThresholds
+	schedule      Schedule
+	flags         *flag.FlagSet
+	hostname      string
+	agentID       string
+	extraLabels   map[string]string
+	harnessRunner CommandRunner
+	logOutput     io.Writer
+	cache         *resultCache
+	progress      *progressHub
+	health        *healthTracker
+	audit         *auditLog
+	auth          *apiAuth
+	webhook       *webhookAuth
+	triggers      *triggerLimiter
+	runLock       *runLock
+
+	// Held by every run and by reload, so a run never sees a half-swapped
+	// configuration
+	runMu          sync.Mutex
+	reloaded       chan struct{}
+	lastRecord     *RunRecord
+	ring           *resultRing
+	savedGCPercent int
+	gcLowered      bool
+	inMaintenance  bool
+	maintenance    *maintenanceSwitch
+	testFailures   int
+}
+
+func snmpConfig(cfg *Config) SNMPConfig {
+	return SNMPConfig{
+		Target:       cfg.SNMPTarget,
+		Version:      cfg.SNMPVersion,
+		Community:    cfg.SNMPCommunity,
+		User:         cfg.SNMPUser,
+		AuthProtocol: cfg.SNMPAuthProtocol,
+		AuthPass:     cfg.SNMPAuthPass,
+		PrivProtocol: cfg.SNMPPrivProtocol,
+		PrivPass:     cfg.SNMPPrivPass,
+		TrapOn:       cfg.SNMPTrapOn,
+	}
+}
+
+// Checks that there is somewhere to send results and the credentials for
+// it.
+func validateOutputConfig(cfg *Config) error {
+	if cfg.LocalAgent {
+		return validateLocalAgentConfiguration(cfg.URL)
+	}
+	if cfg.URLDiscovery != "" && cfg.URL == "" {
+		// --url is only the fallback; the discovered endpoint takes the
+		// same credentials
+		if cfg.RemoteWriteConfig != "" && cfg.Username == "" {
+			return nil
+		}
+		if cfg.Username == "" {
+			return fmt.Errorf("username is required")
+		}
+		if cfg.Password == "" {
+			return fmt.Errorf("password is required")
+		}
+		return nil
+	}
+	if cfg.ListenAddress != "" && cfg.URL == "" {
+		// Pull mode only: Prometheus scrapes us, nothing is pushed
+		return nil
+	}
+	if cfg.DryRun && cfg.URL == "" {
+		return nil
+	}
+	if cfg.RemoteWriteConfig != "" && cfg.Username == "" {
+		// Entries without basic_auth authenticate with headers or client certificates
+		return validateRemoteWriteURL(cfg.URL)
+	}
+	return validateConfiguration(cfg.URL, cfg.Username, cfg.Password)
+}
+
+// Loads --remote-write-config into cfg and builds the client for pushes
+// with its TLS and the proxy flags.
+func newRemoteWriteClient(cfg *Config) (*http.Client, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	if cfg.RemoteWriteConfig != "" {
+		rw, err := loadRemoteWriteConfig(cfg.RemoteWriteConfig, cfg.RemoteWriteName)
+		if err == nil {
+			err = applyRemoteWriteConfig(cfg, rw)
+		}
+		if err == nil {
+			err = applyRemoteWriteTLS(client, rw)
+		}
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Remote write settings loaded from %s", cfg.RemoteWriteConfig)
+	}
+	if cfg.SystemProxy {
+		useSystemProxy(client)
+	}
+	if cfg.ProxyNegotiate {
+		if runtime.GOOS != "windows" {
+			return nil, fmt.Errorf("--proxy-negotiate requires Windows")
+		}
+		useNegotiateProxyAuth(client)
+	}
+	return client, nil
+}
+
+// Validates cfg and derives the per-run settings from it. Nothing is
+// changed unless all of it is valid, so a reload with a bad configuration
+// keeps the current one. explicit holds the flags that were set.
+func (rc *runContext) configure(cfg *Config, explicit map[string]bool) error {
+	if err := checkBuildFeatures(cfg); err != nil {
+		return err
+	}
+	if err := validateOutputConfig(cfg); err != nil {
+		return err
+	}
+	if cfg.SNMPTarget != "" {
+		if err := validateSNMPConfig(snmpConfig(cfg)); err != nil {
+			return err
+		}
+	}
+	if cfg.URLDiscovery != "" && cfg.LocalAgent {
+		return fmt.Errorf("--url-discovery and --local-agent are mutually exclusive")
+	}
+	discovery, err := newRemoteWriteDiscovery(cfg, rc.discovery)
+	if err != nil {
+		return err
+	}
+	encoder, err := newEncoder(cfg.Format)
+	if err != nil {
+		return err
+	}
+	campaign, err := parseCampaign(cfg.Campaign, cfg.CampaignUntil)
+	if err != nil {
+		return err
+	}
+	quietHours, err := parseQuietHours(cfg.QuietHours)
+	if err != nil {
+		return err
+	}
+	timezone, err := parseTimezone(cfg.Timezone)
+	if err != nil {
+		return err
+	}
+	var siteLocation *time.Location
+	if timezone != nil {
+		siteLocation = timezone.Location
+	}
+	calendar, err := newBusinessCalendar(cfg, siteLocation)
+	if err != nil {
+		return err
+	}
+	metadata := rc.metadata
+	if metadata == nil || metadata.path != cfg.MetadataFile {
+		metadata = openMetadataFile(cfg.MetadataFile)
+	}
+	var slaProfile *SLAProfile
+	if cfg.SLAProfile != "" {
+		profile, err := lookupSLAProfile(cfg.SLAProfile)
+		if err != nil {
+			return err
+		}
+		slaProfile = &profile
+	}
+	derived, err := compileDerivedMetrics(cfg.DerivedMetrics)
+	if err != nil {
+		return err
+	}
+	cdnTargets, err := parseCDNTargets(cfg.CDNTargets)
+	if err != nil {
+		return err
+	}
+	if cfg.WMI && runtime.GOOS != "windows" {
+		return fmt.Errorf("--wmi requires Windows")
+	}
+	switch cfg.RunLock {
+	case "wait", "skip", "off":
+	default:
+		return fmt.Errorf("--run-lock must be wait, skip or off, got %q", cfg.RunLock)
+	}
+	apiTokens, err := parseAPITokens(cfg.APITokens)
+	if err != nil {
+		return err
+	}
+	if len(apiTokens) > 0 && cfg.ListenAddress == "" && cfg.GRPCAddress == "" {
+		return fmt.Errorf("--api-token requires --listen-address or --grpc-address")
+	}
+	dscp, dscpClass := -1, ""
+	if cfg.DSCP != "" {
+		if len(cdnTargets) == 0 {
+			return fmt.Errorf("--dscp requires --cdn-target; librespeed-cli cannot mark its own traffic")
+		}
+		if runtime.GOOS == "windows" {
+			return fmt.Errorf("--dscp is not supported on Windows; use a Group Policy QoS policy instead")
+		}
+		dscp, dscpClass, err = parseDSCP(cfg.DSCP)
+		if err != nil {
+			return err
+		}
+	}
+	var cdnHTTP3 *http.Client
+	if cfg.CDNHTTP3 {
+		if len(cdnTargets) == 0 {
+			return fmt.Errorf("--cdn-http3 requires --cdn-target")
+		}
+		if dscp >= 0 {
+			return fmt.Errorf("--cdn-http3 cannot be combined with --dscp; QUIC traffic is not marked")
+		}
+		cdnHTTP3 = newCDNHTTP3Client()
+	}
+	serverIDs := []int(cfg.ServerIDs)
+	if cfg.AllServers && cfg.LocalJSONPath == "" && !cfg.MDNSDiscovery {
+		return fmt.Errorf("--all-servers requires --local-json or --mdns-discovery")
+	}
+	if cfg.AllServers && cfg.LocalJSONPath != "" {
+		serverIDs, err = loadServerIDs(cfg.LocalJSONPath)
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.ServerWorkers < 1 {
+		return fmt.Errorf("--server-workers must be at least 1")
+	}
+	profiles, err := parseTestProfiles(cfg.TestProfiles)
+	if err != nil {
+		return err
+	}
+	mdns, err := newMDNSDiscovery(cfg, explicit)
+	if err != nil {
+		return err
+	}
+	// The checks below hold for whichever servers a profile switches to
+	severalServers := len(serverIDs) > 1 || (cfg.MDNSDiscovery && cfg.AllServers)
+	for _, p := range profiles {
+		if p.ServerIDs != nil && cfg.LocalJSONPath == "" {
+			return fmt.Errorf("server-id in --test-profile %s requires --local-json", p.Name)
+		}
+		severalServers = severalServers || len(p.ServerIDs) > 1
+	}
+	if severalServers && (cfg.AutoConcurrency || cfg.CheckCounters) {
+		return fmt.Errorf("--auto-concurrency and --check-interface-counters test a single server")
+	}
+	if cfg.AutoConcurrency && cfg.CheckCounters {
+		// The counters would also count the ramp's shorter tests
+		return fmt.Errorf("--auto-concurrency cannot be combined with --check-interface-counters")
+	}
+	if cfg.TestRetries < 0 {
+		return fmt.Errorf("--test-retries must not be negative")
+	}
+	if cfg.TestRetries > 0 && (severalServers || cfg.AutoConcurrency || cfg.CheckCounters) {
+		return fmt.Errorf("--test-retries cannot be combined with several servers, --auto-concurrency or --check-interface-counters")
+	}
+	if len(cfg.RetryServerIDs) > 0 && cfg.TestRetries == 0 {
+		return fmt.Errorf("--retry-server-id requires --test-retries")
+	}
+	if cfg.MaxRunDuration < 0 {
+		return fmt.Errorf("--max-run-duration must not be negative")
+	}
+	if cfg.ResultRingSize < 1 || cfg.ResultRingSize > 100000 {
+		return fmt.Errorf("--result-ring-size must be between 1 and 100000")
+	}
+	if cfg.Samples < 1 {
+		return fmt.Errorf("--samples must be at least 1")
+	}
+	if cfg.Samples > 1 && (severalServers || cfg.AutoConcurrency || cfg.CheckCounters) {
+		return fmt.Errorf("--samples cannot be combined with several servers, --auto-concurrency or --check-interface-counters")
+	}
+	if err := validateDailyJobs(cfg); err != nil {
+		return err
+	}
+
+	schedule := rc.schedule
+	if rc.cfg == nil || cfg.Interval != rc.cfg.Interval || cfg.Schedule != rc.cfg.Schedule ||
+		cfg.OffDayInterval != rc.cfg.OffDayInterval || cfg.OffDaySchedule != rc.cfg.OffDaySchedule ||
+		cfg.PeakHours != rc.cfg.PeakHours || cfg.PeakInterval != rc.cfg.PeakInterval || cfg.PeakSchedule != rc.cfg.PeakSchedule {
+		schedule, err = newSchedule(cfg.Interval, cfg.Schedule, clock.Now())
+		if err != nil {
+			return err
+		}
+		if rc.cfg != nil && (schedule == nil) != (rc.schedule == nil) {
+			return fmt.Errorf("switching between a single run and --interval/--schedule needs a restart")
+		}
+		if cfg.PeakHours != "" || cfg.PeakInterval > 0 || cfg.PeakSchedule != "" {
+			if schedule == nil {
+				return fmt.Errorf("--peak-hours requires --interval or --schedule")
+			}
+			if cfg.PeakInterval > 0 && cfg.PeakSchedule != "" {
+				return fmt.Errorf("--peak-interval and --peak-schedule are mutually exclusive")
+			}
+			hours, err := parseQuietHours(cfg.PeakHours)
+			if err != nil {
+				return fmt.Errorf("invalid --peak-hours: %v", err)
+			}
+			if len(hours) == 0 {
+				return fmt.Errorf("--peak-interval and --peak-schedule require --peak-hours")
+			}
+			peak, err := newSchedule(cfg.PeakInterval, cfg.PeakSchedule, clock.Now())
+			if err != nil {
+				return fmt.Errorf("invalid --peak-schedule: %v", err)
+			}
+			if peak == nil {
+				return fmt.Errorf("--peak-hours requires --peak-interval or --peak-schedule")
+			}
+			schedule = peakSchedule{offPeak: schedule, peak: peak, hours: hours}
+		}
+		if cfg.OffDayInterval > 0 || cfg.OffDaySchedule != "" {
+			if schedule == nil {
+				return fmt.Errorf("--off-day-interval and --off-day-schedule require --interval or --schedule")
+			}
+			if cfg.OffDayInterval > 0 && cfg.OffDaySchedule != "" {
+				return fmt.Errorf("--off-day-interval and --off-day-schedule are mutually exclusive")
+			}
+			offDay, err := newSchedule(cfg.OffDayInterval, cfg.OffDaySchedule, clock.Now())
+			if err != nil {
+				return fmt.Errorf("invalid --off-day-schedule: %v", err)
+			}
+			schedule = calendarSchedule{business: schedule, offDay: offDay}
+		}
+	}
+	// Holidays are re-read on every reload
+	if s, ok := schedule.(calendarSchedule); ok {
+		s.calendar = calendar
+		schedule = s
+	}
+	if cfg.Interval > 0 && cfg.ScheduleJitter >= cfg.Interval {
+		return fmt.Errorf("--schedule-jitter must be shorter than --interval")
+	}
+	if cfg.PeakInterval > 0 && cfg.ScheduleJitter >= cfg.PeakInterval {
+		return fmt.Errorf("--schedule-jitter must be shorter than --peak-interval")
+	}
+	if cfg.PathMTU && (cfg.PathMTUMax < minPathMTU || cfg.PathMTUMax > 65535) {
+		return fmt.Errorf("--path-mtu-max must be between %d and 65535", minPathMTU)
+	}
+	if cfg.TCPInfo && (len(cfg.CDNTargets) == 0 || runtime.GOOS != "linux") {
+		return fmt.Errorf("--tcp-info requires --cdn-target and Linux")
+	}
+	if cfg.RunAPI && cfg.ListenAddress == "" {
+		return fmt.Errorf("--enable-run-api requires --listen-address")
+	}
+	if cfg.WebhookSecret != "" && !cfg.RunAPI {
+		return fmt.Errorf("--webhook-secret requires --enable-run-api")
+	}
+	if cfg.TriggerRateLimit < 0 {
+		return fmt.Errorf("--trigger-rate-limit must not be negative")
+	}
+	if cfg.TriggerMaxConcurrent < 1 {
+		return fmt.Errorf("--trigger-max-concurrent must be at least 1")
+	}
+	if cfg.Lifecycle && cfg.ListenAddress == "" {
+		return fmt.Errorf("--enable-lifecycle requires --listen-address")
+	}
+	if cfg.ShadowURL != "" && cfg.URL == "" && cfg.URLDiscovery == "" {
+		return fmt.Errorf("--shadow-url requires --url or --url-discovery")
+	}
+	if cfg.ShadowPercent < 0 || cfg.ShadowPercent > 100 {
+		return fmt.Errorf("--shadow-percent must be between 0 and 100")
+	}
+	if !validCLIVersion(cfg.CLIVersion) {
+		return fmt.Errorf("--cli-version must be a release such as %s, or %s", cliVersion, cliLatest)
+	}
+	if cfg.CLIDownloadURL != "" && !validDownloadURL(cfg.CLIDownloadURL) {
+		return fmt.Errorf("--cli-download-url must be an http or https URL")
+	}
+	if cfg.CLIDownloadURL != "" && cfg.CLIVersion == cliLatest {
+		return fmt.Errorf("--cli-version latest asks GitHub for the release; pin a version with --cli-download-url")
+	}
+	if cfg.DownloadProxy != "" {
+		if !validProxyURL(cfg.DownloadProxy) {
+			return fmt.Errorf("--download-proxy must be an http or https URL such as http://proxy.example.com:3128")
+		}
+		if proxyURL, _ := url.Parse(cfg.DownloadProxy); proxyURL.User != nil && cfg.ProxyNegotiate {
+			return fmt.Errorf("--proxy-negotiate authenticates as the Windows user; remove the credentials from --download-proxy")
+		}
+	}
+	if cfg.CLISHA256 != "" && !validSHA256(cfg.CLISHA256) {
+		return fmt.Errorf("--cli-sha256 must be 64 hex digits")
+	}
+	if cfg.CLIPath != "" {
+		if err := checkCLIPath(cfg.CLIPath); err != nil {
+			return err
+		}
+		for _, name := range []string{"cli-version", "cli-sha256", "cli-download-url", "download-proxy", "system-install"} {
+			if explicit[name] {
+				return fmt.Errorf("--cli-path uses the binary as is; --%s only applies to downloading librespeed-cli", name)
+			}
+		}
+	}
+	if cfg.CardinalityLimit < 0 {
+		return fmt.Errorf("--cardinality-limit must not be negative")
+	}
+
+	thresholds := AlertThresholds{
+		MinDownload: cfg.AlertMinDownload,
+		MinUpload:   cfg.AlertMinUpload,
+		MaxPing:     cfg.AlertMaxPing,
+		MaxJitter:   cfg.AlertMaxJitter,
+	}
+	if slaProfile != nil {
+		thresholds = applySLAProfile(*slaProfile, thresholds, explicit)
+		log.Printf("SLA profile %s: expecting %.0f/%.0f Mbps", cfg.SLAProfile, slaProfile.Download, slaProfile.Upload)
+	}
+
+	rc.cfg = cfg
+	rc.encoder = encoder
+	rc.campaign = campaign
+	rc.quietHours = quietHours
+	rc.timezone = timezone
+	rc.calendar = calendar
+	if rc.metadata != metadata {
+		rc.metadata.close()
+		metadata.watch()
+	}
+	rc.metadata = metadata
+	rc.slaProfile = slaProfile
+	rc.derived = derived
+	rc.cdnTargets = cdnTargets
+	rc.cdnClient = newCDNClient(cfg.SystemProxy, dscp)
+	rc.cdnHTTP3 = cdnHTTP3
+	rc.serverIDs = serverIDs
+	rc.mdns = mdns
+	rc.profiles = profiles
+	rc.discovery = discovery
+	rc.shadow = newShadowWriter(cfg.ShadowURL, cfg.ShadowUsername, cfg.ShadowPassword, cfg.ShadowPercent, rc.shadow)
+	rc.cardinality = newCardinalityGuard(cfg.CardinalityLimit, rc.cardinality)
+	rc.dscpClass = dscpClass
+	rc.schedule = schedule
+	rc.thresholds = thresholds
+	if rc.auth == nil {
+		rc.auth = &apiAuth{}
+	}
+	rc.auth.set(apiTokens)
+	if rc.webhook == nil {
+		rc.webhook = &webhookAuth{}
+	}
+	rc.webhook.set(cfg.WebhookSecret)
+	if rc.triggers == nil {
+		rc.triggers = &triggerLimiter{}
+	}
+	rc.triggers.set(cfg.TriggerRateLimit, cfg.TriggerMaxConcurrent)
+	return nil
+}
+
+// Schedule and jitter of the current configuration; nil for a single run.
+func (rc *runContext) currentSchedule() (Schedule, time.Duration) {
+	rc.runMu.Lock()
+	defer rc.runMu.Unlock()
+	return rc.schedule, rc.cfg.ScheduleJitter
+}
+
+// Pushes marker series for a run that skipped the test (maintenance, quiet
+// hours). Alerting sinks stay quiet so planned skips don't page anyone.
+func (rc *runContext) pushWithoutTest(series []*prompb.TimeSeries) error {
+	addLabels(series, rc.extraLabels)
+	series = rc.limitCardinality(series, clock.Now().UnixMilli())
+	if rc.cache != nil {
+		rc.cache.Update(series)
+	}
+	return rc.pushSeries(series)
+}
+
+// librespeed_exporter_consecutive_failures for the pushed series, as
+// /metrics reports it.
+func (rc *runContext) failuresSeries(now int64, serverURL string) *prompb.TimeSeries {
+	return createTimeSeries("librespeed_exporter_consecutive_failures", float64(rc.testFailures), now, serverURL, rc.hostname)
+}
+
+// Pushes the failure count after a failed test so push-only setups can alert
+// on it. The cached result is left alone: /metrics keeps serving the last
+// one and reports the count itself.
+func (rc *runContext) pushFailures() error {
+	series := []*prompb.TimeSeries{rc.failuresSeries(clock.Now().UnixMilli(), "")}
+	addLabels(series, rc.extraLabels)
+	return rc.pushSeries(series)
+}
+
+// Writes series to --url, or prints them with --dry-run.
+func (rc *runContext) pushSeries(series []*prompb.TimeSeries) error {
+	cfg := rc.cfg
+	if cfg.DryRun {
+		return printDryRun(dryRunOutput, rc.encoder, series)
+	}
+	pushURL := rc.discovery.url(cfg.URL)
+	if pushURL == "" {
+		return nil
+	}
+	send := func() error {
+		return sendWithEncoder(context.Background(), rc.encoder, pushURL, cfg.Username, cfg.Password, series)
+	}
+	start := time.Now()
+	err := sendWithRetry(context.Background(), send, 3)
+	rc.shadow.mirror(context.Background(), rc.encoder, series, err, time.Since(start))
+	return err
+}
+
+// Runs a single test for /probe. Only the core result series are produced;
+// history, alerts and the push sinks belong to scheduled runs.
+func (rc *runContext) probe(ctx context.Context, serverID int, localJSONPath string) ([]*prompb.TimeSeries, error) {
+	rc.runMu.Lock()
+	defer rc.runMu.Unlock()
+	cfg := rc.cfg
+	if cfg.MaxRunDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.MaxRunDuration)
+		defer cancel()
+	}
+	cliPath := "librespeed-cli"
+	var runner CommandRunner = &DefaultRunner{ctx: ctx}
+	if rc.harnessRunner != nil {
+		runner = rc.harnessRunner
+	} else {
+		var err error
+		cliPath, err = ensureLibrespeedCLI(ctx, cfg.cliInstallOptions())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.RunLock != "off" {
+		release, err := rc.runLock.acquire(ctx, cfg.RunLock == "wait")
+		if errors.Is(err, errAlreadyRunning) {
+			return nil, err
+		}
+		if err != nil {
+			log.Printf("WARNING: %v, testing without it", err)
+		} else {
+			defer release()
+		}
+	}
+
+	opts := TestOptions{Chunks: cfg.Chunks, UploadSizeKiB: cfg.UploadSizeKiB, Concurrent: cfg.Concurrent}
+	result, err := runLibrespeedWithOptions(runner, cliPath, localJSONPath, &serverID, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	now := clock.Now().UnixMilli()
+	series := []*prompb.TimeSeries{
+		createTimeSeries("librespeed_download_mbps", result.Download, now, result.Server.URL, rc.hostname),
+		createTimeSeries("librespeed_upload_mbps", result.Upload, now, result.Server.URL, rc.hostname),
+		createTimeSeries("librespeed_ping_ms", result.Ping, now, result.Server.URL, rc.hostname),
+		createTimeSeries("librespeed_jitter_ms", result.Jitter, now, result.Server.URL, rc.hostname),
+	}
+	addLabels(series, rc.extraLabels)
+	return series, nil
+}
+
+// Returns an error when the run failed, or ctx.Err() when it was cut short by
+// a shutdown request.
+func (rc *runContext) runOnce(ctx context.Context) error {
+	_, err := rc.runWithRecord(ctx)
+	return err
+}
+
+// Like runOnce, also returning the run record sent to the sinks (nil when
+// the run ended before producing one, e.g. in maintenance). Runs from the
+// scheduler and the API are serialised so they never share the link.
+func (rc *runContext) runWithRecord(ctx context.Context) (*RunRecord, error) {
+	rc.runMu.Lock()
+	defer rc.runMu.Unlock()
+	rc.lastRecord = nil
+	err := rc.run(ctx)
+	return rc.lastRecord, err
+}
+
+func (rc *runContext) run(ctx context.Context) error {
+	cfg := rc.cfg
+	encoder := rc.encoder
+	campaign := rc.campaign
+	slaProfile := rc.slaProfile
+	thresholds := rc.thresholds
+	hostname := rc.hostname
+	agentID := rc.agentID
+	extraLabels := rc.extraLabels
+	harnessRunner := rc.harnessRunner
+	var err error
+
+	start := clock.Now()
+	rc.progress.publish(stageStarted, nil)
+
+	// ctx also ends at --max-run-duration; shutdown only on a shutdown
+	shutdown := ctx
+	if cfg.MaxRunDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, cfg.MaxRunDuration, fmt.Errorf("run exceeded --max-run-duration of %v", cfg.MaxRunDuration))
+		defer cancel()
+	}
+
+	lokiCfg := LokiConfig{URL: cfg.LokiURL, Username: cfg.LokiUsername, Password: cfg.LokiPassword}
+	if lokiCfg.Password == "" {
+		lokiCfg.Password = cfg.Password
+	}
+
+	syslogCfg := SyslogConfig{Address: cfg.SyslogAddress, Network: cfg.SyslogNetwork}
+	natsCfg := NATSConfig{
+		URL:       cfg.NATSURL,
+		Subject:   cfg.NATSSubject,
+		CredsFile: cfg.NATSCredsFile,
+		CAFile:    cfg.NATSCAFile,
+		JetStream: cfg.NATSJetStream,
+	}
+	amqpCfg := AMQPConfig{
+		URL:        cfg.AMQPURL,
+		Exchange:   cfg.AMQPExchange,
+		RoutingKey: cfg.AMQPRoutingKey,
+		CAFile:     cfg.AMQPCAFile,
+		Confirm:    cfg.AMQPConfirm,
+	}
+	snmpCfg := snmpConfig(cfg)
+
+	reportRun := func(stage string, result *LibrespeedResult, runErr error) {
+		if stage == "preflight" || stage == "install" || stage == "speedtest" {
+			rc.testFailures++
+		}
+		record := newRunRecord(stage, result, runErr, clock.Now().Sub(start))
+		record.ConsecutiveFailures = rc.testFailures
+		rc.lastRecord = &record
+		if runErr != nil {
+			rc.progress.publish(stageFailed, &record)
+		} else {
+			rc.progress.publish(stageSucceeded, &record)
+		}
+		if rc.cache != nil {
+			rc.cache.RecordRun(runErr == nil, rc.testFailures, clock.Now())
+		}
+		rc.health.recordRun(stage, runErr, clock.Now())
+		if err := rc.ring.append(record, clock.Now()); err != nil {
+			log.Printf("WARNING: Failed to store result in the ring: %v", err)
+		}
+		if cfg.WMI {
+			if err := publishWMI(record, hostname, agentID, clock.Now()); err != nil {
+				log.Printf("WARNING: Failed to publish result to WMI: %v", err)
+			}
+		}
+		// --dry-run prints the series instead of sending them, so nothing
+		// else leaves the machine either
+		if cfg.DryRun {
+			return
+		}
+		if lokiCfg.URL != "" {
+			if err := pushRunRecord(lokiCfg, hostname, record, clock.Now()); err != nil {
+				log.Printf("WARNING: Failed to ship run record to Loki: %v", err)
+			}
+		}
+		if syslogCfg.Address != "" {
+			if err := sendSyslog(syslogCfg, formatSyslogMessage(record, hostname, clock.Now())); err != nil {
+				log.Printf("WARNING: Failed to send run record to syslog: %v", err)
+			}
+		}
+		if natsCfg.URL != "" {
+			if err := publishNATS(natsCfg, hostname, record, clock.Now()); err != nil {
+				log.Printf("WARNING: Failed to publish run record to NATS: %v", err)
+			}
+		}
+		if amqpCfg.URL != "" {
+			if err := publishAMQP(amqpCfg, hostname, record, clock.Now()); err != nil {
+				log.Printf("WARNING: Failed to publish run record to AMQP: %v", err)
+			}
+		}
+		if cfg.RegistrationURL != "" {
+			inv := newInventoryRecord(agentID, hostname, configHash(rc.flags), record, clock.Now())
+			if err := postInventory(cfg.RegistrationURL, inv); err != nil {
+				log.Printf("WARNING: Failed to register inventory record: %v", err)
+			}
+		}
+		if cfg.DeliveryURL != "" {
+			if err := rc.deliverResults(record, clock.Now()); err != nil {
+				log.Printf("WARNING: Failed to deliver results: %v", err)
+			}
+		}
+		if snmpCfg.Target != "" {
+			var checks []thresholdCheck
+			if result != nil {
+				checks = evaluateThresholds(thresholds, result)
+			}
+			if err := sendSNMPTrap(snmpCfg, hostname, record, checks); err != nil {
+				log.Printf("WARNING: Failed to send SNMP trap: %v", err)
+			}
+		}
+	}
+
+	// For when ctx ended at stage: a shutdown just stops, the deadline
+	// fails the run
+	stopped := func(stage, shutdownMessage string) error {
+		if shutdown.Err() != nil {
+			log.Println(shutdownMessage)
+			return shutdown.Err()
+		}
+		err := context.Cause(ctx)
+		log.Printf("ERROR: %v", err)
+		reportRun(stage, nil, err)
+		return err
+	}
+	
+	// Resources are re-checked every run so a daemon recovers once space
+	// or memory frees up again
+	degraded := checkResources(filepath.Dir(cfg.LogFile), cfg.MinFreeDiskMB<<20, cfg.MinFreeMemoryMB<<20)
+	lowDisk := false
+	lowMemory := false
+	log.SetOutput(rc.logOutput)
+	verboseMetricLogging = true
+	for _, reason := range degraded {
+		switch reason {
+		case "disk":
+			log.Println("WARNING: Low disk space, logging to stdout only and not updating history")
+			log.SetOutput(os.Stdout)
+			lowDisk = true
+		case "memory":
+			log.Println("WARNING: Low memory, collecting garbage more aggressively")
+			lowMemory = true
+		}
+		verboseMetricLogging = false
+	}
+	if lowMemory && !rc.gcLowered {
+		rc.savedGCPercent = debug.SetGCPercent(25)
+		rc.gcLowered = true
+	} else if !lowMemory && rc.gcLowered {
+		debug.SetGCPercent(rc.savedGCPercent)
+		rc.gcLowered = false
+	}
+
+	if rc.discovery != nil && !cfg.DryRun {
+		pushURL, err := rc.discovery.resolve(ctx, remoteWriteClient, cfg.URL)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			reportRun("remote_write", nil, err)
+			return err
+		}
+		// Everything this run pushes, preflight included, goes to the
+		// endpoint found now
+		discovered := *cfg
+		discovered.URL = pushURL
+		cfg = &discovered
+	}
+
+	maintenance, reason, err := checkMaintenance(cfg.MaintenanceFile)
+	if err != nil {
+		log.Printf("WARNING: %v", err)
+	}
+	if maintenance != rc.inMaintenance {
+		if maintenance {
+			rc.audit.record("maintenance_on", cfg.MaintenanceFile, reason)
+		} else {
+			rc.audit.record("maintenance_off", cfg.MaintenanceFile, "")
+		}
+		rc.inMaintenance = maintenance
+	}
+	if !maintenance {
+		status := rc.maintenance.status()
+		maintenance, reason = status.Active, status.Reason
+	}
+	if maintenance {
+		// Only the maintenance marker is sent; no test runs and alerting
+		// sinks stay quiet so planned work doesn't page anyone.
+		log.Printf("Maintenance mode active, skipping speed test: %s", reason)
+		series := []*prompb.TimeSeries{
+			createTimeSeries("librespeed_maintenance", 1, clock.Now().UnixMilli(), "", hostname),
+		}
+		rc.progress.publish(stageSkipped, nil)
+		if err := rc.pushWithoutTest(series); err != nil {
+			log.Printf("ERROR: Failed to send maintenance metric: %v", err)
+			return err
+		}
+		return nil
+	}
+
+	if rc.quietHours.contains(clock.Now()) {
+		log.Printf("Quiet hours (%s), skipping speed test", cfg.QuietHours)
+		ts := createTimeSeries("librespeed_run_skipped", 1, clock.Now().UnixMilli(), "", hostname)
+		addLabels([]*prompb.TimeSeries{ts}, map[string]string{"reason": "quiet_hours"})
+		rc.progress.publish(stageSkipped, nil)
+		if err := rc.pushWithoutTest([]*prompb.TimeSeries{ts}); err != nil {
+			log.Printf("ERROR: Failed to send skipped-run metric: %v", err)
+			return err
+		}
+		return nil
+	}
+
+	serverIDs := rc.serverIDs
+	mdnsFound := 0
+	if rc.mdns != nil {
+		// The rest of the run tests from the list with the servers found now
+		discovered := *cfg
+		discovered.LocalJSONPath, serverIDs, mdnsFound = rc.mdns.servers(ctx, cfg.LocalJSONPath, cfg.AllServers, serverIDs)
+		cfg = &discovered
+	}
+	opts := TestOptions{Chunks: cfg.Chunks, UploadSizeKiB: cfg.UploadSizeKiB, Concurrent: cfg.Concurrent}
+	profile := activeProfile(rc.profiles, clock.Now())
+	request := requestedRun(ctx)
+	if request.Profile != "" {
+		if profile = findProfile(rc.profiles, request.Profile); profile == nil {
+			return fmt.Errorf("unknown test profile %q requested", request.Profile)
+		}
+	}
+	if profile != nil {
+		log.Printf("Using test profile %s", profile.Name)
+		opts, serverIDs = profile.apply(opts, serverIDs)
+	}
+	if request.ServerID != 0 {
+		log.Printf("Testing server %d as requested", request.ServerID)
+		serverIDs = []int{request.ServerID}
+	}
+
+	if cfg.RunLock != "off" {
+		release, err := rc.runLock.acquire(ctx, cfg.RunLock == "wait")
+		if errors.Is(err, errAlreadyRunning) {
+			log.Println("Another speed test is already running on this machine, skipping this one")
+			ts := createTimeSeries("librespeed_run_skipped", 1, clock.Now().UnixMilli(), "", hostname)
+			addLabels([]*prompb.TimeSeries{ts}, map[string]string{"reason": "already_running"})
+			rc.progress.publish(stageSkipped, nil)
+			if pushErr := rc.pushWithoutTest([]*prompb.TimeSeries{ts}); pushErr != nil {
+				log.Printf("ERROR: Failed to send skipped-run metric: %v", pushErr)
+			}
+			return err
+		}
+		if ctx.Err() != nil {
+			return stopped("speedtest", "Shutdown requested while waiting for the run lock")
+		}
+		if err != nil {
+			// An unwritable state directory shouldn't stop the tests
+			log.Printf("WARNING: %v, testing without it", err)
+		} else {
+			defer release()
+		}
+	}
+	
+	// Check for cancellation before expensive operations
+	if ctx.Err() != nil {
+		return stopped("install", "Shutdown requested before librespeed-cli download")
+	}
+
+	if cfg.Preflight {
+		serverClient := &http.Client{}
+		if cfg.SystemProxy {
+			useSystemProxy(serverClient)
+		}
+		targets, err := preflightTargets(cfg, serverIDs, serverClient, remoteWriteClient)
+		var failed *preflightTarget
+		if err == nil {
+			failed, err = runPreflight(ctx, targets)
+		}
+		if ctx.Err() != nil {
+			return stopped("preflight", "Shutdown requested during preflight checks")
+		}
+		if err != nil {
+			// The test would only fail after minutes of trying; the marker
+			// still reaches /metrics even if --url is the unreachable one
+			log.Printf("ERROR: %v, skipping speed test", err)
+			reportRun("preflight", nil, err)
+			now := clock.Now().UnixMilli()
+			ts := createTimeSeries("librespeed_preflight_failed", 1, now, "", hostname)
+			if failed != nil {
+				addLabels([]*prompb.TimeSeries{ts}, map[string]string{"target": failed.Kind})
+			}
+			if pushErr := rc.pushWithoutTest([]*prompb.TimeSeries{ts, rc.failuresSeries(now, "")}); pushErr != nil {
+				log.Printf("WARNING: Failed to send preflight metric: %v", pushErr)
+			}
+			return err
+		}
+	}
+	
+	cliPath := "librespeed-cli"
+	installedCLI := ""
+	if harnessRunner == nil {
+		rc.progress.publish(stageInstalling, nil)
+		cliPath, err = ensureLibrespeedCLI(ctx, cfg.cliInstallOptions())
+		if ctx.Err() != nil {
+			return stopped("install", "Shutdown requested during librespeed-cli download")
+		}
+		if err != nil {
+			log.Printf("ERROR: Failed to ensure librespeed-cli: %v", err)
+			reportRun("install", nil, err)
+			return err
+		}
+		installedCLI = recordedCLIVersion(cliPath)
+	}
+
+	// Check for cancellation before speed test
+	if ctx.Err() != nil {
+		return stopped("speedtest", "Shutdown requested before running speed test")
+	}
+
+	var runner CommandRunner = &DefaultRunner{ctx: ctx}
+	if harnessRunner != nil {
+		runner = harnessRunner
+	}
+
+	var countersBefore *InterfaceCounters
+	if cfg.CheckCounters {
+		countersBefore, err = readInterfaceCounters(runner)
+		if err != nil {
+			log.Printf("WARNING: Failed to read interface counters, skipping cross-check: %v", err)
+		}
+	}
+
+	rc.progress.publish(stageTesting, nil)
+	var result *LibrespeedResult
+	var servers []serverResult
+	var samples []*LibrespeedResult
+	attempts := 1
+	streams := opts.Concurrent
+	serverID := serverIDs[0]
+	if len(serverIDs) > 1 {
+		servers = testServers(runner, cliPath, cfg.LocalJSONPath, serverIDs, cfg.ServerWorkers, opts)
+		result, err = primaryResult(servers)
+	} else if cfg.AutoConcurrency {
+		result, streams, err = autoTuneConcurrency(runner, cliPath, cfg.LocalJSONPath, &serverID, opts, cfg.MaxConcurrency, autoTuneMinGain)
+	} else if cfg.Samples > 1 {
+		samples, err = runSamples(ctx, cfg.Samples, func() (*LibrespeedResult, error) {
+			if cfg.TestRetries > 0 {
+				result, _, err := runWithTestRetries(ctx, runner, cliPath, cfg.LocalJSONPath, serverID, cfg.RetryServerIDs, cfg.TestRetries, opts)
+				return result, err
+			}
+			id := serverID
+			return runLibrespeedWithOptions(runner, cliPath, cfg.LocalJSONPath, &id, opts)
+		})
+		if err == nil {
+			result = aggregateSamples(samples)
+		}
+	} else if cfg.TestRetries > 0 {
+		result, attempts, err = runWithTestRetries(ctx, runner, cliPath, cfg.LocalJSONPath, serverID, cfg.RetryServerIDs, cfg.TestRetries, opts)
+	} else {
+		result, err = runLibrespeedWithOptions(runner, cliPath, cfg.LocalJSONPath, &serverID, opts)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return stopped("speedtest", "Shutdown requested, speed test aborted")
+		}
+		log.Printf("ERROR: Failed to run librespeed test: %v", err)
+		reportRun("speedtest", nil, err)
+		if pushErr := rc.pushFailures(); pushErr != nil {
+			log.Printf("WARNING: Failed to send failure count: %v", pushErr)
+		}
+		return err
+	}
+	rc.testFailures = 0
+
+	var countersAfter *InterfaceCounters
+	if countersBefore != nil {
+		countersAfter, err = readInterfaceCounters(runner)
+		if err != nil {
+			log.Printf("WARNING: Failed to read interface counters after test: %v", err)
+		}
+	}
+
+	now := clock.Now().UnixMilli()
+	series := []*prompb.TimeSeries{
+		createTimeSeries("librespeed_download_mbps", result.Download, now, result.Server.URL, hostname),
+		createTimeSeries("librespeed_upload_mbps", result.Upload, now, result.Server.URL, hostname),
+		createTimeSeries("librespeed_ping_ms", result.Ping, now, result.Server.URL, hostname),
+		createTimeSeries("librespeed_jitter_ms", result.Jitter, now, result.Server.URL, hostname),
+	}
+	if servers != nil {
+		// One set per server; the rest of the run uses the first that succeeded
+		series = serverSeries(servers, now, hostname)
+	}
+	if samples != nil {
+		// The core metrics above are the medians
+		series = append(series, sampleSeries(samples, now, result.Server.URL, hostname)...)
+	}
+
+	if cfg.HistoryFile != "" {
+		history, err := loadHistory(cfg.HistoryFile)
+		if err != nil {
+			log.Printf("WARNING: Starting with empty history: %v", err)
+		}
+		history = append(history, newHistoryEntry(result, time.UnixMilli(now)))
+		if !lowDisk {
+			if err := saveHistory(cfg.HistoryFile, history, cfg.HistorySize, historyRetention(cfg)); err != nil {
+				log.Printf("WARNING: Failed to save history: %v", err)
+			}
+		}
+
+		shifts := detectLevelShifts(history, cfg.LevelShiftRuns, cfg.LevelShiftThreshold)
+		detected := 0.0
+		if len(shifts) > 0 {
+			detected = 1
+			for _, shift := range shifts {
+				log.Printf("WARNING: Level shift detected in %s: %.2f -> %.2f Mbps (%.0f%%)",
+					shift.Metric, shift.Baseline, shift.Current, shift.Change*100)
+			}
+			if cfg.LevelShiftWebhook != "" && !cfg.DryRun {
+				if err := postLevelShiftWebhook(cfg.LevelShiftWebhook, hostname, result.Server.URL, shifts, time.UnixMilli(now)); err != nil {
+					log.Printf("WARNING: Failed to deliver level shift webhook: %v", err)
+				}
+			}
+		}
+		series = append(series, createTimeSeries("librespeed_level_shift_detected", detected, now, result.Server.URL, hostname))
+
+		if cfg.Interval > 0 || cfg.Schedule != "" {
+			series = append(series, rollingPercentileSeries(history, time.UnixMilli(now), result.Server.URL, hostname)...)
+		}
+	}
+
+	if slaProfile != nil {
+		series = append(series,
+			createTimeSeries("librespeed_expected_download_mbps", slaProfile.Download, now, result.Server.URL, hostname),
+			createTimeSeries("librespeed_expected_upload_mbps", slaProfile.Upload, now, result.Server.URL, hostname),
+		)
+	}
+
+	if len(rc.derived) > 0 {
+		values, err := evaluateDerivedMetrics(rc.derived, newDerivedEnv(result, slaProfile))
+		if err != nil {
+			log.Printf("WARNING: %v", err)
+		}
+		for _, m := range rc.derived {
+			if value, ok := values[m.Name]; ok {
+				series = append(series, createTimeSeries(m.Name, value, now, result.Server.URL, hostname))
+			}
+		}
+	}
+
+	if cfg.MaintenanceFile != "" || rc.maintenance != nil {
+		series = append(series, createTimeSeries("librespeed_maintenance", 0, now, result.Server.URL, hostname))
+	}
+
+	if len(rc.quietHours) > 0 {
+		ts := createTimeSeries("librespeed_run_skipped", 0, now, result.Server.URL, hostname)
+		addLabels([]*prompb.TimeSeries{ts}, map[string]string{"reason": "quiet_hours"})
+		series = append(series, ts)
+	}
+	if cfg.TestRetries > 0 && samples == nil {
+		series = append(series, createTimeSeries("librespeed_test_attempts", float64(attempts), now, result.Server.URL, hostname))
+	}
+	if rc.mdns != nil {
+		series = append(series, createTimeSeries("librespeed_mdns_servers", float64(mdnsFound), now, result.Server.URL, hostname))
+	}
+	if installedCLI != "" {
+		ts := createTimeSeries("librespeed_cli_info", 1, now, result.Server.URL, hostname)
+		addLabels([]*prompb.TimeSeries{ts}, map[string]string{"version": installedCLI})
+		series = append(series, ts)
+	}
+	for _, p := range rc.profiles {
+		value := 0.0
+		if profile != nil && p.Name == profile.Name {
+			value = 1
+		}
+		ts := createTimeSeries("librespeed_test_profile", value, now, result.Server.URL, hostname)
+		addLabels([]*prompb.TimeSeries{ts}, map[string]string{"profile": p.Name})
+		series = append(series, ts)
+	}
+	if cfg.RunLock == "skip" {
+		ts := createTimeSeries("librespeed_run_skipped", 0, now, result.Server.URL, hostname)
+		addLabels([]*prompb.TimeSeries{ts}, map[string]string{"reason": "already_running"})
+		series = append(series, ts)
+	}
+
+	if cfg.ISPStatusURL != "" {
+		incident, description, err := fetchISPStatus(cfg.ISPStatusURL)
+		if err != nil {
+			log.Printf("WARNING: %v", err)
+		} else {
+			value := 0.0
+			if incident {
+				log.Printf("ISP reports an incident: %s", description)
+				value = 1
+			}
+			series = append(series, createTimeSeries("librespeed_isp_reported_incident", value, now, result.Server.URL, hostname))
+		}
+	}
+
+	if cfg.UPnPWANInfo {
+		wan, err := queryWANInfo(ctx)
+		if err != nil {
+			log.Printf("WARNING: %v", err)
+		} else {
+			if wan.DownstreamBps > 0 && result.Download > float64(wan.DownstreamBps)/1e6 {
+				log.Printf("Measured download of %.2f Mbps exceeds the %.2f Mbps the gateway reports as its WAN sync rate", result.Download, float64(wan.DownstreamBps)/1e6)
+			}
+			series = append(series, wan.series(now, result.Server.URL, hostname)...)
+		}
+	}
+
+	if len(rc.cdnTargets) > 0 {
+		cdn := cdnSeries(ctx, rc.cdnClient, rc.cdnTargets, cfg.CDNTimeout, cfg.TCPInfo, now, hostname)
+		if rc.dscpClass != "" {
+			addLabels(cdn, map[string]string{"dscp": rc.dscpClass})
+		}
+		if rc.cdnHTTP3 != nil {
+			addLabels(cdn, map[string]string{"transport": "tcp"})
+			quic := cdnSeries(ctx, rc.cdnHTTP3, rc.cdnTargets, cfg.CDNTimeout, false, now, hostname)
+			addLabels(quic, map[string]string{"transport": "quic"})
+			cdn = append(cdn, quic...)
+		}
+		series = append(series, cdn...)
+	}
+
+	if cfg.PathMTU {
+		host, err := serverHost(result.Server.URL)
+		mtu := 0
+		if err == nil {
+			mtu, err = probePathMTU(runner, host, cfg.PathMTUMax)
+		}
+		if err != nil {
+			log.Printf("WARNING: %v", err)
+		} else {
+			if mtu < cfg.PathMTUMax {
+				log.Printf("Path MTU to %s is %d bytes, below %d", host, mtu, cfg.PathMTUMax)
+			}
+			series = append(series, createTimeSeries("librespeed_path_mtu_bytes", float64(mtu), now, result.Server.URL, hostname))
+		}
+	}
+
+	if streams > 0 {
+		series = append(series, createTimeSeries("librespeed_concurrent_streams", float64(streams), now, result.Server.URL, hostname))
+	}
+
+	if countersBefore != nil && countersAfter != nil {
+		if result.BytesReceived == 0 && result.BytesSent == 0 {
+			log.Println("WARNING: librespeed-cli did not report transferred bytes, skipping cross-check")
+		} else {
+			rxDiscrepancy, txDiscrepancy := checkInterfaceCounters(countersBefore, countersAfter, result, cfg.CounterThreshold)
+			series = append(series,
+				createTimeSeries("librespeed_download_counter_discrepancy_ratio", rxDiscrepancy, now, result.Server.URL, hostname),
+				createTimeSeries("librespeed_upload_counter_discrepancy_ratio", txDiscrepancy, now, result.Server.URL, hostname),
+			)
+		}
+	}
+
+	for _, reason := range degraded {
+		ts := createTimeSeries("librespeed_exporter_degraded", 1, now, result.Server.URL, hostname)
+		addLabels([]*prompb.TimeSeries{ts}, map[string]string{"reason": reason})
+		series = append(series, ts)
+	}
+	series = append(series, rc.failuresSeries(now, result.Server.URL))
+
+	series = append(series, rc.shadow.series(now, hostname)...)
+	series = append(series, rc.timezone.series(time.UnixMilli(now), result.Server.URL, hostname)...)
+
+	addLabels(series, campaign.labels(time.UnixMilli(now)))
+	addLabels(series, rc.timezone.labels())
+	addLabels(series, rc.calendar.labels(time.UnixMilli(now)))
+	addLabels(series, extraLabels)
+	addMissingLabels(series, rc.metadata.currentLabels())
+	series = rc.limitCardinality(series, now)
+
+	if rc.cache != nil {
+		rc.cache.Update(series)
+	}
+
+	// A shutdown or the deadline after the test completed still flushes its
+	// result, just without retries so the run ends promptly
+	rc.progress.publish(stagePushing, nil)
+	retries := 3
+	pushCtx := ctx
+	if ctx.Err() != nil {
+		if shutdown.Err() != nil {
+			log.Println("Shutdown requested, flushing metrics before exit")
+		} else {
+			log.Printf("WARNING: %v, flushing metrics without retries", context.Cause(ctx))
+		}
+		retries = 0
+		pushCtx = context.WithoutCancel(ctx)
+	}
+
+	if cfg.RedisAddress != "" && !cfg.DryRun {
+		redisCfg := RedisConfig{
+			Address:   cfg.RedisAddress,
+			Password:  cfg.RedisPassword,
+			DB:        cfg.RedisDB,
+			TLS:       cfg.RedisTLS,
+			KeyPrefix: cfg.RedisKeyPrefix,
+			Retention: cfg.RedisRetention,
+		}
+		if err := sendToRedis(redisCfg, series); err != nil {
+			log.Printf("WARNING: Failed to write metrics to Redis: %v", err)
+		}
+	}
+
+	if cfg.DryRun {
+		if err := printDryRun(dryRunOutput, encoder, series); err != nil {
+			log.Printf("WARNING: Failed to encode metrics: %v", err)
+		}
+	} else if cfg.URL != "" {
+		send := func() error {
+			return sendWithEncoder(pushCtx, encoder, cfg.URL, cfg.Username, cfg.Password, series)
+		}
+		pushStart := time.Now()
+		err := sendWithRetry(pushCtx, send, retries)
+		rc.shadow.mirror(pushCtx, encoder, series, err, time.Since(pushStart))
+		if err != nil {
+			log.Printf("ERROR: Failed to send metrics after retries: %v", err)
+			reportRun("remote_write", result, err)
+			return err
+		}
+	}
+
+	if cfg.DailyRollup && cfg.URL != "" && !cfg.DryRun && !lowDisk {
+		if err := rc.pushDailyRollup(pushCtx, time.UnixMilli(now), result.Server.URL); err != nil {
+			log.Printf("WARNING: %v", err)
+		}
+	}
+
+	if !lowDisk && !cfg.DryRun {
+		rc.exportDailySummary(time.UnixMilli(now))
+		if cfg.ArchiveURL != "" {
+			if err := rc.archiveHistory(time.UnixMilli(now)); err != nil {
+				log.Printf("WARNING: %v", err)
+			}
+		}
+	}
+
+	reportRun("", result, nil)
+
+	if cfg.AlertmanagerURL != "" && !cfg.DryRun {
+		alerts := buildAlerts(evaluateThresholds(thresholds, result), hostname, result.Server.URL, clock.Now(), cfg.AlertResolveAfter)
+		if err := postAlerts(cfg.AlertmanagerURL, alerts); err != nil {
+			log.Printf("WARNING: Failed to send alerts to Alertmanager: %v", err)
+		}
+	}
+
+	totalDuration := clock.Now().Sub(start)
+	log.Printf("SUCCESS: Librespeed exporter completed successfully in %v", totalDuration)
+	return nil
 }
@@ -1,15 +1,20 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/golang/snappy"
 	"github.com/prometheus/prometheus/prompb"
 )
 
@@ -62,7 +67,7 @@ func TestSendToRemoteWrite_Success(t *testing.T) {
 	defer mockServer.Close()
 
 	ts := createTimeSeries("test_metric", 1.0, time.Now().UnixMilli(), "server", "instance")
-	err := sendToRemoteWrite(mockServer.URL, "user", "pass", []*prompb.TimeSeries{ts})
+	err := sendToRemoteWrite(context.Background(), mockServer.URL, "user", "pass", []*prompb.TimeSeries{ts})
 	if err != nil {
 		t.Errorf("Expected no error, got %v", err)
 	}
@@ -75,7 +80,7 @@ func TestSendToRemoteWrite_Non200Response(t *testing.T) {
 	defer mockServer.Close()
 
 	ts := createTimeSeries("test_metric", 1.0, time.Now().UnixMilli(), "server", "instance")
-	err := sendToRemoteWrite(mockServer.URL, "user", "pass", []*prompb.TimeSeries{ts})
+	err := sendToRemoteWrite(context.Background(), mockServer.URL, "user", "pass", []*prompb.TimeSeries{ts})
 	if err == nil {
 		t.Error("Expected error for non-200 response, got nil")
 	}
@@ -83,7 +88,7 @@ func TestSendToRemoteWrite_Non200Response(t *testing.T) {
 
 func TestSendToRemoteWrite_InvalidURL(t *testing.T) {
 	ts := createTimeSeries("test_metric", 1.0, time.Now().UnixMilli(), "server", "instance")
-	err := sendToRemoteWrite(":", "user", "pass", []*prompb.TimeSeries{ts})
+	err := sendToRemoteWrite(context.Background(), ":", "user", "pass", []*prompb.TimeSeries{ts})
 	if err == nil {
 		t.Error("Expected error for invalid URL, got nil")
 	}
@@ -152,10 +157,12 @@ func TestRunLibrespeed_CommandError(t *testing.T) {
 type MockRunner struct {
 	Output   []byte
 	Err      error
+	Calls    int
 	lastArgs []string
 }
 
 func (m *MockRunner) Run(name string, args ...string) ([]byte, error) {
+	m.Calls++
 	m.lastArgs = args
 	return m.Output, m.Err
 }
@@ -201,7 +208,7 @@ func TestSendToRemoteWrite_EmptySeriesList(t *testing.T) {
 	}))
 	defer mockServer.Close()
 
-	err := sendToRemoteWrite(mockServer.URL, "user", "pass", []*prompb.TimeSeries{})
+	err := sendToRemoteWrite(context.Background(), mockServer.URL, "user", "pass", []*prompb.TimeSeries{})
 	if err == nil {
 		t.Error("Expected error for empty series list, got nil")
 	}
@@ -222,14 +229,17 @@ func TestEnsureLibrespeedCLI_NotFound(t *testing.T) {
 	defer os.Setenv("PATH", originalPath)
 	
 	// Also ensure the install directory doesn't exist
-	installDir := `C:\librespeed-cli`
+	installDir, err := cliInstallDir(false)
+	if err != nil {
+		t.Skipf("No per-user install directory: %v", err)
+	}
 	if _, err := os.Stat(installDir); err == nil {
 		t.Skip("Install directory exists, skipping test")
 	}
 	
 	// This should try to download but likely fail in test environment
 	// We're mainly testing that the function handles errors gracefully
-	_, err := ensureLibrespeedCLI()
+	_, err = ensureLibrespeedCLI(context.Background(), cliInstallOptions{})
 	// We expect an error since we can't download in test environment
 	// The exact error depends on the network conditions
 	if err == nil {
@@ -381,7 +391,7 @@ func TestSendToRemoteWrite_Timeout(t *testing.T) {
 	defer mockServer.Close()
 
 	ts := createTimeSeries("test_metric", 1.0, time.Now().UnixMilli(), "server", "instance")
-	err := sendToRemoteWrite(mockServer.URL, "user", "pass", []*prompb.TimeSeries{ts})
+	err := sendToRemoteWrite(context.Background(), mockServer.URL, "user", "pass", []*prompb.TimeSeries{ts})
 	if err != nil {
 		t.Errorf("Expected no error for delayed but successful response, got %v", err)
 	}
@@ -397,7 +407,7 @@ func TestSendToRemoteWrite_MalformedResponse(t *testing.T) {
 	defer mockServer.Close()
 
 	ts := createTimeSeries("test_metric", 1.0, time.Now().UnixMilli(), "server", "instance")
-	err := sendToRemoteWrite(mockServer.URL, "user", "pass", []*prompb.TimeSeries{ts})
+	err := sendToRemoteWrite(context.Background(), mockServer.URL, "user", "pass", []*prompb.TimeSeries{ts})
 	if err == nil {
 		t.Error("Expected error for server error response, got nil")
 	}
@@ -537,7 +547,7 @@ func TestSendToRemoteWrite_RequestCreationError(t *testing.T) {
 	
 	// Use a URL with invalid characters that will cause NewRequest to fail
 	invalidURL := "ht\ttp://invalid"
-	err := sendToRemoteWrite(invalidURL, "user", "pass", []*prompb.TimeSeries{ts})
+	err := sendToRemoteWrite(context.Background(), invalidURL, "user", "pass", []*prompb.TimeSeries{ts})
 	if err == nil {
 		t.Error("Expected error for invalid URL in NewRequest, got nil")
 	}
@@ -596,7 +606,7 @@ func TestSendToRemoteWrite_LargeDataSet(t *testing.T) {
 		))
 	}
 
-	err := sendToRemoteWrite(mockServer.URL, "user", "pass", series)
+	err := sendToRemoteWrite(context.Background(), mockServer.URL, "user", "pass", series)
 	if err != nil {
 		t.Errorf("Expected no error for large dataset, got %v", err)
 	}
@@ -604,6 +614,7 @@ func TestSendToRemoteWrite_LargeDataSet(t *testing.T) {
 
 // Add a test that can cover part of ensureLibrespeedCLI by testing it in a clean environment
 func TestEnsureLibrespeedCLI_DownloadPath(t *testing.T) {
+	skipIfEmbedded(t)
 	// This test runs ensureLibrespeedCLI but expects it to go through the download path
 	// We'll clear PATH and ensure the install directory doesn't exist initially
 	
@@ -613,12 +624,12 @@ func TestEnsureLibrespeedCLI_DownloadPath(t *testing.T) {
 	// Set PATH to empty to ensure librespeed-cli.exe isn't found
 	os.Setenv("PATH", "")
 	
-	// Remove install directory if it exists
-	installDir := `C:\librespeed-cli`
-	os.RemoveAll(installDir)
+	// Start from an empty per-user cache directory
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("LocalAppData", t.TempDir())
 	
 	// Run ensureLibrespeedCLI - this should attempt to download
-	result, err := ensureLibrespeedCLI()
+	result, err := ensureLibrespeedCLI(context.Background(), cliInstallOptions{})
 	
 	if err != nil {
 		// If it fails, that's okay - we're testing the code paths
@@ -827,7 +838,7 @@ func TestIntegration_CompleteWorkflow(t *testing.T) {
 	}
 
 	// Step 4: Send to remote write
-	err = sendToRemoteWrite(mockServer.URL, "testuser", "testpass", series)
+	err = sendToRemoteWrite(context.Background(), mockServer.URL, "testuser", "testpass", series)
 	if err != nil {
 		t.Fatalf("sendToRemoteWrite failed: %v", err)
 	}
@@ -898,7 +909,7 @@ func TestSendToRemoteWriteWithRetry(t *testing.T) {
 	defer mockServer.Close()
 
 	ts := createTimeSeries("test_metric", 1.0, time.Now().UnixMilli(), "server", "instance")
-	err := sendToRemoteWriteWithRetry(mockServer.URL, "user", "pass", []*prompb.TimeSeries{ts}, 1)
+	err := sendToRemoteWriteWithRetry(context.Background(), mockServer.URL, "user", "pass", []*prompb.TimeSeries{ts}, 1)
 	if err != nil {
 		t.Errorf("Expected retry to succeed, got error: %v", err)
 	}
@@ -916,7 +927,7 @@ func TestSendToRemoteWriteWithRetry_NonRetryableError(t *testing.T) {
 	defer mockServer.Close()
 
 	ts := createTimeSeries("test_metric", 1.0, time.Now().UnixMilli(), "server", "instance")
-	err := sendToRemoteWriteWithRetry(mockServer.URL, "user", "pass", []*prompb.TimeSeries{ts}, 1)
+	err := sendToRemoteWriteWithRetry(context.Background(), mockServer.URL, "user", "pass", []*prompb.TimeSeries{ts}, 1)
 	if err == nil {
 		t.Error("Expected error for forbidden response")
 	}
@@ -943,7 +954,7 @@ func TestSendToRemoteWriteWithRetry_MaxRetriesExceeded(t *testing.T) {
 	defer mockServer.Close()
 
 	ts := createTimeSeries("test_metric", 1.0, time.Now().UnixMilli(), "server", "instance")
-	err := sendToRemoteWriteWithRetry(mockServer.URL, "user", "pass", []*prompb.TimeSeries{ts}, 1)
+	err := sendToRemoteWriteWithRetry(context.Background(), mockServer.URL, "user", "pass", []*prompb.TimeSeries{ts}, 1)
 	if err == nil {
 		t.Error("Expected error after max retries exceeded")
 	}
@@ -954,3 +965,332 @@ func TestSendToRemoteWriteWithRetry_MaxRetriesExceeded(t *testing.T) {
 		t.Errorf("Expected error message about 2 attempts, got: %v", err)
 	}
 }
+
+func TestRunLibrespeedWithOptions_PassesTuningArgs(t *testing.T) {
+	mockOutput := "[{\"download\":100.0,\"upload\":50.0,\"ping\":10.0,\"jitter\":1.0,\"server\":{\"url\":\"http://example.com\"}}]"
+	runner := &MockRunner{Output: []byte(mockOutput)}
+	opts := TestOptions{Chunks: 20, UploadSizeKiB: 4096}
+	_, err := runLibrespeedWithOptions(runner, "librespeed-cli.exe", "", nil, opts)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	args := runner.LastArgs()
+	if !strings.Contains(args, "--chunks 20") {
+		t.Errorf("Expected '--chunks 20' in args, got: %s", args)
+	}
+	if !strings.Contains(args, "--upload-size 4096") {
+		t.Errorf("Expected '--upload-size 4096' in args, got: %s", args)
+	}
+}
+
+func TestRunLibrespeed_DefaultOptionsAddNoTuningArgs(t *testing.T) {
+	mockOutput := "[{\"download\":100.0,\"upload\":50.0,\"ping\":10.0,\"jitter\":1.0,\"server\":{\"url\":\"http://example.com\"}}]"
+	runner := &MockRunner{Output: []byte(mockOutput)}
+	_, err := runLibrespeed(runner, "librespeed-cli.exe", "", nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	args := runner.LastArgs()
+	if strings.Contains(args, "--chunks") || strings.Contains(args, "--upload-size") {
+		t.Errorf("Expected no tuning args with default options, got: %s", args)
+	}
+}
+
+// Test that pooled buffers produce a payload the receiver can decode
+func TestSendToRemoteWrite_PayloadRoundTrip(t *testing.T) {
+	var received []prompb.TimeSeries
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		compressed, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("Failed to read body: %v", err)
+		}
+		data, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			t.Errorf("Failed to decompress payload: %v", err)
+		}
+		var req prompb.WriteRequest
+		if err := req.Unmarshal(data); err != nil {
+			t.Errorf("Failed to unmarshal payload: %v", err)
+		}
+		received = req.Timeseries
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer mockServer.Close()
+
+	// Send twice with different sizes so the second send reuses pooled buffers
+	for _, count := range []int{10, 2} {
+		var series []*prompb.TimeSeries
+		for i := 0; i < count; i++ {
+			series = append(series, createTimeSeries(fmt.Sprintf("test_metric_%d", i), float64(i), 1690000000000, "http://server", "host"))
+		}
+		if err := sendToRemoteWrite(context.Background(), mockServer.URL, "user", "pass", series); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(received) != count {
+			t.Fatalf("Expected %d series, got %d", count, len(received))
+		}
+		if getLabelValue(received[count-1].Labels, "__name__") != fmt.Sprintf("test_metric_%d", count-1) {
+			t.Errorf("Unexpected metric name in decoded payload: %v", received[count-1].Labels)
+		}
+	}
+}
+
+func TestGetBuffer_Resizes(t *testing.T) {
+	buf := getBuffer(64)
+	if len(*buf) != 64 {
+		t.Errorf("Expected length 64, got %d", len(*buf))
+	}
+	putBuffer(buf)
+	buf = getBuffer(16)
+	if len(*buf) != 16 {
+		t.Errorf("Expected length 16, got %d", len(*buf))
+	}
+	putBuffer(buf)
+}
+
+// Decodes a remote write request received by a test server.
+func decodeWriteRequest(t *testing.T, r *http.Request) []prompb.TimeSeries {
+	t.Helper()
+	compressed, err := io.ReadAll(r.Body)
+	if err != nil {
+		t.Errorf("Failed to read body: %v", err)
+	}
+	data, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		t.Errorf("Failed to decompress payload: %v", err)
+	}
+	var req prompb.WriteRequest
+	if err := req.Unmarshal(data); err != nil {
+		t.Errorf("Failed to unmarshal payload: %v", err)
+	}
+	return req.Timeseries
+}
+
+func newTestRunContext(t *testing.T, url string, runner CommandRunner) *runContext {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg := &Config{}
+	cfg.RegisterFlags(fs)
+	if err := fs.Parse([]string{"--url", url, "--username", "u", "--password", "p",
+		"--min-free-disk-mb", "0", "--min-free-memory-mb", "0"}); err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	campaign, _ := parseCampaign("", "")
+	return &runContext{
+		cfg:           cfg,
+		encoder:       remoteWriteEncoder{},
+		campaign:      campaign,
+		hostname:      "host1",
+		extraLabels:   map[string]string{},
+		harnessRunner: runner,
+		logOutput:     os.Stderr,
+		serverIDs:     []int{1},
+	}
+}
+
+func TestRunOnce_FailureDoesNotPreventNextRun(t *testing.T) {
+	originalDelayFunc := retryDelayFunc
+	retryDelayFunc = func(attempt int) time.Duration { return 0 }
+	defer func() { retryDelayFunc = originalDelayFunc }()
+
+	var pushes [][]prompb.TimeSeries
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pushes = append(pushes, decodeWriteRequest(t, r))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	runner := &MockRunner{Err: fmt.Errorf("server unreachable")}
+	rc := newTestRunContext(t, server.URL, runner)
+	ctx := context.Background()
+
+	if err := rc.runOnce(ctx); err == nil {
+		t.Error("Expected the first run to fail, got nil")
+	}
+	// Only the failure count is pushed for a failed test
+	if len(pushes) != 1 || len(pushes[0]) != 1 || pushes[0][0].Labels[0].Value != "librespeed_exporter_consecutive_failures" || pushes[0][0].Samples[0].Value != 1 {
+		t.Errorf("Expected librespeed_exporter_consecutive_failures 1 pushed for a failed test, got %v", pushes)
+	}
+
+	runner.Err = nil
+	runner.Output = []byte(`[{"download":100.0,"upload":50.0,"ping":10.0,"jitter":1.0,"server":{"url":"http://example.com"}}]`)
+	if err := rc.runOnce(ctx); err != nil {
+		t.Errorf("Expected the second run to succeed, got %v", err)
+	}
+	if len(pushes) != 2 {
+		t.Fatalf("Expected the result pushed, got %d pushes", len(pushes))
+	}
+	found := false
+	for _, ts := range pushes[1] {
+		if ts.Labels[0].Value == "librespeed_exporter_consecutive_failures" {
+			found = true
+			if ts.Samples[0].Value != 0 {
+				t.Errorf("Expected the failure count reset with the result, got %v", ts.Samples[0].Value)
+			}
+		}
+	}
+	if !found {
+		t.Error("Expected librespeed_exporter_consecutive_failures in the pushed result")
+	}
+}
+
+func TestRunOnce_Cancelled(t *testing.T) {
+	rc := newTestRunContext(t, "http://127.0.0.1:1/write", &MockRunner{})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := rc.runOnce(ctx); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestDefaultRunner_Run_KilledOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	runner := &DefaultRunner{ctx: ctx}
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	if _, err := runner.Run("sleep", "10"); err == nil {
+		t.Error("Expected error for a killed command, got nil")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the command to be killed on cancel, took %v", elapsed)
+	}
+}
+
+// Cancels the run while "librespeed-cli" is running, as a signal would.
+type cancellingRunner struct {
+	cancel context.CancelFunc
+	output []byte
+	err    error
+}
+
+func (r *cancellingRunner) Run(name string, args ...string) ([]byte, error) {
+	r.cancel()
+	return r.output, r.err
+}
+
+func TestRunOnce_FlushesResultOnShutdown(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runner := &cancellingRunner{
+		cancel: cancel,
+		output: []byte(`[{"download":100.0,"upload":50.0,"ping":10.0,"jitter":1.0,"server":{"url":"http://example.com"}}]`),
+	}
+	rc := newTestRunContext(t, server.URL, runner)
+
+	if err := rc.runOnce(ctx); err != nil {
+		t.Errorf("Expected the completed result to be flushed, got %v", err)
+	}
+	if requests != 1 {
+		t.Errorf("Expected one push during shutdown, got %d", requests)
+	}
+}
+
+func TestRunOnce_AbortedTestReturnsCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	runner := &cancellingRunner{cancel: cancel, err: fmt.Errorf("signal: killed")}
+	rc := newTestRunContext(t, "http://127.0.0.1:1/write", runner)
+
+	if err := rc.runOnce(ctx); err != context.Canceled {
+		t.Errorf("Expected context.Canceled for an aborted test, got %v", err)
+	}
+}
+
+func TestCLIInstallDir(t *testing.T) {
+	cache := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", cache)
+	t.Setenv("LocalAppData", cache)
+	dir, err := cliInstallDir(false)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if runtime.GOOS != "darwin" && dir != filepath.Join(cache, "librespeed_exporter") {
+		t.Errorf("Expected the per-user cache directory, got %s", dir)
+	}
+	if dir, _ := cliInstallDir(true); dir != systemInstallDir {
+		t.Errorf("Expected the machine-wide directory with --system-install, got %s", dir)
+	}
+}
+
+func TestDefaultStateDir(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", home)
+	t.Setenv("LocalAppData", home)
+	t.Setenv("HOME", home)
+	dir, err := defaultStateDir(false)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !filepath.IsAbs(dir) || !strings.HasPrefix(dir, home) {
+		t.Errorf("Expected an absolute per-user directory under %s, got %s", home, dir)
+	}
+	if dir, _ := defaultStateDir(true); dir != systemStateDir {
+		t.Errorf("Expected the machine-wide directory with --system-install, got %s", dir)
+	}
+
+	cfg := &Config{StateDir: "/srv/librespeed"}
+	if err := applyStateDirDefault(cfg); err != nil || cfg.StateDir != "/srv/librespeed" {
+		t.Errorf("Expected an explicit --state-dir to be kept, got %s, %v", cfg.StateDir, err)
+	}
+	cfg = &Config{}
+	if err := applyStateDirDefault(cfg); err != nil || cfg.StateDir != dir {
+		t.Errorf("Expected %s, got %s, %v", dir, cfg.StateDir, err)
+	}
+}
+
+func TestEnsureLibrespeedCLI_FoundInInstallDir(t *testing.T) {
+	skipIfEmbedded(t)
+	if runtime.GOOS == "darwin" {
+		t.Skip("os.UserCacheDir ignores XDG_CACHE_HOME on macOS")
+	}
+	t.Setenv("PATH", "")
+	saved := cliSearchDirs
+	cliSearchDirs = nil
+	defer func() { cliSearchDirs = saved }()
+	cache := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", cache)
+	t.Setenv("LocalAppData", cache)
+	exe := filepath.Join(cache, "librespeed_exporter", cliBinaryName(runtime.GOOS))
+	os.MkdirAll(filepath.Dir(exe), 0755)
+	os.WriteFile(exe, []byte("stub"), 0755)
+
+	path, err := ensureLibrespeedCLI(context.Background(), cliInstallOptions{})
+	if err != nil || path != exe {
+		t.Errorf("Expected %s, got %q, %v", exe, path, err)
+	}
+	if os.Getenv("PATH") != "" {
+		t.Errorf("Expected PATH to be left alone, got %q", os.Getenv("PATH"))
+	}
+}
+
+// Stands in for a librespeed-cli that is killed after hanging.
+type hangingRunner struct {
+	delay time.Duration
+}
+
+func (r *hangingRunner) Run(name string, args ...string) ([]byte, error) {
+	time.Sleep(r.delay)
+	return nil, fmt.Errorf("command failed: signal: killed")
+}
+
+func TestRun_MaxRunDuration(t *testing.T) {
+	rc := newTestRunContext(t, "", &hangingRunner{delay: 200 * time.Millisecond})
+	rc.cfg.URL = ""
+	rc.cfg.MaxRunDuration = 50 * time.Millisecond
+	rc.cache = &resultCache{}
+
+	record, err := rc.runWithRecord(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "--max-run-duration of 50ms") {
+		t.Fatalf("Expected the run to fail on --max-run-duration, got %v", err)
+	}
+	if record == nil || record.Status != "failure" || record.Stage != "speedtest" {
+		t.Errorf("Expected a failure at the speedtest stage, got %+v", record)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reports whether the maintenance file exists. Its contents, if any, are
+// used as the reason so operators can note the change ticket in it.
+func checkMaintenance(path string) (bool, string, error) {
+	if path == "" {
+		return false, "", nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return false, "", nil
+	}
+	if err != nil {
+		return false, "", fmt.Errorf("failed to read maintenance file: %v", err)
+	}
+	return true, strings.TrimSpace(string(data)), nil
+}
+
+// Maintenance mode entered over the API, next to the file. It lasts until
+// it is left over the API or the exporter restarts. A nil *maintenanceSwitch
+// is never active.
+type maintenanceSwitch struct {
+	mu     sync.Mutex
+	active bool
+	reason string
+	since  time.Time
+}
+
+type maintenanceStatus struct {
+	Active bool       `json:"active"`
+	Reason string     `json:"reason,omitempty"`
+	Since  *time.Time `json:"since,omitempty"`
+}
+
+// Enters maintenance with reason, or leaves it. Entering again only updates
+// the reason.
+func (m *maintenanceSwitch) set(active bool, reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if active && !m.active {
+		m.since = clock.Now().UTC()
+	}
+	m.active = active
+	m.reason = ""
+	if active {
+		m.reason = reason
+	}
+}
+
+func (m *maintenanceSwitch) status() maintenanceStatus {
+	if m == nil {
+		return maintenanceStatus{}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.active {
+		return maintenanceStatus{}
+	}
+	since := m.since
+	return maintenanceStatus{Active: true, Reason: m.reason, Since: &since}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckMaintenance(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "maintenance")
+
+	active, _, err := checkMaintenance(path)
+	if err != nil || active {
+		t.Errorf("Expected inactive without file, got %v, %v", active, err)
+	}
+
+	if err := os.WriteFile(path, []byte("CHG-1234 circuit upgrade\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	active, reason, err := checkMaintenance(path)
+	if err != nil || !active {
+		t.Fatalf("Expected active with file, got %v, %v", active, err)
+	}
+	if reason != "CHG-1234 circuit upgrade" {
+		t.Errorf("Expected reason from file, got %q", reason)
+	}
+}
+
+func TestCheckMaintenance_Disabled(t *testing.T) {
+	active, _, err := checkMaintenance("")
+	if err != nil || active {
+		t.Errorf("Expected inactive when not configured, got %v, %v", active, err)
+	}
+}
+
+func TestMaintenanceSwitch(t *testing.T) {
+	var unset *maintenanceSwitch
+	if unset.status().Active {
+		t.Error("Expected a nil switch to be inactive")
+	}
+
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	fake := useFakeClock(t, start)
+	m := &maintenanceSwitch{}
+	m.set(true, "CHG-1234")
+	fake.Advance(time.Hour)
+	m.set(true, "CHG-1234 extended")
+	status := m.status()
+	if !status.Active || status.Reason != "CHG-1234 extended" || status.Since == nil || !status.Since.Equal(start) {
+		t.Errorf("Expected active since the first call with the new reason, got %+v", status)
+	}
+	m.set(false, "")
+	if status := m.status(); status.Active || status.Reason != "" || status.Since != nil {
+		t.Errorf("Expected inactive after leaving, got %+v", status)
+	}
+}
+
+func TestRun_SkipsDuringAPIMaintenance(t *testing.T) {
+	runner := &MockRunner{Output: []byte(`[{"download":100.0,"upload":50.0,"ping":10.0,"jitter":1.0,"server":{"url":"http://example.com"}}]`)}
+	rc := newTestRunContext(t, "", runner)
+	rc.cfg.URL = ""
+	rc.cache = &resultCache{}
+	rc.maintenance = &maintenanceSwitch{}
+	rc.maintenance.set(true, "CHG-1234")
+
+	if err := rc.runOnce(context.Background()); err != nil {
+		t.Fatalf("Expected the skipped run to succeed, got %v", err)
+	}
+	if runner.Calls != 0 {
+		t.Errorf("Expected no test during maintenance, got %d calls", runner.Calls)
+	}
+	if len(rc.cache.series) != 1 || rc.cache.series[0].Labels[0].Value != "librespeed_maintenance" || rc.cache.series[0].Samples[0].Value != 1 {
+		t.Errorf("Expected only librespeed_maintenance 1, got %v", rc.cache.series)
+	}
+
+	rc.maintenance.set(false, "")
+	if err := rc.runOnce(context.Background()); err != nil {
+		t.Fatalf("Expected the run to succeed, got %v", err)
+	}
+	if runner.Calls != 1 {
+		t.Errorf("Expected the test to run after leaving maintenance, got %d calls", runner.Calls)
+	}
+	found := false
+	for _, ts := range rc.cache.series {
+		if ts.Labels[0].Value == "librespeed_maintenance" {
+			found = ts.Samples[0].Value == 0
+		}
+	}
+	if !found {
+		t.Error("Expected librespeed_maintenance 0 with the result")
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// mDNS group and how long answers are collected; replaced by tests.
+var (
+	mdnsGroup         = "224.0.0.251:5353"
+	mdnsBrowseTimeout = 2 * time.Second
+)
+
+var mdnsServiceName = regexp.MustCompile(`^_[a-zA-Z0-9-]+\._(tcp|udp)$`)
+
+// A librespeed backend advertised on the LAN, e.g. by avahi. The TXT record
+// may set name, path (default /backend/), scheme (http or https) and the
+// dl, ul, ping and getip endpoints, which default to those of
+// speedtest-go.
+type mdnsServer struct {
+	Instance string
+	Host     string
+	Port     uint16
+	TXT      map[string]string
+}
+
+// Browses for --mdns-service before each run and adds what answers to the
+// servers librespeed-cli chooses from.
+type mdnsDiscovery struct {
+	service  string
+	stateDir string
+	// Without --server-id or --all-servers the first discovered server is
+	// tested rather than server 1
+	pickDiscovered bool
+}
+
+// nil unless --mdns-discovery is set.
+func newMDNSDiscovery(cfg *Config, explicit map[string]bool) (*mdnsDiscovery, error) {
+	if !cfg.MDNSDiscovery {
+		return nil, nil
+	}
+	if !mdnsServiceName.MatchString(cfg.MDNSService) {
+		return nil, fmt.Errorf("invalid --mdns-service %q, expected a DNS-SD service type such as _librespeed._tcp", cfg.MDNSService)
+	}
+	return &mdnsDiscovery{
+		service:        cfg.MDNSService,
+		stateDir:       cfg.StateDir,
+		pickDiscovered: !explicit["server-id"] && !cfg.AllServers,
+	}, nil
+}
+
+// Sends one PTR query for service and collects the answers until timeout.
+// The query goes out from an ephemeral port, so responders answer by
+// unicast and the mDNS port needn't be free.
+func browseMDNS(ctx context.Context, service string, timeout time.Duration) ([]mdnsServer, error) {
+	domain := service + ".local."
+	name, err := dnsmessage.NewName(domain)
+	if err != nil {
+		return nil, err
+	}
+	query := dnsmessage.Message{Questions: []dnsmessage.Question{{Name: name, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET}}}
+	packet, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+	group, err := net.ResolveUDPAddr("udp4", mdnsGroup)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open mDNS socket: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.WriteToUDP(packet, group); err != nil {
+		return nil, fmt.Errorf("failed to send mDNS query: %v", err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetReadDeadline(deadline)
+	instances := map[string]*mdnsServer{}
+	addresses := map[string]string{}
+	buf := make([]byte, 9000)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			// The deadline ends the browse
+			break
+		}
+		parseMDNSResponse(buf[:n], domain, instances, addresses)
+	}
+
+	var servers []mdnsServer
+	for _, s := range instances {
+		if s.Port == 0 {
+			log.Printf("WARNING: mDNS service %s did not say where it listens, skipping it", s.Instance)
+			continue
+		}
+		// Prefer the address over the .local host name, which Go's
+		// resolver can't look up
+		if addr, ok := addresses[s.Host]; ok {
+			s.Host = addr
+		}
+		servers = append(servers, *s)
+	}
+	sort.Slice(servers, func(i, j int) bool { return servers[i].Instance < servers[j].Instance })
+	return servers, nil
+}
+
+// Adds the PTR, SRV, TXT and A records of one response to instances and
+// addresses. Records are taken from every section, since responders put
+// SRV, TXT and A in the additional one.
+func parseMDNSResponse(packet []byte, domain string, instances map[string]*mdnsServer, addresses map[string]string) {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(packet); err != nil || !msg.Response {
+		return
+	}
+	instance := func(name string) *mdnsServer {
+		s, ok := instances[name]
+		if !ok {
+			s = &mdnsServer{Instance: strings.TrimSuffix(strings.TrimSuffix(name, "."+domain), "."), TXT: map[string]string{}}
+			instances[name] = s
+		}
+		return s
+	}
+	var records []dnsmessage.Resource
+	records = append(records, msg.Answers...)
+	records = append(records, msg.Additionals...)
+	// PTRs first, so SRV and TXT records only count for the service
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Header.Type == dnsmessage.TypePTR && records[j].Header.Type != dnsmessage.TypePTR
+	})
+	for _, r := range records {
+		name := strings.ToLower(r.Header.Name.String())
+		switch body := r.Body.(type) {
+		case *dnsmessage.PTRResource:
+			if name == strings.ToLower(domain) {
+				instance(strings.ToLower(body.PTR.String()))
+			}
+		case *dnsmessage.SRVResource:
+			if s, ok := instances[name]; ok {
+				s.Host, s.Port = strings.ToLower(body.Target.String()), body.Port
+			}
+		case *dnsmessage.TXTResource:
+			if s, ok := instances[name]; ok {
+				for _, txt := range body.TXT {
+					key, value, _ := strings.Cut(txt, "=")
+					s.TXT[strings.ToLower(key)] = value
+				}
+			}
+		case *dnsmessage.AResource:
+			addresses[name] = net.IP(body.A[:]).String()
+		}
+	}
+}
+
+// Entry for a librespeed-cli --local-json server list.
+func (s mdnsServer) entry(id int) map[string]any {
+	txt := func(key, fallback string) string {
+		if value := s.TXT[key]; value != "" {
+			return value
+		}
+		return fallback
+	}
+	scheme := txt("scheme", "http")
+	if scheme != "https" {
+		scheme = "http"
+	}
+	path := "/" + strings.Trim(txt("path", "/backend/"), "/")
+	if path != "/" {
+		path += "/"
+	}
+	return map[string]any{
+		"id":       id,
+		"name":     txt("name", s.Instance),
+		"server":   fmt.Sprintf("%s://%s%s", scheme, net.JoinHostPort(strings.TrimSuffix(s.Host, "."), strconv.Itoa(int(s.Port))), path),
+		"dlURL":    txt("dl", "garbage"),
+		"ulURL":    txt("ul", "empty"),
+		"pingURL":  txt("ping", "empty"),
+		"getIpURL": txt("getip", "getIP"),
+	}
+}
+
+// Writes a server list of the servers in localJSON, if set, followed by
+// those discovered, numbered after the highest existing ID. Returns its
+// path and the IDs given to the discovered servers.
+func writeMDNSServerList(stateDir, localJSON string, servers []mdnsServer) (string, []int, error) {
+	var entries []json.RawMessage
+	next := 1
+	if localJSON != "" {
+		data, err := os.ReadFile(localJSON)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to read server list: %v", err)
+		}
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return "", nil, fmt.Errorf("failed to parse server list: %v", err)
+		}
+		ids, err := loadServerIDs(localJSON)
+		if err != nil {
+			return "", nil, err
+		}
+		for _, id := range ids {
+			next = max(next, id+1)
+		}
+	}
+	var ids []int
+	for _, s := range servers {
+		entry, err := json.Marshal(s.entry(next))
+		if err != nil {
+			return "", nil, err
+		}
+		entries = append(entries, entry)
+		ids = append(ids, next)
+		next++
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return "", nil, err
+	}
+	path := filepath.Join(stateDir, "mdns_servers.json")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", nil, fmt.Errorf("failed to write discovered server list: %v", err)
+	}
+	return path, ids, nil
+}
+
+// Browses for servers and returns the server list and IDs the run should
+// use instead of localJSON and serverIDs, and how many servers answered.
+// Without answers, or on errors, the run goes ahead as configured.
+func (d *mdnsDiscovery) servers(ctx context.Context, localJSON string, allServers bool, serverIDs []int) (string, []int, int) {
+	found, err := browseMDNS(ctx, d.service, mdnsBrowseTimeout)
+	if err != nil {
+		log.Printf("WARNING: mDNS discovery failed: %v", err)
+		return localJSON, serverIDs, 0
+	}
+	if len(found) == 0 {
+		log.Printf("No %s servers answered on mDNS", d.service)
+		return localJSON, serverIDs, 0
+	}
+	path, ids, err := writeMDNSServerList(d.stateDir, localJSON, found)
+	if err != nil {
+		log.Printf("WARNING: Not using mDNS servers: %v", err)
+		return localJSON, serverIDs, len(found)
+	}
+	for i, s := range found {
+		log.Printf("Found server %s via mDNS at %s:%d (id %d)", s.Instance, s.Host, s.Port, ids[i])
+	}
+	switch {
+	case allServers:
+		if localJSON != "" {
+			serverIDs = append(append([]int(nil), serverIDs...), ids...)
+		} else {
+			serverIDs = ids
+		}
+	case d.pickDiscovered:
+		serverIDs = ids[:1]
+	}
+	return path, serverIDs, len(found)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func mdnsName(t *testing.T, name string) dnsmessage.Name {
+	t.Helper()
+	n, err := dnsmessage.NewName(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// Answers every query like avahi would for the given instances, each
+// {instance, host, address, port, txt...}.
+func serveMDNS(t *testing.T, instances ...[]string) {
+	t.Helper()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	savedGroup, savedTimeout := mdnsGroup, mdnsBrowseTimeout
+	mdnsGroup, mdnsBrowseTimeout = conn.LocalAddr().String(), 300*time.Millisecond
+	t.Cleanup(func() { mdnsGroup, mdnsBrowseTimeout = savedGroup, savedTimeout })
+
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			var query dnsmessage.Message
+			if query.Unpack(buf[:n]) != nil || len(query.Questions) != 1 || query.Questions[0].Type != dnsmessage.TypePTR {
+				continue
+			}
+			service := query.Questions[0].Name
+			// One response per instance, as separate responders send them
+			for _, inst := range instances {
+				full := mdnsName(t, inst[0]+"."+service.String())
+				hdr := func(name dnsmessage.Name, typ dnsmessage.Type) dnsmessage.ResourceHeader {
+					return dnsmessage.ResourceHeader{Name: name, Type: typ, Class: dnsmessage.ClassINET, TTL: 120}
+				}
+				resp := dnsmessage.Message{
+					Header:  dnsmessage.Header{Response: true, Authoritative: true},
+					Answers: []dnsmessage.Resource{{Header: hdr(service, dnsmessage.TypePTR), Body: &dnsmessage.PTRResource{PTR: full}}},
+				}
+				if inst[1] != "" {
+					host := mdnsName(t, inst[1])
+					port, _ := net.LookupPort("tcp", inst[3])
+					var a [4]byte
+					copy(a[:], net.ParseIP(inst[2]).To4())
+					resp.Additionals = []dnsmessage.Resource{
+						{Header: hdr(full, dnsmessage.TypeSRV), Body: &dnsmessage.SRVResource{Target: host, Port: uint16(port)}},
+						{Header: hdr(full, dnsmessage.TypeTXT), Body: &dnsmessage.TXTResource{TXT: append([]string{"txtvers=1"}, inst[4:]...)}},
+						{Header: hdr(host, dnsmessage.TypeA), Body: &dnsmessage.AResource{A: a}},
+					}
+				}
+				packet, err := resp.Pack()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				conn.WriteToUDP(packet, from)
+			}
+		}
+	}()
+}
+
+func TestBrowseMDNS(t *testing.T) {
+	serveMDNS(t,
+		[]string{"Homelab", "nas.local.", "192.168.1.10", "8989", "path=/", "name=NAS"},
+		[]string{"Broken", "", "", ""},
+		[]string{"Attic", "pi.local.", "192.168.1.20", "80"},
+	)
+	servers, err := browseMDNS(context.Background(), "_librespeed._tcp", mdnsBrowseTimeout)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(servers) != 2 || servers[0].Instance != "attic" || servers[1].Host != "192.168.1.10" || servers[1].Port != 8989 {
+		t.Fatalf("Expected Attic and Homelab by address, got %+v", servers)
+	}
+	entry := servers[1].entry(7)
+	if entry["server"] != "http://192.168.1.10:8989/" || entry["name"] != "NAS" || entry["id"] != 7 || entry["dlURL"] != "garbage" {
+		t.Errorf("Unexpected server entry %v", entry)
+	}
+	if got := servers[0].entry(1)["server"]; got != "http://192.168.1.20:80/backend/" {
+		t.Errorf("Expected the default path, got %v", got)
+	}
+}
+
+func TestWriteMDNSServerList(t *testing.T) {
+	dir := t.TempDir()
+	local := filepath.Join(dir, "servers.json")
+	os.WriteFile(local, []byte(`[{"id": 3, "name": "HQ", "server": "http://10.0.0.1/backend/"}, {"id": "5", "name": "Branch", "server": "http://10.0.0.2/backend/"}]`), 0644)
+	found := []mdnsServer{{Instance: "a", Host: "192.168.1.10", Port: 80}, {Instance: "b", Host: "192.168.1.11", Port: 80}}
+
+	path, ids, err := writeMDNSServerList(dir, local, found)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(ids) != 2 || ids[0] != 6 || ids[1] != 7 {
+		t.Errorf("Expected IDs after the highest existing one, got %v", ids)
+	}
+	all, err := loadServerIDs(path)
+	if err != nil || len(all) != 4 {
+		t.Errorf("Expected the --local-json servers and the discovered ones, got %v, %v", all, err)
+	}
+	urls, err := serverURLs(path, []int{3, 7})
+	if err != nil || urls[1] != "http://192.168.1.11:80/backend/" {
+		t.Errorf("Unexpected server URLs %v, %v", urls, err)
+	}
+}
+
+func TestRun_MDNSDiscovery(t *testing.T) {
+	serveMDNS(t, []string{"Homelab", "nas.local.", "192.168.1.10", "8989"})
+	runner := &MockRunner{Output: []byte(`[{"download":100.0,"upload":50.0,"ping":10.0,"jitter":1.0,"server":{"url":"http://192.168.1.10:8989/backend/"}}]`)}
+	rc := newTestRunContext(t, "", runner)
+	rc.cfg.URL = ""
+	rc.cfg.MDNSDiscovery = true
+	rc.cfg.StateDir = t.TempDir()
+	rc.cache = &resultCache{}
+	rc.mdns, _ = newMDNSDiscovery(rc.cfg, map[string]bool{})
+
+	if err := rc.runOnce(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	list := filepath.Join(rc.cfg.StateDir, "mdns_servers.json")
+	if want := "--local-json " + list + " --server 1"; !strings.Contains(runner.LastArgs(), want) {
+		t.Errorf("Expected %q in the librespeed-cli arguments, got %s", want, runner.LastArgs())
+	}
+	var servers []map[string]any
+	data, _ := os.ReadFile(list)
+	if json.Unmarshal(data, &servers) != nil || len(servers) != 1 || servers[0]["name"] != "homelab" {
+		t.Errorf("Unexpected server list %s", data)
+	}
+	found := false
+	for _, ts := range rc.cache.series {
+		if getLabelValue(ts.Labels, "__name__") == "librespeed_mdns_servers" {
+			found = ts.Samples[0].Value == 1
+		}
+	}
+	if !found {
+		t.Error("Expected librespeed_mdns_servers 1")
+	}
+}
+
+func TestNewMDNSDiscovery(t *testing.T) {
+	if d, err := newMDNSDiscovery(&Config{}, nil); d != nil || err != nil {
+		t.Errorf("Expected nothing without --mdns-discovery, got %v, %v", d, err)
+	}
+	if _, err := newMDNSDiscovery(&Config{MDNSDiscovery: true, MDNSService: "librespeed"}, nil); err == nil {
+		t.Error("Expected an invalid service type to be rejected")
+	}
+	d, err := newMDNSDiscovery(&Config{MDNSDiscovery: true, MDNSService: "_speedtest._tcp"}, map[string]bool{"server-id": true})
+	if err != nil || d.pickDiscovered {
+		t.Errorf("Expected an explicit --server-id to be kept, got %+v, %v", d, err)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// How often --metadata-file is checked for changes between runs; replaced
+// by tests.
+var metadataPollInterval = 10 * time.Second
+
+var metadataLabelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Labels from a key=value file kept up to date by something else, such as
+// a CMDB sync, e.g. circuit_id, provider and contract tier. The file is
+// re-read whenever it changes, so edits apply from the next run without a
+// restart or reload.
+type metadataFile struct {
+	path string
+	stop chan struct{}
+
+	mu      sync.Mutex
+	labels  map[string]string
+	modTime time.Time
+	size    int64
+}
+
+// One label per line as name=value; blank lines and lines starting with #
+// are skipped and values may be double-quoted. A label with an empty value
+// is left out.
+func parseMetadata(r io.Reader) (map[string]string, error) {
+	labels := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(text, "=")
+		name = strings.TrimSpace(name)
+		if !ok || !metadataLabelName.MatchString(name) || strings.HasPrefix(name, "__") {
+			return nil, fmt.Errorf("line %d: expected label_name=value, got %q", line, text)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) {
+			value = value[1 : len(value)-1]
+		}
+		if value != "" {
+			labels[name] = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return labels, nil
+}
+
+// nil without a path. A file that is missing or invalid at startup is
+// logged and retried, as the sync may not have written it yet.
+func openMetadataFile(path string) *metadataFile {
+	if path == "" {
+		return nil
+	}
+	m := &metadataFile{path: path}
+	if err := m.refresh(); err != nil {
+		log.Printf("WARNING: %v", err)
+	}
+	return m
+}
+
+// Re-reads the file if its size or modification time changed. On error the
+// labels read last are kept.
+func (m *metadataFile) refresh() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	info, err := os.Stat(m.path)
+	if err != nil {
+		return fmt.Errorf("failed to read --metadata-file, keeping its previous labels: %v", err)
+	}
+	if info.ModTime().Equal(m.modTime) && info.Size() == m.size {
+		return nil
+	}
+	f, err := os.Open(m.path)
+	if err != nil {
+		return fmt.Errorf("failed to read --metadata-file, keeping its previous labels: %v", err)
+	}
+	defer f.Close()
+	labels, err := parseMetadata(f)
+	if err != nil {
+		return fmt.Errorf("invalid --metadata-file %s, keeping its previous labels: %v", m.path, err)
+	}
+	m.modTime, m.size = info.ModTime(), info.Size()
+	if maps.Equal(labels, m.labels) {
+		return nil
+	}
+	m.labels = labels
+	names := make([]string, 0, len(labels))
+	for name, value := range labels {
+		names = append(names, name+"="+value)
+	}
+	sort.Strings(names)
+	log.Printf("Metadata labels from %s: %s", m.path, strings.Join(names, ", "))
+	return nil
+}
+
+// Polls the file until close, so a bad edit is reported when it happens
+// rather than at the next run.
+func (m *metadataFile) watch() {
+	if m == nil || m.stop != nil {
+		return
+	}
+	m.stop = make(chan struct{})
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(metadataPollInterval)
+		defer ticker.Stop()
+		var lastErr string
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				err := m.refresh()
+				// A missing file would otherwise be logged every poll
+				if err != nil && err.Error() != lastErr {
+					log.Printf("WARNING: %v", err)
+				}
+				lastErr = errString(err)
+			}
+		}
+	}(m.stop)
+}
+
+func (m *metadataFile) close() {
+	if m != nil && m.stop != nil {
+		close(m.stop)
+	}
+}
+
+// Current labels, re-read first if the file changed since the last check.
+func (m *metadataFile) currentLabels() map[string]string {
+	if m == nil {
+		return nil
+	}
+	if err := m.refresh(); err != nil {
+		log.Printf("WARNING: %v", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return maps.Clone(m.labels)
+}
+
+// Adds labels a series doesn't already have; the exporter's own labels,
+// such as instance or server_url, take precedence over metadata.
+func addMissingLabels(series []*prompb.TimeSeries, labels map[string]string) {
+	for _, ts := range series {
+		missing := map[string]string{}
+		for name, value := range labels {
+			if getLabelValue(ts.Labels, name) == "" {
+				missing[name] = value
+			}
+		}
+		addLabels([]*prompb.TimeSeries{ts}, missing)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMetadata(t *testing.T) {
+	labels, err := parseMetadata(strings.NewReader("# From the CMDB\ncircuit_id = C-1234\n\nprovider=\"Acme Fiber\"\ncontract_tier=\n"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(labels) != 2 || labels["circuit_id"] != "C-1234" || labels["provider"] != "Acme Fiber" {
+		t.Errorf("Unexpected labels: %v", labels)
+	}
+	for _, bad := range []string{"circuit id=C-1234", "__name__=x", "provider"} {
+		if _, err := parseMetadata(strings.NewReader(bad)); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+}
+
+// Writes contents with a distinct modification time, as a sync would.
+func writeMetadata(t *testing.T, path, contents string, age time.Duration) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Now().Add(-age)
+	os.Chtimes(path, mtime, mtime)
+}
+
+func TestMetadataFile_Refresh(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metadata.env")
+	m := openMetadataFile(path)
+	if labels := m.currentLabels(); len(labels) != 0 {
+		t.Errorf("Expected no labels before the file exists, got %v", labels)
+	}
+
+	writeMetadata(t, path, "circuit_id=C-1234\n", time.Hour)
+	if labels := m.currentLabels(); labels["circuit_id"] != "C-1234" {
+		t.Errorf("Expected the new file to be read, got %v", labels)
+	}
+	writeMetadata(t, path, "circuit_id=C-5678\n", time.Minute)
+	if labels := m.currentLabels(); labels["circuit_id"] != "C-5678" {
+		t.Errorf("Expected the change to be read, got %v", labels)
+	}
+	writeMetadata(t, path, "circuit id\n", 0)
+	if labels := m.currentLabels(); labels["circuit_id"] != "C-5678" {
+		t.Errorf("Expected an invalid edit to keep the previous labels, got %v", labels)
+	}
+	os.Remove(path)
+	if labels := m.currentLabels(); labels["circuit_id"] != "C-5678" {
+		t.Errorf("Expected a missing file to keep the previous labels, got %v", labels)
+	}
+}
+
+func TestMetadataFile_Watch(t *testing.T) {
+	saved := metadataPollInterval
+	metadataPollInterval = 10 * time.Millisecond
+	defer func() { metadataPollInterval = saved }()
+	path := filepath.Join(t.TempDir(), "metadata.env")
+	writeMetadata(t, path, "provider=Acme\n", time.Hour)
+	m := openMetadataFile(path)
+	m.watch()
+	defer m.close()
+
+	writeMetadata(t, path, "provider=Globex\n", 0)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		m.mu.Lock()
+		provider := m.labels["provider"]
+		m.mu.Unlock()
+		if provider == "Globex" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the watcher to pick up the change, still %q", provider)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRun_MetadataLabels(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metadata.env")
+	writeMetadata(t, path, "circuit_id=C-1234\ninstance=spoofed\n", time.Hour)
+	runner := &MockRunner{Output: []byte(`[{"download":100.0,"upload":50.0,"ping":10.0,"jitter":1.0,"server":{"url":"http://example.com"}}]`)}
+	rc := newTestRunContext(t, "", runner)
+	rc.cfg.URL = ""
+	rc.cache = &resultCache{}
+	rc.metadata = openMetadataFile(path)
+
+	if err := rc.runOnce(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(rc.cache.series) == 0 {
+		t.Fatal("Expected the result to be cached")
+	}
+	for _, ts := range rc.cache.series {
+		if getLabelValue(ts.Labels, "circuit_id") != "C-1234" || getLabelValue(ts.Labels, "instance") != "host1" {
+			t.Errorf("Expected circuit_id from the file and the exporter's own instance, got %v", ts.Labels)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+//go:build !minimal
+
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// The pull-mode side of --listen-address, left out of -tags minimal builds
+// with client_golang.
+
+// Label sets vary with flags (campaign, agent_id, ...), so this is an
+// unchecked collector: Describe sends nothing.
+func (c *resultCache) Describe(ch chan<- *prometheus.Desc) {}
+
+func (c *resultCache) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, ts := range c.series {
+		name := ""
+		labels := prometheus.Labels{}
+		for _, l := range ts.Labels {
+			if l.Name == "__name__" {
+				name = l.Value
+			} else {
+				labels[l.Name] = l.Value
+			}
+		}
+		// The pushed copy of a status metric reported below
+		if name == "" || len(ts.Samples) == 0 || name == "librespeed_exporter_consecutive_failures" {
+			continue
+		}
+		desc := prometheus.NewDesc(name, "librespeed test result", nil, labels)
+		metric, err := prometheus.NewConstMetric(desc, prometheus.GaugeValue, ts.Samples[len(ts.Samples)-1].Value)
+		if err != nil {
+			log.Printf("WARNING: Skipping metric %s: %v", name, err)
+			continue
+		}
+		ch <- metric
+	}
+
+	if c.lastRun.IsZero() {
+		return
+	}
+	success := 0.0
+	if c.lastSuccess {
+		success = 1
+	}
+	ch <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc("librespeed_exporter_last_run_success", "Whether the last test run succeeded", nil, nil),
+		prometheus.GaugeValue, success)
+	ch <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc("librespeed_exporter_last_run_timestamp_seconds", "Unix time the last test run finished", nil, nil),
+		prometheus.GaugeValue, float64(c.lastRun.Unix()))
+	ch <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc("librespeed_exporter_consecutive_failures", "Tests that failed in a row, reset by the next successful test", nil, nil),
+		prometheus.GaugeValue, float64(c.failures))
+}
+
+// Blackbox-exporter style: the scrape config picks the server through
+// ?server_id=, and a failed test is reported as probe_success 0 with a 200
+// rather than a scrape error. The server list is always the configured
+// --local-json; a request can't point librespeed-cli at other files.
+func probeHandler(probe probeFunc, localJSON string) http.Handler {
+	// Concurrent tests would compete for the same link and skew each other
+	var mu sync.Mutex
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		serverID, err := strconv.Atoi(query.Get("server_id"))
+		if err != nil {
+			http.Error(w, "server_id parameter must be an integer", http.StatusBadRequest)
+			return
+		}
+		if requested := query.Get("local_json"); requested != "" && requested != localJSON {
+			http.Error(w, "local_json parameter is not supported, the probe uses --local-json", http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		start := time.Now()
+		series, err := probe(r.Context(), serverID, localJSON)
+		duration := time.Since(start)
+		mu.Unlock()
+
+		cache := &resultCache{}
+		success := 1.0
+		if err != nil {
+			log.Printf("WARNING: Probe of server %d failed: %v", serverID, err)
+			success = 0
+		} else {
+			cache.Update(series)
+		}
+
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(cache)
+		probeSuccess := prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_success",
+			Help: "Whether the speed test succeeded",
+		})
+		probeDuration := prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probe_duration_seconds",
+			Help: "How long the speed test took",
+		})
+		registry.MustRegister(probeSuccess, probeDuration)
+		probeSuccess.Set(success)
+		probeDuration.Set(duration.Seconds())
+
+		promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	})
+}
+
+// Routes /metrics, and /probe when probe is set.
+func newMetricsMux(cache *resultCache, probe probeFunc, localJSON string) *http.ServeMux {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(cache)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	if probe != nil {
+		mux.Handle("/probe", probeHandler(probe, localJSON))
+	}
+	return mux
+}
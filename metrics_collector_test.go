@@ -0,0 +1,161 @@
+//go:build !minimal
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func TestResultCache_Collect(t *testing.T) {
+	cache := &resultCache{}
+	series := []*prompb.TimeSeries{
+		createTimeSeries("librespeed_download_mbps", 95.5, 1000, "http://server", "host1"),
+		createTimeSeries("librespeed_ping_ms", 12, 1000, "http://server", "host1"),
+		// The pushed copy is left to the collector's own gauge
+		createTimeSeries("librespeed_exporter_consecutive_failures", 0, 1000, "http://server", "host1"),
+	}
+	addLabels(series, map[string]string{"agent_id": "abc"})
+	cache.Update(series)
+	cache.RecordRun(true, 0, time.Unix(1700000000, 0))
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(cache)
+
+	expected := `
+# HELP librespeed_download_mbps librespeed test result
+# TYPE librespeed_download_mbps gauge
+librespeed_download_mbps{agent_id="abc",instance="host1",server_url="http://server"} 95.5
+# HELP librespeed_exporter_consecutive_failures Tests that failed in a row, reset by the next successful test
+# TYPE librespeed_exporter_consecutive_failures gauge
+librespeed_exporter_consecutive_failures 0
+# HELP librespeed_exporter_last_run_success Whether the last test run succeeded
+# TYPE librespeed_exporter_last_run_success gauge
+librespeed_exporter_last_run_success 1
+# HELP librespeed_exporter_last_run_timestamp_seconds Unix time the last test run finished
+# TYPE librespeed_exporter_last_run_timestamp_seconds gauge
+librespeed_exporter_last_run_timestamp_seconds 1.7e+09
+# HELP librespeed_ping_ms librespeed test result
+# TYPE librespeed_ping_ms gauge
+librespeed_ping_ms{agent_id="abc",instance="host1",server_url="http://server"} 12
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestResultCache_EmptyBeforeFirstRun(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(&resultCache{})
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(families) != 0 {
+		t.Errorf("Expected no metrics before the first run, got %d families", len(families))
+	}
+}
+
+func TestProbeHandler(t *testing.T) {
+	var gotServer int
+	var gotJSON string
+	probe := func(ctx context.Context, serverID int, localJSONPath string) ([]*prompb.TimeSeries, error) {
+		gotServer, gotJSON = serverID, localJSONPath
+		return []*prompb.TimeSeries{
+			createTimeSeries("librespeed_download_mbps", 50, 1000, "http://server7", "host1"),
+		}, nil
+	}
+	handler := probeHandler(probe, "default.json")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/probe?server_id=7", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	if gotServer != 7 || gotJSON != "default.json" {
+		t.Errorf("Expected server 7 from default.json, got %d from %q", gotServer, gotJSON)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		`librespeed_download_mbps{instance="host1",server_url="http://server7"} 50`,
+		"probe_success 1",
+		"probe_duration_seconds",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected %q in response, got:\n%s", want, body)
+		}
+	}
+}
+
+func TestProbeHandler_Failure(t *testing.T) {
+	probe := func(ctx context.Context, serverID int, localJSONPath string) ([]*prompb.TimeSeries, error) {
+		return nil, errors.New("server unreachable")
+	}
+	handler := probeHandler(probe, "")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/probe?server_id=3", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 for a failed probe, got %d", rec.Code)
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, "probe_success 0") {
+		t.Errorf("Expected probe_success 0, got:\n%s", body)
+	}
+	if strings.Contains(body, "librespeed_download_mbps") {
+		t.Errorf("Expected no result series for a failed probe, got:\n%s", body)
+	}
+}
+
+func TestProbeHandler_LocalJSONFixed(t *testing.T) {
+	var gotJSON string
+	probe := func(ctx context.Context, serverID int, localJSONPath string) ([]*prompb.TimeSeries, error) {
+		gotJSON = localJSONPath
+		return nil, nil
+	}
+	handler := probeHandler(probe, "servers.json")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/probe?server_id=3&local_json=/etc/shadow", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for another local_json, got %d", rec.Code)
+	}
+	if gotJSON != "" {
+		t.Errorf("Expected no test with a requested file, got one with %q", gotJSON)
+	}
+
+	// Naming the configured list, as older scrape configs may, still works
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/probe?server_id=3&local_json=servers.json", nil))
+	if rec.Code != http.StatusOK || gotJSON != "servers.json" {
+		t.Errorf("Expected a test from servers.json, got %d with %q", rec.Code, gotJSON)
+	}
+}
+
+func TestProbeHandler_InvalidServerID(t *testing.T) {
+	called := false
+	probe := func(ctx context.Context, serverID int, localJSONPath string) ([]*prompb.TimeSeries, error) {
+		called = true
+		return nil, nil
+	}
+	rec := httptest.NewRecorder()
+	probeHandler(probe, "").ServeHTTP(rec, httptest.NewRequest("GET", "/probe?server_id=abc", nil))
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400, got %d", rec.Code)
+	}
+	if called {
+		t.Error("Expected no test to run for an invalid server_id")
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// resultCache holds the series of the last completed test and exposes them
+// to client_golang (metrics_collector.go). It re-emits exactly what would be
+// pushed, so scraped and pushed metrics carry the same names and labels.
+type resultCache struct {
+	mu          sync.Mutex
+	series      []*prompb.TimeSeries
+	lastRun     time.Time
+	lastSuccess bool
+	failures    int
+}
+
+func (c *resultCache) Update(series []*prompb.TimeSeries) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.series = series
+}
+
+func (c *resultCache) RecordRun(success bool, failures int, ts time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastRun = ts
+	c.lastSuccess = success
+	c.failures = failures
+}
+
+// Runs one on-demand test against serverID for /probe.
+type probeFunc func(ctx context.Context, serverID int, localJSONPath string) ([]*prompb.TimeSeries, error)
+
+// Serves handler on addr until ctx is cancelled and the server has shut
+// down.
+func serveHTTP(ctx context.Context, addr string, handler http.Handler) error {
+	server := &http.Server{Addr: addr, Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+
+	stopped := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+		close(stopped)
+	}()
+
+	log.Printf("Serving metrics on %s/metrics", addr)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	// Requests in flight finish before the caller releases what they use
+	<-stopped
+	return nil
+}
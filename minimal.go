@@ -0,0 +1,123 @@
+//go:build minimal
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Stand-ins for the features a -tags minimal build leaves out, together with
+// their dependencies, for routers and other small devices. configure rejects
+// their flags, so none of these are reached.
+
+func checkBuildFeatures(cfg *Config) error {
+	for _, f := range []struct {
+		flag string
+		set  bool
+	}{
+		{"--grpc-address", cfg.GRPCAddress != ""},
+		{"--nats-url", cfg.NATSURL != ""},
+		{"--amqp-url", cfg.AMQPURL != ""},
+		{"--snmp-target", cfg.SNMPTarget != ""},
+		{"--delivery-url", cfg.DeliveryURL != ""},
+		{"--cdn-http3", cfg.CDNHTTP3},
+		{"--derived-metric", len(cfg.DerivedMetrics) > 0},
+	} {
+		if f.set {
+			return fmt.Errorf("%s is not available in this minimal build", f.flag)
+		}
+	}
+	return nil
+}
+
+var errNotInBuild = fmt.Errorf("not available in this minimal build")
+
+type grpcServer struct {
+	run      func(ctx context.Context) (*RunRecord, error)
+	progress *progressHub
+	audit    *auditLog
+	auth     *apiAuth
+	limits   *triggerLimiter
+}
+
+func serveGRPC(ctx context.Context, addr string, impl *grpcServer) error {
+	return errNotInBuild
+}
+
+type NATSConfig struct {
+	URL       string
+	Subject   string
+	CredsFile string
+	CAFile    string
+	JetStream bool
+}
+
+func publishNATS(cfg NATSConfig, hostname string, record RunRecord, ts time.Time) error {
+	return errNotInBuild
+}
+
+type AMQPConfig struct {
+	URL        string
+	Exchange   string
+	RoutingKey string
+	CAFile     string
+	Confirm    bool
+}
+
+func publishAMQP(cfg AMQPConfig, hostname string, record RunRecord, ts time.Time) error {
+	return errNotInBuild
+}
+
+type SNMPConfig struct {
+	Target       string
+	Version      string
+	Community    string
+	User         string
+	AuthProtocol string
+	AuthPass     string
+	PrivProtocol string
+	PrivPass     string
+	TrapOn       string
+}
+
+func validateSNMPConfig(cfg SNMPConfig) error {
+	return errNotInBuild
+}
+
+func sendSNMPTrap(cfg SNMPConfig, hostname string, record RunRecord, checks []thresholdCheck) error {
+	return errNotInBuild
+}
+
+func deliverSFTP(cfg DeliveryConfig, u *url.URL, target string, data []byte) error {
+	return errNotInBuild
+}
+
+func deliverFTPS(cfg DeliveryConfig, u *url.URL, target string, data []byte) error {
+	return errNotInBuild
+}
+
+func newCDNHTTP3Client() *http.Client {
+	return nil
+}
+
+// Without client_golang, --listen-address serves only the health, run API
+// and result ring endpoints.
+func newMetricsMux(cache *resultCache, probe probeFunc, localJSON string) *http.ServeMux {
+	log.Println("Not serving /metrics or /probe, they are not available in this minimal build")
+	return http.NewServeMux()
+}
+
+type exprProgram struct{}
+
+func compileExpr(source string, asBool bool) (*exprProgram, error) {
+	return nil, fmt.Errorf("expressions are %v", errNotInBuild)
+}
+
+func runExpr(program *exprProgram, env derivedEnv) (any, error) {
+	return nil, errNotInBuild
+}
@@ -0,0 +1,5 @@
+//go:build !minimal
+
+package main
+
+func checkBuildFeatures(cfg *Config) error { return nil }
@@ -0,0 +1,36 @@
+//go:build minimal
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCheckBuildFeatures(t *testing.T) {
+	if err := checkBuildFeatures(&Config{URL: "https://example.com/push"}); err != nil {
+		t.Errorf("Expected remote write to be available, got %v", err)
+	}
+	err := checkBuildFeatures(&Config{NATSURL: "nats://localhost:4222"})
+	if err == nil || !strings.Contains(err.Error(), "--nats-url") {
+		t.Errorf("Expected --nats-url to be rejected, got %v", err)
+	}
+	err = checkBuildFeatures(&Config{DerivedMetrics: stringList{"ratio=upload / download"}})
+	if err == nil || !strings.Contains(err.Error(), "--derived-metric") {
+		t.Errorf("Expected --derived-metric to be rejected, got %v", err)
+	}
+}
+
+func TestMinimal_NoMetricsEndpoint(t *testing.T) {
+	mux := newMetricsMux(&resultCache{}, nil, "")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected no /metrics in a minimal build, got %d", rec.Code)
+	}
+	if _, err := compileAssertions([]string{"download > 1"}); err == nil {
+		t.Error("Expected assertions to be unavailable in a minimal build")
+	}
+}
@@ -0,0 +1,87 @@
+//go:build !minimal
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+type NATSConfig struct {
+	URL       string
+	Subject   string
+	CredsFile string
+	CAFile    string
+	JetStream bool
+}
+
+// Result message published to message buses; the run record plus the
+// identity fields a subscriber needs without parsing the subject/key.
+type resultMessage struct {
+	Instance  string    `json:"instance"`
+	Timestamp time.Time `json:"timestamp"`
+	RunRecord
+}
+
+// Expands {host} in the subject pattern. NATS subject tokens can't contain
+// dots or whitespace, so those are replaced in the hostname.
+func natsSubject(pattern, hostname string) string {
+	token := strings.NewReplacer(".", "_", " ", "_", "*", "_", ">", "_").Replace(hostname)
+	return strings.ReplaceAll(pattern, "{host}", token)
+}
+
+func publishNATS(cfg NATSConfig, hostname string, record RunRecord, ts time.Time) error {
+	data, err := json.Marshal(resultMessage{Instance: hostname, Timestamp: ts, RunRecord: record})
+	if err != nil {
+		return fmt.Errorf("failed to marshal NATS message: %v", err)
+	}
+
+	opts := []nats.Option{nats.Name("librespeed_exporter " + hostname), nats.Timeout(10 * time.Second)}
+	if cfg.CredsFile != "" {
+		opts = append(opts, nats.UserCredentials(cfg.CredsFile))
+	}
+	if cfg.CAFile != "" {
+		opts = append(opts, nats.RootCAs(cfg.CAFile))
+	}
+
+	nc, err := nats.Connect(cfg.URL, opts...)
+	if err != nil {
+		return fmt.Errorf("failed to connect to NATS: %v", err)
+	}
+	defer nc.Close()
+
+	subject := natsSubject(cfg.Subject, hostname)
+	if !cfg.JetStream {
+		if err := nc.Publish(subject, data); err != nil {
+			return fmt.Errorf("failed to publish to NATS: %v", err)
+		}
+		if err := nc.FlushTimeout(10 * time.Second); err != nil {
+			return fmt.Errorf("failed to flush NATS connection: %v", err)
+		}
+		log.Printf("Run record published to NATS subject %s", subject)
+		return nil
+	}
+
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return fmt.Errorf("failed to create JetStream context: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Waiting for the ack gives at-least-once delivery: the stream has
+	// persisted the message before we report success.
+	ack, err := js.Publish(ctx, subject, data)
+	if err != nil {
+		return fmt.Errorf("failed to publish to JetStream: %v", err)
+	}
+	log.Printf("Run record published to JetStream stream %s (subject %s, seq %d)", ack.Stream, subject, ack.Sequence)
+	return nil
+}
@@ -0,0 +1,46 @@
+//go:build !minimal
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNATSSubject(t *testing.T) {
+	testCases := []struct {
+		pattern, hostname, expected string
+	}{
+		{"librespeed.results.{host}", "branch-01", "librespeed.results.branch-01"},
+		{"librespeed.results.{host}", "host.corp.local", "librespeed.results.host_corp_local"},
+		{"site.results", "host1", "site.results"},
+	}
+	for _, tc := range testCases {
+		if got := natsSubject(tc.pattern, tc.hostname); got != tc.expected {
+			t.Errorf("natsSubject(%q, %q): expected %q, got %q", tc.pattern, tc.hostname, tc.expected, got)
+		}
+	}
+}
+
+func TestNATSResult_JSON(t *testing.T) {
+	record := newRunRecord("", &LibrespeedResult{Download: 100, Server: ServerInfo{URL: "http://server"}}, nil, time.Second)
+	data, err := json.Marshal(resultMessage{Instance: "host1", Timestamp: time.Unix(0, 0).UTC(), RunRecord: record})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	for _, field := range []string{`"instance":"host1"`, `"status":"success"`, `"download_mbps":100`, `"server":"http://server"`} {
+		if !strings.Contains(string(data), field) {
+			t.Errorf("Expected %s in %s", field, string(data))
+		}
+	}
+}
+
+func TestPublishNATS_ConnectionFailure(t *testing.T) {
+	record := newRunRecord("", &LibrespeedResult{}, nil, time.Second)
+	err := publishNATS(NATSConfig{URL: "nats://127.0.0.1:1", Subject: "test"}, "host1", record, time.Now())
+	if err == nil || !strings.Contains(err.Error(), "failed to connect") {
+		t.Errorf("Expected connection error, got %v", err)
+	}
+}
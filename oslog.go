@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Message types from <os/log.h>. Default messages are kept on disk; info
+// ones only in memory, so everything but errors is logged as default.
+const (
+	osLogTypeDefault = 0x00
+	osLogTypeError   = 0x10
+)
+
+// Subsystem the exporter logs under, for log show --predicate
+// 'subsystem == "io.librespeed.exporter"'.
+const osLogSubsystem = defaultLaunchdLabel
+
+func osLogType(line string) int {
+	if strings.Contains(line, "ERROR:") {
+		return osLogTypeError
+	}
+	return osLogTypeDefault
+}
+
+// io.Writer for the log package that sends each line to the macOS unified
+// log through emit.
+type osLogWriter struct {
+	emit func(logType int, msg string)
+}
+
+func (w *osLogWriter) Write(p []byte) (int, error) {
+	for _, line := range bytes.Split(bytes.TrimRight(p, "\n"), []byte("\n")) {
+		if len(line) > 0 {
+			w.emit(osLogType(string(line)), string(line))
+		}
+	}
+	return len(p), nil
+}
@@ -0,0 +1,34 @@
+//go:build darwin && cgo
+
+package main
+
+/*
+#include <os/log.h>
+#include <stdlib.h>
+
+static os_log_t librespeed_os_log_create(const char *subsystem) {
+	return os_log_create(subsystem, "exporter");
+}
+
+// os_log_with_type is a macro that needs a literal format string
+static void librespeed_os_log(os_log_t log, uint8_t type, const char *msg) {
+	os_log_with_type(log, (os_log_type_t)type, "%{public}s", msg);
+}
+*/
+import "C"
+
+import (
+	"io"
+	"unsafe"
+)
+
+func newOSLogWriter() (io.Writer, error) {
+	subsystem := C.CString(osLogSubsystem)
+	defer C.free(unsafe.Pointer(subsystem))
+	handle := C.librespeed_os_log_create(subsystem)
+	return &osLogWriter{emit: func(logType int, msg string) {
+		cmsg := C.CString(msg)
+		defer C.free(unsafe.Pointer(cmsg))
+		C.librespeed_os_log(handle, C.uint8_t(logType), cmsg)
+	}}, nil
+}
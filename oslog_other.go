@@ -0,0 +1,16 @@
+//go:build !darwin || !cgo
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+)
+
+func newOSLogWriter() (io.Writer, error) {
+	if runtime.GOOS == "darwin" {
+		return nil, fmt.Errorf("--os-log needs a build with cgo enabled")
+	}
+	return nil, fmt.Errorf("--os-log requires macOS")
+}
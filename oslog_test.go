@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+)
+
+func TestOSLogWriter(t *testing.T) {
+	var got []string
+	w := &osLogWriter{emit: func(logType int, msg string) {
+		got = append(got, fmt.Sprintf("%#x %s", logType, msg))
+	}}
+	fmt.Fprint(w, "2024/03/01 12:00:00 Starting\n")
+	fmt.Fprint(w, "ERROR: Failed to run librespeed test: timeout\nsecond line\n")
+
+	want := []string{
+		"0x0 2024/03/01 12:00:00 Starting",
+		"0x10 ERROR: Failed to run librespeed test: timeout",
+		"0x0 second line",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestNewOSLogWriter_OtherPlatforms(t *testing.T) {
+	if runtime.GOOS == "darwin" {
+		t.Skip("os_log is available on macOS")
+	}
+	if _, err := newOSLogWriter(); err == nil {
+		t.Error("Expected error outside macOS, got nil")
+	}
+}
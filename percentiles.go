@@ -0,0 +1,80 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// Windows summarised by the daemon; the history file keeps the longest one
+// even beyond --history-size (historyRetention).
+var rollingWindows = []struct {
+	Label    string
+	Duration time.Duration
+}{
+	{"1h", time.Hour},
+	{"24h", 24 * time.Hour},
+}
+
+var rollingQuantiles = []float64{0.5, 0.95}
+
+// Nearest-rank percentile: always one of the observed values, so a p95 over
+// a handful of runs reports a real result instead of an interpolation.
+func percentile(values []float64, q float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	rank := int(math.Ceil(q * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// Builds librespeed_<metric>_rolling{quantile,window} series from the history
+// entries newer than each window, so receivers that downsample the raw
+// points still see the distribution.
+func rollingPercentileSeries(history []HistoryEntry, now time.Time, serverURL, instance string) []*prompb.TimeSeries {
+	var series []*prompb.TimeSeries
+	for _, window := range rollingWindows {
+		var download, upload, ping []float64
+		for _, entry := range history {
+			if !entry.Timestamp.After(now.Add(-window.Duration)) || entry.Timestamp.After(now) {
+				continue
+			}
+			download = append(download, entry.Download)
+			upload = append(upload, entry.Upload)
+			ping = append(ping, entry.Ping)
+		}
+		if len(download) == 0 {
+			continue
+		}
+		for _, metric := range []struct {
+			name   string
+			values []float64
+		}{
+			{"librespeed_download_mbps_rolling", download},
+			{"librespeed_upload_mbps_rolling", upload},
+			{"librespeed_ping_ms_rolling", ping},
+		} {
+			for _, q := range rollingQuantiles {
+				ts := createTimeSeries(metric.name, percentile(metric.values, q), now.UnixMilli(), serverURL, instance)
+				addLabels([]*prompb.TimeSeries{ts}, map[string]string{
+					"quantile": formatQuantile(q),
+					"window":   window.Label,
+				})
+				series = append(series, ts)
+			}
+		}
+	}
+	return series
+}
+
+func formatQuantile(q float64) string {
+	return strconv.FormatFloat(q, 'f', -1, 64)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	values := []float64{50, 10, 40, 20, 30, 60, 70, 80, 90, 100}
+	if got := percentile(values, 0.5); got != 50 {
+		t.Errorf("Expected p50 of 50, got %v", got)
+	}
+	if got := percentile(values, 0.95); got != 100 {
+		t.Errorf("Expected p95 of 100, got %v", got)
+	}
+	if got := percentile([]float64{42}, 0.95); got != 42 {
+		t.Errorf("Expected single value for p95, got %v", got)
+	}
+	if got := percentile(nil, 0.5); got != 0 {
+		t.Errorf("Expected 0 for no values, got %v", got)
+	}
+}
+
+func TestRollingPercentileSeries(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	history := []HistoryEntry{
+		{Timestamp: now.Add(-30 * time.Hour), Download: 1, Upload: 1, Ping: 1},
+		{Timestamp: now.Add(-10 * time.Hour), Download: 10, Upload: 5, Ping: 40},
+		{Timestamp: now.Add(-40 * time.Minute), Download: 90, Upload: 20, Ping: 12},
+		{Timestamp: now, Download: 100, Upload: 30, Ping: 10},
+	}
+
+	series := rollingPercentileSeries(history, now, "http://server", "host1")
+	if len(series) != 12 {
+		t.Fatalf("Expected 12 series (2 windows x 3 metrics x 2 quantiles), got %d", len(series))
+	}
+
+	values := map[string]float64{}
+	for _, ts := range series {
+		key := getLabelValue(ts.Labels, "__name__") + "/" + getLabelValue(ts.Labels, "window") + "/" + getLabelValue(ts.Labels, "quantile")
+		values[key] = ts.Samples[0].Value
+		if getLabelValue(ts.Labels, "server_url") != "http://server" {
+			t.Errorf("Expected server_url label on %s", key)
+		}
+	}
+
+	expected := map[string]float64{
+		"librespeed_download_mbps_rolling/1h/0.5":   90,
+		"librespeed_download_mbps_rolling/1h/0.95":  100,
+		"librespeed_download_mbps_rolling/24h/0.5":  90,
+		"librespeed_download_mbps_rolling/24h/0.95": 100,
+		"librespeed_ping_ms_rolling/24h/0.95":       40,
+		"librespeed_upload_mbps_rolling/24h/0.5":    20,
+	}
+	for key, want := range expected {
+		if got, ok := values[key]; !ok || got != want {
+			t.Errorf("Expected %s = %v, got %v (present: %v)", key, want, got, ok)
+		}
+	}
+}
+
+func TestRollingPercentileSeries_EmptyHistory(t *testing.T) {
+	if series := rollingPercentileSeries(nil, time.Now(), "", "host1"); len(series) != 0 {
+		t.Errorf("Expected no series without history, got %d", len(series))
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// IPv4 and ICMP headers on top of the ping payload.
+const pingOverhead = 28
+
+// Smallest MTU every IPv4 path must carry (RFC 791).
+const minPathMTU = 576
+
+// Arguments for one IPv4 ping with the Don't Fragment bit set, per the
+// platform's ping.
+func dfPingArgs(host string, size int) []string {
+	payload := strconv.Itoa(size - pingOverhead)
+	switch runtime.GOOS {
+	case "windows":
+		return []string{"-4", "-n", "1", "-w", "2000", "-f", "-l", payload, host}
+	case "linux":
+		return []string{"-4", "-c", "1", "-W", "2", "-M", "do", "-s", payload, host}
+	default:
+		// macOS and the BSDs
+		return []string{"-c", "1", "-t", "2", "-D", "-s", payload, host}
+	}
+}
+
+// Windows ping exits 0 for some ICMP errors, so a reply is only counted when
+// the output shows one.
+func dfPing(runner CommandRunner, host string, size int) bool {
+	output, err := runner.Run("ping", dfPingArgs(host, size)...)
+	return err == nil && strings.Contains(strings.ToLower(string(output)), "ttl=")
+}
+
+// Finds the largest packet up to maxMTU that reaches host unfragmented, by
+// binary search over DF-set pings. A path that drops the ICMP "fragmentation
+// needed" replies shows up here as the MTU that actually gets through.
+func probePathMTU(runner CommandRunner, host string, maxMTU int) (int, error) {
+	if dfPing(runner, host, maxMTU) {
+		return maxMTU, nil
+	}
+	if !dfPing(runner, host, minPathMTU) {
+		return 0, fmt.Errorf("%s does not answer ping, cannot probe the path MTU", host)
+	}
+	lo, hi := minPathMTU, maxMTU
+	for hi-lo > 1 {
+		mid := (lo + hi) / 2
+		if dfPing(runner, host, mid) {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return lo, nil
+}
+
+// Host part of the test server URL, the target of the MTU probe.
+func serverHost(serverURL string) (string, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil || u.Hostname() == "" {
+		return "", fmt.Errorf("cannot get a host from server URL %q", serverURL)
+	}
+	return u.Hostname(), nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+)
+
+// Answers DF pings up to mtu bytes, like a path with that MTU.
+type pathMTURunner struct {
+	mtu   int
+	calls int
+}
+
+func (r *pathMTURunner) Run(name string, args ...string) ([]byte, error) {
+	r.calls++
+	for i, arg := range args[:len(args)-1] {
+		if arg == "-s" || arg == "-l" {
+			payload, _ := strconv.Atoi(args[i+1])
+			if payload+pingOverhead <= r.mtu {
+				return []byte("64 bytes from 192.0.2.1: icmp_seq=1 ttl=57 time=4.2 ms"), nil
+			}
+		}
+	}
+	return []byte("ping: local error: message too long"), errors.New("exit status 1")
+}
+
+func TestProbePathMTU(t *testing.T) {
+	for _, mtu := range []int{1500, 1492, 1400, 1280, 577} {
+		runner := &pathMTURunner{mtu: mtu}
+		got, err := probePathMTU(runner, "speedtest.example.com", 1500)
+		if err != nil {
+			t.Fatalf("MTU %d: expected no error, got %v", mtu, err)
+		}
+		if got != mtu {
+			t.Errorf("Expected path MTU %d, got %d", mtu, got)
+		}
+		if runner.calls > 12 {
+			t.Errorf("MTU %d: expected a binary search, got %d pings", mtu, runner.calls)
+		}
+	}
+}
+
+func TestProbePathMTU_NoReply(t *testing.T) {
+	if _, err := probePathMTU(&pathMTURunner{mtu: 0}, "speedtest.example.com", 1500); err == nil {
+		t.Error("Expected error when the host does not answer ping")
+	}
+}
+
+func TestDfPing_RequiresReply(t *testing.T) {
+	// Windows ping can exit 0 on ICMP errors
+	runner := &MockRunner{Output: []byte("Reply from 192.0.2.1: Destination host unreachable.")}
+	if dfPing(runner, "192.0.2.1", 1500) {
+		t.Error("Expected no reply to be counted without a TTL")
+	}
+}
+
+func TestServerHost(t *testing.T) {
+	host, err := serverHost("https://speedtest.example.com:8080/backend/")
+	if err != nil || host != "speedtest.example.com" {
+		t.Errorf("Expected speedtest.example.com, got %q, %v", host, err)
+	}
+	if _, err := serverHost("not a url"); err == nil {
+		t.Error("Expected error for a URL without host")
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Server list librespeed-cli fetches when --local-json is not given.
+const defaultServerListURL = "https://librespeed.org/backend-servers/servers.php"
+
+const preflightTimeout = 5 * time.Second
+
+type preflightTarget struct {
+	Kind   string // server or remote_write
+	URL    string
+	client *http.Client
+}
+
+// URLs of the selected servers in a librespeed server list. Protocol-relative
+// entries ("//host/") are tried over HTTPS.
+func serverURLs(path string, ids []int) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read server list: %v", err)
+	}
+	var servers []struct {
+		ID     json.RawMessage `json:"id"`
+		Server string          `json:"server"`
+	}
+	if err := json.Unmarshal(data, &servers); err != nil {
+		return nil, fmt.Errorf("failed to parse server list: %v", err)
+	}
+	byID := map[int]string{}
+	for _, server := range servers {
+		if id, err := strconv.Atoi(strings.Trim(string(server.ID), `"`)); err == nil {
+			byID[id] = server.Server
+		}
+	}
+	var urls []string
+	for _, id := range ids {
+		url, ok := byID[id]
+		if !ok {
+			return nil, fmt.Errorf("server %d is not in %s", id, path)
+		}
+		if strings.HasPrefix(url, "//") {
+			url = "https:" + url
+		}
+		urls = append(urls, url)
+	}
+	return urls, nil
+}
+
+// What the preflight check contacts: the selected servers (or, without
+// --local-json, the public server list librespeed-cli would fetch) and the
+// remote_write endpoint, each through the client that will be used for it.
+func preflightTargets(cfg *Config, ids []int, serverClient, rwClient *http.Client) ([]preflightTarget, error) {
+	var targets []preflightTarget
+	if cfg.LocalJSONPath != "" {
+		urls, err := serverURLs(cfg.LocalJSONPath, ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, url := range urls {
+			targets = append(targets, preflightTarget{Kind: "server", URL: url, client: serverClient})
+		}
+	} else {
+		targets = append(targets, preflightTarget{Kind: "server", URL: defaultServerListURL, client: serverClient})
+	}
+	if cfg.URL != "" && !cfg.DryRun {
+		targets = append(targets, preflightTarget{Kind: "remote_write", URL: cfg.URL, client: rwClient})
+	}
+	return targets, nil
+}
+
+// Sends a HEAD request to each target. Any HTTP response counts as
+// reachable, since endpoints like remote_write answer HEAD with 405; only
+// DNS, connection and TLS failures fail the check. Returns the first
+// unreachable target.
+func runPreflight(ctx context.Context, targets []preflightTarget) (*preflightTarget, error) {
+	for i, target := range targets {
+		reqCtx, cancel := context.WithTimeout(ctx, preflightTimeout)
+		req, err := http.NewRequestWithContext(reqCtx, http.MethodHead, target.URL, nil)
+		if err == nil {
+			var resp *http.Response
+			resp, err = target.client.Do(req)
+			if err == nil {
+				resp.Body.Close()
+			}
+		}
+		cancel()
+		if err != nil {
+			return &targets[i], fmt.Errorf("preflight check of %s %s failed: %v", target.Kind, target.URL, err)
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestServerURLs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "servers.json")
+	os.WriteFile(path, []byte(`[{"id":1,"server":"http://a.example/"},{"id":"2","server":"//b.example/"}]`), 0644)
+
+	urls, err := serverURLs(path, []int{2, 1})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if fmt.Sprint(urls) != "[https://b.example/ http://a.example/]" {
+		t.Errorf("Unexpected URLs: %v", urls)
+	}
+	if _, err := serverURLs(path, []int{3}); err == nil {
+		t.Error("Expected error for a server missing from the list, got nil")
+	}
+}
+
+func TestPreflightTargets(t *testing.T) {
+	cfg := &Config{URL: "http://prom/write"}
+	targets, err := preflightTargets(cfg, []int{1}, http.DefaultClient, http.DefaultClient)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(targets) != 2 || targets[0].URL != defaultServerListURL || targets[1].Kind != "remote_write" {
+		t.Errorf("Unexpected targets: %+v", targets)
+	}
+
+	cfg.DryRun = true
+	targets, _ = preflightTargets(cfg, []int{1}, http.DefaultClient, http.DefaultClient)
+	if len(targets) != 1 {
+		t.Errorf("Expected no remote_write target in a dry run, got %+v", targets)
+	}
+}
+
+func TestRunPreflight(t *testing.T) {
+	reachable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}))
+	defer reachable.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	targets := []preflightTarget{
+		{Kind: "server", URL: reachable.URL, client: http.DefaultClient},
+		{Kind: "remote_write", URL: reachable.URL, client: http.DefaultClient},
+	}
+	if _, err := runPreflight(context.Background(), targets); err != nil {
+		t.Errorf("Expected any HTTP response to pass, got %v", err)
+	}
+
+	targets[1].URL = closed.URL
+	failed, err := runPreflight(context.Background(), targets)
+	if err == nil || failed == nil || failed.Kind != "remote_write" {
+		t.Errorf("Expected remote_write to fail, got %v (%+v)", err, failed)
+	}
+}
+
+func TestRun_PreflightFailureSkipsTest(t *testing.T) {
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	path := filepath.Join(t.TempDir(), "servers.json")
+	os.WriteFile(path, []byte(fmt.Sprintf(`[{"id":1,"server":%q}]`, closed.URL)), 0644)
+
+	runner := &MockRunner{Output: []byte(`[{"download":100.0,"upload":50.0,"ping":10.0,"jitter":1.0,"server":{"url":"http://example.com"}}]`)}
+	rc := newTestRunContext(t, "", runner)
+	rc.cfg.URL = ""
+	rc.cfg.Preflight = true
+	rc.cfg.LocalJSONPath = path
+	rc.cache = &resultCache{}
+
+	if err := rc.runOnce(context.Background()); err == nil {
+		t.Error("Expected the run to fail preflight, got nil")
+	}
+	if runner.Calls != 0 {
+		t.Errorf("Expected librespeed-cli not to run, got %d calls", runner.Calls)
+	}
+	if len(rc.cache.series) != 2 || rc.cache.series[0].Labels[0].Value != "librespeed_preflight_failed" ||
+		rc.cache.series[1].Labels[0].Value != "librespeed_exporter_consecutive_failures" || rc.cache.series[1].Samples[0].Value != 1 {
+		t.Errorf("Expected librespeed_preflight_failed and the failure count in the cache, got %v", rc.cache.series)
+	}
+	if failures, _ := rc.failureBackoff(); failures != 1 {
+		t.Errorf("Expected the preflight failure to count, got %d", failures)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// OpenWrt keeps /var in RAM, so the state and log stay off the flash. The
+// log also reaches logread, since procd collects the exporter's stdout.
+var procdLayout = rcdLayout{
+	Script:    "/etc/init.d/librespeed_exporter",
+	Config:    "/etc/librespeed_exporter.conf",
+	StateDir:  "/var/lib/librespeed_exporter",
+	LogFile:   "/var/log/librespeed_exporter.log",
+	Enable:    "/etc/init.d/librespeed_exporter enable && /etc/init.d/librespeed_exporter start",
+	Installer: "install-procd",
+}
+
+// OpenWrt init script that has procd supervise exe with --config-file and
+// send it SIGHUP on reload.
+func procdScript(exe, configPath string) string {
+	return fmt.Sprintf(`#!/bin/sh /etc/rc.common
+#
+# Written by librespeed_exporter install-procd
+
+USE_PROCD=1
+START=99
+STOP=10
+
+start_service() {
+	procd_open_instance
+	procd_set_param command %s --config-file %s
+	procd_set_param respawn
+	procd_set_param stdout 1
+	procd_set_param stderr 1
+	procd_close_instance
+}
+
+reload_service() {
+	procd_send_signal librespeed_exporter
+}
+`, shellQuote(exe), shellQuote(configPath))
+}
+
+// install-procd writes an OpenWrt init script and a --config-file holding
+// the exporter flags given after --.
+func runInstallProcd(args []string) int {
+	fs := flag.NewFlagSet("install-procd", flag.ContinueOnError)
+	scriptPath := fs.String("output", procdLayout.Script, "Write the init script here")
+	configPath := fs.String("config-output", procdLayout.Config, "Write the exporter configuration here")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if len(fs.Args()) == 0 {
+		fmt.Fprintln(os.Stderr, "ERROR: pass the exporter flags after --, e.g. install-procd -- --url https://... --interval 1h")
+		return 2
+	}
+	config, err := rcdConfig(fs.Args(), procdLayout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: invalid exporter flags: %v\n", err)
+		return 2
+	}
+
+	exe, err := os.Executable()
+	if err == nil {
+		exe, err = filepath.EvalSymlinks(exe)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: failed to find own executable: %v\n", err)
+		return 1
+	}
+
+	for _, file := range []struct {
+		path string
+		data []byte
+		mode os.FileMode
+	}{
+		// The configuration may contain passwords
+		{*configPath, config, 0600},
+		{*scriptPath, []byte(procdScript(exe, *configPath)), 0755},
+	} {
+		if err := os.MkdirAll(filepath.Dir(file.path), 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: failed to create %s: %v\n", filepath.Dir(file.path), err)
+			return 1
+		}
+		if err := os.WriteFile(file.path, file.data, file.mode); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: failed to write %s: %v\n", file.path, err)
+			return 1
+		}
+		fmt.Printf("Wrote %s\n", file.path)
+	}
+	fmt.Printf("Start it with: %s\n", procdLayout.Enable)
+	return 0
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProcdScript(t *testing.T) {
+	script := procdScript("/usr/bin/librespeed_exporter", "/etc/librespeed_exporter.conf")
+	for _, want := range []string{
+		"#!/bin/sh /etc/rc.common",
+		"USE_PROCD=1",
+		"procd_set_param command '/usr/bin/librespeed_exporter' --config-file '/etc/librespeed_exporter.conf'",
+		"procd_set_param respawn",
+		"procd_send_signal librespeed_exporter",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("Expected %s in script:\n%s", want, script)
+		}
+	}
+}
+
+func TestRunInstallProcd(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "init.d", "librespeed_exporter")
+	conf := filepath.Join(dir, "librespeed_exporter.conf")
+	code := runInstallProcd([]string{"--output", script, "--config-output", conf, "--", "--url", "https://example.com/push", "--interval", "1h"})
+	if code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+	data, err := os.ReadFile(conf)
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err)
+	}
+	for _, want := range []string{"# Written by librespeed_exporter install-procd", "state-dir=/var/lib/librespeed_exporter", "interval=1h0m0s"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("Expected %s in config:\n%s", want, data)
+		}
+	}
+	if info, err := os.Stat(script); err != nil || info.Mode().Perm() != 0755 {
+		t.Errorf("Expected executable script, got %v, %v", info, err)
+	}
+
+	if code := runInstallProcd([]string{"--output", script}); code != 2 {
+		t.Errorf("Expected exit code 2 without exporter flags, got %d", code)
+	}
+}
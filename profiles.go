@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Test settings used instead of the flags during the profile's local-time
+// windows, e.g. short tests off-peak and full ones in the evening peak.
+// Zero or nil fields keep the flag value.
+type testProfile struct {
+	Name      string
+	Windows   QuietHours
+	Options   TestOptions
+	ServerIDs []int
+}
+
+// Parses name=HH:MM-HH:MM[,...];key=value;... where key is chunks,
+// upload-size, concurrent or server-id.
+func parseTestProfile(spec string) (testProfile, error) {
+	fields := strings.Split(spec, ";")
+	name, windows, ok := strings.Cut(fields[0], "=")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return testProfile{}, fmt.Errorf("invalid test profile %q (expected name=HH:MM-HH:MM;key=value;...)", spec)
+	}
+	profile := testProfile{Name: name}
+	var err error
+	profile.Windows, err = parseQuietHours(windows)
+	if err != nil {
+		return testProfile{}, fmt.Errorf("test profile %s: %v", name, err)
+	}
+	if len(profile.Windows) == 0 {
+		return testProfile{}, fmt.Errorf("test profile %s has no time windows", name)
+	}
+	for _, field := range fields[1:] {
+		key, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			return testProfile{}, fmt.Errorf("test profile %s: invalid setting %q (expected key=value)", name, field)
+		}
+		if key == "server-id" {
+			var ids serverIDList
+			if err := ids.Set(value); err != nil {
+				return testProfile{}, fmt.Errorf("test profile %s: %v", name, err)
+			}
+			profile.ServerIDs = ids
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return testProfile{}, fmt.Errorf("test profile %s: %s must be a positive number, got %q", name, key, value)
+		}
+		switch key {
+		case "chunks":
+			profile.Options.Chunks = n
+		case "upload-size":
+			profile.Options.UploadSizeKiB = n
+		case "concurrent":
+			profile.Options.Concurrent = n
+		default:
+			return testProfile{}, fmt.Errorf("test profile %s: unknown setting %q (expected chunks, upload-size, concurrent or server-id)", name, key)
+		}
+	}
+	return profile, nil
+}
+
+func parseTestProfiles(specs []string) ([]testProfile, error) {
+	var profiles []testProfile
+	seen := map[string]bool{}
+	for _, spec := range specs {
+		profile, err := parseTestProfile(spec)
+		if err != nil {
+			return nil, err
+		}
+		if seen[profile.Name] {
+			return nil, fmt.Errorf("duplicate test profile %s", profile.Name)
+		}
+		seen[profile.Name] = true
+		profiles = append(profiles, profile)
+	}
+	return profiles, nil
+}
+
+// The first profile with a window containing t, or nil.
+func activeProfile(profiles []testProfile, t time.Time) *testProfile {
+	for i := range profiles {
+		if profiles[i].Windows.contains(t) {
+			return &profiles[i]
+		}
+	}
+	return nil
+}
+
+// opts and serverIDs with the profile's settings in place of the flags'.
+func (p *testProfile) apply(opts TestOptions, serverIDs []int) (TestOptions, []int) {
+	if p.Options.Chunks > 0 {
+		opts.Chunks = p.Options.Chunks
+	}
+	if p.Options.UploadSizeKiB > 0 {
+		opts.UploadSizeKiB = p.Options.UploadSizeKiB
+	}
+	if p.Options.Concurrent > 0 {
+		opts.Concurrent = p.Options.Concurrent
+	}
+	if p.ServerIDs != nil {
+		serverIDs = p.ServerIDs
+	}
+	return opts, serverIDs
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTestProfiles(t *testing.T) {
+	profiles, err := parseTestProfiles([]string{
+		"peak=17:00-23:00;chunks=100;concurrent=4",
+		"offpeak=23:00-07:00,12:00-13:00;chunks=10;upload-size=256;server-id=3,5",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(profiles) != 2 {
+		t.Fatalf("Expected 2 profiles, got %d", len(profiles))
+	}
+	peak, offpeak := profiles[0], profiles[1]
+	if peak.Name != "peak" || peak.Options != (TestOptions{Chunks: 100, Concurrent: 4}) || peak.ServerIDs != nil {
+		t.Errorf("Unexpected peak profile: %+v", peak)
+	}
+	if len(offpeak.Windows) != 2 || offpeak.Options != (TestOptions{Chunks: 10, UploadSizeKiB: 256}) || fmt.Sprint(offpeak.ServerIDs) != "[3 5]" {
+		t.Errorf("Unexpected offpeak profile: %+v", offpeak)
+	}
+
+	for _, specs := range [][]string{
+		{"peak"},
+		{"=17:00-23:00"},
+		{"peak=17:00"},
+		{"peak=;chunks=10"},
+		{"peak=17:00-23:00;chunks"},
+		{"peak=17:00-23:00;chunks=0"},
+		{"peak=17:00-23:00;duration=10"},
+		{"peak=17:00-23:00;server-id=x"},
+		{"peak=17:00-23:00", "peak=08:00-09:00"},
+	} {
+		if _, err := parseTestProfiles(specs); err == nil {
+			t.Errorf("Expected error for %q, got nil", specs)
+		}
+	}
+}
+
+func TestActiveProfile(t *testing.T) {
+	profiles, _ := parseTestProfiles([]string{"peak=17:00-23:00;chunks=100", "evening=20:00-22:00;chunks=50"})
+	at := func(hour int) *testProfile {
+		return activeProfile(profiles, time.Date(2024, 5, 1, hour, 0, 0, 0, time.Local))
+	}
+	if p := at(21); p == nil || p.Name != "peak" {
+		t.Errorf("Expected the first matching profile, got %v", p)
+	}
+	if p := at(10); p != nil {
+		t.Errorf("Expected no profile outside the windows, got %v", p)
+	}
+
+	opts, ids := profiles[0].apply(TestOptions{Chunks: 20, Concurrent: 2}, []int{1})
+	if opts != (TestOptions{Chunks: 100, Concurrent: 2}) || fmt.Sprint(ids) != "[1]" {
+		t.Errorf("Expected only chunks to change, got %+v %v", opts, ids)
+	}
+}
+
+func TestRun_UsesTestProfile(t *testing.T) {
+	runner := &MockRunner{Output: []byte(`[{"download":100.0,"upload":50.0,"ping":10.0,"jitter":1.0,"server":{"url":"http://example.com"}}]`)}
+	rc := newTestRunContext(t, "", runner)
+	rc.cfg.URL = ""
+	rc.cfg.Chunks = 20
+	rc.cache = &resultCache{}
+	rc.profiles, _ = parseTestProfiles([]string{"peak=17:00-23:00;chunks=100"})
+	fake := useFakeClock(t, time.Date(2024, 5, 1, 18, 0, 0, 0, time.Local))
+
+	if err := rc.runOnce(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := runner.LastArgs(); !strings.Contains(got, "--chunks 100") {
+		t.Errorf("Expected the peak profile's chunks, got %s", got)
+	}
+	var active float64 = -1
+	for _, ts := range rc.cache.series {
+		if ts.Labels[0].Value == "librespeed_test_profile" {
+			active = ts.Samples[0].Value
+		}
+	}
+	if active != 1 {
+		t.Errorf("Expected librespeed_test_profile{profile=\"peak\"} 1, got %v", active)
+	}
+
+	fake.Advance(12 * time.Hour)
+	if err := rc.runOnce(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := runner.LastArgs(); !strings.Contains(got, "--chunks 20") {
+		t.Errorf("Expected the flag's chunks outside the profile, got %s", got)
+	}
+}
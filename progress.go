@@ -0,0 +1,75 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Stages a run goes through, in order. A run ends with succeeded, failed or
+// skipped. librespeed-cli reports nothing while it measures in --json mode,
+// so there is no finer progress within the testing stage.
+const (
+	stageStarted    = "started"
+	stageInstalling = "installing"
+	stageTesting    = "testing"
+	stagePushing    = "pushing"
+	stageSucceeded  = "succeeded"
+	stageFailed     = "failed"
+	stageSkipped    = "skipped"
+)
+
+type progressEvent struct {
+	Stage  string
+	Time   time.Time
+	Record *RunRecord
+}
+
+// Fans run progress out to API watchers and remembers the last result. A
+// nil hub ignores everything, so runs without an API don't need checks.
+type progressHub struct {
+	mu          sync.Mutex
+	subscribers map[chan progressEvent]struct{}
+	last        *RunRecord
+}
+
+func newProgressHub() *progressHub {
+	return &progressHub{subscribers: map[chan progressEvent]struct{}{}}
+}
+
+// Never blocks the run: a watcher that falls behind misses events.
+func (h *progressHub) publish(stage string, record *RunRecord) {
+	if h == nil {
+		return
+	}
+	event := progressEvent{Stage: stage, Time: clock.Now().UTC(), Record: record}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if record != nil {
+		h.last = record
+	}
+	for ch := range h.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// Returns a channel of events from now on and a function to stop them.
+func (h *progressHub) subscribe() (<-chan progressEvent, func()) {
+	ch := make(chan progressEvent, 16)
+	h.mu.Lock()
+	h.subscribers[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch, func() {
+		h.mu.Lock()
+		delete(h.subscribers, ch)
+		h.mu.Unlock()
+	}
+}
+
+func (h *progressHub) lastResult() *RunRecord {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.last
+}
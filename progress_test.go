@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProgressHub_NilIsNoop(t *testing.T) {
+	var hub *progressHub
+	hub.publish(stageStarted, nil)
+}
+
+func TestProgressHub_PublishAndLastResult(t *testing.T) {
+	useFakeClock(t, time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	hub := newProgressHub()
+	events, stop := hub.subscribe()
+	defer stop()
+
+	hub.publish(stageStarted, nil)
+	if hub.lastResult() != nil {
+		t.Error("Expected no last result before a run finishes")
+	}
+	record := RunRecord{Status: "success", Download: 95}
+	hub.publish(stageSucceeded, &record)
+
+	for _, want := range []string{stageStarted, stageSucceeded} {
+		event := <-events
+		if event.Stage != want {
+			t.Errorf("Expected stage %q, got %q", want, event.Stage)
+		}
+	}
+	if got := hub.lastResult(); got == nil || got.Download != 95 {
+		t.Errorf("Expected last result with download 95, got %+v", got)
+	}
+}
+
+func TestProgressHub_SlowSubscriberDoesNotBlock(t *testing.T) {
+	hub := newProgressHub()
+	_, stop := hub.subscribe()
+	defer stop()
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			hub.publish(stageTesting, nil)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publish blocked on a subscriber that never reads")
+	}
+}
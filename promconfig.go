@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// The subset of Prometheus' remote_write schema we can honour. Unknown keys
+// (queue_config, write_relabel_configs, ...) are ignored so existing configs
+// load unchanged.
+type promRemoteWrite struct {
+	URL       string            `yaml:"url"`
+	Name      string            `yaml:"name"`
+	Headers   map[string]string `yaml:"headers"`
+	BasicAuth *struct {
+		Username     string `yaml:"username"`
+		Password     string `yaml:"password"`
+		PasswordFile string `yaml:"password_file"`
+	} `yaml:"basic_auth"`
+	TLSConfig struct {
+		CAFile             string `yaml:"ca_file"`
+		CertFile           string `yaml:"cert_file"`
+		KeyFile            string `yaml:"key_file"`
+		ServerName         string `yaml:"server_name"`
+		InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+	} `yaml:"tls_config"`
+}
+
+// Extra headers from the YAML config, sent with every remote write request.
+var remoteWriteHeaders map[string]string
+
+// Accepts a whole prometheus.yml or just the remote_write list, and picks the
+// entry with the given name (or the first one when name is empty).
+func loadRemoteWriteConfig(path, name string) (*promRemoteWrite, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read remote write config: %v", err)
+	}
+
+	var entries []promRemoteWrite
+	var doc struct {
+		RemoteWrite []promRemoteWrite `yaml:"remote_write"`
+	}
+	if err := yaml.Unmarshal(data, &doc); err == nil && len(doc.RemoteWrite) > 0 {
+		entries = doc.RemoteWrite
+	} else if err := yaml.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse remote write config: %v", err)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no remote_write entries in %s", path)
+	}
+
+	for i := range entries {
+		if name == "" || entries[i].Name == name {
+			return &entries[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no remote_write entry named %q in %s", name, path)
+}
+
+// Fills URL and credentials from the YAML entry unless they were set by flag,
+// so a single value can still be overridden on the command line.
+func applyRemoteWriteConfig(cfg *Config, rw *promRemoteWrite) error {
+	if cfg.URL == "" {
+		cfg.URL = rw.URL
+	}
+	if rw.BasicAuth != nil {
+		if cfg.Username == "" {
+			cfg.Username = rw.BasicAuth.Username
+		}
+		if cfg.Password == "" {
+			cfg.Password = rw.BasicAuth.Password
+			if rw.BasicAuth.PasswordFile != "" {
+				secret, err := os.ReadFile(rw.BasicAuth.PasswordFile)
+				if err != nil {
+					return fmt.Errorf("failed to read password_file: %v", err)
+				}
+				cfg.Password = strings.TrimSpace(string(secret))
+			}
+		}
+	}
+	remoteWriteHeaders = rw.Headers
+	return nil
+}
+
+func applyRemoteWriteTLS(client *http.Client, rw *promRemoteWrite) error {
+	t := rw.TLSConfig
+	if t.CAFile == "" && t.CertFile == "" && t.ServerName == "" && !t.InsecureSkipVerify {
+		return nil
+	}
+	tlsCfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+	if t.CAFile != "" {
+		pool, err := loadCAPool(t.CAFile)
+		if err != nil {
+			return err
+		}
+		tlsCfg.RootCAs = pool
+	}
+	if t.CertFile != "" || t.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	clientTransport(client).TLSClientConfig = tlsCfg
+	return nil
+}
+
+func loadCAPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTempFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	return path
+}
+
+func TestLoadRemoteWriteConfig_FullPrometheusConfig(t *testing.T) {
+	path := writeTempFile(t, "prometheus.yml", `
+global:
+  scrape_interval: 15s
+remote_write:
+  - name: primary
+    url: https://primary.example.com/api/prom/push
+  - name: grafana
+    url: https://prometheus-prod.grafana.net/api/prom/push
+    basic_auth:
+      username: "123456"
+      password: secret
+    headers:
+      X-Scope-OrgID: team-a
+    queue_config:
+      max_samples_per_send: 1000
+`)
+	rw, err := loadRemoteWriteConfig(path, "grafana")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if rw.URL != "https://prometheus-prod.grafana.net/api/prom/push" {
+		t.Errorf("Unexpected URL %s", rw.URL)
+	}
+	if rw.BasicAuth == nil || rw.BasicAuth.Username != "123456" {
+		t.Errorf("Expected basic auth username, got %+v", rw.BasicAuth)
+	}
+	if rw.Headers["X-Scope-OrgID"] != "team-a" {
+		t.Errorf("Expected header, got %v", rw.Headers)
+	}
+
+	rw, err = loadRemoteWriteConfig(path, "")
+	if err != nil || rw.Name != "primary" {
+		t.Errorf("Expected first entry without a name, got %+v, %v", rw, err)
+	}
+
+	if _, err := loadRemoteWriteConfig(path, "missing"); err == nil {
+		t.Error("Expected error for unknown entry name, got nil")
+	}
+}
+
+func TestLoadRemoteWriteConfig_BareList(t *testing.T) {
+	path := writeTempFile(t, "rw.yml", `
+- url: https://example.com/push
+  tls_config:
+    insecure_skip_verify: true
+`)
+	rw, err := loadRemoteWriteConfig(path, "")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if rw.URL != "https://example.com/push" || !rw.TLSConfig.InsecureSkipVerify {
+		t.Errorf("Unexpected entry %+v", rw)
+	}
+}
+
+func TestApplyRemoteWriteConfig(t *testing.T) {
+	defer func() { remoteWriteHeaders = nil }()
+	secretFile := writeTempFile(t, "secret", "from-file\n")
+	rw := &promRemoteWrite{URL: "https://yaml.example.com/push", Headers: map[string]string{"X-Test": "1"}}
+	rw.BasicAuth = &struct {
+		Username     string `yaml:"username"`
+		Password     string `yaml:"password"`
+		PasswordFile string `yaml:"password_file"`
+	}{Username: "yaml-user", PasswordFile: secretFile}
+
+	cfg := &Config{URL: "https://flag.example.com/push"}
+	if err := applyRemoteWriteConfig(cfg, rw); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.URL != "https://flag.example.com/push" {
+		t.Errorf("Expected flag URL to win, got %s", cfg.URL)
+	}
+	if cfg.Username != "yaml-user" || cfg.Password != "from-file" {
+		t.Errorf("Expected credentials from YAML, got %s/%s", cfg.Username, cfg.Password)
+	}
+	if remoteWriteHeaders["X-Test"] != "1" {
+		t.Errorf("Expected headers to be applied, got %v", remoteWriteHeaders)
+	}
+}
+
+func TestApplyRemoteWriteTLS(t *testing.T) {
+	rw := &promRemoteWrite{}
+	rw.TLSConfig.ServerName = "metrics.internal"
+	client := &http.Client{}
+	if err := applyRemoteWriteTLS(client, rw); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	transport := client.Transport.(*http.Transport)
+	if transport.TLSClientConfig == nil || transport.TLSClientConfig.ServerName != "metrics.internal" {
+		t.Errorf("Expected TLS server name to be set, got %+v", transport.TLSClientConfig)
+	}
+
+	rw.TLSConfig.CAFile = filepath.Join(t.TempDir(), "missing.pem")
+	if err := applyRemoteWriteTLS(&http.Client{}, rw); err == nil {
+		t.Error("Expected error for missing CA file, got nil")
+	}
+}
+
+func TestLoadCAPool(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := loadCAPool(filepath.Join(dir, "missing.pem")); err == nil {
+		t.Error("Expected error for missing CA file, got nil")
+	}
+
+	bogus := filepath.Join(dir, "bogus.pem")
+	if err := os.WriteFile(bogus, []byte("not a certificate"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if _, err := loadCAPool(bogus); err == nil {
+		t.Error("Expected error for file without certificates, got nil")
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Switches the shared HTTP client to the OS proxy configuration. On Windows
+// this resolves PAC/WPAD through WinHTTP; elsewhere it is the usual
+// HTTP(S)_PROXY environment handling.
+func useSystemProxy(client *http.Client) {
+	clientTransport(client).Proxy = systemProxy()
+}
+
+// Returns the client's transport for customisation, installing a copy of
+// the default one first so settings from different flags accumulate.
+func clientTransport(client *http.Client) *http.Transport {
+	if transport, ok := client.Transport.(*http.Transport); ok && transport != nil {
+		return transport
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	client.Transport = transport
+	return transport
+}
+
+// Picks the proxy for scheme out of a WinHTTP proxy list, which is either a
+// plain "host:port" list or per-scheme entries like "http=host:port;https=...".
+// Only the first usable entry is returned.
+func parseProxyList(list, scheme string) (*url.URL, error) {
+	for _, entry := range strings.FieldsFunc(list, func(r rune) bool { return r == ';' || r == ' ' }) {
+		if name, addr, ok := strings.Cut(entry, "="); ok {
+			if !strings.EqualFold(name, scheme) {
+				continue
+			}
+			entry = addr
+		}
+		if entry == "" || strings.EqualFold(entry, "DIRECT") {
+			return nil, nil
+		}
+		if !strings.Contains(entry, "://") {
+			entry = "http://" + entry
+		}
+		proxyURL, err := url.Parse(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy %q: %v", entry, err)
+		}
+		return proxyURL, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,12 @@
+//go:build !windows
+
+package main
+
+import (
+	"net/http"
+	"net/url"
+)
+
+func systemProxy() func(*http.Request) (*url.URL, error) {
+	return http.ProxyFromEnvironment
+}
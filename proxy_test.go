@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestParseProxyList(t *testing.T) {
+	testCases := []struct {
+		list     string
+		scheme   string
+		expected string
+	}{
+		{"proxy.corp:8080", "https", "http://proxy.corp:8080"},
+		{"proxy1.corp:8080;proxy2.corp:8080", "https", "http://proxy1.corp:8080"},
+		{"http=web.corp:80;https=secure.corp:443", "https", "http://secure.corp:443"},
+		{"http=web.corp:80", "https", ""},
+		{"DIRECT", "https", ""},
+		{"", "https", ""},
+	}
+	for _, tc := range testCases {
+		got, err := parseProxyList(tc.list, tc.scheme)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", tc.list, err)
+			continue
+		}
+		gotStr := ""
+		if got != nil {
+			gotStr = got.String()
+		}
+		if gotStr != tc.expected {
+			t.Errorf("%q: expected %q, got %q", tc.list, tc.expected, gotStr)
+		}
+	}
+}
+
+func TestUseSystemProxy(t *testing.T) {
+	client := &http.Client{}
+	useSystemProxy(client)
+	transport, ok := client.Transport.(*http.Transport)
+	if !ok || transport.Proxy == nil {
+		t.Error("Expected a transport with a proxy function")
+	}
+}
@@ -0,0 +1,129 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	winhttp                                   = windows.NewLazySystemDLL("winhttp.dll")
+	procWinHttpOpen                           = winhttp.NewProc("WinHttpOpen")
+	procWinHttpCloseHandle                    = winhttp.NewProc("WinHttpCloseHandle")
+	procWinHttpGetProxyForUrl                 = winhttp.NewProc("WinHttpGetProxyForUrl")
+	procWinHttpGetIEProxyConfigForCurrentUser = winhttp.NewProc("WinHttpGetIEProxyConfigForCurrentUser")
+	procGlobalFree                            = windows.NewLazySystemDLL("kernel32.dll").NewProc("GlobalFree")
+)
+
+const (
+	winhttpAccessTypeNoProxy   = 1
+	winhttpAutoproxyAutoDetect = 0x1
+	winhttpAutoproxyConfigURL  = 0x2
+	winhttpAutoDetectTypeDHCP  = 0x1
+	winhttpAutoDetectTypeDNSA  = 0x2
+)
+
+type winhttpIEProxyConfig struct {
+	AutoDetect    int32
+	AutoConfigURL *uint16
+	Proxy         *uint16
+	ProxyBypass   *uint16
+}
+
+type winhttpAutoproxyOptions struct {
+	Flags                 uint32
+	AutoDetectFlags       uint32
+	AutoConfigURL         *uint16
+	Reserved              uintptr
+	ReservedDword         uint32
+	AutoLogonIfChallenged int32
+}
+
+type winhttpProxyInfo struct {
+	AccessType  uint32
+	Proxy       *uint16
+	ProxyBypass *uint16
+}
+
+func globalFree(p *uint16) {
+	if p != nil {
+		procGlobalFree.Call(uintptr(unsafe.Pointer(p)))
+	}
+}
+
+// Resolves the proxy per request the way WinHTTP-based clients (and
+// therefore most corporate tooling) do: the user's IE/Edge settings, then
+// WPAD or the configured PAC script. Falls back to the environment when
+// WinHTTP has nothing to offer.
+func systemProxy() func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		proxyURL, err := winhttpProxyForURL(req.URL)
+		if err != nil {
+			log.Printf("WARNING: WinHTTP proxy resolution failed, using environment: %v", err)
+			return http.ProxyFromEnvironment(req)
+		}
+		if proxyURL == nil {
+			return http.ProxyFromEnvironment(req)
+		}
+		return proxyURL, nil
+	}
+}
+
+func winhttpProxyForURL(target *url.URL) (*url.URL, error) {
+	var ieConfig winhttpIEProxyConfig
+	r, _, err := procWinHttpGetIEProxyConfigForCurrentUser.Call(uintptr(unsafe.Pointer(&ieConfig)))
+	if r == 0 {
+		// Service accounts have no IE settings; WPAD may still work
+		ieConfig = winhttpIEProxyConfig{AutoDetect: 1}
+	}
+	defer globalFree(ieConfig.AutoConfigURL)
+	defer globalFree(ieConfig.Proxy)
+	defer globalFree(ieConfig.ProxyBypass)
+
+	if ieConfig.AutoDetect == 0 && ieConfig.AutoConfigURL == nil {
+		if ieConfig.Proxy == nil {
+			return nil, nil
+		}
+		return parseProxyList(windows.UTF16PtrToString(ieConfig.Proxy), target.Scheme)
+	}
+
+	agent, _ := windows.UTF16PtrFromString("librespeed_exporter")
+	session, _, err := procWinHttpOpen.Call(uintptr(unsafe.Pointer(agent)), winhttpAccessTypeNoProxy, 0, 0, 0)
+	if session == 0 {
+		return nil, fmt.Errorf("WinHttpOpen: %v", err)
+	}
+	defer procWinHttpCloseHandle.Call(session)
+
+	options := winhttpAutoproxyOptions{AutoLogonIfChallenged: 1}
+	if ieConfig.AutoConfigURL != nil {
+		options.Flags = winhttpAutoproxyConfigURL
+		options.AutoConfigURL = ieConfig.AutoConfigURL
+	} else {
+		options.Flags = winhttpAutoproxyAutoDetect
+		options.AutoDetectFlags = winhttpAutoDetectTypeDHCP | winhttpAutoDetectTypeDNSA
+	}
+
+	targetPtr, err := windows.UTF16PtrFromString(target.String())
+	if err != nil {
+		return nil, err
+	}
+	var info winhttpProxyInfo
+	r, _, err = procWinHttpGetProxyForUrl.Call(session, uintptr(unsafe.Pointer(targetPtr)),
+		uintptr(unsafe.Pointer(&options)), uintptr(unsafe.Pointer(&info)))
+	if r == 0 {
+		return nil, fmt.Errorf("WinHttpGetProxyForUrl: %v", err)
+	}
+	defer globalFree(info.Proxy)
+	defer globalFree(info.ProxyBypass)
+
+	if info.Proxy == nil {
+		return nil, nil
+	}
+	return parseProxyList(windows.UTF16PtrToString(info.Proxy), target.Scheme)
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+
+package main
+
+import "fmt"
+
+func newSSPIContext(scheme, host string) (proxyAuthContext, error) {
+	return nil, fmt.Errorf("%s proxy authentication requires Windows SSPI", scheme)
+}
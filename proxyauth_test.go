@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeProxyAuth struct {
+	challenges [][]byte
+}
+
+func (f *fakeProxyAuth) Next(challenge []byte) ([]byte, error) {
+	f.challenges = append(f.challenges, challenge)
+	if challenge == nil {
+		return []byte("type1"), nil
+	}
+	return []byte("type3:" + string(challenge)), nil
+}
+
+func (f *fakeProxyAuth) Close() {}
+
+// Serves one connection with an NTLM-style three step handshake, then echoes
+// a line through the tunnel.
+func startNTLMProxy(t *testing.T, acceptFinal bool) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		br := bufio.NewReader(conn)
+		for {
+			req, err := http.ReadRequest(br)
+			if err != nil {
+				return
+			}
+			switch req.Header.Get("Proxy-Authorization") {
+			case "":
+				conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\nProxy-Authenticate: NTLM\r\nContent-Length: 0\r\n\r\n"))
+			case "NTLM " + base64.StdEncoding.EncodeToString([]byte("type1")):
+				conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\nProxy-Authenticate: NTLM " +
+					base64.StdEncoding.EncodeToString([]byte("challenge")) + "\r\nContent-Length: 0\r\n\r\n"))
+			default:
+				if !acceptFinal {
+					conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\nProxy-Authenticate: NTLM\r\nContent-Length: 0\r\n\r\n"))
+					continue
+				}
+				conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+				line, _ := br.ReadString('\n')
+				conn.Write([]byte("echo " + line))
+				return
+			}
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestConnectViaProxy_NTLMHandshake(t *testing.T) {
+	auth := &fakeProxyAuth{}
+	original := newProxyAuthContext
+	newProxyAuthContext = func(scheme, host string) (proxyAuthContext, error) {
+		if scheme != "NTLM" {
+			t.Errorf("Expected NTLM scheme, got %s", scheme)
+		}
+		return auth, nil
+	}
+	defer func() { newProxyAuthContext = original }()
+
+	proxyURL, _ := url.Parse("http://" + startNTLMProxy(t, true))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := connectViaProxy(ctx, &net.Dialer{}, proxyURL, "metrics.example.com:443")
+	if err != nil {
+		t.Fatalf("Expected tunnel, got %v", err)
+	}
+	defer conn.Close()
+
+	conn.Write([]byte("ping\n"))
+	reply, _ := bufio.NewReader(conn).ReadString('\n')
+	if reply != "echo ping\n" {
+		t.Errorf("Expected echo through tunnel, got %q", reply)
+	}
+	if len(auth.challenges) != 2 || string(auth.challenges[1]) != "challenge" {
+		t.Errorf("Expected challenge passed to the second leg, got %q", auth.challenges)
+	}
+}
+
+func TestConnectViaProxy_Rejected(t *testing.T) {
+	original := newProxyAuthContext
+	newProxyAuthContext = func(scheme, host string) (proxyAuthContext, error) {
+		return &fakeProxyAuth{}, nil
+	}
+	defer func() { newProxyAuthContext = original }()
+
+	proxyURL, _ := url.Parse("http://" + startNTLMProxy(t, false))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := connectViaProxy(ctx, &net.Dialer{}, proxyURL, "metrics.example.com:443")
+	if err == nil || !strings.Contains(err.Error(), "rejected") {
+		t.Errorf("Expected rejected credentials error, got %v", err)
+	}
+}
+
+func TestParseProxyChallenge(t *testing.T) {
+	header := http.Header{}
+	header.Add("Proxy-Authenticate", "Basic realm=\"corp\"")
+	header.Add("Proxy-Authenticate", "NTLM")
+	header.Add("Proxy-Authenticate", "Negotiate")
+	scheme, token, err := parseProxyChallenge(header)
+	if err != nil || scheme != "Negotiate" || token != nil {
+		t.Errorf("Expected Negotiate without token, got %s %q %v", scheme, token, err)
+	}
+
+	header = http.Header{"Proxy-Authenticate": {"Basic realm=\"corp\""}}
+	if _, _, err := parseProxyChallenge(header); err == nil {
+		t.Error("Expected error for basic-only proxy, got nil")
+	}
+}
@@ -0,0 +1,82 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/alexbrainman/sspi"
+	"github.com/alexbrainman/sspi/negotiate"
+	"github.com/alexbrainman/sspi/ntlm"
+)
+
+// Both contexts authenticate as the account the exporter runs under, so a
+// scheduled task running as a domain user needs no stored password.
+func newSSPIContext(scheme, host string) (proxyAuthContext, error) {
+	switch scheme {
+	case "Negotiate":
+		cred, err := negotiate.AcquireCurrentUserCredentials()
+		if err != nil {
+			return nil, err
+		}
+		return &negotiateContext{cred: cred, spn: "HTTP/" + host}, nil
+	case "NTLM":
+		cred, err := ntlm.AcquireCurrentUserCredentials()
+		if err != nil {
+			return nil, err
+		}
+		return &ntlmContext{cred: cred}, nil
+	default:
+		return nil, fmt.Errorf("unsupported scheme %s", scheme)
+	}
+}
+
+type negotiateContext struct {
+	cred *sspi.Credentials
+	spn  string
+	ctx  *negotiate.ClientContext
+}
+
+func (c *negotiateContext) Next(challenge []byte) ([]byte, error) {
+	if c.ctx == nil {
+		ctx, token, err := negotiate.NewClientContext(c.cred, c.spn)
+		if err != nil {
+			return nil, err
+		}
+		c.ctx = ctx
+		return token, nil
+	}
+	_, token, err := c.ctx.Update(challenge)
+	return token, err
+}
+
+func (c *negotiateContext) Close() {
+	if c.ctx != nil {
+		c.ctx.Release()
+	}
+	c.cred.Release()
+}
+
+type ntlmContext struct {
+	cred *sspi.Credentials
+	ctx  *ntlm.ClientContext
+}
+
+func (c *ntlmContext) Next(challenge []byte) ([]byte, error) {
+	if c.ctx == nil {
+		ctx, token, err := ntlm.NewClientContext(c.cred)
+		if err != nil {
+			return nil, err
+		}
+		c.ctx = ctx
+		return token, nil
+	}
+	return c.ctx.Update(challenge)
+}
+
+func (c *ntlmContext) Close() {
+	if c.ctx != nil {
+		c.ctx.Release()
+	}
+	c.cred.Release()
+}
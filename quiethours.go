@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A daily local-time window in minutes since midnight. End before start
+// wraps past midnight, e.g. 22:00-06:00.
+type quietWindow struct {
+	Start, End int
+}
+
+type QuietHours []quietWindow
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q (expected HH:MM)", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Parses comma-separated HH:MM-HH:MM windows, e.g. "22:00-06:00,12:00-13:00".
+func parseQuietHours(spec string) (QuietHours, error) {
+	var windows QuietHours
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		from, to, ok := strings.Cut(part, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid quiet hours %q (expected HH:MM-HH:MM)", part)
+		}
+		start, err := parseClock(from)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quiet hours %q: %v", part, err)
+		}
+		end, err := parseClock(to)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quiet hours %q: %v", part, err)
+		}
+		if start == end {
+			return nil, fmt.Errorf("invalid quiet hours %q: start and end are equal", part)
+		}
+		windows = append(windows, quietWindow{Start: start, End: end})
+	}
+	return windows, nil
+}
+
+// Reports whether t (in its own location) falls in any window; the start is
+// inclusive and the end exclusive.
+func (q QuietHours) contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	for _, w := range q {
+		if w.Start < w.End {
+			if minute >= w.Start && minute < w.End {
+				return true
+			}
+		} else if minute >= w.Start || minute < w.End {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseQuietHours(t *testing.T) {
+	q, err := parseQuietHours("22:00-06:00, 12:30-13:00")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(q) != 2 || q[0] != (quietWindow{Start: 22 * 60, End: 6 * 60}) || q[1] != (quietWindow{Start: 12*60 + 30, End: 13 * 60}) {
+		t.Errorf("Unexpected windows: %+v", q)
+	}
+
+	for _, spec := range []string{"22:00", "25:00-06:00", "08:00-08:00", "8-9"} {
+		if _, err := parseQuietHours(spec); err == nil {
+			t.Errorf("Expected error for %q, got nil", spec)
+		}
+	}
+}
+
+func TestQuietHours_Contains(t *testing.T) {
+	q, _ := parseQuietHours("22:00-06:00,12:30-13:00")
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, 5, 1, hour, minute, 0, 0, time.Local)
+	}
+	cases := []struct {
+		t    time.Time
+		want bool
+	}{
+		{at(22, 0), true},
+		{at(23, 59), true},
+		{at(3, 0), true},
+		{at(6, 0), false},
+		{at(12, 29), false},
+		{at(12, 45), true},
+		{at(13, 0), false},
+		{at(18, 0), false},
+	}
+	for _, c := range cases {
+		if got := q.contains(c.t); got != c.want {
+			t.Errorf("contains(%s) = %v, want %v", c.t.Format("15:04"), got, c.want)
+		}
+	}
+
+	if (QuietHours(nil)).contains(at(23, 0)) {
+		t.Error("Expected no quiet hours to never match")
+	}
+}
+
+func TestRunOnce_QuietHoursSkipsTest(t *testing.T) {
+	var pushed []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		series := decodeWriteRequest(t, r)
+		for _, ts := range series {
+			pushed = append(pushed, getLabelValue(ts.Labels, "__name__")+"/"+getLabelValue(ts.Labels, "reason"))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	runner := &MockRunner{Output: []byte(`[{"download":100.0,"upload":50.0,"ping":10.0,"jitter":1.0,"server":{"url":"http://example.com"}}]`)}
+	rc := newTestRunContext(t, server.URL, runner)
+	rc.quietHours, _ = parseQuietHours("22:00-06:00")
+	fake := useFakeClock(t, time.Date(2024, 5, 1, 23, 0, 0, 0, time.Local))
+
+	if err := rc.runOnce(context.Background()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if runner.Calls != 0 {
+		t.Errorf("Expected no test during quiet hours, got %d runs", runner.Calls)
+	}
+	if len(pushed) != 1 || pushed[0] != "librespeed_run_skipped/quiet_hours" {
+		t.Errorf("Expected only the skipped-run metric, got %v", pushed)
+	}
+
+	pushed = nil
+	fake.Advance(8 * time.Hour)
+	if err := rc.runOnce(context.Background()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if runner.Calls != 1 {
+		t.Errorf("Expected the test to run outside quiet hours, got %d runs", runner.Calls)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// Where install-rcd puts things for each rc.d flavour. FreeBSD's layout is
+// also used by pfSense and OPNsense.
+type rcdLayout struct {
+	Script, Config, StateDir, LogFile string
+	Enable                            string
+	// Subcommand named in the written files
+	Installer string
+}
+
+var rcdLayouts = map[string]rcdLayout{
+	"freebsd": {
+		Script:    "/usr/local/etc/rc.d/librespeed_exporter",
+		Config:    "/usr/local/etc/librespeed_exporter.conf",
+		StateDir:  "/var/db/librespeed_exporter",
+		LogFile:   "/var/log/librespeed_exporter.log",
+		Enable:    "sysrc librespeed_exporter_enable=YES && service librespeed_exporter start",
+		Installer: "install-rcd",
+	},
+	"openbsd": {
+		Script:    "/etc/rc.d/librespeed_exporter",
+		Config:    "/etc/librespeed_exporter.conf",
+		StateDir:  "/var/db/librespeed_exporter",
+		LogFile:   "/var/log/librespeed_exporter.log",
+		Enable:    "rcctl enable librespeed_exporter && rcctl start librespeed_exporter",
+		Installer: "install-rcd",
+	},
+}
+
+func isBSD(goos string) bool {
+	switch goos {
+	case "freebsd", "openbsd", "netbsd", "dragonfly":
+		return true
+	}
+	return false
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// rc.d script running exe with --config-file. FreeBSD's rc.subr has no
+// background mode, so daemon(8) detaches the exporter and records its pid
+// for stop and reload.
+func rcdScript(style, exe, configPath string) string {
+	if style == "openbsd" {
+		return fmt.Sprintf(`#!/bin/ksh
+#
+# Written by librespeed_exporter install-rcd
+
+daemon=%s
+daemon_flags=%s
+
+. /etc/rc.d/rc.subr
+
+rc_bg=YES
+rc_reload_signal=HUP
+
+rc_cmd $1
+`, shellQuote(exe), shellQuote("--config-file "+configPath))
+	}
+	return fmt.Sprintf(`#!/bin/sh
+#
+# PROVIDE: librespeed_exporter
+# REQUIRE: LOGIN NETWORKING
+# KEYWORD: shutdown
+#
+# Written by librespeed_exporter install-rcd. Enable with
+#   sysrc librespeed_exporter_enable=YES
+
+. /etc/rc.subr
+
+name="librespeed_exporter"
+rcvar="librespeed_exporter_enable"
+
+load_rc_config $name
+
+: ${librespeed_exporter_enable:="NO"}
+
+pidfile="/var/run/${name}.pid"
+procname=%s
+command="/usr/sbin/daemon"
+command_args="-f -p ${pidfile} ${procname} --config-file %s"
+extra_commands="reload"
+sig_reload="HUP"
+
+run_rc_command "$1"
+`, shellQuote(exe), shellQuote(configPath))
+}
+
+// --config-file lines for the flags set in args, adding the layout's state
+// directory and log file unless they were given. Repeatable flags get a
+// line per value.
+func rcdConfig(args []string, layout rcdLayout) ([]byte, error) {
+	fs := flag.NewFlagSet("exporter", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	cfg := &Config{}
+	cfg.RegisterFlags(fs)
+	registerHarnessFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	explicit := explicitFlags(fs)
+	if explicit["config-file"] {
+		return nil, fmt.Errorf("--config-file is written by %s; pass the flags themselves", layout.Installer)
+	}
+	if !explicit["state-dir"] {
+		fs.Set("state-dir", layout.StateDir)
+	}
+	if !explicit["logfile"] {
+		fs.Set("logfile", layout.LogFile)
+	}
+
+	var lines []string
+	fs.Visit(func(f *flag.Flag) {
+		if list, ok := f.Value.(*stringList); ok {
+			for _, value := range *list {
+				lines = append(lines, f.Name+"="+value)
+			}
+			return
+		}
+		lines = append(lines, f.Name+"="+f.Value.String())
+	})
+	sort.Strings(lines)
+	return []byte("# Written by librespeed_exporter " + layout.Installer + "\n" + strings.Join(lines, "\n") + "\n"), nil
+}
+
+// install-rcd writes an rc.d script for FreeBSD (pfSense, OPNsense) or
+// OpenBSD and a --config-file holding the exporter flags given after --.
+func runInstallRCD(args []string) int {
+	fs := flag.NewFlagSet("install-rcd", flag.ContinueOnError)
+	defaultStyle := "freebsd"
+	if runtime.GOOS == "openbsd" {
+		defaultStyle = "openbsd"
+	}
+	style := fs.String("style", defaultStyle, "rc.d flavour: freebsd (also pfSense and OPNsense) or openbsd")
+	scriptPath := fs.String("output", "", "Write the rc.d script here instead of the system rc.d directory")
+	configPath := fs.String("config-output", "", "Write the exporter configuration here instead of the system etc directory")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	layout, ok := rcdLayouts[*style]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "ERROR: unknown --style %q, expected freebsd or openbsd\n", *style)
+		return 2
+	}
+	if len(fs.Args()) == 0 {
+		fmt.Fprintln(os.Stderr, "ERROR: pass the exporter flags after --, e.g. install-rcd -- --url https://... --interval 1h")
+		return 2
+	}
+	if *scriptPath == "" {
+		*scriptPath = layout.Script
+	}
+	if *configPath == "" {
+		*configPath = layout.Config
+	}
+	config, err := rcdConfig(fs.Args(), layout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: invalid exporter flags: %v\n", err)
+		return 2
+	}
+
+	exe, err := os.Executable()
+	if err == nil {
+		exe, err = filepath.EvalSymlinks(exe)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: failed to find own executable: %v\n", err)
+		return 1
+	}
+
+	for _, file := range []struct {
+		path string
+		data []byte
+		mode os.FileMode
+	}{
+		// The configuration may contain passwords
+		{*configPath, config, 0600},
+		{*scriptPath, []byte(rcdScript(*style, exe, *configPath)), 0755},
+	} {
+		if err := os.MkdirAll(filepath.Dir(file.path), 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: failed to create %s: %v\n", filepath.Dir(file.path), err)
+			return 1
+		}
+		if err := os.WriteFile(file.path, file.data, file.mode); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: failed to write %s: %v\n", file.path, err)
+			return 1
+		}
+		fmt.Printf("Wrote %s\n", file.path)
+	}
+	fmt.Printf("Start it with: %s\n", layout.Enable)
+	return 0
+}
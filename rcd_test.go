@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRCDConfig(t *testing.T) {
+	data, err := rcdConfig([]string{"--url", "https://example.com/push", "--interval", "1h", "--cdn-target", "a=http://a", "--cdn-target", "b=http://b", "--server-id", "3,5"}, rcdLayouts["freebsd"])
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := `# Written by librespeed_exporter install-rcd
+cdn-target=a=http://a
+cdn-target=b=http://b
+interval=1h0m0s
+logfile=/var/log/librespeed_exporter.log
+server-id=3,5
+state-dir=/var/db/librespeed_exporter
+url=https://example.com/push
+`
+	if string(data) != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, data)
+	}
+
+	// The written file must read back as the same flags
+	path := filepath.Join(t.TempDir(), "exporter.conf")
+	os.WriteFile(path, data, 0600)
+	args, err := loadConfigFile(path)
+	if err != nil {
+		t.Fatalf("Config does not load: %v", err)
+	}
+	if len(args) != 7 {
+		t.Errorf("Expected 7 flags back, got %q", args)
+	}
+
+	if _, err := rcdConfig([]string{"--config-file", "x.conf"}, rcdLayouts["freebsd"]); err == nil {
+		t.Error("Expected error for --config-file, got nil")
+	}
+	if _, err := rcdConfig([]string{"--no-such-flag"}, rcdLayouts["freebsd"]); err == nil {
+		t.Error("Expected error for an unknown flag, got nil")
+	}
+}
+
+func TestRCDScript(t *testing.T) {
+	freebsd := rcdScript("freebsd", "/usr/local/bin/librespeed_exporter", "/usr/local/etc/librespeed_exporter.conf")
+	for _, want := range []string{
+		`rcvar="librespeed_exporter_enable"`,
+		`procname='/usr/local/bin/librespeed_exporter'`,
+		`command_args="-f -p ${pidfile} ${procname} --config-file '/usr/local/etc/librespeed_exporter.conf'"`,
+		`sig_reload="HUP"`,
+	} {
+		if !strings.Contains(freebsd, want) {
+			t.Errorf("Expected %s in FreeBSD script:\n%s", want, freebsd)
+		}
+	}
+
+	openbsd := rcdScript("openbsd", "/usr/local/bin/librespeed_exporter", "/etc/librespeed_exporter.conf")
+	for _, want := range []string{
+		"#!/bin/ksh",
+		"daemon='/usr/local/bin/librespeed_exporter'",
+		"daemon_flags='--config-file /etc/librespeed_exporter.conf'",
+		"rc_bg=YES",
+	} {
+		if !strings.Contains(openbsd, want) {
+			t.Errorf("Expected %s in OpenBSD script:\n%s", want, openbsd)
+		}
+	}
+}
+
+func TestRunInstallRCD(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "rc.d", "librespeed_exporter")
+	conf := filepath.Join(dir, "librespeed_exporter.conf")
+	code := runInstallRCD([]string{"--style", "openbsd", "--output", script, "--config-output", conf, "--", "--url", "https://example.com/push", "--password", "secret"})
+	if code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+	if info, err := os.Stat(conf); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected config with mode 0600, got %v, %v", info, err)
+	}
+	if info, err := os.Stat(script); err != nil || info.Mode().Perm() != 0755 {
+		t.Errorf("Expected executable script, got %v, %v", info, err)
+	}
+
+	if code := runInstallRCD([]string{"--style", "solaris", "--", "--url", "x"}); code != 2 {
+		t.Errorf("Expected exit code 2 for an unknown style, got %d", code)
+	}
+	if code := runInstallRCD([]string{"--output", script}); code != 2 {
+		t.Errorf("Expected exit code 2 without exporter flags, got %d", code)
+	}
+}
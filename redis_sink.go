@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+type RedisConfig struct {
+	Address   string
+	Password  string
+	DB        int
+	TLS       bool
+	KeyPrefix string
+	Retention time.Duration
+}
+
+// Minimal RESP2 client; we only need to pipeline a handful of commands per
+// run, which doesn't justify pulling in a full Redis library.
+func writeRESPCommand(w io.Writer, args ...string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Reads a single reply, returning Redis error replies as errors. Array
+// elements are read and discarded since none of our commands need them.
+func readRESPReply(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return "", fmt.Errorf("empty RESP reply")
+	}
+	switch line[0] {
+	case '+', ':':
+		return line[1:], nil
+	case '-':
+		return "", fmt.Errorf("redis: %s", line[1:])
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return "", fmt.Errorf("invalid bulk length: %v", err)
+		}
+		if n < 0 {
+			return "", nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return "", err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return "", fmt.Errorf("invalid array length: %v", err)
+		}
+		for i := 0; i < n; i++ {
+			if _, err := readRESPReply(r); err != nil {
+				return "", err
+			}
+		}
+		return "", nil
+	default:
+		return "", fmt.Errorf("unexpected RESP reply: %q", line)
+	}
+}
+
+// <prefix><metric>:<instance>:<hash>, where the hash covers the full label
+// set, so series that differ in server_url, server_id, profile and the like
+// get keys of their own.
+func redisKey(prefix string, ts *prompb.TimeSeries) string {
+	pairs := make([]string, 0, len(ts.Labels))
+	for _, label := range ts.Labels {
+		pairs = append(pairs, label.Name+"="+label.Value)
+	}
+	sort.Strings(pairs)
+	sum := sha256.Sum256([]byte(strings.Join(pairs, "\x00")))
+	return prefix + getLabelValue(ts.Labels, "__name__") + ":" + getLabelValue(ts.Labels, "instance") + ":" + hex.EncodeToString(sum[:8])
+}
+
+// Builds one TS.ADD per series. LABELS only take effect when the key is
+// created, which redisKey makes safe: each label set has its own key.
+func tsAddCommands(cfg RedisConfig, series []*prompb.TimeSeries) [][]string {
+	var commands [][]string
+	for _, ts := range series {
+		for _, sample := range ts.Samples {
+			cmd := []string{"TS.ADD", redisKey(cfg.KeyPrefix, ts),
+				strconv.FormatInt(sample.Timestamp, 10),
+				strconv.FormatFloat(sample.Value, 'f', -1, 64)}
+			if cfg.Retention > 0 {
+				cmd = append(cmd, "RETENTION", strconv.FormatInt(cfg.Retention.Milliseconds(), 10))
+			}
+			cmd = append(cmd, "ON_DUPLICATE", "LAST", "LABELS")
+			for _, label := range ts.Labels {
+				name := label.Name
+				if name == "__name__" {
+					name = "metric"
+				}
+				cmd = append(cmd, name, label.Value)
+			}
+			commands = append(commands, cmd)
+		}
+	}
+	return commands
+}
+
+func sendToRedis(cfg RedisConfig, series []*prompb.TimeSeries) error {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	var conn net.Conn
+	var err error
+	if cfg.TLS {
+		host, _, _ := net.SplitHostPort(cfg.Address)
+		conn, err = tls.DialWithDialer(dialer, "tcp", cfg.Address, &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12})
+	} else {
+		conn, err = dialer.Dial("tcp", cfg.Address)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to Redis: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	var commands [][]string
+	if cfg.Password != "" {
+		commands = append(commands, []string{"AUTH", cfg.Password})
+	}
+	if cfg.DB != 0 {
+		commands = append(commands, []string{"SELECT", strconv.Itoa(cfg.DB)})
+	}
+	commands = append(commands, tsAddCommands(cfg, series)...)
+
+	w := bufio.NewWriter(conn)
+	for _, cmd := range commands {
+		if err := writeRESPCommand(w, cmd...); err != nil {
+			return fmt.Errorf("failed to write Redis command: %v", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to send Redis commands: %v", err)
+	}
+
+	// Drain every reply so one failed TS.ADD doesn't hide the rest
+	r := bufio.NewReader(conn)
+	var firstErr error
+	for _, cmd := range commands {
+		if _, err := readRESPReply(r); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("%s failed: %v", cmd[0], err)
+		}
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+
+	log.Printf("Wrote %d samples to RedisTimeSeries at %s", len(commands), cfg.Address)
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func TestWriteRESPCommand(t *testing.T) {
+	var b strings.Builder
+	if err := writeRESPCommand(&b, "TS.ADD", "key", "1"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := "*3\r\n$6\r\nTS.ADD\r\n$3\r\nkey\r\n$1\r\n1\r\n"
+	if b.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, b.String())
+	}
+}
+
+func TestReadRESPReply(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected string
+		wantErr  bool
+	}{
+		{"+OK\r\n", "OK", false},
+		{":1700000000000\r\n", "1700000000000", false},
+		{"$5\r\nhello\r\n", "hello", false},
+		{"$-1\r\n", "", false},
+		{"*2\r\n:1\r\n:2\r\n", "", false},
+		{"-ERR unknown command 'TS.ADD'\r\n", "", true},
+		{"?weird\r\n", "", true},
+	}
+	for _, tc := range testCases {
+		got, err := readRESPReply(bufio.NewReader(strings.NewReader(tc.input)))
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%q: expected error %v, got %v", tc.input, tc.wantErr, err)
+		}
+		if got != tc.expected {
+			t.Errorf("%q: expected %q, got %q", tc.input, tc.expected, got)
+		}
+	}
+}
+
+func TestTSAddCommands(t *testing.T) {
+	series := []*prompb.TimeSeries{createTimeSeries("librespeed_download_mbps", 95.5, 1000, "http://server", "host1")}
+	cfg := RedisConfig{KeyPrefix: "edge:", Retention: time.Hour}
+	commands := tsAddCommands(cfg, series)
+	if len(commands) != 1 {
+		t.Fatalf("Expected 1 command, got %d", len(commands))
+	}
+	key := redisKey("edge:", series[0])
+	if !strings.HasPrefix(key, "edge:librespeed_download_mbps:host1:") {
+		t.Errorf("Expected a readable key, got %s", key)
+	}
+	got := strings.Join(commands[0], " ")
+	expected := "TS.ADD " + key + " 1000 95.5 RETENTION 3600000 ON_DUPLICATE LAST LABELS metric librespeed_download_mbps instance host1 server_url http://server"
+	if got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestRedisKey_FullLabelSet(t *testing.T) {
+	first := createTimeSeries("librespeed_download_mbps", 95.5, 1000, "http://server", "host1")
+	second := createTimeSeries("librespeed_download_mbps", 80, 1000, "http://server", "host1")
+	first.Labels = append(first.Labels, prompb.Label{Name: "server_id", Value: "1"})
+	second.Labels = append(second.Labels, prompb.Label{Name: "server_id", Value: "2"})
+	if redisKey("edge:", first) == redisKey("edge:", second) {
+		t.Error("Expected series that differ only in server_id to get different keys")
+	}
+
+	// The order of the labels doesn't matter
+	reordered := &prompb.TimeSeries{Labels: append([]prompb.Label{first.Labels[len(first.Labels)-1]}, first.Labels[:len(first.Labels)-1]...)}
+	if redisKey("edge:", first) != redisKey("edge:", reordered) {
+		t.Error("Expected the same label set in another order to get the same key")
+	}
+}
+
+// Reads one RESP array of bulk strings, as sent by writeRESPCommand.
+func readRESPArray(r *bufio.Reader) ([]string, error) {
+	header, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(header[1:]))
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		if _, err := r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		value, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, strings.TrimSuffix(value, "\r\n"))
+	}
+	return args, nil
+}
+
+func TestSendToRedis(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		var names []string
+		for i := 0; i < 3; i++ {
+			args, err := readRESPArray(r)
+			if err != nil {
+				return
+			}
+			names = append(names, args[0])
+		}
+		conn.Write([]byte("+OK\r\n+OK\r\n-ERR TSDB: invalid value\r\n"))
+		received <- names
+	}()
+
+	series := []*prompb.TimeSeries{createTimeSeries("librespeed_ping_ms", 10, 1000, "http://server", "host1")}
+	cfg := RedisConfig{Address: ln.Addr().String(), Password: "secret", DB: 2}
+	err = sendToRedis(cfg, series)
+	if err == nil || !strings.Contains(err.Error(), "TS.ADD failed") {
+		t.Errorf("Expected TS.ADD error, got %v", err)
+	}
+	names := <-received
+	if strings.Join(names, ",") != "AUTH,SELECT,TS.ADD" {
+		t.Errorf("Expected AUTH,SELECT,TS.ADD, got %v", names)
+	}
+}
+
+func TestSendToRedis_ConnectionFailure(t *testing.T) {
+	series := []*prompb.TimeSeries{createTimeSeries("librespeed_ping_ms", 10, 1000, "http://server", "host1")}
+	err := sendToRedis(RedisConfig{Address: "127.0.0.1:1"}, series)
+	if err == nil || !strings.Contains(err.Error(), "failed to connect") {
+		t.Errorf("Expected connection error, got %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Settings bound to listeners, files or identity that were set up at
+// startup. A reload that changes them keeps the running value and says so.
+func keepRestartOnlySettings(old, cfg *Config) {
+	keep := func(name string, oldValue, newValue any, restore func()) {
+		if oldValue != newValue {
+			log.Printf("WARNING: --%s changed from %v to %v; restart to apply it", name, oldValue, newValue)
+			restore()
+		}
+	}
+	keep("logfile", old.LogFile, cfg.LogFile, func() { cfg.LogFile = old.LogFile })
+	keep("state-dir", old.StateDir, cfg.StateDir, func() { cfg.StateDir = old.StateDir })
+	keep("listen-address", old.ListenAddress, cfg.ListenAddress, func() { cfg.ListenAddress = old.ListenAddress })
+	keep("enable-run-api", old.RunAPI, cfg.RunAPI, func() { cfg.RunAPI = old.RunAPI })
+	keep("enable-lifecycle", old.Lifecycle, cfg.Lifecycle, func() { cfg.Lifecycle = old.Lifecycle })
+	keep("grpc-address", old.GRPCAddress, cfg.GRPCAddress, func() { cfg.GRPCAddress = old.GRPCAddress })
+	keep("audit-log", old.AuditLog, cfg.AuditLog, func() { cfg.AuditLog = old.AuditLog })
+	keep("os-log", old.OSLog, cfg.OSLog, func() { cfg.OSLog = old.OSLog })
+	keep("result-ring", old.ResultRing, cfg.ResultRing, func() { cfg.ResultRing = old.ResultRing })
+	keep("result-ring-size", old.ResultRingSize, cfg.ResultRingSize, func() { cfg.ResultRingSize = old.ResultRingSize })
+}
+
+// Re-reads the command line and --config-file (with the files they point
+// to, such as --remote-write-config) and switches to the result once the
+// current test has finished. An invalid configuration is rejected and the
+// running one kept. actor says who asked, for the audit log.
+func (rc *runContext) reload(actor string) (err error) {
+	defer func() {
+		if err != nil {
+			rc.audit.record("config_reload_failed", actor, err.Error())
+		}
+	}()
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	cfg := &Config{}
+	cfg.RegisterFlags(fs)
+	registerHarnessFlags(fs)
+	args, err := withConfigFile(os.Args[1:])
+	if err == nil {
+		err = fs.Parse(args)
+	}
+	if err != nil {
+		return fmt.Errorf("reload failed, keeping the current configuration: %v", err)
+	}
+	explicit := explicitFlags(fs)
+	if cfg.Launchd {
+		applyLaunchdDefaults(cfg, explicit)
+	}
+	if err := applyStateDirDefault(cfg); err != nil {
+		return fmt.Errorf("reload failed, keeping the current configuration: %v", err)
+	}
+	if cfg.LocalAgent && cfg.URL == "" {
+		cfg.URL = defaultLocalAgentURL
+	}
+
+	rc.runMu.Lock()
+	defer rc.runMu.Unlock()
+	old := rc.cfg
+	keepRestartOnlySettings(old, cfg)
+	savedHeaders := remoteWriteHeaders
+	client, err := newRemoteWriteClient(cfg)
+	if err == nil {
+		err = rc.configure(cfg, explicit)
+	}
+	if err != nil {
+		remoteWriteHeaders = savedHeaders
+		return fmt.Errorf("reload failed, keeping the current configuration: %v", err)
+	}
+	remoteWriteClient = client
+	rc.flags = fs
+	rc.audit.record("config_reload", actor, "config "+configHash(fs))
+	changed := changedCredentials(old, cfg)
+	if !maps.Equal(savedHeaders, remoteWriteHeaders) {
+		changed = strings.TrimPrefix(changed+", remote-write headers", ", ")
+	}
+	if changed != "" {
+		rc.audit.record("credentials_changed", actor, changed)
+	}
+	select {
+	case rc.reloaded <- struct{}{}:
+	default:
+	}
+	log.Println("Configuration reloaded")
+	return nil
+}
+
+// POST /-/reload, as in Prometheus: 200 once reloaded, 500 with the reason
+// if the new configuration was rejected.
+func (rc *runContext) registerReload(mux *http.ServeMux) {
+	mux.HandleFunc("POST /-/reload", func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("Configuration reload requested from %s", r.RemoteAddr)
+		if err := rc.reload(requestActor(r)); err != nil {
+			log.Printf("ERROR: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintln(w, "Configuration reloaded")
+	})
+}
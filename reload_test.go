@@ -0,0 +1,134 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Points os.Args at a config file holding content and returns its path.
+func useConfigFileArgs(t *testing.T, content string, extra ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "exporter.conf")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	saved := os.Args
+	os.Args = append([]string{"librespeed_exporter", "--config-file", path}, extra...)
+	t.Cleanup(func() { os.Args = saved })
+	return path
+}
+
+func newReloadTestContext(t *testing.T) *runContext {
+	t.Helper()
+	savedClient, savedHeaders := remoteWriteClient, remoteWriteHeaders
+	t.Cleanup(func() { remoteWriteClient, remoteWriteHeaders = savedClient, savedHeaders })
+
+	args, err := withConfigFile(os.Args[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg := &Config{}
+	cfg.RegisterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	rc := &runContext{flags: fs, reloaded: make(chan struct{}, 1)}
+	if err := rc.configure(cfg, explicitFlags(fs)); err != nil {
+		t.Fatalf("Initial configuration rejected: %v", err)
+	}
+	return rc
+}
+
+func TestReload(t *testing.T) {
+	useFakeClock(t, time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	path := useConfigFileArgs(t, "url=https://example.com/push\nusername=1\npassword=p\ninterval=1h\n", "--state-dir", t.TempDir())
+	rc := newReloadTestContext(t)
+	auditPath := filepath.Join(t.TempDir(), "audit.jsonl")
+	rc.audit = newAuditLog(auditPath, "host1")
+	firstSchedule := rc.schedule
+
+	os.WriteFile(path, []byte("url=https://other.example.com/push\nusername=1\npassword=p\ninterval=15m\ncampaign=isp-migration\ncampaign-until=2024-04-01T00:00:00Z\nstate-dir=/elsewhere\n"), 0600)
+	if err := rc.reload("test"); err != nil {
+		t.Fatalf("Expected reload to succeed, got %v", err)
+	}
+	if rc.cfg.URL != "https://other.example.com/push" || rc.cfg.Interval != 15*time.Minute || rc.campaign == nil {
+		t.Errorf("New configuration not applied: %+v", rc.cfg)
+	}
+	if rc.schedule == firstSchedule {
+		t.Error("Expected a new schedule for the new interval")
+	}
+	if rc.cfg.StateDir == "/elsewhere" {
+		t.Error("Expected --state-dir to keep its startup value until a restart")
+	}
+	select {
+	case <-rc.reloaded:
+	default:
+		t.Error("Expected the daemon loop to be notified")
+	}
+
+	os.WriteFile(path, []byte("url=https://other.example.com/push\nusername=1\npassword=p\ninterval=15m\nformat=carrier-pigeon\n"), 0600)
+	if err := rc.reload("test"); err == nil {
+		t.Fatal("Expected an invalid configuration to be rejected")
+	}
+	if rc.cfg.URL != "https://other.example.com/push" || rc.campaign == nil {
+		t.Errorf("Expected the previous configuration to be kept, got %+v", rc.cfg)
+	}
+
+	os.WriteFile(path, []byte("url=https://other.example.com/push\nusername=1\npassword=p\n"), 0600)
+	if err := rc.reload("test"); err == nil {
+		t.Error("Expected switching from daemon mode to a single run to be rejected")
+	}
+
+	var actions []string
+	for _, event := range readAuditLog(t, auditPath) {
+		actions = append(actions, event.Action+" "+event.Detail)
+	}
+	if len(actions) != 4 || !strings.HasPrefix(actions[0], "config_reload config ") || actions[1] != "credentials_changed url" ||
+		!strings.HasPrefix(actions[2], "config_reload_failed ") || !strings.HasPrefix(actions[3], "config_reload_failed ") {
+		t.Errorf("Unexpected audit trail: %q", actions)
+	}
+}
+
+func TestReloadEndpoint(t *testing.T) {
+	path := useConfigFileArgs(t, "url=https://example.com/push\nusername=1\npassword=p\ninterval=1h\n")
+	rc := newReloadTestContext(t)
+	mux := http.NewServeMux()
+	rc.registerReload(mux)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/-/reload", "", nil)
+	if err != nil {
+		t.Fatalf("POST failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200, got %d", resp.StatusCode)
+	}
+
+	os.WriteFile(path, []byte("url=not a url\n"), 0600)
+	resp, err = http.Post(server.URL+"/-/reload", "", nil)
+	if err != nil {
+		t.Fatalf("POST failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("Expected 500 for an invalid configuration, got %d", resp.StatusCode)
+	}
+
+	resp, err = http.Get(server.URL + "/-/reload")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for GET, got %d", resp.StatusCode)
+	}
+}
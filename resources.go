@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Per-metric send logging; turned off when the host is short on resources.
+var verboseMetricLogging = true
+
+// Returns the reasons (disk, memory) the host is below the configured
+// minimums. Probes that fail or aren't supported are treated as healthy so a
+// monitoring quirk never stops the test.
+func checkResources(dir string, minDiskBytes, minMemoryBytes uint64) []string {
+	var reasons []string
+	if minDiskBytes > 0 {
+		if free, err := diskFreeBytes(dir); err == nil && free < minDiskBytes {
+			reasons = append(reasons, "disk")
+		}
+	}
+	if minMemoryBytes > 0 {
+		if avail, err := availableMemoryBytes(); err == nil && avail < minMemoryBytes {
+			reasons = append(reasons, "memory")
+		}
+	}
+	return reasons
+}
+
+func parseMemAvailable(r io.Reader) (uint64, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "MemAvailable:" {
+			continue
+		}
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid MemAvailable: %v", err)
+		}
+		return kb * 1024, nil
+	}
+	return 0, fmt.Errorf("no MemAvailable in meminfo")
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseMemAvailable(t *testing.T) {
+	meminfo := `MemTotal:        2048000 kB
+MemFree:          100000 kB
+MemAvailable:     512000 kB
+Buffers:           20000 kB
+`
+	avail, err := parseMemAvailable(strings.NewReader(meminfo))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if avail != 512000*1024 {
+		t.Errorf("Expected %d bytes, got %d", 512000*1024, avail)
+	}
+
+	if _, err := parseMemAvailable(strings.NewReader("MemTotal: 1 kB\n")); err == nil {
+		t.Error("Expected error without MemAvailable, got nil")
+	}
+}
+
+func TestCheckResources(t *testing.T) {
+	dir := t.TempDir()
+	if reasons := checkResources(dir, 0, 0); len(reasons) != 0 {
+		t.Errorf("Expected no reasons with checks disabled, got %v", reasons)
+	}
+	// No real disk has this much free space
+	reasons := checkResources(dir, 1<<62, 0)
+	if len(reasons) != 1 || reasons[0] != "disk" {
+		t.Errorf("Expected disk reason, got %v", reasons)
+	}
+	if reasons := checkResources(dir, 1, 0); len(reasons) != 0 {
+		t.Errorf("Expected no reasons for a tiny minimum, got %v", reasons)
+	}
+}
@@ -0,0 +1,21 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+func availableMemoryBytes() (uint64, error) {
+	if runtime.GOOS != "linux" {
+		return 0, fmt.Errorf("memory check is not supported on %s", runtime.GOOS)
+	}
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return parseMemAvailable(f)
+}
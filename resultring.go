@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// File layout of --result-ring: a header followed by fixed-size slots, each
+// a little-endian uint16 length and the JSON of one ringEntry. The header
+// holds the magic, slot size, slot count and the number of entries ever
+// written, which is updated after the slot so an interrupted write only
+// loses the entry being replaced.
+const (
+	ringMagic      = "LSRING01"
+	ringHeaderSize = 32
+	ringSlotSize   = 512
+)
+
+type ringEntry struct {
+	Timestamp time.Time `json:"timestamp"`
+	RunRecord
+}
+
+// The last results in a memory-mapped file of fixed size. Each run dirties
+// at most two pages, which the kernel writes back on its own, so the file
+// never grows and flash sees little wear.
+type resultRing struct {
+	mu    sync.Mutex
+	data  []byte
+	slots int
+	unmap func() error
+}
+
+func openResultRing(path string, slots int) (*resultRing, error) {
+	size := ringHeaderSize + slots*ringSlotSize
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open result ring: %v", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open result ring: %v", err)
+	}
+	if info.Size() != int64(size) {
+		if info.Size() > 0 {
+			log.Printf("WARNING: Result ring %s has a different size, starting it afresh", path)
+		}
+		if err := f.Truncate(0); err != nil {
+			return nil, fmt.Errorf("failed to size result ring: %v", err)
+		}
+		if err := f.Truncate(int64(size)); err != nil {
+			return nil, fmt.Errorf("failed to size result ring: %v", err)
+		}
+	}
+	data, unmap, err := mmapFile(f, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to map result ring: %v", err)
+	}
+
+	r := &resultRing{data: data, slots: slots, unmap: unmap}
+	if string(data[:8]) != ringMagic || r.header(8) != ringSlotSize || r.header(12) != uint32(slots) {
+		clear(data)
+		copy(data, ringMagic)
+		binary.LittleEndian.PutUint32(data[8:], ringSlotSize)
+		binary.LittleEndian.PutUint32(data[12:], uint32(slots))
+	}
+	return r, nil
+}
+
+func (r *resultRing) header(offset int) uint32 {
+	return binary.LittleEndian.Uint32(r.data[offset:])
+}
+
+func (r *resultRing) count() uint64 {
+	if r.data == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(r.data[16:])
+}
+
+func (r *resultRing) slot(i uint64) []byte {
+	start := ringHeaderSize + int(i%uint64(r.slots))*ringSlotSize
+	return r.data[start : start+ringSlotSize]
+}
+
+// JSON of the entry, shortening the error message until it fits a slot.
+func encodeRingEntry(entry ringEntry) ([]byte, error) {
+	for {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return nil, err
+		}
+		over := len(data) - (ringSlotSize - 2)
+		if over <= 0 {
+			return data, nil
+		}
+		if entry.Error == "" {
+			return nil, fmt.Errorf("result of %d bytes does not fit a ring slot", len(data))
+		}
+		// Escaping makes the JSON longer than the message, so cut at most
+		// half of what is left each time
+		message := strings.TrimSuffix(entry.Error, "...")
+		cut := min(over+3, (len(message)+1)/2)
+		message = strings.ToValidUTF8(message[:len(message)-cut], "")
+		entry.Error = ""
+		if message != "" {
+			entry.Error = message + "..."
+		}
+	}
+}
+
+// Stores record as the newest entry, replacing the oldest once full.
+func (r *resultRing) append(record RunRecord, ts time.Time) error {
+	if r == nil {
+		return nil
+	}
+	data, err := encodeRingEntry(ringEntry{Timestamp: ts.UTC(), RunRecord: record})
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.data == nil {
+		return nil
+	}
+	n := r.count()
+	slot := r.slot(n)
+	binary.LittleEndian.PutUint16(slot, uint16(len(data)))
+	copy(slot[2:], data)
+	binary.LittleEndian.PutUint64(r.data[16:], n+1)
+	return nil
+}
+
+// Up to limit entries, newest first. Unreadable slots are skipped.
+func (r *resultRing) recent(limit int) []ringEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entries := []ringEntry{}
+	if r.data == nil {
+		return entries
+	}
+	n := r.count()
+	for i := uint64(0); i < n && i < uint64(r.slots) && len(entries) < limit; i++ {
+		slot := r.slot(n - 1 - i)
+		length := int(binary.LittleEndian.Uint16(slot))
+		if length > ringSlotSize-2 {
+			continue
+		}
+		var entry ringEntry
+		if json.Unmarshal(slot[2:2+length], &entry) == nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// Later appends and reads find the ring empty rather than touching the
+// unmapped file.
+func (r *resultRing) close() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.data == nil {
+		return nil
+	}
+	r.data = nil
+	return r.unmap()
+}
+
+// GET /api/v1/results?limit=N answers the newest N results (default all).
+func (r *resultRing) register(mux *http.ServeMux) {
+	if r == nil {
+		return
+	}
+	mux.HandleFunc("GET /api/v1/results", func(w http.ResponseWriter, req *http.Request) {
+		limit := r.slots
+		if s := req.URL.Query().Get("limit"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 {
+				http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		writeJSON(w, http.StatusOK, r.recent(limit))
+	})
+}
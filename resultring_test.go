@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func openTestRing(t *testing.T, path string, slots int) *resultRing {
+	t.Helper()
+	ring, err := openResultRing(path, slots)
+	if err != nil {
+		t.Fatalf("Failed to open ring: %v", err)
+	}
+	t.Cleanup(func() { ring.close() })
+	return ring
+}
+
+func TestResultRing_WrapsAndPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.ring")
+	ring := openTestRing(t, path, 3)
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i := 1; i <= 5; i++ {
+		if err := ring.append(RunRecord{Status: "success", Download: float64(i)}, start.Add(time.Duration(i)*time.Hour)); err != nil {
+			t.Fatalf("Failed to append: %v", err)
+		}
+	}
+	ring.close()
+
+	info, _ := os.Stat(path)
+	if info.Size() != ringHeaderSize+3*ringSlotSize {
+		t.Errorf("Expected the file to keep its size, got %d bytes", info.Size())
+	}
+
+	reopened := openTestRing(t, path, 3)
+	entries := reopened.recent(10)
+	if len(entries) != 3 {
+		t.Fatalf("Expected the last 3 results, got %d", len(entries))
+	}
+	for i, want := range []float64{5, 4, 3} {
+		if entries[i].Download != want {
+			t.Errorf("Expected entry %d to be %v, got %v", i, want, entries[i].Download)
+		}
+	}
+	if !entries[0].Timestamp.Equal(start.Add(5 * time.Hour)) {
+		t.Errorf("Unexpected timestamp %v", entries[0].Timestamp)
+	}
+	if got := reopened.recent(1); len(got) != 1 || got[0].Download != 5 {
+		t.Errorf("Expected only the newest entry, got %v", got)
+	}
+}
+
+func TestResultRing_ResizeStartsAfresh(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.ring")
+	ring := openTestRing(t, path, 3)
+	ring.append(RunRecord{Status: "success"}, time.Now())
+	ring.close()
+
+	if entries := openTestRing(t, path, 5).recent(10); len(entries) != 0 {
+		t.Errorf("Expected an empty ring after resizing, got %v", entries)
+	}
+}
+
+func TestResultRing_TruncatesLongErrors(t *testing.T) {
+	ring := openTestRing(t, filepath.Join(t.TempDir(), "results.ring"), 2)
+	record := RunRecord{Status: "failure", Stage: "speedtest", Error: strings.Repeat("\"quoted\" ", 200)}
+	if err := ring.append(record, time.Now()); err != nil {
+		t.Fatalf("Expected the error to be shortened, got %v", err)
+	}
+	entries := ring.recent(1)
+	if len(entries) != 1 || !strings.HasSuffix(entries[0].Error, "...") {
+		t.Errorf("Expected a truncated error, got %+v", entries)
+	}
+}
+
+func TestResultRing_HTTP(t *testing.T) {
+	ring := openTestRing(t, filepath.Join(t.TempDir(), "results.ring"), 10)
+	ring.append(RunRecord{Status: "success", Download: 90}, time.Now())
+	ring.append(RunRecord{Status: "success", Download: 95}, time.Now())
+	mux := http.NewServeMux()
+	ring.register(mux)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/results?limit=1", nil))
+	var entries []ringEntry
+	if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil {
+		t.Fatalf("Failed to decode %q: %v", w.Body.String(), err)
+	}
+	if len(entries) != 1 || entries[0].Download != 95 {
+		t.Errorf("Expected the newest result, got %v", entries)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/results?limit=0", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for limit=0, got %d", w.Code)
+	}
+
+	var none *resultRing
+	if err := none.append(RunRecord{}, time.Now()); err != nil {
+		t.Errorf("Expected a nil ring to ignore results, got %v", err)
+	}
+}
+
+func TestResultRing_UseAfterClose(t *testing.T) {
+	ring := openTestRing(t, filepath.Join(t.TempDir(), "results.ring"), 3)
+	ring.append(RunRecord{Status: "success"}, time.Now())
+	if err := ring.close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	// A run or request still in flight at shutdown must not touch the
+	// unmapped file
+	if err := ring.append(RunRecord{Status: "success"}, time.Now()); err != nil {
+		t.Errorf("Expected append after close to be ignored, got %v", err)
+	}
+	if got := ring.recent(10); len(got) != 0 {
+		t.Errorf("Expected no entries after close, got %v", got)
+	}
+	if err := ring.close(); err != nil {
+		t.Errorf("Expected a second close to do nothing, got %v", err)
+	}
+}
@@ -0,0 +1,49 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var procNtMapViewOfSection = windows.NewLazySystemDLL("ntdll.dll").NewProc("NtMapViewOfSection")
+
+// SECTION_INHERIT: the view is not inherited by child processes
+const viewUnmap = 2
+
+// Maps the first size bytes of f shared and writable; f may be closed
+// afterwards.
+func mmapFile(f *os.File, size int) ([]byte, func() error, error) {
+	mapping, err := windows.CreateFileMapping(windows.Handle(f.Fd()), nil, windows.PAGE_READWRITE, 0, uint32(size), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	view, err := mapView(mapping, size)
+	if err != nil {
+		windows.CloseHandle(mapping)
+		return nil, nil, err
+	}
+	return unsafe.Slice((*byte)(view), size), func() error {
+		err := windows.UnmapViewOfFile(uintptr(view))
+		windows.CloseHandle(mapping)
+		return err
+	}, nil
+}
+
+// MapViewOfFile returns the view's address as a uintptr, which can't be
+// turned back into a pointer under the unsafe.Pointer rules.
+// NtMapViewOfSection instead stores it through its BaseAddress argument,
+// here straight into an unsafe.Pointer.
+func mapView(mapping windows.Handle, size int) (unsafe.Pointer, error) {
+	var view unsafe.Pointer
+	viewSize := uintptr(size)
+	status, _, _ := procNtMapViewOfSection.Call(uintptr(mapping), uintptr(windows.CurrentProcess()),
+		uintptr(unsafe.Pointer(&view)), 0, 0, 0, uintptr(unsafe.Pointer(&viewSize)), viewUnmap, 0, windows.PAGE_READWRITE)
+	if status != 0 {
+		return nil, windows.NTStatus(status)
+	}
+	return view, nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+const rollupDayFormat = "2006-01-02"
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+type dailyStats struct {
+	Min, Median, Max float64
+}
+
+func newDailyStats(values []float64) dailyStats {
+	stats := dailyStats{Min: values[0], Median: median(values), Max: values[0]}
+	for _, v := range values {
+		stats.Min = min(stats.Min, v)
+		stats.Max = max(stats.Max, v)
+	}
+	return stats
+}
+
+// One (local) day of history, shared by the rollup push and the
+// spreadsheet/CSV exports.
+type dailySummary struct {
+	Day      time.Time
+	Runs     int
+	Download dailyStats
+	Upload   dailyStats
+	Ping     dailyStats
+	Jitter   dailyStats
+}
+
+// Summarises the history entries of the day starting at dayStart. Returns
+// nil when the day has no runs.
+func summarizeDay(history []HistoryEntry, dayStart time.Time) *dailySummary {
+	dayEnd := dayStart.AddDate(0, 0, 1)
+	var download, upload, ping, jitter []float64
+	for _, entry := range history {
+		if entry.Timestamp.Before(dayStart) || !entry.Timestamp.Before(dayEnd) {
+			continue
+		}
+		download = append(download, entry.Download)
+		upload = append(upload, entry.Upload)
+		ping = append(ping, entry.Ping)
+		jitter = append(jitter, entry.Jitter)
+	}
+	if len(download) == 0 {
+		return nil
+	}
+	return &dailySummary{
+		Day:      dayStart,
+		Runs:     len(download),
+		Download: newDailyStats(download),
+		Upload:   newDailyStats(upload),
+		Ping:     newDailyStats(ping),
+		Jitter:   newDailyStats(jitter),
+	}
+}
+
+// min/median/max per measurement plus a run count, all timestamped at the
+// last millisecond of the day.
+func (s *dailySummary) series(serverURL, instance string) []*prompb.TimeSeries {
+	ts := s.Day.AddDate(0, 0, 1).Add(-time.Millisecond).UnixMilli()
+	series := []*prompb.TimeSeries{
+		createTimeSeries("librespeed_daily_runs", float64(s.Runs), ts, serverURL, instance),
+	}
+	for _, metric := range []struct {
+		name  string
+		stats dailyStats
+	}{
+		{"librespeed_download_mbps_daily", s.Download},
+		{"librespeed_upload_mbps_daily", s.Upload},
+		{"librespeed_ping_ms_daily", s.Ping},
+		{"librespeed_jitter_ms_daily", s.Jitter},
+	} {
+		series = append(series,
+			createTimeSeries(metric.name+"_min", metric.stats.Min, ts, serverURL, instance),
+			createTimeSeries(metric.name+"_median", metric.stats.Median, ts, serverURL, instance),
+			createTimeSeries(metric.name+"_max", metric.stats.Max, ts, serverURL, instance),
+		)
+	}
+	return series
+}
+
+// The last day handled by each daily job is kept in the state dir so neither
+// later runs that day nor a restart repeat it.
+func loadLastDay(stateDir, job string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(stateDir, job))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %v", job, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func saveLastDay(stateDir, job, day string) error {
+	if err := os.MkdirAll(stateDir, 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(stateDir, job), []byte(day+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to save %s: %v", job, err)
+	}
+	return nil
+}
+
+// Runs job once for yesterday on the first run of a new day, passing it the
+// start of yesterday and the history file. Only the previous day is handled;
+// missed days are not backfilled.
+func runDailyJob(stateDir, historyFile, job string, now time.Time, do func(day time.Time, history []HistoryEntry) error) error {
+	yesterday := startOfDay(now).AddDate(0, 0, -1)
+	day := yesterday.Format(rollupDayFormat)
+
+	last, err := loadLastDay(stateDir, job)
+	if err != nil {
+		return err
+	}
+	if last == day {
+		return nil
+	}
+
+	history, err := loadHistory(historyFile)
+	if err != nil {
+		return err
+	}
+	if err := do(yesterday, history); err != nil {
+		return fmt.Errorf("%s for %s failed: %v", job, day, err)
+	}
+	return saveLastDay(stateDir, job, day)
+}
+
+// Daily jobs summarise the history file, so they can't run without one.
+func validateDailyJobs(cfg *Config) error {
+	if cfg.SheetsID != "" && cfg.SheetsCredentials == "" {
+		return fmt.Errorf("--sheets-id requires --sheets-credentials")
+	}
+	if cfg.ArchiveURL != "" {
+		archive := ArchiveConfig{URL: cfg.ArchiveURL, SSE: cfg.ArchiveSSE, KMSKeyID: cfg.ArchiveKMSKeyID}
+		if err := validateArchiveConfig(archive); err != nil {
+			return err
+		}
+	}
+	if cfg.DeliveryURL != "" {
+		delivery := DeliveryConfig{URL: cfg.DeliveryURL, Mode: cfg.DeliveryMode, KeyFile: cfg.DeliveryKey}
+		if err := validateDeliveryConfig(delivery); err != nil {
+			return err
+		}
+	}
+	if cfg.HistoryFile != "" {
+		return nil
+	}
+	switch {
+	case cfg.DailyRollup:
+		return fmt.Errorf("--daily-rollup requires --history-file")
+	case cfg.CSVExport != "":
+		return fmt.Errorf("--csv-export requires --history-file")
+	case cfg.SheetsID != "":
+		return fmt.Errorf("--sheets-id requires --history-file")
+	case cfg.ArchiveURL != "":
+		return fmt.Errorf("--archive-url requires --history-file")
+	case cfg.DeliveryURL != "" && cfg.DeliveryMode == "daily":
+		return fmt.Errorf("--delivery-mode daily requires --history-file")
+	}
+	return nil
+}
+
+// Receivers reject samples much older than their head block, which is why
+// only yesterday is pushed.
+func (rc *runContext) pushDailyRollup(ctx context.Context, now time.Time, serverURL string) error {
+	cfg := rc.cfg
+	return runDailyJob(cfg.StateDir, cfg.HistoryFile, "last_rollup", now, func(day time.Time, history []HistoryEntry) error {
+		summary := summarizeDay(history, day)
+		if summary == nil {
+			return nil
+		}
+		series := summary.series(serverURL, rc.hostname)
+		addLabels(series, rc.extraLabels)
+		send := func() error {
+			return sendWithEncoder(ctx, rc.encoder, rc.discovery.url(cfg.URL), cfg.Username, cfg.Password, series)
+		}
+		if err := sendWithRetry(ctx, send, 3); err != nil {
+			return err
+		}
+		log.Printf("Pushed daily rollup for %s", summary.Day.Format(rollupDayFormat))
+		return nil
+	})
+}
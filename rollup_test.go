@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDailySummarySeries(t *testing.T) {
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	history := []HistoryEntry{
+		{Timestamp: day.Add(-time.Minute), Download: 999},
+		{Timestamp: day.Add(1 * time.Hour), Download: 80, Upload: 20, Ping: 15, Jitter: 2},
+		{Timestamp: day.Add(9 * time.Hour), Download: 100, Upload: 40, Ping: 10, Jitter: 1},
+		{Timestamp: day.Add(20 * time.Hour), Download: 60, Upload: 30, Ping: 30, Jitter: 5},
+		{Timestamp: day.Add(24 * time.Hour), Download: 1},
+	}
+
+	series := summarizeDay(history, day).series("http://server", "host1")
+	if len(series) != 13 {
+		t.Fatalf("Expected 13 series (count + 4 measurements x 3 stats), got %d", len(series))
+	}
+
+	values := map[string]float64{}
+	for _, ts := range series {
+		values[getLabelValue(ts.Labels, "__name__")] = ts.Samples[0].Value
+		if want := day.Add(24*time.Hour - time.Millisecond).UnixMilli(); ts.Samples[0].Timestamp != want {
+			t.Errorf("Expected %s timestamped at day end, got %d", getLabelValue(ts.Labels, "__name__"), ts.Samples[0].Timestamp)
+		}
+	}
+	expected := map[string]float64{
+		"librespeed_daily_runs":                 3,
+		"librespeed_download_mbps_daily_min":    60,
+		"librespeed_download_mbps_daily_median": 80,
+		"librespeed_download_mbps_daily_max":    100,
+		"librespeed_ping_ms_daily_max":          30,
+		"librespeed_jitter_ms_daily_median":     2,
+	}
+	for name, want := range expected {
+		if values[name] != want {
+			t.Errorf("Expected %s = %v, got %v", name, want, values[name])
+		}
+	}
+}
+
+func TestSummarizeDay_NoRuns(t *testing.T) {
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	history := []HistoryEntry{{Timestamp: day.Add(48 * time.Hour), Download: 1}}
+	if summary := summarizeDay(history, day); summary != nil {
+		t.Errorf("Expected no summary for a day without runs, got %+v", summary)
+	}
+}
+
+func TestPushDailyRollup_OncePerDay(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	rc := newTestRunContext(t, server.URL, &MockRunner{})
+	rc.cfg.StateDir = dir
+	rc.cfg.HistoryFile = filepath.Join(dir, "history.json")
+
+	now := time.Date(2024, 5, 2, 0, 10, 0, 0, time.Local)
+	history := []HistoryEntry{
+		{Timestamp: now.Add(-3 * time.Hour), Download: 50},
+		{Timestamp: now, Download: 70},
+	}
+	if err := saveHistory(rc.cfg.HistoryFile, history, 0, 0); err != nil {
+		t.Fatalf("Failed to save history: %v", err)
+	}
+
+	if err := rc.pushDailyRollup(context.Background(), now, "http://server"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := rc.pushDailyRollup(context.Background(), now.Add(time.Hour), "http://server"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if requests != 1 {
+		t.Errorf("Expected the rollup to be pushed once, got %d pushes", requests)
+	}
+
+	day, err := loadLastDay(dir, "last_rollup")
+	if err != nil || day != "2024-05-01" {
+		t.Errorf("Expected last rollup day 2024-05-01, got %q (%v)", day, err)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Runs kept for GET /api/v1/run/{id}; older ones are forgotten.
+const maxAPIRuns = 100
+
+type apiRun struct {
+	ID       string     `json:"id"`
+	Status   string     `json:"status"`
+	Queued   time.Time  `json:"queued"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Error    string     `json:"error,omitempty"`
+	Result   *RunRecord `json:"result,omitempty"`
+}
+
+// On-demand runs triggered over HTTP. Tests go through the same runContext
+// as scheduled runs, so results are pushed to every configured sink.
+type runAPI struct {
+	ctx         context.Context
+	run         func(ctx context.Context) (*RunRecord, error)
+	audit       *auditLog
+	maintenance *maintenanceSwitch
+	webhook     *webhookAuth
+	limits      *triggerLimiter
+
+	mu    sync.Mutex
+	runs  map[string]*apiRun
+	order []string
+	// The latest run still queued or running
+	pending string
+}
+
+func newRunAPI(ctx context.Context, run func(ctx context.Context) (*RunRecord, error)) *runAPI {
+	return &runAPI{ctx: ctx, run: run, runs: map[string]*apiRun{}, limits: &triggerLimiter{maxActive: 1}}
+}
+
+func (a *runAPI) register(mux *http.ServeMux) {
+	mux.HandleFunc("POST /api/v1/run", a.handleTrigger)
+	mux.HandleFunc("GET /api/v1/run/{id}", a.handleStatus)
+	if a.webhook != nil {
+		mux.HandleFunc("POST /api/v1/webhook", a.handleWebhook)
+	}
+	if a.maintenance != nil {
+		mux.HandleFunc("GET /api/v1/maintenance", a.handleMaintenance)
+		mux.HandleFunc("PUT /api/v1/maintenance", a.handleMaintenance)
+		mux.HandleFunc("DELETE /api/v1/maintenance", a.handleMaintenance)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (a *runAPI) handleTrigger(w http.ResponseWriter, r *http.Request) {
+	a.trigger(w, r, runRequest{}, "")
+}
+
+// Inbound trigger for incident tooling: the body, optionally
+// {"server_id": 7, "profile": "night", "reason": "INC-1234"}, is signed with
+// the shared secret instead of carrying an API token.
+func (a *runAPI) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	if !a.webhook.enabled() {
+		http.NotFound(w, r)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<16))
+	if err != nil {
+		http.Error(w, "failed to read request body", http.StatusBadRequest)
+		return
+	}
+	if err := a.webhook.verify(r, body); err != nil {
+		log.Printf("WARNING: Rejected webhook from %s: %v", r.RemoteAddr, err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	var payload struct {
+		runRequest
+		Reason string `json:"reason"`
+	}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &payload); err != nil {
+			http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if payload.ServerID < 0 {
+		http.Error(w, "server_id must be positive", http.StatusBadRequest)
+		return
+	}
+	a.trigger(w, r, payload.runRequest, payload.Reason)
+}
+
+// Starts a test in the background and answers 202 with its ID. A caller
+// over its rate limit gets 429, and a request while the limit of
+// outstanding runs is reached gets 409 with the latest run in progress.
+func (a *runAPI) trigger(w http.ResponseWriter, r *http.Request, req runRequest, reason string) {
+	release, err := a.limits.acquire(triggerCaller(tokenName(r.Context()), r.RemoteAddr))
+	var limited *rateLimitError
+	if errors.As(err, &limited) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(limited.retryAfter.Seconds()))))
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	a.mu.Lock()
+	if err != nil {
+		pending, ok := a.runs[a.pending]
+		var run apiRun
+		if ok {
+			run = *pending
+		}
+		a.mu.Unlock()
+		if !ok {
+			// Held by a gRPC RunTest
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		writeJSON(w, http.StatusConflict, run)
+		return
+	}
+	id, err := newUUID()
+	if err != nil {
+		a.mu.Unlock()
+		release()
+		http.Error(w, "failed to generate run ID", http.StatusInternalServerError)
+		return
+	}
+	run := &apiRun{ID: id, Status: "queued", Queued: clock.Now().UTC()}
+	a.runs[id] = run
+	a.order = append(a.order, id)
+	if len(a.order) > maxAPIRuns {
+		delete(a.runs, a.order[0])
+		a.order = a.order[1:]
+	}
+	a.pending = id
+	snapshot := *run
+	a.mu.Unlock()
+
+	detail := "run " + id
+	for _, part := range []string{req.String(), reason} {
+		if part != "" {
+			detail += "; " + part
+		}
+	}
+	log.Printf("Run %s triggered over %s from %s", detail, r.URL.Path, r.RemoteAddr)
+	a.audit.record("run_triggered", requestActor(r), detail)
+	go func() {
+		defer release()
+		a.execute(withRunRequest(a.ctx, req), run)
+	}()
+
+	w.Header().Set("Location", "/api/v1/run/"+id)
+	writeJSON(w, http.StatusAccepted, snapshot)
+}
+
+func (a *runAPI) execute(ctx context.Context, run *apiRun) {
+	a.mu.Lock()
+	started := clock.Now().UTC()
+	run.Status = "running"
+	run.Started = &started
+	a.mu.Unlock()
+
+	record, err := a.run(ctx)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	finished := clock.Now().UTC()
+	run.Finished = &finished
+	run.Result = record
+	run.Status = "succeeded"
+	if err != nil {
+		run.Status = "failed"
+		run.Error = err.Error()
+	}
+	if a.pending == run.ID {
+		a.pending = ""
+	}
+}
+
+func (a *runAPI) handleStatus(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	run, ok := a.runs[r.PathValue("id")]
+	var snapshot apiRun
+	if ok {
+		snapshot = *run
+	}
+	a.mu.Unlock()
+
+	if !ok {
+		http.Error(w, "run not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, snapshot)
+}
+
+// PUT enters maintenance mode, with an optional {"reason": "..."} body, and
+// DELETE leaves it; all three methods answer with the current state.
+func (a *runAPI) handleMaintenance(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPut:
+		var body struct {
+			Reason string `json:"reason"`
+		}
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<16)).Decode(&body); err != nil && err != io.EOF {
+			http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+			return
+		}
+		a.maintenance.set(true, body.Reason)
+		log.Printf("Maintenance mode entered over the API from %s: %s", r.RemoteAddr, body.Reason)
+		a.audit.record("maintenance_on", requestActor(r), body.Reason)
+	case http.MethodDelete:
+		a.maintenance.set(false, "")
+		log.Printf("Maintenance mode left over the API from %s", r.RemoteAddr)
+		a.audit.record("maintenance_off", requestActor(r), "")
+	}
+	writeJSON(w, http.StatusOK, a.maintenance.status())
+}
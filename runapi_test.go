@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestRunAPIServer(t *testing.T, run func(ctx context.Context) (*RunRecord, error)) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	newRunAPI(context.Background(), run).register(mux)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func getAPIRun(t *testing.T, url string) (int, apiRun) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s failed: %v", url, err)
+	}
+	defer resp.Body.Close()
+	var run apiRun
+	json.NewDecoder(resp.Body).Decode(&run)
+	return resp.StatusCode, run
+}
+
+func waitForAPIRun(t *testing.T, url string) apiRun {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		_, run := getAPIRun(t, url)
+		if run.Status == "succeeded" || run.Status == "failed" {
+			return run
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Run at %s did not finish", url)
+	return apiRun{}
+}
+
+func TestRunAPI_TriggerAndPoll(t *testing.T) {
+	release := make(chan struct{})
+	server := newTestRunAPIServer(t, func(ctx context.Context) (*RunRecord, error) {
+		<-release
+		record := newRunRecord("", &LibrespeedResult{Download: 95, Upload: 40}, nil, time.Second)
+		return &record, nil
+	})
+
+	resp, err := http.Post(server.URL+"/api/v1/run", "", nil)
+	if err != nil {
+		t.Fatalf("POST failed: %v", err)
+	}
+	var queued apiRun
+	json.NewDecoder(resp.Body).Decode(&queued)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted || queued.ID == "" {
+		t.Fatalf("Expected 202 with a run ID, got %d %+v", resp.StatusCode, queued)
+	}
+	location := resp.Header.Get("Location")
+	if location != "/api/v1/run/"+queued.ID {
+		t.Errorf("Unexpected Location header: %s", location)
+	}
+
+	// A second trigger while the first is outstanding points at the first
+	resp, err = http.Post(server.URL+"/api/v1/run", "", nil)
+	if err != nil {
+		t.Fatalf("POST failed: %v", err)
+	}
+	var conflict apiRun
+	json.NewDecoder(resp.Body).Decode(&conflict)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict || conflict.ID != queued.ID {
+		t.Errorf("Expected 409 for run %s, got %d %+v", queued.ID, resp.StatusCode, conflict)
+	}
+
+	close(release)
+	run := waitForAPIRun(t, server.URL+location)
+	if run.Status != "succeeded" || run.Result == nil || run.Result.Download != 95 {
+		t.Errorf("Expected a succeeded run with results, got %+v", run)
+	}
+	if run.Started == nil || run.Finished == nil {
+		t.Errorf("Expected start and finish times, got %+v", run)
+	}
+}
+
+func TestRunAPI_FailedRun(t *testing.T) {
+	server := newTestRunAPIServer(t, func(ctx context.Context) (*RunRecord, error) {
+		return nil, fmt.Errorf("librespeed-cli failed")
+	})
+
+	resp, err := http.Post(server.URL+"/api/v1/run", "", nil)
+	if err != nil {
+		t.Fatalf("POST failed: %v", err)
+	}
+	resp.Body.Close()
+
+	run := waitForAPIRun(t, server.URL+resp.Header.Get("Location"))
+	if run.Status != "failed" || run.Error != "librespeed-cli failed" {
+		t.Errorf("Expected a failed run with its error, got %+v", run)
+	}
+}
+
+func TestRunAPI_UnknownRun(t *testing.T) {
+	server := newTestRunAPIServer(t, func(ctx context.Context) (*RunRecord, error) { return nil, nil })
+	if status, _ := getAPIRun(t, server.URL+"/api/v1/run/does-not-exist"); status != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", status)
+	}
+	resp, err := http.Get(server.URL + "/api/v1/run")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for GET on the trigger endpoint, got %d", resp.StatusCode)
+	}
+}
+
+func TestRunAPI_RateLimit(t *testing.T) {
+	api := newRunAPI(context.Background(), func(ctx context.Context) (*RunRecord, error) { return nil, nil })
+	api.limits = &triggerLimiter{}
+	api.limits.set(1, 5)
+	mux := http.NewServeMux()
+	api.register(mux)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/api/v1/run", "", nil)
+	if err != nil {
+		t.Fatalf("POST failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("Expected the first run to start, got %d", resp.StatusCode)
+	}
+	waitForAPIRun(t, server.URL+resp.Header.Get("Location"))
+
+	resp, err = http.Post(server.URL+"/api/v1/run", "", nil)
+	if err != nil {
+		t.Fatalf("POST failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("Expected 429 with Retry-After over the hourly limit, got %d %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+}
+
+func TestRunAPI_Maintenance(t *testing.T) {
+	api := newRunAPI(context.Background(), func(ctx context.Context) (*RunRecord, error) { return nil, nil })
+	api.maintenance = &maintenanceSwitch{}
+	mux := http.NewServeMux()
+	api.register(mux)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	call := func(method, body string) maintenanceStatus {
+		t.Helper()
+		req, _ := http.NewRequest(method, server.URL+"/api/v1/maintenance", strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s failed: %v", method, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", method, resp.StatusCode)
+		}
+		var status maintenanceStatus
+		json.NewDecoder(resp.Body).Decode(&status)
+		return status
+	}
+
+	if status := call("GET", ""); status.Active {
+		t.Errorf("Expected maintenance off initially, got %+v", status)
+	}
+	if status := call("PUT", `{"reason":"CHG-1234 circuit upgrade"}`); !status.Active || status.Reason != "CHG-1234 circuit upgrade" {
+		t.Errorf("Expected maintenance on with the reason, got %+v", status)
+	}
+	if status := call("GET", ""); !status.Active || status.Since == nil {
+		t.Errorf("Expected maintenance to stay on, got %+v", status)
+	}
+	if status := call("DELETE", ""); status.Active {
+		t.Errorf("Expected maintenance off, got %+v", status)
+	}
+	if status := call("PUT", ""); !status.Active || status.Reason != "" {
+		t.Errorf("Expected a body to be optional, got %+v", status)
+	}
+
+	req, _ := http.NewRequest("PUT", server.URL+"/api/v1/maintenance", strings.NewReader("{"))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("PUT failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid body, got %d", resp.StatusCode)
+	}
+}
+
+func TestRunWithRecord_ReturnsRecord(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	runner := &MockRunner{Output: []byte(`[{"download":100.0,"upload":50.0,"ping":10.0,"jitter":1.0,"server":{"url":"http://example.com"}}]`)}
+	rc := newTestRunContext(t, server.URL, runner)
+	record, err := rc.runWithRecord(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if record == nil || record.Status != "success" || record.Download != 100 {
+		t.Errorf("Expected the success record, got %+v", record)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+var errAlreadyRunning = errors.New("another speed test is already running")
+
+// Exit status of a single run skipped by --run-lock skip.
+const exitAlreadyRunning = 3
+
+// How often a waiting run retries the lock.
+var runLockPoll = time.Second
+
+// Where the run lock lives. It is the same for every exporter on the
+// machine whatever its --state-dir, user or TMPDIR, so a service and a cron
+// job always contend for the same file; replaced by tests.
+var runLockPath = func() string {
+	switch runtime.GOOS {
+	case "windows":
+		dir := os.Getenv("ProgramData")
+		if dir == "" {
+			dir = `C:\ProgramData`
+		}
+		return filepath.Join(dir, "librespeed_exporter", "run.lock")
+	case "linux":
+		if info, err := os.Stat("/run/lock"); err == nil && info.IsDir() {
+			return "/run/lock/librespeed_exporter.lock"
+		}
+	}
+	return "/tmp/librespeed_exporter.lock"
+}()
+
+// Lock file held for the duration of each test, so a daemon, a cron job and
+// a manual run on the same machine never measure at the same time. The OS
+// releases it if the process dies. A nil *runLock never blocks.
+type runLock struct {
+	path string
+}
+
+func newRunLock() *runLock {
+	return &runLock{path: runLockPath}
+}
+
+// Takes the lock, waiting for the current holder to finish when wait is set
+// and returning errAlreadyRunning otherwise. The returned func releases it.
+func (l *runLock) acquire(ctx context.Context, wait bool) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	os.MkdirAll(filepath.Dir(l.path), 0755)
+	f, err := os.OpenFile(l.path, os.O_RDWR|os.O_CREATE, 0666)
+	if os.IsPermission(err) {
+		// Created by another user; locking only needs read access
+		f, err = os.Open(l.path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open run lock: %v", err)
+	}
+	for logged := false; ; logged = true {
+		locked, err := tryLockFile(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to take run lock %s: %v", l.path, err)
+		}
+		if locked {
+			break
+		}
+		if !wait {
+			f.Close()
+			return nil, errAlreadyRunning
+		}
+		if !logged {
+			log.Printf("Another speed test holds %s, waiting for it to finish", l.path)
+		}
+		select {
+		case <-ctx.Done():
+			f.Close()
+			return nil, ctx.Err()
+		case <-clock.After(runLockPoll):
+		}
+	}
+	return func() {
+		unlockFile(f)
+		f.Close()
+	}, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func tempRunLockPath(t *testing.T) {
+	t.Helper()
+	original := runLockPath
+	runLockPath = filepath.Join(t.TempDir(), "run.lock")
+	t.Cleanup(func() { runLockPath = original })
+}
+
+func TestRunLock_Skip(t *testing.T) {
+	tempRunLockPath(t)
+	lock := newRunLock()
+	release, err := lock.acquire(context.Background(), false)
+	if err != nil {
+		t.Fatalf("Failed to take the lock: %v", err)
+	}
+	if _, err := lock.acquire(context.Background(), false); !errors.Is(err, errAlreadyRunning) {
+		t.Errorf("Expected errAlreadyRunning while held, got %v", err)
+	}
+	release()
+	release, err = lock.acquire(context.Background(), false)
+	if err != nil {
+		t.Fatalf("Expected the lock to be free after release, got %v", err)
+	}
+	release()
+}
+
+func TestRunLock_Wait(t *testing.T) {
+	originalPoll := runLockPoll
+	runLockPoll = 10 * time.Millisecond
+	defer func() { runLockPoll = originalPoll }()
+
+	tempRunLockPath(t)
+	lock := newRunLock()
+	release, err := lock.acquire(context.Background(), true)
+	if err != nil {
+		t.Fatalf("Failed to take the lock: %v", err)
+	}
+	time.AfterFunc(50*time.Millisecond, release)
+
+	start := time.Now()
+	second, err := lock.acquire(context.Background(), true)
+	if err != nil {
+		t.Fatalf("Expected to get the lock after waiting, got %v", err)
+	}
+	second()
+	if time.Since(start) < 40*time.Millisecond {
+		t.Error("Expected to wait for the first holder")
+	}
+
+	release, _ = lock.acquire(context.Background(), true)
+	defer release()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	if _, err := lock.acquire(ctx, true); err != context.DeadlineExceeded {
+		t.Errorf("Expected the wait to end with the context, got %v", err)
+	}
+}
+
+func TestRun_SkipsWhileLocked(t *testing.T) {
+	runner := &MockRunner{Output: []byte(`[{"download":100.0,"upload":50.0,"ping":10.0,"jitter":1.0,"server":{"url":"http://example.com"}}]`)}
+	rc := newTestRunContext(t, "", runner)
+	rc.cfg.URL = ""
+	rc.cfg.RunLock = "skip"
+	rc.cache = &resultCache{}
+	tempRunLockPath(t)
+	rc.runLock = newRunLock()
+
+	release, err := rc.runLock.acquire(context.Background(), false)
+	if err != nil {
+		t.Fatalf("Failed to take the lock: %v", err)
+	}
+	defer release()
+
+	if err := rc.runOnce(context.Background()); !errors.Is(err, errAlreadyRunning) {
+		t.Errorf("Expected errAlreadyRunning, got %v", err)
+	}
+	if runner.Calls != 0 {
+		t.Errorf("Expected librespeed-cli not to run, got %d calls", runner.Calls)
+	}
+	if len(rc.cache.series) != 1 || rc.cache.series[0].Labels[0].Value != "librespeed_run_skipped" {
+		t.Errorf("Expected only librespeed_run_skipped in the cache, got %v", rc.cache.series)
+	}
+}
+
+// Runs a func as librespeed-cli.
+type runnerFunc func(name string, args ...string) ([]byte, error)
+
+func (f runnerFunc) Run(name string, args ...string) ([]byte, error) { return f(name, args...) }
+
+func TestRun_LockIgnoresStateDir(t *testing.T) {
+	tempRunLockPath(t)
+	output := []byte(`[{"download":100.0,"upload":50.0,"ping":10.0,"jitter":1.0,"server":{"url":"http://example.com"}}]`)
+	newRC := func(runner CommandRunner) *runContext {
+		rc := newTestRunContext(t, "", runner)
+		rc.cfg.URL = ""
+		rc.cfg.RunLock = "skip"
+		rc.cfg.StateDir = t.TempDir()
+		rc.runLock = newRunLock()
+		return rc
+	}
+
+	// A service and a cron job with different state directories
+	second := newRC(&MockRunner{Output: output})
+	var secondErr error
+	first := newRC(runnerFunc(func(name string, args ...string) ([]byte, error) {
+		secondErr = second.runOnce(context.Background())
+		return output, nil
+	}))
+	if err := first.runOnce(context.Background()); err != nil {
+		t.Fatalf("Expected the first run to succeed, got %v", err)
+	}
+	if !errors.Is(secondErr, errAlreadyRunning) {
+		t.Errorf("Expected the run with another --state-dir to find the lock held, got %v", secondErr)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// Runs test n times back to back and returns the results that succeeded.
+// Fails only if none did, or when ctx is cancelled.
+func runSamples(ctx context.Context, n int, test func() (*LibrespeedResult, error)) ([]*LibrespeedResult, error) {
+	var results []*LibrespeedResult
+	var lastErr error
+	for i := 1; i <= n; i++ {
+		result, err := test()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			log.Printf("WARNING: Sample %d/%d failed: %v", i, n, err)
+			lastErr = err
+			continue
+		}
+		log.Printf("Sample %d/%d: Download %.2f Mbps, Upload %.2f Mbps, Ping %.2f ms", i, n, result.Download, result.Upload, result.Ping)
+		results = append(results, result)
+	}
+	if len(results) == 0 {
+		return nil, fmt.Errorf("all %d samples failed, last error: %v", n, lastErr)
+	}
+	return results, nil
+}
+
+type sampleStats struct {
+	Median, Mean, Min, Max float64
+}
+
+func summarizeSamples(values []float64) sampleStats {
+	stats := sampleStats{Median: percentile(values, 0.5), Min: values[0], Max: values[0]}
+	for _, v := range values {
+		stats.Mean += v
+		stats.Min = min(stats.Min, v)
+		stats.Max = max(stats.Max, v)
+	}
+	stats.Mean /= float64(len(values))
+	return stats
+}
+
+// Per-metric values of the samples, in the order the series are emitted.
+func sampleValues(results []*LibrespeedResult) []struct {
+	name   string
+	values []float64
+} {
+	metrics := []struct {
+		name   string
+		values []float64
+	}{
+		{"librespeed_download_mbps", nil},
+		{"librespeed_upload_mbps", nil},
+		{"librespeed_ping_ms", nil},
+		{"librespeed_jitter_ms", nil},
+	}
+	for _, r := range results {
+		metrics[0].values = append(metrics[0].values, r.Download)
+		metrics[1].values = append(metrics[1].values, r.Upload)
+		metrics[2].values = append(metrics[2].values, r.Ping)
+		metrics[3].values = append(metrics[3].values, r.Jitter)
+	}
+	return metrics
+}
+
+// The result the rest of the run works with: the first sample with each
+// measurement replaced by the median of all samples.
+func aggregateSamples(results []*LibrespeedResult) *LibrespeedResult {
+	aggregate := *results[0]
+	metrics := sampleValues(results)
+	aggregate.Download = summarizeSamples(metrics[0].values).Median
+	aggregate.Upload = summarizeSamples(metrics[1].values).Median
+	aggregate.Ping = summarizeSamples(metrics[2].values).Median
+	aggregate.Jitter = summarizeSamples(metrics[3].values).Median
+	return &aggregate
+}
+
+// librespeed_<metric>_{median,mean,min,max} and librespeed_samples, the
+// number of samples that succeeded.
+func sampleSeries(results []*LibrespeedResult, now int64, serverURL, instance string) []*prompb.TimeSeries {
+	series := []*prompb.TimeSeries{
+		createTimeSeries("librespeed_samples", float64(len(results)), now, serverURL, instance),
+	}
+	for _, metric := range sampleValues(results) {
+		stats := summarizeSamples(metric.values)
+		series = append(series,
+			createTimeSeries(metric.name+"_median", stats.Median, now, serverURL, instance),
+			createTimeSeries(metric.name+"_mean", stats.Mean, now, serverURL, instance),
+			createTimeSeries(metric.name+"_min", stats.Min, now, serverURL, instance),
+			createTimeSeries(metric.name+"_max", stats.Max, now, serverURL, instance),
+		)
+	}
+	return series
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestSummarizeSamples(t *testing.T) {
+	stats := summarizeSamples([]float64{90, 20, 100, 70})
+	if stats.Median != 70 || stats.Mean != 70 || stats.Min != 20 || stats.Max != 100 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestRunSamples(t *testing.T) {
+	calls := 0
+	results, err := runSamples(context.Background(), 3, func() (*LibrespeedResult, error) {
+		calls++
+		if calls == 2 {
+			return nil, errors.New("exit 1")
+		}
+		return &LibrespeedResult{Download: float64(calls)}, nil
+	})
+	if err != nil {
+		t.Fatalf("Expected the failed sample to be skipped, got %v", err)
+	}
+	if calls != 3 || len(results) != 2 {
+		t.Errorf("Expected 3 runs and 2 results, got %d and %d", calls, len(results))
+	}
+
+	_, err = runSamples(context.Background(), 2, func() (*LibrespeedResult, error) {
+		return nil, errors.New("exit 1")
+	})
+	if err == nil {
+		t.Error("Expected an error when every sample fails")
+	}
+}
+
+func TestRun_ReportsSamples(t *testing.T) {
+	runner := &MockRunner{Output: []byte(`[{"download":100.0,"upload":50.0,"ping":10.0,"jitter":1.0,"server":{"url":"http://example.com"}}]`)}
+	rc := newTestRunContext(t, "", runner)
+	rc.cfg.URL = ""
+	rc.cfg.Samples = 3
+	rc.cache = &resultCache{}
+
+	if err := rc.runOnce(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if runner.Calls != 3 {
+		t.Errorf("Expected 3 tests, got %d", runner.Calls)
+	}
+	values := map[string]float64{}
+	for _, ts := range rc.cache.series {
+		values[ts.Labels[0].Value] = ts.Samples[0].Value
+	}
+	for name, want := range map[string]float64{
+		"librespeed_download_mbps":        100,
+		"librespeed_download_mbps_median": 100,
+		"librespeed_upload_mbps_mean":     50,
+		"librespeed_ping_ms_min":          10,
+		"librespeed_jitter_ms_max":        1,
+		"librespeed_samples":              3,
+	} {
+		if got, ok := values[name]; !ok || got != want {
+			t.Errorf("Expected %s %v, got %v (present %v)", name, want, got, ok)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Schedule decides when the daemon runs next.
+type Schedule interface {
+	Next(after time.Time) time.Time
+}
+
+// Fixed cadence anchored at start, like a ticker: a run that overruns its
+// slot skips the missed ticks instead of drifting.
+type intervalSchedule struct {
+	start    time.Time
+	interval time.Duration
+}
+
+func (s intervalSchedule) Next(after time.Time) time.Time {
+	if after.Before(s.start) {
+		return s.start
+	}
+	n := after.Sub(s.start)/s.interval + 1
+	return s.start.Add(n * s.interval)
+}
+
+// Several cron expressions, firing at the earliest of them. Lets
+// "every 15 minutes in business hours, hourly otherwise" be written as two
+// plain expressions.
+type cronSchedules []cron.Schedule
+
+func (s cronSchedules) Next(after time.Time) time.Time {
+	var next time.Time
+	for _, schedule := range s {
+		t := schedule.Next(after)
+		if !t.IsZero() && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+	return next
+}
+
+// Parses ";"-separated standard 5-field cron expressions (descriptors such as
+// @hourly and a CRON_TZ= prefix are accepted too). Expressions that never
+// fire are rejected.
+func parseCronSchedule(spec string) (Schedule, error) {
+	var schedules cronSchedules
+	for _, expression := range strings.Split(spec, ";") {
+		expression = strings.TrimSpace(expression)
+		if expression == "" {
+			continue
+		}
+		schedule, err := cron.ParseStandard(expression)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %v", expression, err)
+		}
+		// robfig/cron gives up after five years, e.g. for 0 0 30 2 *, and
+		// returns the zero time
+		if schedule.Next(clock.Now()).IsZero() {
+			return nil, fmt.Errorf("cron expression %q never fires", expression)
+		}
+		schedules = append(schedules, schedule)
+	}
+	if len(schedules) == 0 {
+		return nil, fmt.Errorf("empty schedule")
+	}
+	return schedules, nil
+}
+
+// Fires peak inside the --peak-hours windows and offPeak outside them, so
+// tests can be frequent at night and sparse during the working day.
+type peakSchedule struct {
+	offPeak, peak Schedule
+	hours         QuietHours
+}
+
+func (s peakSchedule) Next(after time.Time) time.Time {
+	next := func(schedule Schedule, peak bool) time.Time {
+		t := schedule.Next(after)
+		for i := 0; i < maxCalendarSteps && s.hours.contains(t) != peak; i++ {
+			t = schedule.Next(t)
+		}
+		return t
+	}
+	offPeak, peak := next(s.offPeak, false), next(s.peak, true)
+	if peak.Before(offPeak) {
+		return peak
+	}
+	return offPeak
+}
+
+// Returns nil when the process should run once and exit.
+func newSchedule(interval time.Duration, spec string, now time.Time) (Schedule, error) {
+	if interval > 0 && spec != "" {
+		return nil, fmt.Errorf("--interval and --schedule are mutually exclusive")
+	}
+	if spec != "" {
+		return parseCronSchedule(spec)
+	}
+	if interval > 0 {
+		return intervalSchedule{start: now, interval: interval}, nil
+	}
+	return nil, nil
+}
+
+// Overridden in tests.
+var jitterFunc = func(max time.Duration) time.Duration {
+	return time.Duration(rand.Int63n(int64(max)))
+}
+
+// Waits a random part of jitter so a fleet on the same schedule doesn't hit
+// the speedtest server all at once. Returns false if ctx is cancelled first.
+func waitJitter(ctx context.Context, jitter time.Duration) bool {
+	if jitter <= 0 {
+		return true
+	}
+	delay := jitterFunc(jitter)
+	log.Printf("Delaying run by %v (schedule jitter)", delay.Round(time.Second))
+	timer := clock.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C():
+		return true
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIntervalSchedule(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	s := intervalSchedule{start: start, interval: 15 * time.Minute}
+
+	testCases := []struct {
+		after    time.Time
+		expected time.Time
+	}{
+		{start, start.Add(15 * time.Minute)},
+		{start.Add(3 * time.Minute), start.Add(15 * time.Minute)},
+		// A run overrunning two slots skips them rather than drifting
+		{start.Add(31 * time.Minute), start.Add(45 * time.Minute)},
+	}
+	for _, tc := range testCases {
+		if got := s.Next(tc.after); !got.Equal(tc.expected) {
+			t.Errorf("Next(%v): expected %v, got %v", tc.after, tc.expected, got)
+		}
+	}
+}
+
+func TestParseCronSchedule_MultipleExpressions(t *testing.T) {
+	s, err := parseCronSchedule("*/15 8-17 * * 1-5; 0 0-7,18-23 * * *")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// Wednesday 2024-05-01
+	testCases := []struct {
+		after    time.Time
+		expected time.Time
+	}{
+		{time.Date(2024, 5, 1, 9, 2, 0, 0, time.Local), time.Date(2024, 5, 1, 9, 15, 0, 0, time.Local)},
+		{time.Date(2024, 5, 1, 17, 50, 0, 0, time.Local), time.Date(2024, 5, 1, 18, 0, 0, 0, time.Local)},
+		{time.Date(2024, 5, 1, 22, 10, 0, 0, time.Local), time.Date(2024, 5, 1, 23, 0, 0, 0, time.Local)},
+	}
+	for _, tc := range testCases {
+		if got := s.Next(tc.after); !got.Equal(tc.expected) {
+			t.Errorf("Next(%v): expected %v, got %v", tc.after, tc.expected, got)
+		}
+	}
+}
+
+func TestPeakSchedule(t *testing.T) {
+	// Every 15 minutes overnight, every 2 hours from 08:00 to 18:00
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	hours, _ := parseQuietHours("08:00-18:00")
+	s := peakSchedule{
+		offPeak: intervalSchedule{start: start, interval: 15 * time.Minute},
+		peak:    intervalSchedule{start: start, interval: 2 * time.Hour},
+		hours:   hours,
+	}
+
+	testCases := []struct {
+		after    time.Time
+		expected time.Time
+	}{
+		{start.Add(3 * time.Hour), start.Add(3*time.Hour + 15*time.Minute)},
+		{start.Add(7*time.Hour + 50*time.Minute), start.Add(8 * time.Hour)},
+		{start.Add(8 * time.Hour), start.Add(10 * time.Hour)},
+		{start.Add(16 * time.Hour), start.Add(18 * time.Hour)},
+		{start.Add(18 * time.Hour), start.Add(18*time.Hour + 15*time.Minute)},
+	}
+	for _, tc := range testCases {
+		if got := s.Next(tc.after); !got.Equal(tc.expected) {
+			t.Errorf("Next(%v): expected %v, got %v", tc.after, tc.expected, got)
+		}
+	}
+}
+
+func TestConfigure_PeakHours(t *testing.T) {
+	configure := func(args ...string) (*runContext, error) {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		cfg := &Config{}
+		cfg.RegisterFlags(fs)
+		if err := fs.Parse(append([]string{"--url", "http://localhost:9090/api/v1/write", "--username", "u", "--password", "p", "--state-dir", t.TempDir()}, args...)); err != nil {
+			t.Fatal(err)
+		}
+		rc := &runContext{flags: fs}
+		return rc, rc.configure(cfg, explicitFlags(fs))
+	}
+	for _, args := range [][]string{
+		{"--peak-hours", "08:00-18:00", "--peak-interval", "2h"},
+		{"--interval", "15m", "--peak-hours", "08:00-18:00"},
+		{"--interval", "15m", "--peak-interval", "2h"},
+		{"--interval", "15m", "--peak-hours", "08:00-18:00", "--peak-interval", "2h", "--peak-schedule", "@hourly"},
+		{"--interval", "15m", "--peak-hours", "8-18", "--peak-interval", "2h"},
+		{"--interval", "15m", "--schedule-jitter", "10m", "--peak-hours", "08:00-18:00", "--peak-interval", "5m"},
+	} {
+		if _, err := configure(args...); err == nil {
+			t.Errorf("Expected %v to be rejected", args)
+		}
+	}
+	rc, err := configure("--interval", "15m", "--peak-hours", "08:00-18:00", "--peak-schedule", "0 */2 * * *")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if s, ok := rc.schedule.(peakSchedule); !ok || len(s.hours) != 1 {
+		t.Errorf("Expected a peak schedule, got %#v", rc.schedule)
+	}
+}
+
+func TestNewSchedule(t *testing.T) {
+	now := time.Now()
+	if s, err := newSchedule(0, "", now); s != nil || err != nil {
+		t.Errorf("Expected one-shot mode, got %v, %v", s, err)
+	}
+	if _, err := newSchedule(time.Hour, "@hourly", now); err == nil {
+		t.Error("Expected error when both --interval and --schedule are set, got nil")
+	}
+	if _, err := newSchedule(0, "61 * * * *", now); err == nil {
+		t.Error("Expected error for invalid cron expression, got nil")
+	}
+	if s, err := newSchedule(0, "@hourly", now); s == nil || err != nil {
+		t.Errorf("Expected cron schedule, got %v, %v", s, err)
+	}
+}
+
+func TestWaitJitter(t *testing.T) {
+	original := jitterFunc
+	defer func() { jitterFunc = original }()
+
+	var gotMax time.Duration
+	jitterFunc = func(max time.Duration) time.Duration {
+		gotMax = max
+		return time.Millisecond
+	}
+	if !waitJitter(context.Background(), 5*time.Minute) {
+		t.Error("Expected the wait to complete")
+	}
+	if gotMax != 5*time.Minute {
+		t.Errorf("Expected jitter drawn up to 5m, got %v", gotMax)
+	}
+
+	jitterFunc = func(max time.Duration) time.Duration { return time.Hour }
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if waitJitter(ctx, 2*time.Hour) {
+		t.Error("Expected the wait to stop on cancellation")
+	}
+
+	gotMax = 0
+	if !waitJitter(context.Background(), 0) || gotMax != 0 {
+		t.Error("Expected no delay without jitter")
+	}
+}
+
+func TestJitterFunc_WithinBounds(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		if d := jitterFunc(time.Minute); d < 0 || d >= time.Minute {
+			t.Fatalf("Expected jitter in [0, 1m), got %v", d)
+		}
+	}
+}
+
+func TestParseCronSchedule_NeverFires(t *testing.T) {
+	if _, err := parseCronSchedule("0 0 30 2 *"); err == nil || !strings.Contains(err.Error(), "never fires") {
+		t.Errorf("Expected February 30th to be rejected, got %v", err)
+	}
+	if _, err := parseCronSchedule("@hourly; 0 0 30 2 *"); err == nil {
+		t.Error("Expected an expression that never fires to be rejected among others")
+	}
+}
+
+// Never fires, like a cron expression robfig/cron gives up on.
+type neverSchedule struct{}
+
+func (neverSchedule) Next(after time.Time) time.Time { return time.Time{} }
+
+func TestDaemon_ScheduleNeverFires(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	runner := &MockRunner{Output: []byte(`[{"download":100.0,"upload":50.0,"ping":10.0,"jitter":1.0,"server":{"url":"http://example.com"}}]`)}
+	rc := newTestRunContext(t, "", runner)
+	rc.cfg.URL = ""
+	rc.schedule = neverSchedule{}
+
+	rc.daemon(ctx, true)
+	if runner.Calls != 1 {
+		t.Errorf("Expected only the first run, got %d", runner.Calls)
+	}
+}
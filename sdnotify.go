@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Sends a state such as READY=1 to systemd for Type=notify units. Outside
+// systemd NOTIFY_SOCKET is unset and this does nothing.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	if socket[0] == '@' {
+		// Abstract namespace socket
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("failed to connect to systemd notify socket: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("failed to notify systemd: %v", err)
+	}
+	return nil
+}
+
+// Logs instead of failing: supervision is best effort and the exporter
+// works the same without it.
+func notifySystemd(state string) {
+	if err := sdNotify(state); err != nil {
+		log.Printf("WARNING: %v", err)
+	}
+}
+
+// Heartbeat interval for WatchdogSec=, half the timeout as sd_watchdog_enabled
+// recommends, or 0 if systemd isn't watching this process.
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// Sends WATCHDOG=1 until ctx is cancelled, including while a long test is
+// running, so systemd only restarts the exporter if the process hangs.
+func startWatchdog(ctx context.Context) {
+	interval := watchdogInterval()
+	if interval == 0 {
+		return
+	}
+	log.Printf("systemd watchdog enabled, heartbeat every %s", interval)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				notifySystemd("WATCHDOG=1")
+			}
+		}
+	}()
+}
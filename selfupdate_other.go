@@ -0,0 +1,19 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// Renames newPath over exe. The rename is atomic and a running exporter
+// keeps executing the file it started from.
+func replaceExecutable(exe, newPath string) error {
+	if err := os.Rename(newPath, exe); err != nil {
+		return fmt.Errorf("failed to replace %s: %v", exe, err)
+	}
+	return nil
+}
+
+func removeReplacedExecutable() {}
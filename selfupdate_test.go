@@ -0,0 +1,137 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// Serves files as the exporter's releases, with tag as the latest, and
+// points self-update at a fake executable. Returns its path.
+func serveExporterRelease(t *testing.T, tag string, files map[string]string) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/latest" {
+			w.Write([]byte(`{"tag_name": "` + tag + `"}`))
+			return
+		}
+		body, ok := files[path.Base(r.URL.Path)]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	exe := filepath.Join(t.TempDir(), "librespeed_exporter")
+	os.WriteFile(exe, []byte("old"), 0755)
+
+	savedBase, savedLatest, savedExe, savedVersion := exporterReleaseBase, exporterLatestReleaseURL, selfExecutable, version
+	exporterReleaseBase, exporterLatestReleaseURL = server.URL, server.URL+"/latest"
+	selfExecutable = func() (string, error) { return exe, nil }
+	version = "1.4.0"
+	t.Cleanup(func() {
+		exporterReleaseBase, exporterLatestReleaseURL, selfExecutable, version = savedBase, savedLatest, savedExe, savedVersion
+	})
+	return exe
+}
+
+func TestCompareVersions(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		cmp  int
+		ok   bool
+	}{
+		{"1.4.0", "1.4.0", 0, true},
+		{"v1.10.0", "1.9.3", 1, true},
+		{"1.4.0", "1.4.1", -1, true},
+		{"1.4.0", "dev", 0, false},
+		{"1.4", "1.4.0", 0, false},
+	} {
+		if cmp, ok := compareVersions(tc.a, tc.b); cmp != tc.cmp || ok != tc.ok {
+			t.Errorf("compareVersions(%q, %q) = %d, %v, want %d, %v", tc.a, tc.b, cmp, ok, tc.cmp, tc.ok)
+		}
+	}
+}
+
+func TestRunSelfUpdate(t *testing.T) {
+	asset := exporterAssetName(runtime.GOOS, runtime.GOARCH)
+	exe := serveExporterRelease(t, "v1.5.0", map[string]string{
+		asset: "new",
+		"librespeed_exporter_1.5.0_checksums.txt": sha256Hex("new") + "  " + asset + "\n",
+	})
+
+	if code := runSelfUpdate([]string{"--check"}); code != 0 {
+		t.Fatalf("Expected exit code 0 for --check, got %d", code)
+	}
+	if data, _ := os.ReadFile(exe); string(data) != "old" {
+		t.Fatalf("Expected --check to leave the executable alone, got %q", data)
+	}
+
+	if code := runSelfUpdate(nil); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+	if data, _ := os.ReadFile(exe); string(data) != "new" {
+		t.Errorf("Expected the new release in place, got %q", data)
+	}
+	if info, err := os.Stat(exe); err != nil || (runtime.GOOS != "windows" && info.Mode().Perm()&0100 == 0) {
+		t.Errorf("Expected an executable, got %v, %v", info, err)
+	}
+	if _, err := os.Stat(exe + ".new"); !os.IsNotExist(err) {
+		t.Errorf("Expected no download left behind, got %v", err)
+	}
+}
+
+func TestRunSelfUpdate_UpToDate(t *testing.T) {
+	exe := serveExporterRelease(t, "v1.4.0", nil)
+	if code := runSelfUpdate(nil); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+	version = "dev"
+	if code := runSelfUpdate(nil); code != 1 {
+		t.Errorf("Expected a build without version to need --force, got exit code %d", code)
+	}
+	if data, _ := os.ReadFile(exe); string(data) != "old" {
+		t.Errorf("Expected the executable to be kept, got %q", data)
+	}
+}
+
+func TestRunSelfUpdate_ChecksumMismatch(t *testing.T) {
+	asset := exporterAssetName(runtime.GOOS, runtime.GOARCH)
+	exe := serveExporterRelease(t, "v1.5.0", map[string]string{
+		asset: "tampered",
+		"librespeed_exporter_1.5.0_checksums.txt": sha256Hex("new") + "  " + asset + "\n",
+	})
+	if code := runSelfUpdate(nil); code != 1 {
+		t.Fatalf("Expected exit code 1, got %d", code)
+	}
+	if data, _ := os.ReadFile(exe); string(data) != "old" {
+		t.Errorf("Expected the executable to be kept, got %q", data)
+	}
+	if _, err := os.Stat(exe + ".new"); !os.IsNotExist(err) {
+		t.Errorf("Expected the download to be deleted, got %v", err)
+	}
+
+	// Without a checksums file only --sha256 will do
+	if code := runSelfUpdate([]string{"--version", "1.3.0", "--sha256", sha256Hex("x")}); code != 1 {
+		t.Errorf("Expected a missing asset to fail, got exit code %d", code)
+	}
+	if code := runSelfUpdate([]string{"--sha256", "abc"}); code != 2 {
+		t.Errorf("Expected an invalid --sha256 to be rejected, got exit code %d", code)
+	}
+}
+
+func TestRunSelfUpdate_Downgrade(t *testing.T) {
+	asset := exporterAssetName(runtime.GOOS, runtime.GOARCH)
+	exe := serveExporterRelease(t, "v1.5.0", map[string]string{asset: "older"})
+	if code := runSelfUpdate([]string{"--version", "v1.3.0", "--sha256", sha256Hex("older")}); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+	if data, _ := os.ReadFile(exe); string(data) != "older" {
+		t.Errorf("Expected the requested release in place, got %q", data)
+	}
+}
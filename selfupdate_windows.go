@@ -0,0 +1,46 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Windows won't overwrite or delete an executable that is running, but will
+// rename it. So exe is moved aside to exe.old first and newPath renamed into
+// its place; should that fail, exe is moved back. The old copy is deleted
+// once nothing runs it any more, at the latest by the next start.
+func replaceExecutable(exe, newPath string) error {
+	old := exe + ".old"
+	if err := os.Remove(old); err != nil && !os.IsNotExist(err) {
+		// Still running from an earlier update
+		old = exe + ".old-" + strconv.FormatInt(time.Now().Unix(), 10)
+	}
+	if err := os.Rename(exe, old); err != nil {
+		return fmt.Errorf("failed to move %s aside: %v", exe, err)
+	}
+	if err := os.Rename(newPath, exe); err != nil {
+		if restoreErr := os.Rename(old, exe); restoreErr != nil {
+			return fmt.Errorf("failed to replace %s: %v; the previous version is at %s: %v", exe, err, old, restoreErr)
+		}
+		return fmt.Errorf("failed to replace %s: %v", exe, err)
+	}
+	os.Remove(old)
+	return nil
+}
+
+// Deletes copies left behind by replaceExecutable while they were running.
+func removeReplacedExecutable() {
+	exe, err := selfExecutable()
+	if err != nil {
+		return
+	}
+	old, _ := filepath.Glob(exe + ".old*")
+	for _, path := range old {
+		os.Remove(path)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// Comma-separated server IDs for --server-id. Setting the flag replaces the
+// default rather than adding to it.
+type serverIDList []int
+
+func (l *serverIDList) String() string {
+	ids := make([]string, len(*l))
+	for i, id := range *l {
+		ids[i] = strconv.Itoa(id)
+	}
+	return strings.Join(ids, ",")
+}
+
+func (l *serverIDList) Set(value string) error {
+	var ids []int
+	for _, part := range strings.Split(value, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return fmt.Errorf("invalid server ID %q", part)
+		}
+		ids = append(ids, id)
+	}
+	*l = ids
+	return nil
+}
+
+// IDs of every server in a librespeed server list. librespeed-cli accepts
+// them as numbers or strings.
+func loadServerIDs(path string) ([]int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read server list: %v", err)
+	}
+	var servers []struct {
+		ID json.RawMessage `json:"id"`
+	}
+	if err := json.Unmarshal(data, &servers); err != nil {
+		return nil, fmt.Errorf("failed to parse server list: %v", err)
+	}
+	var ids []int
+	for _, server := range servers {
+		id, err := strconv.Atoi(strings.Trim(string(server.ID), `"`))
+		if err != nil {
+			return nil, fmt.Errorf("server list %s has an invalid id %s", path, server.ID)
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("server list %s is empty", path)
+	}
+	return ids, nil
+}
+
+type serverResult struct {
+	ID     int
+	Result *LibrespeedResult
+	Err    error
+}
+
+// Tests every server, at most workers at a time, and returns the results in
+// the order of ids. Concurrent tests share the link, so each one measures
+// less than it would alone.
+func testServers(runner CommandRunner, cliPath, localJSONPath string, ids []int, workers int, opts TestOptions) []serverResult {
+	results := make([]serverResult, len(ids))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < len(ids); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				id := ids[i]
+				result, err := runLibrespeedWithOptions(runner, cliPath, localJSONPath, &id, opts)
+				if err != nil {
+					log.Printf("WARNING: Test against server %d failed: %v", id, err)
+				}
+				results[i] = serverResult{ID: id, Result: result, Err: err}
+			}
+		}()
+	}
+	for i := range ids {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// The first successful result, which feeds history, alerts and the run
+// record; the first error if every server failed.
+func primaryResult(results []serverResult) (*LibrespeedResult, error) {
+	for _, r := range results {
+		if r.Err == nil {
+			return r.Result, nil
+		}
+	}
+	return nil, results[0].Err
+}
+
+// Result series for each server labelled server_id, with librespeed_server_up
+// telling failed servers apart from slow ones. A failed test has no server
+// URL, so librespeed_server_up is keyed on server_id alone to stay one series
+// whether the server answers or not.
+func serverSeries(results []serverResult, now int64, instance string) []*prompb.TimeSeries {
+	var series []*prompb.TimeSeries
+	for _, r := range results {
+		var perServer []*prompb.TimeSeries
+		if r.Err != nil {
+			perServer = append(perServer, serverUpSeries(0, now, instance))
+		} else {
+			url := r.Result.Server.URL
+			perServer = append(perServer,
+				serverUpSeries(1, now, instance),
+				createTimeSeries("librespeed_download_mbps", r.Result.Download, now, url, instance),
+				createTimeSeries("librespeed_upload_mbps", r.Result.Upload, now, url, instance),
+				createTimeSeries("librespeed_ping_ms", r.Result.Ping, now, url, instance),
+				createTimeSeries("librespeed_jitter_ms", r.Result.Jitter, now, url, instance),
+			)
+		}
+		addLabels(perServer, map[string]string{"server_id": strconv.Itoa(r.ID)})
+		series = append(series, perServer...)
+	}
+	return series
+}
+
+func serverUpSeries(up float64, now int64, instance string) *prompb.TimeSeries {
+	return &prompb.TimeSeries{
+		Labels: []prompb.Label{
+			{Name: "__name__", Value: "librespeed_server_up"},
+			{Name: "instance", Value: instance},
+		},
+		Samples: []prompb.Sample{
+			{Value: up, Timestamp: now},
+		},
+	}
+}
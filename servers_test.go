@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// Answers librespeed-cli calls per --server; servers missing from results fail.
+type serverRunner struct {
+	mu      sync.Mutex
+	results map[string]float64
+	calls   []string
+}
+
+func (r *serverRunner) Run(name string, args ...string) ([]byte, error) {
+	server := ""
+	for i, arg := range args {
+		if arg == "--server" && i+1 < len(args) {
+			server = args[i+1]
+		}
+	}
+	r.mu.Lock()
+	r.calls = append(r.calls, server)
+	r.mu.Unlock()
+	download, ok := r.results[server]
+	if !ok {
+		return nil, fmt.Errorf("server %s unreachable", server)
+	}
+	return []byte(fmt.Sprintf(`[{"download":%g,"upload":50.0,"ping":10.0,"jitter":1.0,"server":{"url":"http://server%s.example.com"}}]`, download, server)), nil
+}
+
+func TestServerIDList(t *testing.T) {
+	ids := serverIDList{1}
+	if err := ids.Set("3, 5,8"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if ids.String() != "3,5,8" {
+		t.Errorf("Expected the default replaced by 3,5,8, got %s", ids.String())
+	}
+	if err := ids.Set("3,x"); err == nil {
+		t.Error("Expected error for a non-numeric ID")
+	}
+}
+
+func TestLoadServerIDs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "servers.json")
+	os.WriteFile(path, []byte(`[{"id":"1","name":"HQ","server":"http://10.0.0.1/"},{"id":7,"name":"Branch","server":"http://10.0.0.7/"}]`), 0600)
+	ids, err := loadServerIDs(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(ids) != 2 || ids[0] != 1 || ids[1] != 7 {
+		t.Errorf("Expected [1 7], got %v", ids)
+	}
+
+	os.WriteFile(path, []byte(`[]`), 0600)
+	if _, err := loadServerIDs(path); err == nil {
+		t.Error("Expected error for an empty server list")
+	}
+	os.WriteFile(path, []byte(`[{"id":"hq"}]`), 0600)
+	if _, err := loadServerIDs(path); err == nil {
+		t.Error("Expected error for a non-numeric id")
+	}
+}
+
+func TestTestServers(t *testing.T) {
+	runner := &serverRunner{results: map[string]float64{"1": 100, "3": 300}}
+	results := testServers(runner, "librespeed-cli", "servers.json", []int{2, 1, 3}, 2, TestOptions{})
+
+	if len(results) != 3 || len(runner.calls) != 3 {
+		t.Fatalf("Expected three tests, got %d results from %d calls", len(results), len(runner.calls))
+	}
+	if results[0].ID != 2 || results[0].Err == nil {
+		t.Errorf("Expected server 2 to fail, got %+v", results[0])
+	}
+	if results[2].ID != 3 || results[2].Result.Download != 300 {
+		t.Errorf("Expected results in the order given, got %+v", results[2])
+	}
+	primary, err := primaryResult(results)
+	if err != nil || primary.Download != 100 {
+		t.Errorf("Expected server 1 as the first success, got %+v, %v", primary, err)
+	}
+
+	series := serverSeries(results, 1000, "host1")
+	got := map[string]float64{}
+	for _, ts := range series {
+		got[getLabelValue(ts.Labels, "server_id")+" "+getLabelValue(ts.Labels, "__name__")] = ts.Samples[0].Value
+	}
+	if got["2 librespeed_server_up"] != 0 || got["3 librespeed_server_up"] != 1 || got["3 librespeed_download_mbps"] != 300 {
+		t.Errorf("Unexpected per-server series: %v", got)
+	}
+	if _, has := got["2 librespeed_download_mbps"]; has {
+		t.Errorf("Expected no throughput for a failed server, got %v", got)
+	}
+	for _, ts := range series {
+		name := getLabelValue(ts.Labels, "__name__")
+		if name == "librespeed_server_up" && len(ts.Labels) != 3 {
+			t.Errorf("Expected librespeed_server_up labelled only instance and server_id, got %v", ts.Labels)
+		}
+		if name == "librespeed_download_mbps" && getLabelValue(ts.Labels, "server_url") == "" {
+			t.Errorf("Expected server_url on the result series, got %v", ts.Labels)
+		}
+	}
+}
+
+func TestRunOnce_MultipleServers(t *testing.T) {
+	runner := &serverRunner{results: map[string]float64{"4": 400, "5": 500}}
+	rc := newTestRunContext(t, "", runner)
+	rc.cfg.LocalJSONPath = "servers.json"
+	rc.serverIDs = []int{4, 5}
+	rc.cache = &resultCache{}
+	if err := rc.runOnce(context.Background()); err != nil {
+		t.Fatalf("Expected the run to succeed, got %v", err)
+	}
+	downloads := map[string]float64{}
+	for _, ts := range rc.cache.series {
+		if getLabelValue(ts.Labels, "__name__") == "librespeed_download_mbps" {
+			downloads[getLabelValue(ts.Labels, "server_id")] = ts.Samples[0].Value
+		}
+	}
+	if len(downloads) != 2 || downloads["4"] != 400 || downloads["5"] != 500 {
+		t.Errorf("Expected one download series per server, got %v", downloads)
+	}
+
+	runner.results = map[string]float64{}
+	if err := rc.runOnce(context.Background()); err == nil {
+		t.Error("Expected the run to fail when every server fails")
+	}
+}
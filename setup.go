@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+	"go.yaml.in/yaml/v3"
+)
+
+// Overridden in tests.
+var grafanaCloudAPI = "https://grafana.com/api"
+
+type grafanaCloudStack struct {
+	PromURL string `json:"hmInstancePromUrl"`
+	PromID  int    `json:"hmInstancePromId"`
+}
+
+// setup-grafana-cloud looks up the stack's Prometheus endpoint, writes a
+// remote_write config for --remote-write-config and pushes one probe sample
+// so a bad token shows up now rather than on the first scheduled run.
+func runSetupGrafanaCloud(args []string) int {
+	fs := flag.NewFlagSet("setup-grafana-cloud", flag.ContinueOnError)
+	stack := fs.String("stack", "", "Grafana Cloud stack slug (the <stack> in <stack>.grafana.net)")
+	token := fs.String("token", "", "Grafana Cloud access policy token with stacks:read and metrics:write")
+	writeToken := fs.String("write-token", "", "Separate token for pushing metrics (default: --token)")
+	output := fs.String("output", "remote_write.yml", "Path of the remote write config to write")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *stack == "" || *token == "" {
+		fmt.Fprintln(os.Stderr, "ERROR: --stack and --token are required")
+		return 2
+	}
+	if *writeToken == "" {
+		*writeToken = *token
+	}
+
+	info, err := lookupGrafanaCloudStack(*stack, *token)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		return 1
+	}
+	pushURL := strings.TrimSuffix(info.PromURL, "/") + "/api/prom/push"
+	username := strconv.Itoa(info.PromID)
+	fmt.Printf("Stack %s: remote write %s, instance ID %s\n", *stack, pushURL, username)
+
+	if err := writeGrafanaCloudConfig(*output, pushURL, username, *writeToken); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Config written to %s\n", *output)
+
+	hostname, _ := os.Hostname()
+	probe := []*prompb.TimeSeries{createTimeSeries("librespeed_setup_probe", 1, time.Now().UnixMilli(), "", hostname)}
+	if err := sendToRemoteWrite(context.Background(), pushURL, username, *writeToken, probe); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: Probe push failed, check the token has metrics:write: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Probe succeeded. Run with: --remote-write-config %s\n", *output)
+	return 0
+}
+
+func lookupGrafanaCloudStack(stack, token string) (*grafanaCloudStack, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", grafanaCloudAPI+"/instances/"+url.PathEscape(stack), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := remoteWriteClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query Grafana Cloud API: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("Grafana Cloud API returned %s - %s", resp.Status, string(body))
+	}
+
+	var info grafanaCloudStack
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("failed to parse stack details: %v", err)
+	}
+	if info.PromURL == "" || info.PromID == 0 {
+		return nil, fmt.Errorf("stack %s has no hosted Prometheus instance", stack)
+	}
+	return &info, nil
+}
+
+func writeGrafanaCloudConfig(path, pushURL, username, password string) error {
+	doc := map[string]any{
+		"remote_write": []map[string]any{{
+			"name": "grafana-cloud",
+			"url":  pushURL,
+			"basic_auth": map[string]string{
+				"username": username,
+				"password": password,
+			},
+		}},
+	}
+	data, err := yaml.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("failed to encode config: %v", err)
+	}
+	// The file holds a token, so keep it private to the service account
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write config: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestLookupGrafanaCloudStack(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/instances/mystack" {
+			t.Errorf("Unexpected path %s", r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != "Bearer glc_token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"slug":"mystack","hmInstancePromUrl":"https://prometheus-prod-10-prod-us-central-0.grafana.net","hmInstancePromId":123456}`))
+	}))
+	defer server.Close()
+
+	original := grafanaCloudAPI
+	grafanaCloudAPI = server.URL
+	defer func() { grafanaCloudAPI = original }()
+
+	info, err := lookupGrafanaCloudStack("mystack", "glc_token")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if info.PromID != 123456 || info.PromURL != "https://prometheus-prod-10-prod-us-central-0.grafana.net" {
+		t.Errorf("Unexpected stack details %+v", info)
+	}
+
+	if _, err := lookupGrafanaCloudStack("mystack", "wrong"); err == nil {
+		t.Error("Expected error for rejected token, got nil")
+	}
+}
+
+func TestWriteGrafanaCloudConfig_RoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "remote_write.yml")
+	if err := writeGrafanaCloudConfig(path, "https://example.grafana.net/api/prom/push", "123456", "glc_token"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	rw, err := loadRemoteWriteConfig(path, "grafana-cloud")
+	if err != nil {
+		t.Fatalf("Expected written config to load, got %v", err)
+	}
+	if rw.URL != "https://example.grafana.net/api/prom/push" {
+		t.Errorf("Unexpected URL %s", rw.URL)
+	}
+	if rw.BasicAuth == nil || rw.BasicAuth.Username != "123456" || rw.BasicAuth.Password != "glc_token" {
+		t.Errorf("Unexpected basic auth %+v", rw.BasicAuth)
+	}
+}
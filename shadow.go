@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// Mirrors remote_write payloads to a second endpoint, e.g. while moving
+// from one backend to another, and counts how its outcomes compare with
+// those of --url.
+type shadowWriter struct {
+	url, username, password string
+	percent                 float64
+	stats                   *shadowStats
+}
+
+// Kept across reloads, so the counters only reset with the process.
+type shadowStats struct {
+	mu         sync.Mutex
+	mirrored   int
+	skipped    int
+	failed     int
+	mismatched int
+	// Of the last mirrored payload
+	primaryLatency time.Duration
+	shadowLatency  time.Duration
+}
+
+// Draws the percentile a payload falls in; replaced by tests.
+var shadowSample = func() float64 { return rand.Float64() * 100 }
+
+// nil without a URL. stats is carried over from the previous configuration.
+func newShadowWriter(url, username, password string, percent float64, previous *shadowWriter) *shadowWriter {
+	if url == "" {
+		return nil
+	}
+	stats := &shadowStats{}
+	if previous != nil {
+		stats = previous.stats
+	}
+	return &shadowWriter{url: url, username: username, password: password, percent: percent, stats: stats}
+}
+
+// Sends series to the shadow endpoint if this payload is in the sampled
+// percentage, once and without retries. primaryErr and primaryLatency are
+// the outcome of the push to --url, for comparison.
+func (s *shadowWriter) mirror(ctx context.Context, encoder Encoder, series []*prompb.TimeSeries, primaryErr error, primaryLatency time.Duration) {
+	if s == nil {
+		return
+	}
+	if shadowSample() >= s.percent {
+		s.stats.mu.Lock()
+		s.stats.skipped++
+		s.stats.mu.Unlock()
+		return
+	}
+	start := time.Now()
+	err := sendWithEncoder(ctx, encoder, s.url, s.username, s.password, series)
+	latency := time.Since(start)
+
+	s.stats.mu.Lock()
+	defer s.stats.mu.Unlock()
+	s.stats.mirrored++
+	s.stats.primaryLatency = primaryLatency
+	s.stats.shadowLatency = latency
+	if err != nil {
+		s.stats.failed++
+		log.Printf("WARNING: Shadow write failed after %v: %v", latency, err)
+	}
+	if (err == nil) != (primaryErr == nil) {
+		s.stats.mismatched++
+		log.Printf("WARNING: Shadow write outcome differs from --url (shadow: %v, primary: %v)", errString(err), errString(primaryErr))
+	}
+	log.Printf("Shadow write took %v, --url took %v; %d of %d mirrored payloads failed, %d differed",
+		latency, primaryLatency, s.stats.failed, s.stats.mirrored, s.stats.mismatched)
+}
+
+func errString(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	return err.Error()
+}
+
+// librespeed_shadow_* counters as of the previous payloads, pushed and
+// served with each result.
+func (s *shadowWriter) series(now int64, instance string) []*prompb.TimeSeries {
+	if s == nil {
+		return nil
+	}
+	s.stats.mu.Lock()
+	defer s.stats.mu.Unlock()
+	return []*prompb.TimeSeries{
+		createTimeSeries("librespeed_shadow_mirrored_total", float64(s.stats.mirrored), now, "", instance),
+		createTimeSeries("librespeed_shadow_skipped_total", float64(s.stats.skipped), now, "", instance),
+		createTimeSeries("librespeed_shadow_failures_total", float64(s.stats.failed), now, "", instance),
+		createTimeSeries("librespeed_shadow_mismatches_total", float64(s.stats.mismatched), now, "", instance),
+		createTimeSeries("librespeed_shadow_latency_seconds", s.stats.shadowLatency.Seconds(), now, "", instance),
+		createTimeSeries("librespeed_shadow_primary_latency_seconds", s.stats.primaryLatency.Seconds(), now, "", instance),
+	}
+}
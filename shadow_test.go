@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func useShadowSample(t *testing.T, value float64) {
+	t.Helper()
+	saved := shadowSample
+	shadowSample = func() float64 { return value }
+	t.Cleanup(func() { shadowSample = saved })
+}
+
+func shadowSeriesValues(s *shadowWriter) map[string]float64 {
+	values := map[string]float64{}
+	for _, ts := range s.series(0, "host1") {
+		values[ts.Labels[0].Value] = ts.Samples[0].Value
+	}
+	return values
+}
+
+func TestShadowWriter_Mirror(t *testing.T) {
+	useShadowSample(t, 0)
+	status := http.StatusNoContent
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if user, _, _ := r.BasicAuth(); user != "shadow" {
+			t.Errorf("Expected the shadow credentials, got user %q", user)
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	shadow := newShadowWriter(server.URL, "shadow", "secret", 100, nil)
+	series := []*prompb.TimeSeries{createTimeSeries("librespeed_download_mbps", 100, 0, "", "host1")}
+	shadow.mirror(context.Background(), remoteWriteEncoder{}, series, nil, time.Second)
+	status = http.StatusInternalServerError
+	shadow.mirror(context.Background(), remoteWriteEncoder{}, series, nil, time.Second)
+	shadow.mirror(context.Background(), remoteWriteEncoder{}, series, fmt.Errorf("503"), 2*time.Second)
+
+	if requests != 3 {
+		t.Errorf("Expected 3 mirrored payloads, got %d", requests)
+	}
+	values := shadowSeriesValues(shadow)
+	for name, want := range map[string]float64{
+		"librespeed_shadow_mirrored_total":          3,
+		"librespeed_shadow_skipped_total":           0,
+		"librespeed_shadow_failures_total":          2,
+		"librespeed_shadow_mismatches_total":        1,
+		"librespeed_shadow_primary_latency_seconds": 2,
+	} {
+		if values[name] != want {
+			t.Errorf("Expected %s %v, got %v", name, want, values[name])
+		}
+	}
+
+	// A reload keeps the counters
+	reloaded := newShadowWriter(server.URL, "shadow", "secret", 50, shadow)
+	if shadowSeriesValues(reloaded)["librespeed_shadow_mirrored_total"] != 3 {
+		t.Error("Expected the counters to survive a reload")
+	}
+}
+
+func TestShadowWriter_Percent(t *testing.T) {
+	useShadowSample(t, 60)
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer server.Close()
+
+	shadow := newShadowWriter(server.URL, "", "", 50, nil)
+	series := []*prompb.TimeSeries{createTimeSeries("librespeed_download_mbps", 100, 0, "", "host1")}
+	shadow.mirror(context.Background(), remoteWriteEncoder{}, series, nil, time.Second)
+	if requests != 0 || shadowSeriesValues(shadow)["librespeed_shadow_skipped_total"] != 1 {
+		t.Errorf("Expected the payload to be skipped, got %d requests", requests)
+	}
+
+	var none *shadowWriter
+	none.mirror(context.Background(), remoteWriteEncoder{}, series, nil, time.Second)
+	if none.series(0, "host1") != nil {
+		t.Error("Expected no series without --shadow-url")
+	}
+}
+
+func TestRun_ShadowWrite(t *testing.T) {
+	useShadowSample(t, 0)
+	primary, shadowed := 0, 0
+	primaryServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		primary++
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer primaryServer.Close()
+	shadowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		shadowed++
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer shadowServer.Close()
+
+	runner := &MockRunner{Output: []byte(`[{"download":100.0,"upload":50.0,"ping":10.0,"jitter":1.0,"server":{"url":"http://example.com"}}]`)}
+	rc := newTestRunContext(t, primaryServer.URL, runner)
+	rc.shadow = newShadowWriter(shadowServer.URL, "", "", 100, nil)
+	rc.cache = &resultCache{}
+
+	if err := rc.runOnce(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if primary != 1 || shadowed != 1 {
+		t.Errorf("Expected one push to each endpoint, got %d and %d", primary, shadowed)
+	}
+	found := false
+	for _, ts := range rc.cache.series {
+		found = found || ts.Labels[0].Value == "librespeed_shadow_mirrored_total"
+	}
+	if !found {
+		t.Error("Expected the shadow counters with the result")
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Fraction of the advertised plan speed below which a run counts as a breach.
+const slaTolerance = 0.8
+
+// Advertised plan speeds in Mbps plus a latency budget for the link type.
+type SLAProfile struct {
+	Download float64
+	Upload   float64
+	MaxPing  float64
+}
+
+var slaProfiles = map[string]SLAProfile{
+	"25/3":      {Download: 25, Upload: 3, MaxPing: 60},
+	"50/10":     {Download: 50, Upload: 10, MaxPing: 50},
+	"100/20":    {Download: 100, Upload: 20, MaxPing: 40},
+	"100/40":    {Download: 100, Upload: 40, MaxPing: 40},
+	"300/30":    {Download: 300, Upload: 30, MaxPing: 30},
+	"500/50":    {Download: 500, Upload: 50, MaxPing: 30},
+	"1000/50":   {Download: 1000, Upload: 50, MaxPing: 25},
+	"1000/1000": {Download: 1000, Upload: 1000, MaxPing: 15},
+}
+
+func lookupSLAProfile(name string) (SLAProfile, error) {
+	profile, ok := slaProfiles[name]
+	if !ok {
+		var names []string
+		for n := range slaProfiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return SLAProfile{}, fmt.Errorf("unknown SLA profile: %s (available: %s)", name, strings.Join(names, ", "))
+	}
+	return profile, nil
+}
+
+func (p SLAProfile) thresholds() AlertThresholds {
+	return AlertThresholds{
+		MinDownload: p.Download * slaTolerance,
+		MinUpload:   p.Upload * slaTolerance,
+		MaxPing:     p.MaxPing,
+	}
+}
+
+// Fills in thresholds the operator left unset from the profile, so explicit
+// --alert-* flags always win.
+func applySLAProfile(profile SLAProfile, thresholds AlertThresholds, explicit map[string]bool) AlertThresholds {
+	defaults := profile.thresholds()
+	if !explicit["alert-min-download"] {
+		thresholds.MinDownload = defaults.MinDownload
+	}
+	if !explicit["alert-min-upload"] {
+		thresholds.MinUpload = defaults.MinUpload
+	}
+	if !explicit["alert-max-ping"] {
+		thresholds.MaxPing = defaults.MaxPing
+	}
+	return thresholds
+}
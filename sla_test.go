@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLookupSLAProfile(t *testing.T) {
+	profile, err := lookupSLAProfile("100/40")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if profile.Download != 100 || profile.Upload != 40 {
+		t.Errorf("Unexpected profile: %+v", profile)
+	}
+
+	_, err = lookupSLAProfile("42/7")
+	if err == nil {
+		t.Fatal("Expected error for unknown profile, got nil")
+	}
+	if !strings.Contains(err.Error(), "1000/50") {
+		t.Errorf("Expected available profiles in error, got: %v", err)
+	}
+}
+
+func TestApplySLAProfile(t *testing.T) {
+	profile := SLAProfile{Download: 100, Upload: 40, MaxPing: 40}
+
+	thresholds := applySLAProfile(profile, AlertThresholds{MaxJitter: 5}, map[string]bool{})
+	if thresholds.MinDownload != 80 || thresholds.MinUpload != 32 || thresholds.MaxPing != 40 {
+		t.Errorf("Expected profile defaults, got %+v", thresholds)
+	}
+	if thresholds.MaxJitter != 5 {
+		t.Errorf("Expected jitter threshold to be untouched, got %f", thresholds.MaxJitter)
+	}
+
+	explicit := map[string]bool{"alert-min-download": true}
+	thresholds = applySLAProfile(profile, AlertThresholds{MinDownload: 50}, explicit)
+	if thresholds.MinDownload != 50 {
+		t.Errorf("Expected explicit download threshold to win, got %f", thresholds.MinDownload)
+	}
+	if thresholds.MinUpload != 32 {
+		t.Errorf("Expected profile upload threshold, got %f", thresholds.MinUpload)
+	}
+}
@@ -0,0 +1,219 @@
+//go:build !minimal
+
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+// OIDs from mibs/LIBRESPEED-EXPORTER-MIB.txt. The enterprise number is the
+// one reserved for documentation (RFC 5612).
+const (
+	snmpEnterpriseOID   = ".1.3.6.1.4.1.32473.1"
+	snmpTrapOID         = ".1.3.6.1.6.3.1.1.4.1.0"
+	snmpNotifyResult    = snmpEnterpriseOID + ".0.1"
+	snmpNotifyFailure   = snmpEnterpriseOID + ".0.2"
+	snmpNotifyBreach    = snmpEnterpriseOID + ".0.3"
+	snmpObjHostname     = snmpEnterpriseOID + ".1.1.0"
+	snmpObjServer       = snmpEnterpriseOID + ".1.2.0"
+	snmpObjStatus       = snmpEnterpriseOID + ".1.3.0"
+	snmpObjDownloadKbps = snmpEnterpriseOID + ".1.4.0"
+	snmpObjUploadKbps   = snmpEnterpriseOID + ".1.5.0"
+	snmpObjPingMicros   = snmpEnterpriseOID + ".1.6.0"
+	snmpObjJitterMicros = snmpEnterpriseOID + ".1.7.0"
+	snmpObjDetail       = snmpEnterpriseOID + ".1.8.0"
+)
+
+type SNMPConfig struct {
+	Target       string
+	Version      string
+	Community    string
+	User         string
+	AuthProtocol string
+	AuthPass     string
+	PrivProtocol string
+	PrivPass     string
+	TrapOn       string
+}
+
+func newSNMPClient(cfg SNMPConfig, hostname string) (*gosnmp.GoSNMP, error) {
+	host, portStr, err := net.SplitHostPort(cfg.Target)
+	if err != nil {
+		host, portStr = cfg.Target, "162"
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SNMP port: %s", portStr)
+	}
+
+	client := &gosnmp.GoSNMP{
+		Target:  host,
+		Port:    uint16(port),
+		Timeout: 5 * time.Second,
+		Retries: 1,
+	}
+
+	switch cfg.Version {
+	case "", "2c":
+		client.Version = gosnmp.Version2c
+		client.Community = cfg.Community
+		if client.Community == "" {
+			client.Community = "public"
+		}
+	case "3":
+		if cfg.User == "" {
+			return nil, fmt.Errorf("SNMPv3 requires --snmp-user")
+		}
+		params := &gosnmp.UsmSecurityParameters{
+			UserName: cfg.User,
+			// We are the authoritative engine for traps we send
+			AuthoritativeEngineID: snmpEngineID(hostname),
+		}
+		client.MsgFlags = gosnmp.NoAuthNoPriv
+		if cfg.AuthPass != "" {
+			switch strings.ToUpper(cfg.AuthProtocol) {
+			case "", "SHA":
+				params.AuthenticationProtocol = gosnmp.SHA
+			case "SHA256":
+				params.AuthenticationProtocol = gosnmp.SHA256
+			case "MD5":
+				params.AuthenticationProtocol = gosnmp.MD5
+			default:
+				return nil, fmt.Errorf("unknown SNMP auth protocol: %s (supported: SHA, SHA256, MD5)", cfg.AuthProtocol)
+			}
+			params.AuthenticationPassphrase = cfg.AuthPass
+			client.MsgFlags = gosnmp.AuthNoPriv
+		}
+		if cfg.PrivPass != "" {
+			if cfg.AuthPass == "" {
+				return nil, fmt.Errorf("SNMPv3 privacy requires an auth passphrase")
+			}
+			switch strings.ToUpper(cfg.PrivProtocol) {
+			case "", "AES":
+				params.PrivacyProtocol = gosnmp.AES
+			case "DES":
+				params.PrivacyProtocol = gosnmp.DES
+			default:
+				return nil, fmt.Errorf("unknown SNMP privacy protocol: %s (supported: AES, DES)", cfg.PrivProtocol)
+			}
+			params.PrivacyPassphrase = cfg.PrivPass
+			client.MsgFlags = gosnmp.AuthPriv
+		}
+		client.Version = gosnmp.Version3
+		client.SecurityModel = gosnmp.UserSecurityModel
+		client.SecurityParameters = params
+	default:
+		return nil, fmt.Errorf("unknown SNMP version: %s (supported: 2c, 3)", cfg.Version)
+	}
+	return client, nil
+}
+
+// Checks the trap settings at startup, rather than failing every run.
+func validateSNMPConfig(cfg SNMPConfig) error {
+	switch cfg.Version {
+	case "", "2c", "3":
+	default:
+		return fmt.Errorf("--snmp-version must be 2c or 3")
+	}
+	switch strings.ToUpper(cfg.AuthProtocol) {
+	case "", "SHA", "SHA256", "MD5":
+	default:
+		return fmt.Errorf("--snmp-auth-protocol must be SHA, SHA256 or MD5")
+	}
+	switch strings.ToUpper(cfg.PrivProtocol) {
+	case "", "AES", "DES":
+	default:
+		return fmt.Errorf("--snmp-priv-protocol must be AES or DES")
+	}
+	if cfg.TrapOn != "all" && cfg.TrapOn != "breach" {
+		return fmt.Errorf("--snmp-trap-on must be all or breach")
+	}
+	if _, err := newSNMPClient(cfg, ""); err != nil {
+		return fmt.Errorf("invalid --snmp-target settings: %v", err)
+	}
+	return nil
+}
+
+// RFC 3411 text-format engine ID derived from the hostname, so it stays
+// stable across runs without any state on disk.
+func snmpEngineID(hostname string) string {
+	if len(hostname) > 27 {
+		hostname = hostname[:27]
+	}
+	id, _ := hex.DecodeString("80007ed904")
+	return string(append(id, hostname...))
+}
+
+// Decides which notification to send, if any. In "breach" mode only failures
+// and threshold breaches produce a trap.
+func snmpNotification(mode string, record RunRecord, checks []thresholdCheck) (string, bool) {
+	if record.Status != "success" {
+		return snmpNotifyFailure, true
+	}
+	for _, check := range checks {
+		if check.Breached {
+			return snmpNotifyBreach, true
+		}
+	}
+	if mode == "breach" {
+		return "", false
+	}
+	return snmpNotifyResult, true
+}
+
+func buildSNMPTrap(notification string, record RunRecord, hostname string, checks []thresholdCheck) gosnmp.SnmpTrap {
+	var breached []string
+	for _, check := range checks {
+		if check.Breached {
+			breached = append(breached, check.AlertName)
+		}
+	}
+	detail := record.Error
+	if len(breached) > 0 {
+		detail = strings.Join(breached, ",")
+	}
+
+	return gosnmp.SnmpTrap{
+		Variables: []gosnmp.SnmpPDU{
+			{Name: snmpTrapOID, Type: gosnmp.ObjectIdentifier, Value: notification},
+			{Name: snmpObjHostname, Type: gosnmp.OctetString, Value: hostname},
+			{Name: snmpObjServer, Type: gosnmp.OctetString, Value: record.Server},
+			{Name: snmpObjStatus, Type: gosnmp.OctetString, Value: record.Status},
+			{Name: snmpObjDownloadKbps, Type: gosnmp.Gauge32, Value: uint(record.Download * 1000)},
+			{Name: snmpObjUploadKbps, Type: gosnmp.Gauge32, Value: uint(record.Upload * 1000)},
+			{Name: snmpObjPingMicros, Type: gosnmp.Gauge32, Value: uint(record.Ping * 1000)},
+			{Name: snmpObjJitterMicros, Type: gosnmp.Gauge32, Value: uint(record.Jitter * 1000)},
+			{Name: snmpObjDetail, Type: gosnmp.OctetString, Value: detail},
+		},
+	}
+}
+
+func sendSNMPTrap(cfg SNMPConfig, hostname string, record RunRecord, checks []thresholdCheck) error {
+	notification, ok := snmpNotification(cfg.TrapOn, record, checks)
+	if !ok {
+		return nil
+	}
+
+	client, err := newSNMPClient(cfg, hostname)
+	if err != nil {
+		return err
+	}
+	if err := client.Connect(); err != nil {
+		return fmt.Errorf("failed to connect to SNMP target: %v", err)
+	}
+	defer client.Conn.Close()
+
+	if _, err := client.SendTrap(buildSNMPTrap(notification, record, hostname, checks)); err != nil {
+		return fmt.Errorf("failed to send SNMP trap: %v", err)
+	}
+	log.Printf("SNMP trap %s sent to %s", notification, cfg.Target)
+	return nil
+}
@@ -0,0 +1,154 @@
+//go:build !minimal
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+func TestSNMPNotification(t *testing.T) {
+	success := newRunRecord("", &LibrespeedResult{Download: 100}, nil, time.Second)
+	failure := newRunRecord("speedtest", nil, fmt.Errorf("command failed"), time.Second)
+	breached := []thresholdCheck{{AlertName: "LibrespeedDownloadLow", Breached: true}}
+	healthy := []thresholdCheck{{AlertName: "LibrespeedDownloadLow", Breached: false}}
+
+	testCases := []struct {
+		name     string
+		mode     string
+		record   RunRecord
+		checks   []thresholdCheck
+		expected string
+		send     bool
+	}{
+		{"All mode result", "all", success, healthy, snmpNotifyResult, true},
+		{"Breach mode healthy", "breach", success, healthy, "", false},
+		{"Breach mode breached", "breach", success, breached, snmpNotifyBreach, true},
+		{"Failure always sent", "breach", failure, nil, snmpNotifyFailure, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			notification, send := snmpNotification(tc.mode, tc.record, tc.checks)
+			if send != tc.send || notification != tc.expected {
+				t.Errorf("Expected (%s, %v), got (%s, %v)", tc.expected, tc.send, notification, send)
+			}
+		})
+	}
+}
+
+func TestNewSNMPClient(t *testing.T) {
+	testCases := []struct {
+		name        string
+		cfg         SNMPConfig
+		shouldError bool
+	}{
+		{"v2c default port", SNMPConfig{Target: "nms.local"}, false},
+		{"v2c explicit port", SNMPConfig{Target: "nms.local:1162", Version: "2c"}, false},
+		{"v3 authpriv", SNMPConfig{Target: "nms.local", Version: "3", User: "ops", AuthPass: "authpass1", PrivPass: "privpass1"}, false},
+		{"v3 without user", SNMPConfig{Target: "nms.local", Version: "3"}, true},
+		{"v3 priv without auth", SNMPConfig{Target: "nms.local", Version: "3", User: "ops", PrivPass: "privpass1"}, true},
+		{"v3 bad auth protocol", SNMPConfig{Target: "nms.local", Version: "3", User: "ops", AuthPass: "x", AuthProtocol: "CRC32"}, true},
+		{"Unknown version", SNMPConfig{Target: "nms.local", Version: "1"}, true},
+		{"Bad port", SNMPConfig{Target: "nms.local:abc"}, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client, err := newSNMPClient(tc.cfg, "host1")
+			if (err != nil) != tc.shouldError {
+				t.Fatalf("Expected error=%v, got %v", tc.shouldError, err)
+			}
+			if err != nil {
+				return
+			}
+			if tc.cfg.Target == "nms.local" && client.Port != 162 {
+				t.Errorf("Expected default port 162, got %d", client.Port)
+			}
+			if tc.cfg.Version == "3" && client.MsgFlags != gosnmp.AuthPriv {
+				t.Errorf("Expected AuthPriv flags, got %v", client.MsgFlags)
+			}
+		})
+	}
+}
+
+func TestValidateSNMPConfig(t *testing.T) {
+	valid := SNMPConfig{Target: "nms.local", Version: "2c", AuthProtocol: "SHA", PrivProtocol: "AES", TrapOn: "all"}
+	if err := validateSNMPConfig(valid); err != nil {
+		t.Errorf("Expected the defaults to be valid, got %v", err)
+	}
+	for name, change := range map[string]func(*SNMPConfig){
+		"version":       func(c *SNMPConfig) { c.Version = "2" },
+		"auth-protocol": func(c *SNMPConfig) { c.AuthProtocol = "SHA1" },
+		"priv-protocol": func(c *SNMPConfig) { c.PrivProtocol = "AES256" },
+		"trap-on":       func(c *SNMPConfig) { c.TrapOn = "breaches" },
+		"target":        func(c *SNMPConfig) { c.Target = "nms.local:abc" },
+		"user":          func(c *SNMPConfig) { c.Version = "3" },
+	} {
+		cfg := valid
+		change(&cfg)
+		if err := validateSNMPConfig(cfg); err == nil {
+			t.Errorf("Expected an invalid %s to be rejected", name)
+		}
+	}
+}
+
+func TestSendSNMPTrap_V2c(t *testing.T) {
+	listener := gosnmp.NewTrapListener()
+	received := make(chan *gosnmp.SnmpPacket, 1)
+	listener.OnNewTrap = func(packet *gosnmp.SnmpPacket, addr *net.UDPAddr) {
+		received <- packet
+	}
+	listener.Params = gosnmp.Default
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to reserve a port: %v", err)
+	}
+	addr := conn.LocalAddr().String()
+	conn.Close()
+
+	go listener.Listen(addr)
+	defer listener.Close()
+	select {
+	case <-listener.Listening():
+	case <-time.After(2 * time.Second):
+		t.Fatal("Trap listener did not start")
+	}
+
+	record := newRunRecord("", &LibrespeedResult{Download: 12.5, Server: ServerInfo{URL: "http://server"}}, nil, time.Second)
+	if err := sendSNMPTrap(SNMPConfig{Target: addr, TrapOn: "all"}, "host1", record, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	select {
+	case packet := <-received:
+		values := map[string]interface{}{}
+		for _, v := range packet.Variables {
+			values[v.Name] = v.Value
+		}
+		if values[snmpTrapOID] != snmpNotifyResult {
+			t.Errorf("Expected result notification, got %v", values[snmpTrapOID])
+		}
+		if values[snmpObjDownloadKbps] != uint(12500) {
+			t.Errorf("Expected 12500 kbps, got %v (%T)", values[snmpObjDownloadKbps], values[snmpObjDownloadKbps])
+		}
+		if string(values[snmpObjHostname].([]byte)) != "host1" {
+			t.Errorf("Expected hostname host1, got %v", values[snmpObjHostname])
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for trap")
+	}
+}
+
+func TestSendSNMPTrap_BreachModeSkipsHealthyRuns(t *testing.T) {
+	record := newRunRecord("", &LibrespeedResult{Download: 100}, nil, time.Second)
+	// No listener needed: nothing should be sent
+	if err := sendSNMPTrap(SNMPConfig{Target: "127.0.0.1:1", TrapOn: "breach"}, "host1", record, nil); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	syslogFacilityLocal0 = 16
+	syslogSeverityWarn   = 4
+	syslogSeverityInfo   = 6
+
+	// Private enterprise number reserved for documentation (RFC 5612).
+	syslogSDID = "librespeed@32473"
+)
+
+type SyslogConfig struct {
+	Address string
+	Network string
+}
+
+var sdParamEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// Formats a run record as an RFC 5424 message with the measurements carried
+// in structured data, so receivers can parse fields without regexes.
+func formatSyslogMessage(record RunRecord, hostname string, ts time.Time) string {
+	severity := syslogSeverityInfo
+	if record.Status != "success" {
+		severity = syslogSeverityWarn
+	}
+
+	params := []struct{ name, value string }{
+		{"status", record.Status},
+		{"stage", record.Stage},
+		{"server", record.Server},
+		{"download_mbps", strconv.FormatFloat(record.Download, 'f', 2, 64)},
+		{"upload_mbps", strconv.FormatFloat(record.Upload, 'f', 2, 64)},
+		{"ping_ms", strconv.FormatFloat(record.Ping, 'f', 2, 64)},
+		{"jitter_ms", strconv.FormatFloat(record.Jitter, 'f', 2, 64)},
+		{"duration_seconds", strconv.FormatFloat(record.DurationSeconds, 'f', 1, 64)},
+	}
+	var sd strings.Builder
+	sd.WriteString("[" + syslogSDID)
+	for _, p := range params {
+		if p.value == "" {
+			continue
+		}
+		fmt.Fprintf(&sd, ` %s="%s"`, p.name, sdParamEscaper.Replace(p.value))
+	}
+	sd.WriteString("]")
+
+	msg := fmt.Sprintf("speed test %s", record.Status)
+	if record.Error != "" {
+		msg += ": " + record.Error
+	}
+
+	if hostname == "" {
+		hostname = "-"
+	}
+	return fmt.Sprintf("<%d>1 %s %s librespeed_exporter %d result %s %s",
+		syslogFacilityLocal0*8+severity,
+		ts.UTC().Format(time.RFC3339Nano),
+		hostname,
+		os.Getpid(),
+		sd.String(),
+		msg,
+	)
+}
+
+func sendSyslog(cfg SyslogConfig, message string) error {
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	switch cfg.Network {
+	case "", "udp":
+		conn, err = dialer.Dial("udp", cfg.Address)
+	case "tcp":
+		conn, err = dialer.Dial("tcp", cfg.Address)
+	case "tls":
+		conn, err = tls.DialWithDialer(dialer, "tcp", cfg.Address, &tls.Config{MinVersion: tls.VersionTLS12})
+	default:
+		return fmt.Errorf("unknown syslog network: %s (supported: udp, tcp, tls)", cfg.Network)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to syslog server: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	// Stream transports use octet-counting framing (RFC 6587 / RFC 5425)
+	payload := message
+	if cfg.Network == "tcp" || cfg.Network == "tls" {
+		payload = fmt.Sprintf("%d %s", len(message), message)
+	}
+	if _, err := conn.Write([]byte(payload)); err != nil {
+		return fmt.Errorf("failed to send syslog message: %v", err)
+	}
+
+	log.Printf("Run record sent to syslog at %s (%s)", cfg.Address, cfg.Network)
+	return nil
+}
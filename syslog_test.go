@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatSyslogMessage(t *testing.T) {
+	record := newRunRecord("", &LibrespeedResult{Download: 100.5, Upload: 50, Ping: 10, Jitter: 1, Server: ServerInfo{URL: `http://srv/"x"]`}}, nil, 2*time.Second)
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	msg := formatSyslogMessage(record, "host1", ts)
+
+	if !strings.HasPrefix(msg, "<134>1 2024-01-02T03:04:05Z host1 librespeed_exporter ") {
+		t.Errorf("Unexpected header: %s", msg)
+	}
+	if !strings.Contains(msg, `[librespeed@32473 status="success"`) {
+		t.Errorf("Expected structured data, got: %s", msg)
+	}
+	if !strings.Contains(msg, `download_mbps="100.50"`) {
+		t.Errorf("Expected download param, got: %s", msg)
+	}
+	if !strings.Contains(msg, `server="http://srv/\"x\"\]"`) {
+		t.Errorf("Expected escaped server param, got: %s", msg)
+	}
+	if strings.Contains(msg, "stage=") {
+		t.Errorf("Expected empty stage to be omitted, got: %s", msg)
+	}
+}
+
+func TestFormatSyslogMessage_Failure(t *testing.T) {
+	record := newRunRecord("speedtest", nil, fmt.Errorf("command failed"), time.Second)
+	msg := formatSyslogMessage(record, "", time.Now())
+	if !strings.HasPrefix(msg, "<132>1 ") {
+		t.Errorf("Expected warning severity, got: %s", msg)
+	}
+	if !strings.HasSuffix(msg, "speed test failure: command failed") {
+		t.Errorf("Expected failure message, got: %s", msg)
+	}
+}
+
+func TestSendSyslog_UDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer conn.Close()
+
+	if err := sendSyslog(SyslogConfig{Address: conn.LocalAddr().String(), Network: "udp"}, "<134>1 test"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	if string(buf[:n]) != "<134>1 test" {
+		t.Errorf("Unexpected datagram: %q", string(buf[:n]))
+	}
+}
+
+func TestSendSyslog_TCPOctetCounting(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		received <- line
+	}()
+
+	if err := sendSyslog(SyslogConfig{Address: listener.Addr().String(), Network: "tcp"}, "<134>1 test"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	select {
+	case msg := <-received:
+		if msg != "11 <134>1 test" {
+			t.Errorf("Expected octet-counted frame, got %q", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for syslog message")
+	}
+}
+
+func TestSendSyslog_UnknownNetwork(t *testing.T) {
+	if err := sendSyslog(SyslogConfig{Address: "127.0.0.1:514", Network: "sctp"}, "msg"); err == nil {
+		t.Error("Expected error for unknown network, got nil")
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net"
+	"syscall"
+)
+
+// Kernel view of a download connection. We are the receiving end, so the
+// sender-side numbers (retransmits, congestion window) belong to the server
+// and aren't visible here; out-of-order arrivals are the receiver's sign of
+// loss and retransmission.
+type tcpStats struct {
+	RTT        float64 // smoothed RTT in ms
+	RcvRTT     float64 // receiver-side RTT estimate in ms
+	OutOfOrder uint32  // out-of-order packets received (Linux 5.4+)
+}
+
+// Socket under conn, looking through TLS.
+func rawTCPConn(conn net.Conn) (syscall.RawConn, bool) {
+	if tlsConn, ok := conn.(interface{ NetConn() net.Conn }); ok {
+		conn = tlsConn.NetConn()
+	}
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return nil, false
+	}
+	raw, err := sc.SyscallConn()
+	return raw, err == nil
+}
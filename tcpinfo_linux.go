@@ -0,0 +1,27 @@
+//go:build linux
+
+package main
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+func readTCPStats(raw syscall.RawConn) (*tcpStats, error) {
+	var info *unix.TCPInfo
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		info, sockErr = unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO)
+	}); err != nil {
+		return nil, err
+	}
+	if sockErr != nil {
+		return nil, sockErr
+	}
+	return &tcpStats{
+		RTT:        float64(info.Rtt) / 1000,
+		RcvRTT:     float64(info.Rcv_rtt) / 1000,
+		OutOfOrder: info.Rcv_ooopack,
+	}, nil
+}
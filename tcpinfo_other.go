@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+)
+
+func readTCPStats(raw syscall.RawConn) (*tcpStats, error) {
+	return nil, fmt.Errorf("TCP_INFO is not supported on %s", runtime.GOOS)
+}
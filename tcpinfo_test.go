@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMeasureCDNTarget_TCPInfo(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("TCP_INFO is read on Linux only")
+	}
+	payload := strings.Repeat("x", 1<<20)
+	for _, newServer := range []func(http.Handler) *httptest.Server{httptest.NewServer, httptest.NewTLSServer} {
+		server := newServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(payload))
+		}))
+		result, err := measureCDNTarget(context.Background(), server.Client(), cdnTarget{Name: "edge", URL: server.URL}, 5*time.Second, true)
+		server.Close()
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if result.TCP == nil {
+			t.Fatalf("Expected TCP stats for %s", server.URL)
+		}
+		if result.TCP.RTT <= 0 {
+			t.Errorf("Expected a positive RTT, got %+v", result.TCP)
+		}
+	}
+}
+
+func TestCDNSeries_TCPInfo(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("TCP_INFO is read on Linux only")
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", 1<<20)))
+	}))
+	defer server.Close()
+
+	series := cdnSeries(context.Background(), newCDNClient(false, -1), []cdnTarget{{Name: "edge", URL: server.URL}}, 5*time.Second, true, 1000, "host1")
+	names := map[string]bool{}
+	for _, ts := range series {
+		names[getLabelValue(ts.Labels, "__name__")] = true
+	}
+	for _, want := range []string{"librespeed_cdn_tcp_rtt_ms", "librespeed_cdn_tcp_rcv_rtt_ms", "librespeed_cdn_tcp_out_of_order_packets"} {
+		if !names[want] {
+			t.Errorf("Expected %s, got %v", want, names)
+		}
+	}
+}
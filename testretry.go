@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"log"
+)
+
+// Runs the test, retrying up to retries times after a failure with the
+// remote_write backoff between attempts. Retries go to the servers in
+// fallback in turn, or to serverID again when there are none. Returns the
+// number of attempts made.
+func runWithTestRetries(ctx context.Context, runner CommandRunner, cliPath, localJSONPath string, serverID int, fallback []int, retries int, opts TestOptions) (*LibrespeedResult, int, error) {
+	id := serverID
+	for attempt := 1; ; attempt++ {
+		result, err := runLibrespeedWithOptions(runner, cliPath, localJSONPath, &id, opts)
+		if err == nil || attempt > retries || ctx.Err() != nil {
+			return result, attempt, err
+		}
+		if len(fallback) > 0 {
+			id = fallback[(attempt-1)%len(fallback)]
+		}
+		delay := retryDelayFunc(attempt)
+		log.Printf("WARNING: Speed test failed (%v), retrying against server %d in %v (attempt %d/%d)", err, id, delay, attempt+1, retries+1)
+		select {
+		case <-ctx.Done():
+			return nil, attempt, ctx.Err()
+		case <-clock.After(delay):
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunWithTestRetries_FallbackServers(t *testing.T) {
+	fake := useFakeClock(t, time.Now())
+	runner := &serverRunner{results: map[string]float64{"7": 80}}
+
+	result, attempts, err := runWithTestRetries(context.Background(), runner, "librespeed-cli", "servers.json", 1, []int{5, 7}, 2, TestOptions{})
+	if err != nil {
+		t.Fatalf("Expected the third attempt to succeed, got %v", err)
+	}
+	if attempts != 3 || result.Download != 80 {
+		t.Errorf("Expected 3 attempts ending on server 7, got %d (%v)", attempts, result)
+	}
+	if fmt.Sprint(runner.calls) != "[1 5 7]" {
+		t.Errorf("Expected servers 1, 5, 7 in turn, got %v", runner.calls)
+	}
+	// The remote_write backoff: attempt n waits [2^(n-1), 2^n) seconds
+	if len(fake.Sleeps) != 2 {
+		t.Fatalf("Expected a wait before each retry, got %v", fake.Sleeps)
+	}
+	for i, d := range fake.Sleeps {
+		min := time.Duration(1<<i) * time.Second
+		if d < min || d >= 2*min {
+			t.Errorf("Wait %d: expected [%v, %v), got %v", i+1, min, 2*min, d)
+		}
+	}
+}
+
+func TestRunWithTestRetries_GivesUp(t *testing.T) {
+	useFakeClock(t, time.Now())
+	runner := &serverRunner{results: map[string]float64{}}
+
+	_, attempts, err := runWithTestRetries(context.Background(), runner, "librespeed-cli", "servers.json", 1, nil, 1, TestOptions{})
+	if err == nil {
+		t.Fatal("Expected an error after the retries, got nil")
+	}
+	if attempts != 2 || fmt.Sprint(runner.calls) != "[1 1]" {
+		t.Errorf("Expected the same server twice, got %d attempts on %v", attempts, runner.calls)
+	}
+}
+
+func TestRun_ReportsTestAttempts(t *testing.T) {
+	useFakeClock(t, time.Now())
+	runner := &serverRunner{results: map[string]float64{"2": 90}}
+	rc := newTestRunContext(t, "", runner)
+	rc.cfg.URL = ""
+	rc.cfg.LocalJSONPath = "servers.json"
+	rc.cfg.TestRetries = 1
+	rc.cfg.RetryServerIDs = serverIDList{2}
+	rc.cache = &resultCache{}
+
+	if err := rc.runOnce(context.Background()); err != nil {
+		t.Fatalf("Expected the retry to succeed, got %v", err)
+	}
+	found := false
+	for _, ts := range rc.cache.series {
+		if ts.Labels[0].Value == "librespeed_test_attempts" {
+			found = true
+			if ts.Samples[0].Value != 2 {
+				t.Errorf("Expected 2 attempts, got %v", ts.Samples[0].Value)
+			}
+		}
+	}
+	if !found {
+		t.Error("Expected librespeed_test_attempts in the result")
+	}
+}
+
+func TestConfigure_TestRetries(t *testing.T) {
+	configure := func(args ...string) error {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		cfg := &Config{}
+		cfg.RegisterFlags(fs)
+		if err := fs.Parse(append([]string{"--url", "http://localhost:9090/api/v1/write", "--username", "u", "--password", "p", "--state-dir", t.TempDir()}, args...)); err != nil {
+			t.Fatal(err)
+		}
+		rc := &runContext{flags: fs}
+		return rc.configure(cfg, explicitFlags(fs))
+	}
+	if err := configure("--test-retries", "2"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	// The counters are read around the whole run, so a retried test would
+	// count the failed attempts' traffic too
+	if err := configure("--test-retries", "2", "--check-interface-counters"); err == nil || !strings.Contains(err.Error(), "--check-interface-counters") {
+		t.Errorf("Expected --test-retries to be rejected with --check-interface-counters, got %v", err)
+	}
+	if err := configure("--test-retries", "2", "--auto-concurrency"); err == nil {
+		t.Error("Expected --test-retries to be rejected with --auto-concurrency")
+	}
+}
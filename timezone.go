@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"time"
+	// Windows has no zoneinfo database of its own
+	_ "time/tzdata"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// Time zone of the site being measured, which need not be the host's: results
+// are labelled with it and report the local time of the test, so sites across
+// many zones can be compared by local business hours.
+type siteTimezone struct {
+	Name     string
+	Location *time.Location
+}
+
+// nil without a name.
+func parseTimezone(name string) (*siteTimezone, error) {
+	if name == "" {
+		return nil, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil || name == "Local" {
+		return nil, fmt.Errorf("invalid --timezone %q, expected an IANA name such as Europe/Berlin", name)
+	}
+	return &siteTimezone{Name: name, Location: loc}, nil
+}
+
+func (z *siteTimezone) labels() map[string]string {
+	if z == nil {
+		return nil
+	}
+	return map[string]string{"timezone": z.Name}
+}
+
+// librespeed_local_hour, librespeed_local_weekday (0 is Sunday) and
+// librespeed_utc_offset_seconds at t. The hour is a metric rather than a
+// label so it doesn't multiply the series.
+func (z *siteTimezone) series(t time.Time, serverURL, instance string) []*prompb.TimeSeries {
+	if z == nil {
+		return nil
+	}
+	local := t.In(z.Location)
+	_, offset := local.Zone()
+	now := t.UnixMilli()
+	return []*prompb.TimeSeries{
+		createTimeSeries("librespeed_local_hour", float64(local.Hour()), now, serverURL, instance),
+		createTimeSeries("librespeed_local_weekday", float64(local.Weekday()), now, serverURL, instance),
+		createTimeSeries("librespeed_utc_offset_seconds", float64(offset), now, serverURL, instance),
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseTimezone(t *testing.T) {
+	if z, err := parseTimezone(""); z != nil || err != nil {
+		t.Errorf("Expected no time zone, got %v, %v", z, err)
+	}
+	for _, name := range []string{"Mars/Olympus", "Local", "+02:00"} {
+		if _, err := parseTimezone(name); err == nil {
+			t.Errorf("Expected %q to be rejected", name)
+		}
+	}
+	z, err := parseTimezone("America/Chicago")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if z.labels()["timezone"] != "America/Chicago" {
+		t.Errorf("Expected a timezone label, got %v", z.labels())
+	}
+}
+
+func TestSiteTimezone_Series(t *testing.T) {
+	z, _ := parseTimezone("America/Chicago")
+	values := map[string]float64{}
+	for _, ts := range z.series(time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC), "", "host1") {
+		values[ts.Labels[0].Value] = ts.Samples[0].Value
+	}
+	for name, want := range map[string]float64{
+		"librespeed_local_hour":         6,
+		"librespeed_local_weekday":      5,
+		"librespeed_utc_offset_seconds": -6 * 3600,
+	} {
+		if values[name] != want {
+			t.Errorf("Expected %s %v, got %v", name, want, values[name])
+		}
+	}
+
+	var none *siteTimezone
+	if none.series(time.Now(), "", "host1") != nil || none.labels() != nil {
+		t.Error("Expected nothing without --timezone")
+	}
+}
+
+func TestRun_TimezoneLabels(t *testing.T) {
+	useFakeClock(t, time.Date(2024, 7, 1, 22, 0, 0, 0, time.UTC))
+	runner := &MockRunner{Output: []byte(`[{"download":100.0,"upload":50.0,"ping":10.0,"jitter":1.0,"server":{"url":"http://example.com"}}]`)}
+	rc := newTestRunContext(t, "", runner)
+	rc.cfg.URL = ""
+	rc.cache = &resultCache{}
+	rc.timezone, _ = parseTimezone("Asia/Tokyo")
+
+	if err := rc.runOnce(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	hour := -1.0
+	for _, ts := range rc.cache.series {
+		if getLabelValue(ts.Labels, "timezone") != "Asia/Tokyo" {
+			t.Errorf("Expected every series to carry the timezone, got %v", ts.Labels)
+		}
+		if ts.Labels[0].Value == "librespeed_local_hour" {
+			hour = ts.Samples[0].Value
+		}
+	}
+	if hour != 7 {
+		t.Errorf("Expected local hour 7, got %v", hour)
+	}
+}
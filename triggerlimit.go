@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+var errTooManyTests = errors.New("too many triggered tests are queued or running")
+
+// A caller started its hourly allowance of tests.
+type rateLimitError struct {
+	caller     string
+	retryAfter time.Duration
+}
+
+func (e *rateLimitError) Error() string {
+	return fmt.Sprintf("%s started too many tests in the last hour, retry in %v", e.caller, e.retryAfter.Round(time.Second))
+}
+
+// Limits on tests started over the HTTP, webhook and gRPC APIs, so a
+// runaway script can't keep the link busy: each caller may start perHour
+// tests in any hour, and at most maxActive may be queued or running at once.
+// Limits are replaced on reload. A nil *triggerLimiter allows everything.
+type triggerLimiter struct {
+	mu        sync.Mutex
+	perHour   int
+	maxActive int
+	active    int
+	started   map[string][]time.Time
+}
+
+func (l *triggerLimiter) set(perHour, maxActive int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.perHour = perHour
+	l.maxActive = maxActive
+}
+
+// Takes a slot for caller, returning errTooManyTests or a *rateLimitError
+// when it may not start a test now. release frees the slot once the run is
+// over.
+func (l *triggerLimiter) acquire(caller string) (release func(), err error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := clock.Now()
+	if l.started == nil {
+		l.started = map[string][]time.Time{}
+	}
+	// Forget starts older than an hour, and callers without any
+	for name, times := range l.started {
+		i := 0
+		for i < len(times) && now.Sub(times[i]) >= time.Hour {
+			i++
+		}
+		if i == len(times) {
+			delete(l.started, name)
+		} else {
+			l.started[name] = times[i:]
+		}
+	}
+	if l.maxActive > 0 && l.active >= l.maxActive {
+		return nil, errTooManyTests
+	}
+	if times := l.started[caller]; l.perHour > 0 && len(times) >= l.perHour {
+		return nil, &rateLimitError{caller: caller, retryAfter: times[len(times)-l.perHour].Add(time.Hour).Sub(now)}
+	}
+	l.started[caller] = append(l.started[caller], now)
+	l.active++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			l.active--
+		})
+	}, nil
+}
+
+// Who a trigger counts against: the API token's name if there is one,
+// otherwise the address it came from without the port.
+func triggerCaller(token, addr string) string {
+	if token != "" {
+		return token
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
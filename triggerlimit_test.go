@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTriggerLimiter_RatePerCaller(t *testing.T) {
+	fake := useFakeClock(t, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	limiter := &triggerLimiter{}
+	limiter.set(2, 5)
+
+	for i := 0; i < 2; i++ {
+		release, err := limiter.acquire("ci")
+		if err != nil {
+			t.Fatalf("Start %d: expected no error, got %v", i+1, err)
+		}
+		release()
+		fake.Advance(10 * time.Minute)
+	}
+	_, err := limiter.acquire("ci")
+	var limited *rateLimitError
+	if !errors.As(err, &limited) {
+		t.Fatalf("Expected a rate limit error, got %v", err)
+	}
+	// The first start leaves the window 40 minutes from now
+	if limited.retryAfter != 40*time.Minute {
+		t.Errorf("Expected to retry in 40m, got %v", limited.retryAfter)
+	}
+	if release, err := limiter.acquire("helpdesk"); err != nil {
+		t.Errorf("Expected another caller to have its own allowance, got %v", err)
+	} else {
+		release()
+	}
+
+	fake.Advance(40 * time.Minute)
+	if release, err := limiter.acquire("ci"); err != nil {
+		t.Errorf("Expected a start once the first left the hour, got %v", err)
+	} else {
+		release()
+	}
+}
+
+func TestTriggerLimiter_MaxActive(t *testing.T) {
+	useFakeClock(t, time.Now())
+	limiter := &triggerLimiter{}
+	limiter.set(0, 1)
+
+	release, err := limiter.acquire("ci")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := limiter.acquire("helpdesk"); err != errTooManyTests {
+		t.Errorf("Expected errTooManyTests while a test is outstanding, got %v", err)
+	}
+	release()
+	release()
+	second, err := limiter.acquire("helpdesk")
+	if err != nil {
+		t.Fatalf("Expected a slot after release, got %v", err)
+	}
+	if _, err := limiter.acquire("ci"); err != errTooManyTests {
+		t.Errorf("Expected a second release not to free another slot, got %v", err)
+	}
+	second()
+
+	var unlimited *triggerLimiter
+	if _, err := unlimited.acquire("ci"); err != nil {
+		t.Errorf("Expected a nil limiter to allow everything, got %v", err)
+	}
+}
+
+func TestTriggerCaller(t *testing.T) {
+	for _, tt := range []struct{ token, addr, want string }{
+		{"ci", "10.0.0.5:51234", "ci"},
+		{"", "10.0.0.5:51234", "10.0.0.5"},
+		{"", "[2001:db8::1]:443", "2001:db8::1"},
+		{"", "grpc", "grpc"},
+	} {
+		if got := triggerCaller(tt.token, tt.addr); got != tt.want {
+			t.Errorf("triggerCaller(%q, %q): expected %q, got %q", tt.token, tt.addr, tt.want, got)
+		}
+	}
+}
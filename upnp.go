@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// SSDP group and how long the gateway has to answer; replaced by tests.
+var (
+	ssdpGroup         = "239.255.255.250:1900"
+	upnpSearchTimeout = 2 * time.Second
+)
+
+// What the Internet gateway reports about its WAN link. Rates are in bits
+// per second and 0 when the gateway doesn't say.
+type wanInfo struct {
+	Gateway       string
+	ExternalIP    string
+	AccessType    string
+	LinkStatus    string
+	DownstreamBps uint64
+	UpstreamBps   uint64
+}
+
+// Part of a UPnP device description.
+type upnpDevice struct {
+	FriendlyName string `xml:"friendlyName"`
+	ModelName    string `xml:"modelName"`
+	Services     []struct {
+		ServiceType string `xml:"serviceType"`
+		ControlURL  string `xml:"controlURL"`
+	} `xml:"serviceList>service"`
+	Devices []upnpDevice `xml:"deviceList>device"`
+}
+
+// Control URL and service type of the first service, depth first, whose
+// type starts with prefix.
+func (d upnpDevice) service(prefix string) (controlURL, serviceType string) {
+	for _, s := range d.Services {
+		if strings.HasPrefix(s.ServiceType, prefix) {
+			return s.ControlURL, s.ServiceType
+		}
+	}
+	for _, child := range d.Devices {
+		if controlURL, serviceType = child.service(prefix); controlURL != "" {
+			return controlURL, serviceType
+		}
+	}
+	return "", ""
+}
+
+// Asks the LAN for an Internet gateway via SSDP and returns the location of
+// the device description of the first that answers.
+func findUPnPGateway(ctx context.Context, timeout time.Duration) (string, error) {
+	group, err := net.ResolveUDPAddr("udp4", ssdpGroup)
+	if err != nil {
+		return "", err
+	}
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return "", fmt.Errorf("failed to open SSDP socket: %v", err)
+	}
+	defer conn.Close()
+	// IGD:2 gateways also answer for IGD:1
+	search := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: 239.255.255.250:1900\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n" +
+		"ST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n\r\n"
+	if _, err := conn.WriteToUDP([]byte(search), group); err != nil {
+		return "", fmt.Errorf("failed to send SSDP search: %v", err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetReadDeadline(deadline)
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return "", fmt.Errorf("no UPnP Internet gateway answered within %s", timeout)
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			continue
+		}
+		if location := resp.Header.Get("Location"); location != "" {
+			return location, nil
+		}
+	}
+}
+
+// Calls action of the service at controlURL without arguments and returns
+// the output arguments by name.
+func upnpAction(ctx context.Context, client *http.Client, controlURL, serviceType, action string) (map[string]string, error) {
+	body := fmt.Sprintf(`<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+<s:Body><u:%s xmlns:u="%s"></u:%s></s:Body>
+</s:Envelope>`, action, serviceType, action)
+	req, err := http.NewRequestWithContext(ctx, "POST", controlURL, strings.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %v", err)
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", fmt.Sprintf(`"%s#%s"`, serviceType, action))
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %v", action, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s failed with status: %s", action, resp.Status)
+	}
+	// The arguments are the leaf elements of the response
+	values := map[string]string{}
+	decoder := xml.NewDecoder(io.LimitReader(resp.Body, 1<<20))
+	var name string
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return values, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s response: %v", action, err)
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			name = t.Name.Local
+		case xml.CharData:
+			if name != "" {
+				values[name] = strings.TrimSpace(string(t))
+			}
+		case xml.EndElement:
+			name = ""
+		}
+	}
+}
+
+// Finds the gateway and asks it for its WAN link properties and external
+// address. Either is enough; it is an error only if both fail.
+func queryWANInfo(ctx context.Context) (*wanInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	location, err := findUPnPGateway(ctx, upnpSearchTimeout)
+	if err != nil {
+		return nil, err
+	}
+	base, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid UPnP description location %q: %v", location, err)
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+	req, err := http.NewRequestWithContext(ctx, "GET", location, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %v", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch UPnP description: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("UPnP description request failed with status: %s", resp.Status)
+	}
+	var root struct {
+		URLBase string     `xml:"URLBase"`
+		Device  upnpDevice `xml:"device"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&root); err != nil {
+		return nil, fmt.Errorf("failed to parse UPnP description: %v", err)
+	}
+	if root.URLBase != "" {
+		if u, err := url.Parse(root.URLBase); err == nil {
+			base = u
+		}
+	}
+	resolve := func(ref string) string {
+		u, err := base.Parse(ref)
+		if err != nil {
+			return ref
+		}
+		return u.String()
+	}
+
+	info := &wanInfo{Gateway: root.Device.ModelName}
+	if info.Gateway == "" {
+		info.Gateway = root.Device.FriendlyName
+	}
+	var errs []string
+	if control, serviceType := root.Device.service("urn:schemas-upnp-org:service:WANCommonInterfaceConfig:"); control == "" {
+		errs = append(errs, "gateway has no WANCommonInterfaceConfig service")
+	} else if values, err := upnpAction(ctx, client, resolve(control), serviceType, "GetCommonLinkProperties"); err != nil {
+		errs = append(errs, err.Error())
+	} else {
+		info.AccessType = values["NewWANAccessType"]
+		info.LinkStatus = values["NewPhysicalLinkStatus"]
+		info.DownstreamBps, _ = strconv.ParseUint(values["NewLayer1DownstreamMaxBitRate"], 10, 64)
+		info.UpstreamBps, _ = strconv.ParseUint(values["NewLayer1UpstreamMaxBitRate"], 10, 64)
+	}
+	// PPPoE links are WANPPPConnection, the others WANIPConnection
+	control, serviceType := root.Device.service("urn:schemas-upnp-org:service:WANIPConnection:")
+	if control == "" {
+		control, serviceType = root.Device.service("urn:schemas-upnp-org:service:WANPPPConnection:")
+	}
+	if control == "" {
+		errs = append(errs, "gateway has no WANIPConnection or WANPPPConnection service")
+	} else if values, err := upnpAction(ctx, client, resolve(control), serviceType, "GetExternalIPAddress"); err != nil {
+		errs = append(errs, err.Error())
+	} else {
+		info.ExternalIP = values["NewExternalIPAddress"]
+	}
+	switch len(errs) {
+	case 2:
+		return nil, fmt.Errorf("failed to query UPnP gateway %s: %s", location, strings.Join(errs, "; "))
+	case 1:
+		log.Printf("WARNING: UPnP gateway %s: %s", location, errs[0])
+	}
+	return info, nil
+}
+
+// librespeed_wan_info with what the gateway reported as labels, and the
+// sync rates it claims, for comparison with the measured throughput.
+func (w *wanInfo) series(now int64, serverURL, hostname string) []*prompb.TimeSeries {
+	info := createTimeSeries("librespeed_wan_info", 1, now, serverURL, hostname)
+	labels := map[string]string{}
+	for name, value := range map[string]string{
+		"gateway":     w.Gateway,
+		"external_ip": w.ExternalIP,
+		"access_type": w.AccessType,
+		"link_status": w.LinkStatus,
+	} {
+		if value != "" {
+			labels[name] = value
+		}
+	}
+	addLabels([]*prompb.TimeSeries{info}, labels)
+	series := []*prompb.TimeSeries{info}
+	if w.DownstreamBps > 0 {
+		series = append(series, createTimeSeries("librespeed_wan_sync_download_mbps", float64(w.DownstreamBps)/1e6, now, serverURL, hostname))
+	}
+	if w.UpstreamBps > 0 {
+		series = append(series, createTimeSeries("librespeed_wan_sync_upload_mbps", float64(w.UpstreamBps)/1e6, now, serverURL, hostname))
+	}
+	return series
+}
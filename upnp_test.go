@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testIGDDescription = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <device>
+    <deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+    <friendlyName>FRITZ!Box 7590</friendlyName>
+    <modelName>FRITZ!Box 7590</modelName>
+    <deviceList>
+      <device>
+        <deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+        <serviceList>
+          <service>
+            <serviceType>urn:schemas-upnp-org:service:WANCommonInterfaceConfig:1</serviceType>
+            <controlURL>/igdupnp/control/WANCommonIFC1</controlURL>
+          </service>
+        </serviceList>
+        <deviceList>
+          <device>
+            <deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+            <serviceList>
+              <service>
+                <serviceType>urn:schemas-upnp-org:service:WANPPPConnection:1</serviceType>
+                <controlURL>/igdupnp/control/WANPPPConn1</controlURL>
+              </service>
+            </serviceList>
+          </device>
+        </deviceList>
+      </device>
+    </deviceList>
+  </device>
+</root>`
+
+// Answers SSDP searches with the location of a fake gateway serving
+// description and the SOAP responses in actions, by SOAPAction.
+func serveUPnPGateway(t *testing.T, description string, actions map[string]string) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" && r.URL.Path == "/igd.xml" {
+			w.Write([]byte(description))
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		action := strings.Trim(r.Header.Get("SOAPAction"), `"`)
+		response, ok := actions[action]
+		if !ok || !strings.Contains(string(body), "<s:Body>") {
+			http.Error(w, "unknown action "+action, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+		w.Write([]byte(`<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>` + response + `</s:Body></s:Envelope>`))
+	}))
+	t.Cleanup(server.Close)
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	savedGroup, savedTimeout := ssdpGroup, upnpSearchTimeout
+	ssdpGroup, upnpSearchTimeout = conn.LocalAddr().String(), 300*time.Millisecond
+	t.Cleanup(func() { ssdpGroup, upnpSearchTimeout = savedGroup, savedTimeout })
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if !strings.HasPrefix(string(buf[:n]), "M-SEARCH * HTTP/1.1\r\n") || !strings.Contains(string(buf[:n]), "InternetGatewayDevice") {
+				continue
+			}
+			// Other devices answer too, without being a gateway
+			conn.WriteToUDP([]byte("NOTIFY * HTTP/1.1\r\nHOST: 239.255.255.250:1900\r\n\r\n"), from)
+			conn.WriteToUDP([]byte("HTTP/1.1 200 OK\r\nCACHE-CONTROL: max-age=1800\r\nLOCATION: "+server.URL+"/igd.xml\r\nST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n\r\n"), from)
+		}
+	}()
+}
+
+func TestQueryWANInfo(t *testing.T) {
+	serveUPnPGateway(t, testIGDDescription, map[string]string{
+		"urn:schemas-upnp-org:service:WANCommonInterfaceConfig:1#GetCommonLinkProperties": `<u:GetCommonLinkPropertiesResponse xmlns:u="urn:schemas-upnp-org:service:WANCommonInterfaceConfig:1">
+<NewWANAccessType>DSL</NewWANAccessType>
+<NewLayer1UpstreamMaxBitRate>40000000</NewLayer1UpstreamMaxBitRate>
+<NewLayer1DownstreamMaxBitRate>250000000</NewLayer1DownstreamMaxBitRate>
+<NewPhysicalLinkStatus>Up</NewPhysicalLinkStatus>
+</u:GetCommonLinkPropertiesResponse>`,
+		"urn:schemas-upnp-org:service:WANPPPConnection:1#GetExternalIPAddress": `<u:GetExternalIPAddressResponse xmlns:u="urn:schemas-upnp-org:service:WANPPPConnection:1"><NewExternalIPAddress>203.0.113.7</NewExternalIPAddress></u:GetExternalIPAddressResponse>`,
+	})
+
+	info, err := queryWANInfo(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := wanInfo{Gateway: "FRITZ!Box 7590", ExternalIP: "203.0.113.7", AccessType: "DSL", LinkStatus: "Up", DownstreamBps: 250000000, UpstreamBps: 40000000}
+	if *info != want {
+		t.Errorf("Expected %+v, got %+v", want, *info)
+	}
+
+	series := info.series(1000, "http://server", "host")
+	if len(series) != 3 {
+		t.Fatalf("Expected info and two sync rate series, got %d", len(series))
+	}
+	if getLabelValue(series[0].Labels, "external_ip") != "203.0.113.7" || getLabelValue(series[0].Labels, "access_type") != "DSL" {
+		t.Errorf("Unexpected librespeed_wan_info labels %v", series[0].Labels)
+	}
+	if getLabelValue(series[1].Labels, "__name__") != "librespeed_wan_sync_download_mbps" || series[1].Samples[0].Value != 250 {
+		t.Errorf("Expected a 250 Mbps download sync rate, got %v", series[1])
+	}
+	if series[2].Samples[0].Value != 40 {
+		t.Errorf("Expected a 40 Mbps upload sync rate, got %v", series[2].Samples[0].Value)
+	}
+}
+
+func TestQueryWANInfo_ExternalIPOnly(t *testing.T) {
+	// Gateways often refuse GetCommonLinkProperties; the address still counts
+	serveUPnPGateway(t, testIGDDescription, map[string]string{
+		"urn:schemas-upnp-org:service:WANPPPConnection:1#GetExternalIPAddress": `<u:GetExternalIPAddressResponse xmlns:u="urn:schemas-upnp-org:service:WANPPPConnection:1"><NewExternalIPAddress>203.0.113.7</NewExternalIPAddress></u:GetExternalIPAddressResponse>`,
+	})
+	info, err := queryWANInfo(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if info.ExternalIP != "203.0.113.7" || info.DownstreamBps != 0 {
+		t.Errorf("Unexpected %+v", *info)
+	}
+	if series := info.series(1000, "http://server", "host"); len(series) != 1 {
+		t.Errorf("Expected only librespeed_wan_info without sync rates, got %d series", len(series))
+	}
+}
+
+func TestQueryWANInfo_Errors(t *testing.T) {
+	serveUPnPGateway(t, testIGDDescription, nil)
+	if _, err := queryWANInfo(context.Background()); err == nil || !strings.Contains(err.Error(), "GetExternalIPAddress") {
+		t.Errorf("Expected both actions to be reported, got %v", err)
+	}
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ssdpGroup = conn.LocalAddr().String()
+	if _, err := queryWANInfo(context.Background()); err == nil || !strings.Contains(err.Error(), "no UPnP Internet gateway") {
+		t.Errorf("Expected no gateway to be found, got %v", err)
+	}
+}
+
+func TestRun_UPnPWANInfo(t *testing.T) {
+	serveUPnPGateway(t, testIGDDescription, map[string]string{
+		"urn:schemas-upnp-org:service:WANCommonInterfaceConfig:1#GetCommonLinkProperties": `<u:GetCommonLinkPropertiesResponse xmlns:u="urn:schemas-upnp-org:service:WANCommonInterfaceConfig:1"><NewLayer1DownstreamMaxBitRate>100000000</NewLayer1DownstreamMaxBitRate></u:GetCommonLinkPropertiesResponse>`,
+	})
+	runner := &MockRunner{Output: []byte(`[{"download":95.0,"upload":20.0,"ping":10.0,"jitter":1.0,"server":{"url":"http://server"}}]`)}
+	rc := newTestRunContext(t, "", runner)
+	rc.cfg.URL = ""
+	rc.cfg.UPnPWANInfo = true
+	rc.cache = &resultCache{}
+
+	if err := rc.runOnce(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	found := false
+	for _, ts := range rc.cache.series {
+		if getLabelValue(ts.Labels, "__name__") == "librespeed_wan_sync_download_mbps" {
+			found = ts.Samples[0].Value == 100
+		}
+	}
+	if !found {
+		t.Error("Expected librespeed_wan_sync_download_mbps 100")
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How far a webhook's timestamp may be from now, so a captured request
+// can't be replayed later.
+const webhookMaxSkew = 5 * time.Minute
+
+// What a triggered run asks for instead of the configured servers and the
+// profile active now.
+type runRequest struct {
+	ServerID int    `json:"server_id,omitempty"`
+	Profile  string `json:"profile,omitempty"`
+}
+
+type runRequestKey struct{}
+
+func withRunRequest(ctx context.Context, req runRequest) context.Context {
+	return context.WithValue(ctx, runRequestKey{}, req)
+}
+
+// The request a run was triggered with; zero for scheduled runs.
+func requestedRun(ctx context.Context) runRequest {
+	req, _ := ctx.Value(runRequestKey{}).(runRequest)
+	return req
+}
+
+func (req runRequest) String() string {
+	var parts []string
+	if req.ServerID != 0 {
+		parts = append(parts, fmt.Sprintf("server %d", req.ServerID))
+	}
+	if req.Profile != "" {
+		parts = append(parts, "profile "+req.Profile)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// The profile named name, or nil.
+func findProfile(profiles []testProfile, name string) *testProfile {
+	for i := range profiles {
+		if profiles[i].Name == name {
+			return &profiles[i]
+		}
+	}
+	return nil
+}
+
+// Hex HMAC-SHA256 of "<timestamp>.<body>" with secret, as sent in
+// X-Webhook-Signature.
+func signWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Shared secret for POST /api/v1/webhook; replaced on reload. Without one
+// the webhook is off.
+type webhookAuth struct {
+	mu     sync.RWMutex
+	secret string
+}
+
+func (a *webhookAuth) set(secret string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.secret = secret
+}
+
+func (a *webhookAuth) enabled() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.secret != ""
+}
+
+// Checks the request's X-Webhook-Timestamp (Unix seconds) and
+// X-Webhook-Signature against body.
+func (a *webhookAuth) verify(r *http.Request, body []byte) error {
+	a.mu.RLock()
+	secret := a.secret
+	a.mu.RUnlock()
+	timestamp, err := strconv.ParseInt(r.Header.Get("X-Webhook-Timestamp"), 10, 64)
+	if err != nil {
+		return fmt.Errorf("missing or invalid X-Webhook-Timestamp")
+	}
+	if skew := clock.Now().Sub(time.Unix(timestamp, 0)); skew > webhookMaxSkew || skew < -webhookMaxSkew {
+		return fmt.Errorf("X-Webhook-Timestamp is more than %v away from now", webhookMaxSkew)
+	}
+	want := signWebhook(secret, timestamp, body)
+	if !hmac.Equal([]byte(want), []byte(r.Header.Get("X-Webhook-Signature"))) {
+		return fmt.Errorf("invalid X-Webhook-Signature")
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func signedWebhookRequest(t *testing.T, url, secret string, timestamp int64, body string) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest("POST", url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Webhook-Timestamp", fmt.Sprint(timestamp))
+	req.Header.Set("X-Webhook-Signature", signWebhook(secret, timestamp, []byte(body)))
+	return req
+}
+
+func TestWebhookAuth_Verify(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	useFakeClock(t, now)
+	auth := &webhookAuth{}
+	auth.set("s3cret")
+	body := `{"server_id":7}`
+
+	req := signedWebhookRequest(t, "/api/v1/webhook", "s3cret", now.Unix(), body)
+	if err := auth.verify(req, []byte(body)); err != nil {
+		t.Errorf("Expected a valid signature to pass, got %v", err)
+	}
+	if err := auth.verify(req, []byte(`{"server_id":8}`)); err == nil {
+		t.Error("Expected a changed body to fail")
+	}
+	req = signedWebhookRequest(t, "/api/v1/webhook", "other", now.Unix(), body)
+	if err := auth.verify(req, []byte(body)); err == nil {
+		t.Error("Expected another secret's signature to fail")
+	}
+	req = signedWebhookRequest(t, "/api/v1/webhook", "s3cret", now.Add(-10*time.Minute).Unix(), body)
+	if err := auth.verify(req, []byte(body)); err == nil || !strings.Contains(err.Error(), "Timestamp") {
+		t.Errorf("Expected a replayed request to fail, got %v", err)
+	}
+	req.Header.Del("X-Webhook-Timestamp")
+	if err := auth.verify(req, []byte(body)); err == nil {
+		t.Error("Expected a request without timestamp to fail")
+	}
+}
+
+func TestRunAPI_Webhook(t *testing.T) {
+	requests := make(chan runRequest, 1)
+	api := newRunAPI(context.Background(), func(ctx context.Context) (*RunRecord, error) {
+		requests <- requestedRun(ctx)
+		return nil, nil
+	})
+	api.webhook = &webhookAuth{}
+	mux := http.NewServeMux()
+	api.register(mux)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	post := func(req *http.Request) int {
+		t.Helper()
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("POST failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	body := `{"server_id":7,"profile":"night","reason":"INC-1234"}`
+	if status := post(signedWebhookRequest(t, server.URL+"/api/v1/webhook", "", time.Now().Unix(), body)); status != http.StatusNotFound {
+		t.Errorf("Expected 404 without --webhook-secret, got %d", status)
+	}
+
+	api.webhook.set("s3cret")
+	if status := post(signedWebhookRequest(t, server.URL+"/api/v1/webhook", "wrong", time.Now().Unix(), body)); status != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a bad signature, got %d", status)
+	}
+	select {
+	case <-requests:
+		t.Fatal("Expected no run for a bad signature")
+	default:
+	}
+
+	if status := post(signedWebhookRequest(t, server.URL+"/api/v1/webhook", "s3cret", time.Now().Unix(), body)); status != http.StatusAccepted {
+		t.Fatalf("Expected 202, got %d", status)
+	}
+	select {
+	case req := <-requests:
+		if req.ServerID != 7 || req.Profile != "night" {
+			t.Errorf("Expected server 7 with profile night, got %+v", req)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the run to start")
+	}
+}
+
+func TestRun_RequestedServerAndProfile(t *testing.T) {
+	runner := &serverRunner{results: map[string]float64{"7": 80}}
+	rc := newTestRunContext(t, "", runner)
+	rc.cfg.URL = ""
+	rc.cfg.LocalJSONPath = "servers.json"
+	rc.profiles, _ = parseTestProfiles([]string{"night=00:00-00:01;chunks=5"})
+
+	ctx := withRunRequest(context.Background(), runRequest{ServerID: 7, Profile: "night"})
+	if err := rc.runOnce(ctx); err != nil {
+		t.Fatalf("Expected the requested run to succeed, got %v", err)
+	}
+	if fmt.Sprint(runner.calls) != "[7]" {
+		t.Errorf("Expected server 7 to be tested, got %v", runner.calls)
+	}
+
+	ctx = withRunRequest(context.Background(), runRequest{Profile: "weekend"})
+	if err := rc.runOnce(ctx); err == nil || !strings.Contains(err.Error(), "weekend") {
+		t.Errorf("Expected an unknown profile to fail the run, got %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Class definition compiled before every instance, so an upgraded exporter
+// brings its new properties with it. The namespace and class live in the
+// WMI repository, where inventory tools read them like any other class.
+const wmiClassMOF = `#pragma namespace("\\\\.\\root")
+instance of __Namespace
+{
+    Name = "LibreSpeed";
+};
+
+#pragma namespace("\\\\.\\root\\LibreSpeed")
+[Description("Latest result of librespeed_exporter on this machine")]
+class LibreSpeed_Result
+{
+    [key] string Instance;
+    string AgentID;
+    string Status;
+    string Stage;
+    string Error;
+    string ServerURL;
+    real64 DownloadMbps;
+    real64 UploadMbps;
+    real64 PingMs;
+    real64 JitterMs;
+    real64 DurationSeconds;
+    uint32 ConsecutiveFailures;
+    datetime Timestamp;
+};
+`
+
+var mofStringEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+
+func mofString(s string) string {
+	return `"` + mofStringEscaper.Replace(s) + `"`
+}
+
+func mofReal(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// CIM datetime, e.g. 20240301120000.000000+000.
+func cimDatetime(ts time.Time) string {
+	return ts.UTC().Format("20060102150405.000000") + "+000"
+}
+
+// MOF that creates root\LibreSpeed and LibreSpeed_Result if needed and
+// replaces the instance for hostname with record.
+func formatWMIMOF(record RunRecord, hostname, agentID string, ts time.Time) string {
+	var b strings.Builder
+	b.WriteString(wmiClassMOF)
+	b.WriteString("\ninstance of LibreSpeed_Result\n{\n")
+	for _, p := range []struct{ name, value string }{
+		{"Instance", mofString(hostname)},
+		{"AgentID", mofString(agentID)},
+		{"Status", mofString(record.Status)},
+		{"Stage", mofString(record.Stage)},
+		{"Error", mofString(record.Error)},
+		{"ServerURL", mofString(record.Server)},
+		{"DownloadMbps", mofReal(record.Download)},
+		{"UploadMbps", mofReal(record.Upload)},
+		{"PingMs", mofReal(record.Ping)},
+		{"JitterMs", mofReal(record.Jitter)},
+		{"DurationSeconds", mofReal(record.DurationSeconds)},
+		{"ConsecutiveFailures", strconv.Itoa(record.ConsecutiveFailures)},
+		{"Timestamp", mofString(cimDatetime(ts))},
+	} {
+		fmt.Fprintf(&b, "    %s = %s;\n", p.name, p.value)
+	}
+	b.WriteString("};\n")
+	return b.String()
+}
+
+// Compiles mof into the WMI repository with mofcomp, which ships with
+// Windows. Writing to root needs administrator rights, as a service has.
+var compileMOF = func(mof string) error {
+	f, err := os.CreateTemp("", "librespeed-*.mof")
+	if err != nil {
+		return fmt.Errorf("failed to create MOF file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(mof)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write MOF file: %v", err)
+	}
+	out, err := exec.Command("mofcomp.exe", "-class:forceupdate", f.Name()).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("mofcomp failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Publishes record as the LibreSpeed_Result instance for hostname.
+func publishWMI(record RunRecord, hostname, agentID string, ts time.Time) error {
+	return compileMOF(formatWMIMOF(record, hostname, agentID, ts))
+}
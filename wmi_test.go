@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatWMIMOF(t *testing.T) {
+	record := RunRecord{
+		Status:              "failure",
+		Stage:               "speedtest",
+		Error:               "command failed: \"exit 1\"\nC:\\librespeed-cli",
+		Download:            94.25,
+		Upload:              40,
+		ConsecutiveFailures: 2,
+	}
+	mof := formatWMIMOF(record, "branch-pc", "abc", time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+
+	for _, want := range []string{
+		`#pragma namespace("\\\\.\\root\\LibreSpeed")`,
+		"class LibreSpeed_Result",
+		`    Instance = "branch-pc";`,
+		`    Error = "command failed: \"exit 1\"\nC:\\librespeed-cli";`,
+		"    DownloadMbps = 94.25;",
+		"    UploadMbps = 40;",
+		"    ConsecutiveFailures = 2;",
+		`    Timestamp = "20240301120000.000000+000";`,
+	} {
+		if !strings.Contains(mof, want) {
+			t.Errorf("Expected MOF to contain %q, got:\n%s", want, mof)
+		}
+	}
+}
+
+func TestPublishWMI(t *testing.T) {
+	originalCompile := compileMOF
+	defer func() { compileMOF = originalCompile }()
+	var compiled string
+	compileMOF = func(mof string) error {
+		compiled = mof
+		return nil
+	}
+
+	if err := publishWMI(RunRecord{Status: "success"}, "branch-pc", "", time.Now()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(compiled, "instance of LibreSpeed_Result") {
+		t.Errorf("Expected an instance to be compiled, got:\n%s", compiled)
+	}
+}